@@ -0,0 +1,50 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package client
+
+import (
+    "math/rand"
+    "time"
+)
+
+const (
+    DefaultReconnectMinDelay = time.Second
+    DefaultReconnectMaxDelay = 2 * time.Minute
+)
+
+//重新连接的指数退避策略：第n次（从0开始）重试前等待Min*2^n，不超过Max，
+//实际等待时间在该值的[1/2, 1]之间随机选取，避免大量客户端同时重新连接
+type Backoff struct {
+    //最小等待时间，0表示DefaultReconnectMinDelay
+    Min time.Duration
+    //最大等待时间，0表示DefaultReconnectMaxDelay
+    Max time.Duration
+}
+
+//第attempt次（从0开始）重试前的等待时间
+func (b Backoff) Delay(attempt int) time.Duration {
+    min, max := b.Min, b.Max
+    if min <= 0 {
+        min = DefaultReconnectMinDelay
+    }
+    if max <= 0 {
+        max = DefaultReconnectMaxDelay
+    }
+    if max < min {
+        max = min
+    }
+
+    d := min
+    for i := 0; i < attempt && d < max; i++ {
+        d *= 2
+    }
+    if d > max {
+        d = max
+    }
+    half := d / 2
+    return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
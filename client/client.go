@@ -0,0 +1,1047 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package client
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "io"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "mqtt/session"
+    "mqtt/store"
+    "mqtt/topic"
+    "net"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+var (
+    ErrNotConnected     = errors.New("client: not connected")
+    ErrAlreadyConnected = errors.New("client: already connected")
+    ErrConnectionLost   = errors.New("client: connection lost")
+    ErrPingTimeout      = errors.New("client: PINGRESP not received within keep alive")
+    ErrSessionLost      = errors.New("client: session not present on server")
+)
+
+//MQTT客户端，基于message包的编解码，可并发使用。
+//Connect建立连接后由读取协程处理服务端报文：应答报文交给等待中的请求，PUBLISH报文按订阅分发给处理函数
+type Client struct {
+    opts    Options
+    version byte
+
+    ids *session.IDAllocator
+    out *session.Outbound
+    in  *session.Inbound
+    //离线队列，未设置Options.OfflineQueue时为nil
+    queue *offlineQueue
+    //上一个连接的离线队列发送结束时关闭，由connLock保护
+    drained chan struct{}
+    //是否已从Options.Store恢复会话状态，由connLock保护
+    restored bool
+
+    //串行化Connect及Disconnect
+    connLock sync.Mutex
+
+    lock     sync.Mutex
+    cc       *connection
+    clientID string
+    //等待应答的请求，key为报文标识符
+    waiters map[uint16]chan response
+    //订阅处理函数，key为订阅的主题过滤器
+    routes map[string]*route
+    //正在自动重新连接时用于停止重新连接
+    reconnectCancel context.CancelFunc
+}
+
+//等待的应答报文，err不为nil时表示请求失败
+type response struct {
+    msg message.Message
+    err error
+}
+
+type route struct {
+    filter  topic.Filter
+    handler Handler
+}
+
+//单个网络连接的状态，连接断开后不再使用
+type connection struct {
+    //最后一次发送报文的时间（UnixNano），int64字段放在前面以保证原子操作的对齐
+    lastSent int64
+    //已发送PINGREQ尚未收到PINGRESP时为发送时间，否则为0
+    pingSent int64
+
+    conn net.Conn
+    //连接结束时关闭，之后err为断开的原因（调用Disconnect时为nil）
+    done chan struct{}
+    once sync.Once
+    err  error
+
+    writeLock sync.Mutex
+    //服务端允许接收的最大报文长度，0表示不限制
+    maxPacketSize uint32
+    keepAlive     time.Duration
+    queue         deliveryQueue
+    //发送及接收PUBLISH的主题别名映射，只在该连接内有效
+    sendAliases *session.OutboundAliases
+    recvAliases *session.InboundAliases
+}
+
+//待投递的PUBLISH报文，不限制长度，避免处理函数中等待应答时阻塞读取协程
+type deliveryQueue struct {
+    lock   sync.Mutex
+    msgs   []*message.PublishMessage
+    signal chan struct{}
+}
+
+func (q *deliveryQueue) push(msg *message.PublishMessage) {
+    q.lock.Lock()
+    q.msgs = append(q.msgs, msg)
+    q.lock.Unlock()
+
+    select {
+    case q.signal <- struct{}{}:
+    default:
+    }
+}
+
+func (q *deliveryQueue) take() []*message.PublishMessage {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    msgs := q.msgs
+    q.msgs = nil
+    return msgs
+}
+
+func New(opts Options) *Client {
+    version := opts.version()
+    ids := session.NewIDAllocator(session.DefaultReceiveMaximum)
+    c := &Client{
+        opts:     opts,
+        version:  version,
+        ids:      ids,
+        out:      session.NewOutbound(ids, version),
+        in:       session.NewInbound(session.NewInboundIDs(opts.ReceiveMaximum), version),
+        clientID: opts.ClientID,
+        waiters:  map[uint16]chan response{},
+        routes:   map[string]*route{},
+    }
+    if opts.OfflineQueue != nil {
+        c.queue = newOfflineQueue(&opts)
+    }
+    return c
+}
+
+//客户端标识符，服务端分配标识符后返回分配的值
+func (c *Client) ClientID() string {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    return c.clientID
+}
+
+//是否已连接
+func (c *Client) IsConnected() bool {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    return c.cc != nil
+}
+
+//建立连接，发送CONNECT并等待CONNACK。
+//服务端拒绝连接时返回CONNACK及原因码对应的*errcode.Reason（3.1.1的返回码转换为5.0原因码）。
+//会话存在（Session Present）时按原顺序重发未完成的PUBLISH及PUBREL报文，之后才返回；
+//会话不存在时丢弃未完成的消息，等待中的Publish返回ErrSessionLost [MQTT-4.1.0-1]。
+//离线队列中的消息在返回后按入队顺序发送
+func (c *Client) Connect(ctx context.Context) (*message.ConnackMessage, error) {
+    return c.connect(ctx, false)
+}
+
+//resume为true时Clean Start为0，用于自动重新连接
+func (c *Client) connect(ctx context.Context, resume bool) (*message.ConnackMessage, error) {
+    c.connLock.Lock()
+    defer c.connLock.Unlock()
+
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+    if c.IsConnected() {
+        return nil, ErrAlreadyConnected
+    }
+    //上一个连接发送离线队列时进入会话的消息需随本次会话恢复重发，连接断开后发送很快结束
+    if c.drained != nil {
+        <-c.drained
+    }
+    if err := c.restore(); err != nil {
+        return nil, err
+    }
+
+    conn, err := c.opts.dial(ctx)
+    if err != nil {
+        return nil, err
+    }
+    stop := interruptOnDone(ctx, conn)
+    r := bufio.NewReader(conn)
+    ack, err := c.handshake(conn, r, resume)
+    if e := stop(); e != nil {
+        err = e
+    }
+    if err != nil {
+        conn.Close()
+        return ack, err
+    }
+
+    c.lock.Lock()
+    if id, ok := ack.GetAssignedClientIdentifier(); ok {
+        c.clientID = id
+    }
+    c.lock.Unlock()
+    if ack.GetAckFlag()&0x01 == 0 {
+        c.out.Clear()
+        c.in.Clear()
+        c.failWaiters(ErrSessionLost)
+        if err := c.deleteSession(); err != nil {
+            conn.Close()
+            return ack, err
+        }
+    }
+
+    cc := c.newConnection(conn, ack)
+    go c.readLoop(cc, r)
+    go c.deliverLoop(cc)
+
+    //先按原顺序重发未完成的报文，之后才允许发送新的报文 [MQTT-4.6.0-1]。
+    //写入失败时连接已关闭，报文保留在会话中，重新连接后再发送
+    for _, m := range c.out.Resend() {
+        if c.write(cc, m) != nil {
+            break
+        }
+    }
+
+    c.lock.Lock()
+    select {
+    case <-cc.done:
+        c.lock.Unlock()
+        return ack, cc.err
+    default:
+    }
+    c.cc = cc
+    c.reconnectCancel = nil
+    c.lock.Unlock()
+
+    if cc.keepAlive > 0 {
+        go c.keepAliveLoop(cc)
+    }
+    if c.queue != nil {
+        c.drained = make(chan struct{})
+        go c.drainQueue(cc, c.drained)
+    }
+    return ack, nil
+}
+
+func (c *Client) handshake(conn net.Conn, r io.Reader, resume bool) (*message.ConnackMessage, error) {
+    connect := c.opts.connectMessage()
+    connect.SetClientId(c.ClientID())
+    if resume {
+        connect.SetCleanStart(false)
+    }
+    if _, err := message.WriteMessage(conn, connect, c.version); err != nil {
+        return nil, err
+    }
+
+    m, _, err := message.ReadMessageWithOption(r, c.readOption())
+    if err != nil {
+        return nil, err
+    }
+    ack, ok := m.(*message.ConnackMessage)
+    if !ok {
+        return nil, errcode.ProtocolError
+    }
+
+    code := ack.GetReasonCode()
+    if !packet.IsV5(c.version) {
+        code = errcode.ConnackReasonCode(code)
+    }
+    if code.IsError() {
+        return ack, errcode.ReasonFor(code)
+    }
+    return ack, nil
+}
+
+func (c *Client) newConnection(conn net.Conn, ack *message.ConnackMessage) *connection {
+    cc := &connection{
+        lastSent:      time.Now().UnixNano(),
+        conn:          conn,
+        done:          make(chan struct{}),
+        maxPacketSize: message.MaxPacketSize(ack),
+        keepAlive:     time.Duration(c.opts.KeepAlive) * time.Second,
+    }
+    sendMax, _ := ack.GetTopicAliasMaximum()
+    cc.sendAliases = session.NewOutboundAliases(sendMax)
+    cc.sendAliases.SetThreshold(c.opts.topicAliasThreshold())
+    var recvMax uint16
+    if packet.IsV5(c.version) {
+        recvMax = c.opts.TopicAliasMaximum
+    }
+    cc.recvAliases = session.NewInboundAliases(recvMax)
+    cc.queue.signal = make(chan struct{}, 1)
+    if v, ok := ack.GetServerKeepAlive(); ok {
+        cc.keepAlive = time.Duration(v) * time.Second
+    }
+    v, _ := ack.GetReceiveMaximum()
+    c.ids.SetReceiveMaximum(v)
+    return cc
+}
+
+func (c *Client) readOption() message.ReadOption {
+    return message.ReadOption{Version: c.version, MaxPacketSize: c.opts.MaximumPacketSize}
+}
+
+//发送DISCONNECT（原因码为正常断开）并关闭连接，不触发OnConnectionLost。
+//正在自动重新连接时停止重新连接，等待中的Publish返回ErrNotConnected
+func (c *Client) Disconnect(ctx context.Context) error {
+    c.lock.Lock()
+    cancel := c.reconnectCancel
+    c.reconnectCancel = nil
+    c.lock.Unlock()
+    if cancel != nil {
+        cancel()
+    }
+
+    c.connLock.Lock()
+    defer c.connLock.Unlock()
+    defer c.failWaiters(ErrNotConnected)
+
+    cc, err := c.current()
+    if err != nil {
+        if cancel != nil {
+            return nil
+        }
+        return err
+    }
+
+    msg := message.NewDisconnectMessage()
+    msg.SetVersion(c.version)
+    stop := interruptOnDone(ctx, cc.conn)
+    err = c.write(cc, msg)
+    if e := stop(); e != nil {
+        err = e
+    }
+    c.closeConnection(cc, nil)
+    return err
+}
+
+//发布消息。QoS 0在报文写入后返回；QoS 1等待PUBACK，QoS 2等待PUBCOMP，
+//原因码表示失败时返回对应的*errcode.Reason。
+//发送窗口（服务端的Receive Maximum）已满时等待其他消息完成。
+//ctx结束或连接断开时返回错误，已发送的QoS 1及QoS 2消息仍保留在会话中，会话恢复后重发；
+//设置了AutoReconnect时连接断开后继续等待，直到重新连接后交互完成、会话丢失（ErrSessionLost）或调用Disconnect。
+//设置了OfflineQueue时，未连接或离线队列尚未发送完时消息进入队列，入队后即返回nil。
+//msg在交互完成前由客户端持有，调用者不应再修改。
+//设置了Store、AutoReconnect或OfflineQueue时，报文可能被多次写入，流式payload先读入内存
+func (c *Client) Publish(ctx context.Context, msg *message.PublishMessage) error {
+    if err := c.bufferPayload(msg); err != nil {
+        return err
+    }
+    cc, err := c.publishConnection(ctx, msg)
+    if cc == nil {
+        return err
+    }
+    if msg.GetQos() == 0 {
+        if err := msg.Validate(); err != nil {
+            return err
+        }
+        return c.write(cc, msg)
+    }
+
+    //报文标识符分配后才能校验
+    if err := c.out.PublishContext(ctx, msg); err != nil {
+        return err
+    }
+    id := msg.GetPacketIdentifier()
+    if err := msg.Validate(); err != nil {
+        c.out.Discard(id)
+        return err
+    }
+    if err := c.persist(store.Outbound, msg); err != nil {
+        c.out.Discard(id)
+        return err
+    }
+    ch := c.addWaiter(id)
+    if err := c.write(cc, msg); err != nil {
+        var reason *errcode.Reason
+        if errors.As(err, &reason) {
+            c.removeWaiter(id)
+            c.discard(id)
+            return err
+        }
+        if !c.opts.AutoReconnect {
+            c.removeWaiter(id)
+            return err
+        }
+    }
+
+    resp, err := c.wait(ctx, cc, id, ch, c.opts.AutoReconnect)
+    if err != nil {
+        return err
+    }
+    if code := resp.(reasonCoder).GetReasonCode(); code.IsError() {
+        return errcode.ReasonFor(code)
+    }
+    return nil
+}
+
+//流式payload只能读取一次，报文需要保存（Store或离线队列）或在重新连接后重发时读入内存，
+//之后的每次写入使用同一份数据。读取的数据不足时返回io.ErrUnexpectedEOF
+func (c *Client) bufferPayload(msg *message.PublishMessage) error {
+    if !msg.IsPayloadStream() {
+        return nil
+    }
+    if c.queue == nil && (msg.GetQos() == 0 || c.opts.Store == nil && !c.opts.AutoReconnect) {
+        return nil
+    }
+    payload := make([]byte, msg.GetPayloadSize())
+    if _, err := io.ReadFull(msg.GetPayloadReader(), payload); err != nil {
+        return err
+    }
+    msg.SetPayload(payload)
+    return nil
+}
+
+//返回发送消息的连接；设置了离线队列且消息已入队时返回nil连接及nil错误
+func (c *Client) publishConnection(ctx context.Context, msg *message.PublishMessage) (*connection, error) {
+    for {
+        if c.queue != nil {
+            queued, dropped, err := c.queue.push(ctx, msg)
+            if dropped != nil {
+                c.queueDropped(dropped, ErrQueueFull)
+            }
+            if queued || err != nil {
+                return nil, err
+            }
+        }
+        cc, err := c.current()
+        //连接在切换为直接发送后断开，重新尝试入队
+        if err == ErrNotConnected && c.queue != nil {
+            continue
+        }
+        return cc, err
+    }
+}
+
+func (c *Client) queueDropped(msg *message.PublishMessage, err error) {
+    if c.opts.OnQueueDrop != nil {
+        c.opts.OnQueueDrop(c, msg, err)
+    }
+}
+
+//按入队顺序发送离线队列中的消息，队列清空后切换为直接发送。连接断开时停止，消息留在队列中
+func (c *Client) drainQueue(cc *connection, drained chan struct{}) {
+    defer close(drained)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go func() {
+        select {
+        case <-cc.done:
+            cancel()
+        case <-ctx.Done():
+        }
+    }()
+
+    for {
+        msg, enqueued, err := c.queue.peek()
+        if err != nil {
+            c.closeConnection(cc, err)
+            return
+        }
+        if msg == nil {
+            if c.queue.setOnline(cc.done) {
+                return
+            }
+            select {
+            case <-cc.done:
+                return
+            default:
+            }
+            continue
+        }
+        if err := c.sendQueued(ctx, cc, msg, enqueued); err != nil {
+            return
+        }
+    }
+}
+
+//发送离线队列的队首消息queued，返回错误时停止发送。
+//发送的是queued的拷贝，出队前队列中的消息不被修改。QoS 1及QoS 2消息保存并进入会话后才出队，
+//之后由会话负责重发；QoS 0消息出队后发送，连接断开时丢失。
+//出队时只删除queued，等待发送窗口期间queued被DropOldest丢弃（已通知OnQueueDrop）时不再发送
+func (c *Client) sendQueued(ctx context.Context, cc *connection, queued *message.PublishMessage, enqueued time.Time) error {
+    msg := queued.ShallowCopy()
+    //发送窗口已满时等待其他消息完成
+    if err := c.out.PublishContext(ctx, msg); err != nil {
+        return err
+    }
+    id := msg.GetPacketIdentifier()
+    discard := func() {
+        if msg.GetQos() > 0 {
+            c.discard(id)
+        }
+    }
+
+    err := expire(msg, enqueued, time.Now())
+    if err == nil {
+        err = msg.Validate()
+    }
+    if err == nil && msg.GetQos() > 0 {
+        //保存失败时消息留在队列中，重新连接后再发送
+        if err := c.persist(store.Outbound, msg); err != nil {
+            c.out.Discard(id)
+            c.closeConnection(cc, err)
+            return err
+        }
+    }
+    removed, errQ := c.queue.remove(queued)
+    if errQ != nil {
+        discard()
+        c.closeConnection(cc, errQ)
+        return errQ
+    }
+    if !removed {
+        discard()
+        return nil
+    }
+
+    if err == nil {
+        err = c.write(cc, msg)
+    }
+    if err != nil {
+        var reason *errcode.Reason
+        if err != ErrMessageExpired && !errors.As(err, &reason) {
+            //网络错误，连接已关闭
+            return err
+        }
+        discard()
+        c.queueDropped(queued, err)
+    }
+    return nil
+}
+
+type reasonCoder interface {
+    GetReasonCode() errcode.ReasonCode
+}
+
+//订阅并等待SUBACK，匹配filters的PUBLISH报文交给handler处理，相同的主题过滤器以最后一次订阅为准。
+//处理函数在发送SUBSCRIBE前注册，以免遗漏SUBACK之后立即到达的消息；
+//服务端拒绝的主题过滤器不会保留处理函数，并返回第一个失败原因码对应的*errcode.Reason
+func (c *Client) Subscribe(ctx context.Context, handler Handler, filters ...message.SubscribeFilter) (*message.SubAckMessage, error) {
+    routes := make([]*route, len(filters))
+    for i, f := range filters {
+        tf, err := topic.ParseFilter(f.Filter)
+        if err != nil {
+            return nil, err
+        }
+        routes[i] = &route{filter: tf, handler: handler}
+    }
+    cc, err := c.current()
+    if err != nil {
+        return nil, err
+    }
+
+    msg := message.NewSubscribeMessage()
+    msg.SetPayload(filters)
+
+    c.lock.Lock()
+    for _, r := range routes {
+        c.routes[r.filter.String()] = r
+    }
+    c.lock.Unlock()
+
+    resp, err := c.request(ctx, cc, msg)
+    if err != nil {
+        c.removeRoutes(routes)
+        return nil, err
+    }
+    ack, ok := resp.(*message.SubAckMessage)
+    if !ok {
+        c.removeRoutes(routes)
+        return nil, errcode.ProtocolError
+    }
+
+    var failed []*route
+    codes := ack.GetPayload()
+    for i, r := range routes {
+        if i < len(codes) && errcode.ReasonCode(codes[i]).IsError() {
+            failed = append(failed, r)
+            if err == nil {
+                err = errcode.ReasonFor(errcode.ReasonCode(codes[i]))
+            }
+            continue
+        }
+        if e := c.persistSubscribe(filters[i]); e != nil && err == nil {
+            err = e
+        }
+    }
+    c.removeRoutes(failed)
+    return ack, err
+}
+
+//取消订阅并等待UNSUBACK，之后不再调用对应的处理函数。
+//5.0的UNSUBACK原因码表示失败时返回第一个失败原因码对应的*errcode.Reason
+func (c *Client) Unsubscribe(ctx context.Context, filters ...string) (*message.UnsubAckMessage, error) {
+    cc, err := c.current()
+    if err != nil {
+        return nil, err
+    }
+
+    msg := message.NewUnsubscribeMessage()
+    msg.SetPayload(filters)
+
+    resp, err := c.request(ctx, cc, msg)
+    if err != nil {
+        return nil, err
+    }
+    ack, ok := resp.(*message.UnsubAckMessage)
+    if !ok {
+        return nil, errcode.ProtocolError
+    }
+
+    c.lock.Lock()
+    for _, f := range filters {
+        delete(c.routes, f)
+    }
+    c.lock.Unlock()
+
+    for _, f := range filters {
+        if err := c.persistUnsubscribe(f); err != nil {
+            return ack, err
+        }
+    }
+    for _, code := range ack.GetPayload() {
+        if errcode.ReasonCode(code).IsError() {
+            return ack, errcode.ReasonFor(errcode.ReasonCode(code))
+        }
+    }
+    return ack, nil
+}
+
+func (c *Client) removeRoutes(routes []*route) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    for _, r := range routes {
+        if c.routes[r.filter.String()] == r {
+            delete(c.routes, r.filter.String())
+        }
+    }
+}
+
+type packetIdentifierSetter interface {
+    message.Message
+    SetPacketIdentifier(v uint16)
+}
+
+//分配报文标识符，校验并发送SUBSCRIBE或UNSUBSCRIBE，等待应答，完成后释放报文标识符
+func (c *Client) request(ctx context.Context, cc *connection, msg packetIdentifierSetter) (message.Message, error) {
+    id, err := c.ids.Allocate()
+    if err != nil {
+        return nil, err
+    }
+    defer c.ids.Release(id)
+
+    msg.SetPacketIdentifier(id)
+    if err := msg.Validate(); err != nil {
+        return nil, err
+    }
+    ch := c.addWaiter(id)
+    if err := c.write(cc, msg); err != nil {
+        c.removeWaiter(id)
+        return nil, err
+    }
+    return c.wait(ctx, cc, id, ch, false)
+}
+
+func (c *Client) addWaiter(id uint16) chan response {
+    ch := make(chan response, 1)
+
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    c.waiters[id] = ch
+    return ch
+}
+
+func (c *Client) removeWaiter(id uint16) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    delete(c.waiters, id)
+}
+
+//将应答交给等待中的请求，请求已放弃等待时丢弃
+func (c *Client) notify(id uint16, resp message.Message) {
+    c.lock.Lock()
+    ch, ok := c.waiters[id]
+    delete(c.waiters, id)
+    c.lock.Unlock()
+
+    if ok {
+        ch <- response{msg: resp}
+    }
+}
+
+//所有等待中的请求以err结束
+func (c *Client) failWaiters(err error) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    for id, ch := range c.waiters {
+        ch <- response{err: err}
+        delete(c.waiters, id)
+    }
+}
+
+//等待应答，persistent为true时连接断开后继续等待
+func (c *Client) wait(ctx context.Context, cc *connection, id uint16, ch chan response, persistent bool) (message.Message, error) {
+    done := cc.done
+    if persistent {
+        done = nil
+    }
+    select {
+    case resp := <-ch:
+        return resp.msg, resp.err
+    case <-ctx.Done():
+        c.removeWaiter(id)
+        return nil, ctx.Err()
+    case <-done:
+        c.removeWaiter(id)
+        if cc.err != nil {
+            return nil, cc.err
+        }
+        return nil, ErrConnectionLost
+    }
+}
+
+func (c *Client) current() (*connection, error) {
+    c.lock.Lock()
+    defer c.lock.Unlock()
+
+    if c.cc == nil {
+        return nil, ErrNotConnected
+    }
+    return c.cc, nil
+}
+
+//写报文，网络错误时关闭连接；编码错误（*errcode.Reason，如超出服务端的最大报文长度）不影响连接。
+//PUBLISH报文按写入顺序分配主题别名，实际写入的是设置了别名的拷贝，不修改msg
+func (c *Client) write(cc *connection, msg message.Message) error {
+    cc.writeLock.Lock()
+    var topicName string
+    var err error
+    if pub, ok := msg.(*message.PublishMessage); ok {
+        topicName = pub.GetTopicName()
+        //调用者设置的别名无效时返回TopicAliasInvalid，不写入报文
+        msg, err = cc.sendAliases.Apply(pub)
+    }
+    if err == nil {
+        _, err = message.WriteMessageWithLimit(cc.conn, msg, c.version, cc.maxPacketSize)
+        if err != nil && topicName != "" {
+            //建立映射的报文可能未发送，之后重新发送主题名
+            cc.sendAliases.Remove(topicName)
+        }
+    }
+    cc.writeLock.Unlock()
+
+    if err != nil {
+        var reason *errcode.Reason
+        if !errors.As(err, &reason) {
+            c.closeConnection(cc, err)
+        }
+        return err
+    }
+    atomic.StoreInt64(&cc.lastSent, time.Now().UnixNano())
+    return nil
+}
+
+//关闭连接并结束该连接的所有协程，err为nil表示主动断开
+func (c *Client) closeConnection(cc *connection, err error) {
+    closed := false
+    cc.once.Do(func() {
+        closed = true
+        cc.err = err
+        close(cc.done)
+        cc.conn.Close()
+    })
+    if !closed {
+        return
+    }
+    //先切换离线队列，之后的Publish才会发现未连接
+    if c.queue != nil {
+        c.queue.setOffline()
+    }
+
+    c.lock.Lock()
+    if c.cc == cc {
+        c.cc = nil
+    }
+    c.lock.Unlock()
+
+    if err == nil {
+        return
+    }
+    if c.opts.OnConnectionLost != nil {
+        go c.opts.OnConnectionLost(c, err)
+    }
+    if c.opts.AutoReconnect {
+        c.startReconnect()
+    }
+}
+
+func (c *Client) startReconnect() {
+    ctx, cancel := context.WithCancel(context.Background())
+
+    c.lock.Lock()
+    if c.reconnectCancel != nil {
+        c.lock.Unlock()
+        cancel()
+        return
+    }
+    c.reconnectCancel = cancel
+    c.lock.Unlock()
+
+    go c.reconnect(ctx, cancel)
+}
+
+//按退避策略重新连接，直到连接成功、其他调用已建立连接或调用Disconnect
+func (c *Client) reconnect(ctx context.Context, cancel context.CancelFunc) {
+    defer cancel()
+
+    for attempt := 0; ; attempt++ {
+        timer := time.NewTimer(c.opts.Backoff.Delay(attempt))
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return
+        case <-timer.C:
+        }
+
+        attemptCtx, attemptCancel := context.WithTimeout(ctx, c.opts.connectTimeout())
+        ack, err := c.connect(attemptCtx, true)
+        attemptCancel()
+        switch {
+        case err == nil:
+            if c.opts.OnReconnect != nil {
+                c.opts.OnReconnect(c, ack)
+            }
+            return
+        case err == ErrAlreadyConnected || ctx.Err() != nil:
+            return
+        }
+    }
+}
+
+func (c *Client) readLoop(cc *connection, r io.Reader) {
+    opt := c.readOption()
+    for {
+        m, _, err := message.ReadMessageWithOption(r, opt)
+        if err == nil {
+            err = c.handle(cc, m)
+        }
+        if err != nil {
+            //协议错误时以对应的原因码发送DISCONNECT [MQTT-4.13.2-1]
+            var reason *errcode.Reason
+            if errors.As(err, &reason) {
+                c.write(cc, message.NewDisconnectMessageFromError(err, c.version))
+            } else if err == io.EOF {
+                err = ErrConnectionLost
+            }
+            c.closeConnection(cc, err)
+            return
+        }
+    }
+}
+
+func (c *Client) handle(cc *connection, m message.Message) error {
+    switch msg := m.(type) {
+    case *message.PublishMessage:
+        //按别名恢复主题名，未知或超出范围的别名以TopicAliasInvalid断开连接
+        if err := cc.recvAliases.Resolve(msg); err != nil {
+            return err
+        }
+        deliver, resp, err := c.in.HandlePublish(msg)
+        if err != nil {
+            return err
+        }
+        if deliver {
+            //投递及发送PUBREC前保存报文标识符，重启后不再投递对端重发的该消息；
+            //保存失败时释放报文标识符并断开连接，不投递也不发送PUBREC，对端重发时重新处理
+            if msg.GetQos() == 2 {
+                if err := c.persist(store.Inbound, msg); err != nil {
+                    c.in.Release(msg.GetPacketIdentifier())
+                    return err
+                }
+            }
+            cc.queue.push(msg)
+        }
+        if resp != nil {
+            c.write(cc, resp)
+        }
+    case *message.PubAckMessage:
+        if _, err := c.out.HandlePubAck(msg); err == nil {
+            if err := c.unpersist(store.Outbound, msg.GetPacketIdentifier()); err != nil {
+                return err
+            }
+            c.notify(msg.GetPacketIdentifier(), msg)
+        }
+    case *message.PubRecMessage:
+        rel, err := c.out.HandlePubRec(msg)
+        if err != nil {
+            return err
+        }
+        if rel == nil {
+            if err := c.unpersist(store.Outbound, msg.GetPacketIdentifier()); err != nil {
+                return err
+            }
+            c.notify(msg.GetPacketIdentifier(), msg)
+            break
+        }
+        //PUBREL替换保存的PUBLISH，之后重发PUBREL
+        if !rel.GetReasonCode().IsError() {
+            if err := c.persist(store.Outbound, rel); err != nil {
+                return err
+            }
+        }
+        c.write(cc, rel)
+    case *message.PubCompMessage:
+        if _, err := c.out.HandlePubComp(msg); err == nil {
+            if err := c.unpersist(store.Outbound, msg.GetPacketIdentifier()); err != nil {
+                return err
+            }
+            c.notify(msg.GetPacketIdentifier(), msg)
+        }
+    case *message.PubRelMessage:
+        comp := c.in.HandlePubRel(msg)
+        //先删除保存的报文标识符，避免重启后相同标识符的新消息不被投递
+        if err := c.unpersist(store.Inbound, msg.GetPacketIdentifier()); err != nil {
+            return err
+        }
+        c.write(cc, comp)
+    case *message.SubAckMessage:
+        c.notify(msg.GetPacketIdentifier(), msg)
+    case *message.UnsubAckMessage:
+        c.notify(msg.GetPacketIdentifier(), msg)
+    case *message.PingRespMessage:
+        atomic.StoreInt64(&cc.pingSent, 0)
+    case *message.DisconnectMessage:
+        err := ErrConnectionLost
+        if code := msg.GetReasonCode(); code.IsError() {
+            err = errcode.ReasonFor(code)
+        }
+        c.closeConnection(cc, err)
+    default:
+        return errcode.ProtocolError
+    }
+    return nil
+}
+
+//按到达顺序串行调用处理函数，连接断开后仍投递已收到的消息
+func (c *Client) deliverLoop(cc *connection) {
+    for {
+        select {
+        case <-cc.queue.signal:
+        case <-cc.done:
+            for _, msg := range cc.queue.take() {
+                c.dispatch(msg)
+            }
+            return
+        }
+        for _, msg := range cc.queue.take() {
+            c.dispatch(msg)
+        }
+    }
+}
+
+//调用所有匹配的订阅处理函数，没有匹配时调用DefaultHandler
+func (c *Client) dispatch(msg *message.PublishMessage) {
+    var handlers []Handler
+    if name, err := topic.ParseName(msg.GetTopicName()); err == nil {
+        c.lock.Lock()
+        for _, r := range c.routes {
+            if r.filter.Match(name) {
+                handlers = append(handlers, r.handler)
+            }
+        }
+        c.lock.Unlock()
+    }
+
+    if len(handlers) == 0 && c.opts.DefaultHandler != nil {
+        handlers = append(handlers, c.opts.DefaultHandler)
+    }
+    for _, h := range handlers {
+        h(c, msg)
+    }
+}
+
+//保持连接：空闲超过保持连接时间的3/4时发送PINGREQ，
+//在保持连接时间内没有收到PINGRESP时断开连接 [MQTT-3.1.2-24]
+func (c *Client) keepAliveLoop(cc *connection) {
+    ticker := time.NewTicker(cc.keepAlive / 4)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-cc.done:
+            return
+        case now := <-ticker.C:
+            if sent := atomic.LoadInt64(&cc.pingSent); sent != 0 {
+                if now.UnixNano()-sent >= int64(cc.keepAlive) {
+                    c.closeConnection(cc, ErrPingTimeout)
+                    return
+                }
+                continue
+            }
+            if now.UnixNano()-atomic.LoadInt64(&cc.lastSent) >= int64(cc.keepAlive*3/4) {
+                atomic.StoreInt64(&cc.pingSent, now.UnixNano())
+                c.write(cc, message.NewPingReqMessage())
+            }
+        }
+    }
+}
+
+//ctx结束时中断conn上阻塞的读写，返回的函数停止监听并清除超时设置，已中断时返回ctx的错误
+func interruptOnDone(ctx context.Context, conn net.Conn) func() error {
+    if ctx.Done() == nil {
+        return func() error { return nil }
+    }
+
+    stop := make(chan struct{})
+    exited := make(chan struct{})
+    interrupted := false
+    go func() {
+        defer close(exited)
+        select {
+        case <-ctx.Done():
+            conn.SetDeadline(time.Unix(1, 0))
+            interrupted = true
+        case <-stop:
+        }
+    }()
+
+    return func() error {
+        close(stop)
+        <-exited
+        if interrupted {
+            conn.SetDeadline(time.Time{})
+            return ctx.Err()
+        }
+        return nil
+    }
+}
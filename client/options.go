@@ -0,0 +1,154 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package client
+
+import (
+    "context"
+    "mqtt/message"
+    "mqtt/packet"
+    "mqtt/store"
+    "net"
+    "time"
+)
+
+const (
+    DefaultConnectTimeout = 30 * time.Second
+    //主题发送两次后才分配主题别名，只发送一次的主题不占用别名
+    DefaultTopicAliasThreshold = 2
+)
+
+//收到PUBLISH报文时调用，同一客户端的处理函数按消息到达顺序串行调用
+type Handler func(c *Client, msg *message.PublishMessage)
+
+//客户端选项
+type Options struct {
+    //服务端地址，如"127.0.0.1:1883"，使用TCP连接
+    Address string
+    //建立网络连接，设置后忽略Address，可用于TLS、WebSocket等连接方式
+    Dialer func(ctx context.Context) (net.Conn, error)
+
+    //协议版本，0表示MQTT 5.0
+    Version byte
+    //客户端标识符，为空时由服务端分配（5.0通过CONNACK的AssignedClientIdentifier返回）
+    ClientID string
+    Username string
+    Password []byte
+    //为true时开始新会话（3.1.1为Clean Session）
+    CleanStart bool
+    //保持连接时间（秒），0表示关闭保持连接。服务端在CONNACK中返回ServerKeepAlive时以服务端为准
+    KeepAlive uint16
+    //会话过期时间（秒），仅5.0
+    SessionExpiryInterval uint32
+    //本端接收最大值，即允许服务端同时发送的未完成QoS 1及QoS 2消息数量，0表示65535，仅5.0
+    ReceiveMaximum uint16
+    //本端允许接收的最大报文长度，0表示不限制，仅5.0
+    MaximumPacketSize uint32
+    //允许服务端使用的主题别名最大值，0表示不接受主题别名，仅5.0。
+    //发送时按服务端在CONNACK中返回的主题别名最大值自动为主题分配别名
+    TopicAliasMaximum uint16
+    //同一连接中主题的发送次数达到该值后才自动分配主题别名，0表示DefaultTopicAliasThreshold，1表示第一次发送即分配。
+    //Publish的消息已设置主题别名时不再自动分配，并以该别名更新映射
+    TopicAliasThreshold int
+    //发送CONNECT报文前调用，用于设置遗嘱、认证等其他字段
+    OnConnect func(msg *message.ConnectMessage)
+    //每次建立连接（包括自动重新连接）的超时时间，0表示DefaultConnectTimeout
+    ConnectTimeout time.Duration
+
+    //连接意外断开后按Backoff自动重新连接，重新连接时使用相同的客户端标识符（包括服务端分配的标识符），
+    //Clean Start为0以恢复会话。5.0需要设置SessionExpiryInterval，否则服务端在连接断开时即结束会话
+    AutoReconnect bool
+    //重新连接的退避策略
+    Backoff Backoff
+    //自动重新连接成功后调用
+    OnReconnect func(c *Client, ack *message.ConnackMessage)
+
+    //离线队列，为nil时未连接的Publish返回ErrNotConnected。
+    //设置后未连接时Publish的消息进入队列并立即返回，连接建立（收到CONNACK并重发会话中未完成的报文）后按入队顺序发送，
+    //发送时按等待的时间减少消息过期间隔（MessageExpiryInterval），已过期的消息被丢弃。
+    //队列中的消息发送后不再通知发布结果，QoS 1及QoS 2消息进入会话，按会话状态重发
+    OfflineQueue store.Queue
+    //离线队列的最大消息数，0表示DefaultOfflineQueueSize
+    OfflineQueueSize int
+    //离线队列已满时的处理方式
+    OverflowPolicy OverflowPolicy
+    //离线队列中的消息被丢弃时调用，err为ErrQueueFull（DropOldest）、ErrMessageExpired或发送前校验失败的原因
+    OnQueueDrop func(c *Client, msg *message.PublishMessage, err error)
+
+    //会话状态存储，为nil时会话状态只保存在内存中，进程退出后丢失。
+    //设置后未完成的QoS 1及QoS 2报文、尚未收到PUBREL的QoS 2报文标识符、服务端接受的订阅及下一个报文标识符
+    //在变化时保存，第一次连接前按ClientID恢复，进程重启后以相同的ClientID及Store创建客户端即可继续会话。
+    //订阅的处理函数不会保存，恢复会话后需重新注册或使用DefaultHandler。
+    //ClientID为空时只在服务端分配标识符后保存，不能在重启后恢复。保存失败时断开连接，Store由调用者关闭
+    Store store.Store
+
+    //没有匹配的订阅处理函数时调用，为nil时丢弃该消息
+    DefaultHandler Handler
+    //连接意外断开（不是调用Disconnect）时调用，err为断开的原因
+    OnConnectionLost func(c *Client, err error)
+}
+
+func (o *Options) version() byte {
+    if o.Version == 0 {
+        return packet.MqttProtocolVersion5
+    }
+    return o.Version
+}
+
+func (o *Options) topicAliasThreshold() int {
+    if o.TopicAliasThreshold <= 0 {
+        return DefaultTopicAliasThreshold
+    }
+    return o.TopicAliasThreshold
+}
+
+func (o *Options) connectTimeout() time.Duration {
+    if o.ConnectTimeout <= 0 {
+        return DefaultConnectTimeout
+    }
+    return o.ConnectTimeout
+}
+
+func (o *Options) dial(ctx context.Context) (net.Conn, error) {
+    if o.Dialer != nil {
+        return o.Dialer(ctx)
+    }
+    d := net.Dialer{}
+    return d.DialContext(ctx, "tcp", o.Address)
+}
+
+//按选项创建CONNECT报文
+func (o *Options) connectMessage() *message.ConnectMessage {
+    msg := message.NewConnectMessage()
+    msg.SetVersion(o.version())
+    msg.SetClientId(o.ClientID)
+    msg.SetCleanStart(o.CleanStart)
+    msg.SetKeepAlive(o.KeepAlive)
+    if o.Username != "" {
+        msg.SetUsername(o.Username)
+    }
+    if o.Password != nil {
+        msg.SetPassword(o.Password)
+    }
+    if packet.IsV5(o.version()) {
+        if o.SessionExpiryInterval > 0 {
+            msg.SetSessionExpiryInterval(o.SessionExpiryInterval)
+        }
+        if o.ReceiveMaximum > 0 {
+            msg.SetReceiveMaximum(o.ReceiveMaximum)
+        }
+        if o.MaximumPacketSize > 0 {
+            msg.SetMaximumPacketSize(o.MaximumPacketSize)
+        }
+        if o.TopicAliasMaximum > 0 {
+            msg.SetTopicAliasMaximum(o.TopicAliasMaximum)
+        }
+    }
+    if o.OnConnect != nil {
+        o.OnConnect(msg)
+    }
+    return msg
+}
@@ -0,0 +1,138 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package client
+
+import (
+    "mqtt/message"
+    "mqtt/session"
+    "mqtt/store"
+)
+
+//会话状态的持久化，未设置Options.Store或客户端标识符为空时不做处理
+
+//返回会话状态存储及客户端标识符，不需要持久化时返回nil
+func (c *Client) sessionStore() (store.Store, string) {
+    clientID := c.ClientID()
+    if c.opts.Store == nil || clientID == "" {
+        return nil, ""
+    }
+    return c.opts.Store, clientID
+}
+
+//第一次连接前从Store恢复会话状态：未完成的PUBLISH及PUBREL按保存的顺序进入发送方向的会话，
+//尚未收到PUBREL的QoS 2报文标识符进入接收方向的会话，并恢复下一个报文标识符。
+//恢复失败时丢弃已恢复的部分，下次连接时重试
+func (c *Client) restore() error {
+    s, clientID := c.sessionStore()
+    if s == nil || c.restored {
+        return nil
+    }
+    if err := c.restoreSession(s, clientID); err != nil {
+        c.out.Clear()
+        c.in.Clear()
+        return err
+    }
+    c.restored = true
+    return nil
+}
+
+func (c *Client) restoreSession(s store.Store, clientID string) error {
+    msgs, err := s.Messages(clientID, store.Outbound)
+    if err != nil {
+        return err
+    }
+    for _, m := range msgs {
+        switch msg := m.(type) {
+        case *message.PublishMessage:
+            state := session.StateWaitPubAck
+            if msg.GetQos() == 2 {
+                state = session.StateWaitPubRec
+            }
+            err = c.out.Restore(msg, state)
+        case *message.PubRelMessage:
+            err = c.out.RestorePubRel(msg.GetPacketIdentifier())
+        }
+        if err != nil {
+            return err
+        }
+    }
+
+    msgs, err = s.Messages(clientID, store.Inbound)
+    if err != nil {
+        return err
+    }
+    for _, m := range msgs {
+        if msg, ok := m.(*message.PublishMessage); ok {
+            if err := c.in.Restore(msg.GetPacketIdentifier()); err != nil {
+                return err
+            }
+        }
+    }
+
+    next, err := s.NextID(clientID)
+    if err != nil {
+        return err
+    }
+    c.ids.SetNext(next)
+    return nil
+}
+
+//保存未完成的报文，发送方向的PUBLISH同时保存下一个报文标识符
+func (c *Client) persist(dir store.Direction, msg message.Message) error {
+    s, clientID := c.sessionStore()
+    if s == nil {
+        return nil
+    }
+    if err := s.Put(clientID, dir, msg); err != nil {
+        return err
+    }
+    if _, ok := msg.(*message.PublishMessage); ok && dir == store.Outbound {
+        return s.SetNextID(clientID, c.ids.Next())
+    }
+    return nil
+}
+
+//删除交互完成或放弃的报文
+func (c *Client) unpersist(dir store.Direction, id uint16) error {
+    s, clientID := c.sessionStore()
+    if s == nil {
+        return nil
+    }
+    return s.Delete(clientID, dir, id)
+}
+
+//放弃未完成的PUBLISH报文，释放报文标识符并删除保存的报文
+func (c *Client) discard(id uint16) {
+    c.out.Discard(id)
+    c.unpersist(store.Outbound, id)
+}
+
+//服务端没有会话时删除保存的会话状态
+func (c *Client) deleteSession() error {
+    s, clientID := c.sessionStore()
+    if s == nil {
+        return nil
+    }
+    return s.DeleteSession(clientID)
+}
+
+//保存服务端接受的订阅
+func (c *Client) persistSubscribe(f message.SubscribeFilter) error {
+    s, clientID := c.sessionStore()
+    if s == nil {
+        return nil
+    }
+    return s.Subscribe(clientID, f)
+}
+
+func (c *Client) persistUnsubscribe(filter string) error {
+    s, clientID := c.sessionStore()
+    if s == nil {
+        return nil
+    }
+    return s.Unsubscribe(clientID, filter)
+}
@@ -0,0 +1,186 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package client
+
+import (
+    "context"
+    "errors"
+    "mqtt/message"
+    "mqtt/store"
+    "mqtt/topic"
+    "sync"
+    "time"
+)
+
+const (
+    DefaultOfflineQueueSize = 1000
+)
+
+var (
+    ErrQueueFull      = errors.New("client: offline queue full")
+    ErrMessageExpired = errors.New("client: message expired in offline queue")
+)
+
+//离线队列已满时的处理方式
+type OverflowPolicy byte
+
+const (
+    //丢弃最早入队的消息，通过OnQueueDrop通知
+    DropOldest OverflowPolicy = iota
+    //丢弃新发布的消息，Publish返回ErrQueueFull
+    DropNewest
+    //Publish等待队列有空闲位置，直到ctx结束
+    Block
+)
+
+//离线队列：未连接时Publish的消息进入队列，连接建立后由drainQueue按入队顺序发送，
+//队列清空前新发布的消息仍进入队列，以保持发布顺序
+type offlineQueue struct {
+    lock   sync.Mutex
+    q      store.Queue
+    size   int
+    policy OverflowPolicy
+    //队列已清空且连接可用时为true，此时Publish直接发送
+    online bool
+    //消息出队或恢复连接时关闭并重新创建，通知等待空闲位置的Publish
+    space chan struct{}
+}
+
+func newOfflineQueue(opts *Options) *offlineQueue {
+    size := opts.OfflineQueueSize
+    if size <= 0 {
+        size = DefaultOfflineQueueSize
+    }
+    return &offlineQueue{
+        q:      opts.OfflineQueue,
+        size:   size,
+        policy: opts.OverflowPolicy,
+        space:  make(chan struct{}),
+    }
+}
+
+func (q *offlineQueue) notify() {
+    close(q.space)
+    q.space = make(chan struct{})
+}
+
+//未连接时消息入队并返回true；连接可用时返回false，由调用者直接发送。
+//DropOldest策略下返回被丢弃的消息
+func (q *offlineQueue) push(ctx context.Context, msg *message.PublishMessage) (bool, *message.PublishMessage, error) {
+    for {
+        q.lock.Lock()
+        if q.online {
+            q.lock.Unlock()
+            return false, nil, nil
+        }
+        //主题别名只在单个连接内有效，入队的消息必须包含主题名
+        if _, err := topic.ParseName(msg.GetTopicName()); err != nil {
+            q.lock.Unlock()
+            return false, nil, err
+        }
+
+        var dropped *message.PublishMessage
+        if q.q.Len() >= q.size {
+            switch q.policy {
+            case DropNewest:
+                q.lock.Unlock()
+                return false, nil, ErrQueueFull
+            case DropOldest:
+                old, _, err := q.q.Peek()
+                if err == nil {
+                    err = q.q.Pop()
+                }
+                if err != nil {
+                    q.lock.Unlock()
+                    return false, nil, err
+                }
+                dropped = old
+            default:
+                space := q.space
+                q.lock.Unlock()
+                select {
+                case <-space:
+                    continue
+                case <-ctx.Done():
+                    return false, nil, ctx.Err()
+                }
+            }
+        }
+
+        err := q.q.Push(msg, time.Now())
+        q.lock.Unlock()
+        return err == nil, dropped, err
+    }
+}
+
+func (q *offlineQueue) peek() (*message.PublishMessage, time.Time, error) {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    return q.q.Peek()
+}
+
+//msg仍在队首时出队并返回true；msg已被DropOldest丢弃时不做处理，返回false
+func (q *offlineQueue) remove(msg *message.PublishMessage) (bool, error) {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    head, _, err := q.q.Peek()
+    if err != nil {
+        return false, err
+    }
+    if head != msg {
+        return false, nil
+    }
+    if err := q.q.Pop(); err != nil {
+        return false, err
+    }
+    q.notify()
+    return true, nil
+}
+
+//队列已清空且连接未断开时切换为直接发送，返回是否切换成功
+func (q *offlineQueue) setOnline(done chan struct{}) bool {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    select {
+    case <-done:
+        return false
+    default:
+    }
+    if q.q.Len() > 0 {
+        return false
+    }
+    q.online = true
+    q.notify()
+    return true
+}
+
+func (q *offlineQueue) setOffline() {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    q.online = false
+}
+
+//按消息在队列中等待的时间减少消息过期间隔，已过期时返回ErrMessageExpired [MQTT-3.3.2-6]
+func expire(msg *message.PublishMessage, enqueued time.Time, now time.Time) error {
+    v, ok := msg.GetMessageExpiryInterval()
+    if !ok {
+        return nil
+    }
+    waited := now.Sub(enqueued)
+    if waited < 0 {
+        waited = 0
+    }
+    if waited >= time.Duration(v)*time.Second {
+        return ErrMessageExpired
+    }
+    msg.SetMessageExpiryInterval(v - uint32(waited/time.Second))
+    return nil
+}
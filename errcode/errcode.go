@@ -7,8 +7,8 @@
 package errcode
 
 type Reason struct {
-    Msg  string `json:"msg"`
-    Code byte   `json:"code"`
+    Msg  string     `json:"msg"`
+    Code ReasonCode `json:"code"`
 }
 
 func (r *Reason) Error() string {
@@ -22,8 +22,30 @@ var (
     ProtocolNameError        = &Reason{Msg: "Protocol Name error", Code: ReasonProtocolError}
     MessageNotSupport        = &Reason{Msg: "Message Not Support", Code: ReasonProtocolError}
     MessageReadSizeNotMatch  = &Reason{Msg: "Message Read Size Not Match", Code: ReasonProtocolError}
+    MessageWriteSizeNotMatch = &Reason{Msg: "Message Write Size Not Match", Code: ReasonImplementationSpecificError}
+    ConnectVersionMismatch   = &Reason{Msg: "CONNECT Protocol Level does not match the write version", Code: ReasonImplementationSpecificError}
     DisconnectMissReasonCode = &Reason{Msg: "Disconnect Miss ReasonCode", Code: ReasonProtocolError}
 
+    FixedHeaderFlagInvalid     = &Reason{Msg: "Fixed Header flags must be set to the listed values [MQTT-2.1.3-1]", Code: ReasonMalformedPacket}
+    PublishQosInvalid          = &Reason{Msg: "PUBLISH must not have both QoS bits set to 1 [MQTT-3.3.1-4]", Code: ReasonMalformedPacket}
+    PublishDupInvalid          = &Reason{Msg: "DUP flag must be set to 0 for all QoS 0 messages [MQTT-3.3.1-2]", Code: ReasonMalformedPacket}
+    ConnectReservedFlagInvalid = &Reason{Msg: "CONNECT reserved flag must be set to 0 [MQTT-3.1.2-3]", Code: ReasonMalformedPacket}
+    WillQosInvalid             = &Reason{Msg: "Will QoS must not be 3 [MQTT-3.1.2-12]", Code: ReasonMalformedPacket}
+    WillQosWithoutWill         = &Reason{Msg: "Will QoS must be 0 if the Will Flag is 0 [MQTT-3.1.2-11]", Code: ReasonMalformedPacket}
+    WillRetainWithoutWill      = &Reason{Msg: "Will Retain must be 0 if the Will Flag is 0 [MQTT-3.1.2-13]", Code: ReasonMalformedPacket}
+    PasswordWithoutUsername    = &Reason{Msg: "Password Flag must be 0 if the User Name Flag is 0 [MQTT-3.1.2-22]", Code: ReasonMalformedPacket}
+    DuplicateProperty          = &Reason{Msg: "Property must not be included more than once [MQTT-2.2.2]", Code: ReasonProtocolError}
+    PropertyNotAllowed         = &Reason{Msg: "Property Identifier is not valid for its packet type [MQTT-2.2.2]", Code: ReasonMalformedPacket}
+    PacketIdentifierInvalid    = &Reason{Msg: "Packet Identifier must be non-zero [MQTT-2.2.1-3]", Code: ReasonProtocolError}
+    SubscribeMissTopicFilter   = &Reason{Msg: "SUBSCRIBE must contain at least one Topic Filter [MQTT-3.8.3-2]", Code: ReasonProtocolError}
+    UnsubscribeMissTopicFilter = &Reason{Msg: "UNSUBSCRIBE must contain at least one Topic Filter [MQTT-3.10.3-2]", Code: ReasonProtocolError}
+    RemainLengthInvalid        = &Reason{Msg: "Remaining Length must be encoded in the minimum number of bytes, at most 4 [MQTT-1.5.5-1]", Code: ReasonMalformedPacket}
+    SubscribeReservedInvalid   = &Reason{Msg: "Reserved bits in the Subscription Options must be 0 [MQTT-3.8.3-5]", Code: ReasonMalformedPacket}
+    SubscribeQosInvalid        = &Reason{Msg: "Maximum QoS in the Subscription Options must not be 3 [MQTT-3.8.3]", Code: ReasonProtocolError}
+    RetainHandlingInvalid      = &Reason{Msg: "Retain Handling must not be 3 [MQTT-3.8.3]", Code: ReasonProtocolError}
+    ReasonCodeInvalid          = &Reason{Msg: "Reason Code is not valid for the packet type [MQTT-2.4]", Code: ReasonProtocolError}
+    SharedSubscriptionNoLocal  = &Reason{Msg: "No Local must not be set to 1 on a Shared Subscription [MQTT-3.8.3-4]", Code: ReasonProtocolError}
+
     NormalDisconnection                 = &Reason{Msg: "Normal disconnection", Code: ReasonNormalDisconnection}
     GrantedQoS0                         = &Reason{Msg: "Granted QoS 0", Code: ReasonGrantedQoS0}
     GrantedQoS1                         = &Reason{Msg: "Granted QoS 1", Code: ReasonGrantedQoS1}
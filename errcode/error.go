@@ -0,0 +1,110 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package errcode
+
+import (
+    "errors"
+    "fmt"
+    "io"
+)
+
+var packetNames = [16]string{
+    "Reserved", "CONNECT", "CONNACK", "PUBLISH", "PUBACK", "PUBREC", "PUBREL", "PUBCOMP",
+    "SUBSCRIBE", "SUBACK", "UNSUBSCRIBE", "UNSUBACK", "PINGREQ", "PINGRESP", "DISCONNECT", "AUTH",
+}
+
+//报文错误，携带出错的报文类型、字段及已读取的字节偏移。
+//通过errors.Is判断原因（如errors.Is(err, errcode.MalformedPacket)），通过errors.As获得*Reason
+type PacketError struct {
+    //报文类型
+    PacketType byte
+    //出错的字段
+    Field string
+    //出错时已读取的字节数（从固定头首字节开始计算），出错的数据位于该偏移之前
+    Offset int
+    //协议错误的原因，非协议错误（如io.ErrUnexpectedEOF）时为nil
+    Reason *Reason
+    //原始错误
+    Err error
+}
+
+//包装报文错误，err为nil时返回nil，err已经是*PacketError时直接返回
+func WrapError(err error, packetType byte, field string, offset int) error {
+    if err == nil {
+        return nil
+    }
+    if _, ok := err.(*PacketError); ok {
+        return err
+    }
+    e := &PacketError{
+        PacketType: packetType,
+        Field:      field,
+        Offset:     offset,
+        Err:        err,
+    }
+    errors.As(err, &e.Reason)
+    return e
+}
+
+func (e *PacketError) Error() string {
+    return fmt.Sprintf("%s %s at offset %d: %v", packetNames[e.PacketType&0x0F], e.Field, e.Offset, e.Err)
+}
+
+func (e *PacketError) Unwrap() error {
+    return e.Err
+}
+
+//获得错误对应的原因：
+//包含*Reason时返回该原因；报文数据不完整（io.ErrUnexpectedEOF）返回MalformedPacket；其他返回UnspecifiedError
+func ReasonOf(err error) *Reason {
+    var r *Reason
+    if errors.As(err, &r) {
+        return r
+    }
+    if errors.Is(err, io.ErrUnexpectedEOF) {
+        return MalformedPacket
+    }
+    return UnspecifiedError
+}
+
+//原因码对应的通用原因
+var reasonOfCode = map[ReasonCode]*Reason{}
+
+func init() {
+    for _, r := range []*Reason{
+        UnspecifiedError, MalformedPacket, ProtocolError, ImplementationSpecificError,
+        UnsupportedProtocolVersion, ClientIdentifierNotValid, BadUserNameOrPassword, NotAuthorized,
+        ServerUnavailable, ServerBusy, Banned, ServerShuttingDown, BadAuthenticationMethod,
+        KeepAliveTimeout, SessionTakenOver, TopicFilterInvalid, TopicNameInvalid,
+        PacketIdentifierInUse, PacketIdentifierNotFound, ReceiveMaximumExceeded, TopicAliasInvalid,
+        PacketTooLarge, MessageRateTooHigh, QuotaExceeded, AdministrativeAction, PayloadFormatInvalid,
+        RetainNotSupported, QoSNotSupported, UseAnotherServer, ServerMoved,
+        SharedSubscriptionsNotSupported, ConnectionRateExceeded, MaximumConnectTime,
+        SubscriptionIdentifiersNotSupported, WildcardSubscriptionsNotSupported,
+    } {
+        reasonOfCode[r.Code] = r
+    }
+}
+
+//支持errors.Is按原因码匹配：target为原因码对应的通用原因（如MalformedPacket）时，
+//原因码相同的具体原因（如RemainLengthInvalid）也匹配；具体原因之间仍按指针比较
+func (r *Reason) Is(target error) bool {
+    t, ok := target.(*Reason)
+    if !ok {
+        return false
+    }
+    return r == t || (reasonOfCode[r.Code] == t && t.Code == r.Code)
+}
+
+//原因码对应的通用原因（如ReasonNotAuthorized对应NotAuthorized），
+//用于将对端应答中的原因码转换为错误；没有对应的通用原因时以原因码名称创建
+func ReasonFor(code ReasonCode) *Reason {
+    if r, ok := reasonOfCode[code]; ok {
+        return r
+    }
+    return &Reason{Msg: code.String(), Code: code}
+}
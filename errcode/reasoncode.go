@@ -6,52 +6,188 @@
 
 package errcode
 
+import "fmt"
+
+const (
+    ReasonSuccess                             ReasonCode = 0   //0x00	成功	CONNACK, PUBACK, PUBREC, PUBREL, PUBCOMP, UNSUBACK, AUTH
+    ReasonNormalDisconnection                 ReasonCode = 0   //0x00	正常断开	DISCONNECT
+    ReasonGrantedQoS0                         ReasonCode = 0   //0x00	授权的QoS 0	SUBACK
+    ReasonGrantedQoS1                         ReasonCode = 1   //0x01	授权的QoS 1	SUBACK
+    ReasonGrantedQoS2                         ReasonCode = 2   //0x02	授权的QoS 2	SUBACK
+    ReasonDisconnectWithWillMessage           ReasonCode = 4   //0x04	包含遗嘱的断开	DISCONNECT
+    ReasonNoMatchingSubscribers               ReasonCode = 16  //0x10	无匹配订阅	PUBACK, PUBREC
+    ReasonNoSubscriptionExisted               ReasonCode = 17  //0x11	订阅不存在	UNSUBACK
+    ReasonContinueAuthentication              ReasonCode = 24  //0x18	继续认证	AUTH
+    ReasonReauthenticate                      ReasonCode = 25  //0x19	重新认证	AUTH
+    ReasonUnspecifiedError                    ReasonCode = 128 //0x80	未指明的错误	CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT
+    ReasonMalformedPacket                     ReasonCode = 129 //0x81	无效报文	CONNACK, DISCONNECT
+    ReasonProtocolError                       ReasonCode = 130 //0x82	协议错误	CONNACK, DISCONNECT
+    ReasonImplementationSpecificError         ReasonCode = 131 //0x83	实现错误	CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT
+    ReasonUnsupportedProtocolVersion          ReasonCode = 132 //0x84	协议版本不支持	CONNACK
+    ReasonClientIdentifierNotValid            ReasonCode = 133 //0x85	客户标识符无效	CONNACK
+    ReasonBadUserNameOrPassword               ReasonCode = 134 //0x86	用户名密码错误	CONNACK
+    ReasonNotAuthorized                       ReasonCode = 135 //0x87	未授权	CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT
+    ReasonServerUnavailable                   ReasonCode = 136 //0x88	服务端不可用	CONNACK
+    ReasonServerBusy                          ReasonCode = 137 //0x89	服务端正忙	CONNACK, DISCONNECT
+    ReasonBanned                              ReasonCode = 138 //0x8A	禁止	CONNACK
+    ReasonServerShuttingDown                  ReasonCode = 139 //0x8B	服务端关闭中	DISCONNECT
+    ReasonBadAuthenticationMethod             ReasonCode = 140 //0x8C	无效的认证方法	CONNACK, DISCONNECT
+    ReasonKeepAliveTimeout                    ReasonCode = 141 //0x8D	保活超时	DISCONNECT
+    ReasonSessionTakenOver                    ReasonCode = 142 //0x8E	会话被接管	DISCONNECT
+    ReasonTopicFilterInvalid                  ReasonCode = 143 //0x8F	主题过滤器无效	SUBACK, UNSUBACK, DISCONNECT
+    ReasonTopicNameInvalid                    ReasonCode = 144 //0x90	主题名无效	CONNACK, PUBACK, PUBREC, DISCONNECT
+    ReasonPacketIdentifierInUse               ReasonCode = 145 //0x91	报文标识符已被占用	PUBACK, PUBREC, SUBACK, UNSUBACK
+    ReasonPacketIdentifierNotFound            ReasonCode = 146 //0x92	报文标识符无效	PUBREL, PUBCOMP
+    ReasonReceiveMaximumExceeded              ReasonCode = 147 //0x93	接收超出最大数量	DISCONNECT
+    ReasonTopicAliasInvalid                   ReasonCode = 148 //0x94	主题别名无效	DISCONNECT
+    ReasonPacketTooLarge                      ReasonCode = 149 //0x95	报文过长	CONNACK, DISCONNECT
+    ReasonMessageRateTooHigh                  ReasonCode = 150 //0x96	消息太过频繁	DISCONNECT
+    ReasonQuotaExceeded                       ReasonCode = 151 //0x97	超出配额	CONNACK, PUBACK, PUBREC, SUBACK, DISCONNECT
+    ReasonAdministrativeAction                ReasonCode = 152 //0x98	管理行为	DISCONNECT
+    ReasonPayloadFormatInvalid                ReasonCode = 153 //0x99	载荷格式无效	CONNACK, PUBACK, PUBREC, DISCONNECT
+    ReasonRetainNotSupported                  ReasonCode = 154 //0x9A	不支持保留	CONNACK, DISCONNECT
+    ReasonQoSNotSupported                     ReasonCode = 155 //0x9B	不支持的QoS等级	CONNACK, DISCONNECT
+    ReasonUseAnotherServer                    ReasonCode = 156 //0x9C	（临时）使用其他服务端	CONNACK, DISCONNECT
+    ReasonServerMoved                         ReasonCode = 157 //0x9D	服务端已（永久）移动	CONNACK, DISCONNECT
+    ReasonSharedSubscriptionsNotSupported     ReasonCode = 158 //0x9E	不支持共享订阅	SUBACK, DISCONNECT
+    ReasonConnectionRateExceeded              ReasonCode = 159 //0x9F	超出连接速率限制	CONNACK, DISCONNECT
+    ReasonMaximumConnectTime                  ReasonCode = 160 //0xA0	最大连接时间	DISCONNECT
+    ReasonSubscriptionIdentifiersNotSupported ReasonCode = 161 //0xA1	不支持订阅标识符	SUBACK, DISCONNECT
+    ReasonWildcardSubscriptionsNotSupported   ReasonCode = 162 //0xA2	不支持通配符订阅	SUBACK, DISCONNECT
+)
+
+//MQTT 3.1及3.1.1 CONNACK返回码
 const (
-    ReasonSuccess                             = 0   //0x00	成功	CONNACK, PUBACK, PUBREC, PUBREL, PUBCOMP, UNSUBACK, AUTH
-    ReasonNormalDisconnection                 = 0   //0x00	正常断开	DISCONNECT
-    ReasonGrantedQoS0                         = 0   //0x00	授权的QoS 0	SUBACK
-    ReasonGrantedQoS1                         = 1   //0x01	授权的QoS 1	SUBACK
-    ReasonGrantedQoS2                         = 2   //0x02	授权的QoS 2	SUBACK
-    ReasonDisconnectWithWillMessage           = 4   //0x04	包含遗嘱的断开	DISCONNECT
-    ReasonNoMatchingSubscribers               = 16  //0x10	无匹配订阅	PUBACK, PUBREC
-    ReasonNoSubscriptionExisted               = 17  //0x11	订阅不存在	UNSUBACK
-    ReasonContinueAuthentication              = 24  //0x18	继续认证	AUTH
-    ReasonReauthenticate                      = 25  //0x19	重新认证	AUTH
-    ReasonUnspecifiedError                    = 128 //0x80	未指明的错误	CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT
-    ReasonMalformedPacket                     = 129 //0x81	无效报文	CONNACK, DISCONNECT
-    ReasonProtocolError                       = 130 //0x82	协议错误	CONNACK, DISCONNECT
-    ReasonImplementationSpecificError         = 131 //0x83	实现错误	CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT
-    ReasonUnsupportedProtocolVersion          = 132 //0x84	协议版本不支持	CONNACK
-    ReasonClientIdentifierNotValid            = 133 //0x85	客户标识符无效	CONNACK
-    ReasonBadUserNameOrPassword               = 134 //0x86	用户名密码错误	CONNACK
-    ReasonNotAuthorized                       = 135 //0x87	未授权	CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT
-    ReasonServerUnavailable                   = 136 //0x88	服务端不可用	CONNACK
-    ReasonServerBusy                          = 137 //0x89	服务端正忙	CONNACK, DISCONNECT
-    ReasonBanned                              = 138 //0x8A	禁止	CONNACK
-    ReasonServerShuttingDown                  = 139 //0x8B	服务端关闭中	DISCONNECT
-    ReasonBadAuthenticationMethod             = 140 //0x8C	无效的认证方法	CONNACK, DISCONNECT
-    ReasonKeepAliveTimeout                    = 141 //0x8D	保活超时	DISCONNECT
-    ReasonSessionTakenOver                    = 142 //0x8E	会话被接管	DISCONNECT
-    ReasonTopicFilterInvalid                  = 143 //0x8F	主题过滤器无效	SUBACK, UNSUBACK, DISCONNECT
-    ReasonTopicNameInvalid                    = 144 //0x90	主题名无效	CONNACK, PUBACK, PUBREC, DISCONNECT
-    ReasonPacketIdentifierInUse               = 145 //0x91	报文标识符已被占用	PUBACK, PUBREC, SUBACK, UNSUBACK
-    ReasonPacketIdentifierNotFound            = 146 //0x92	报文标识符无效	PUBREL, PUBCOMP
-    ReasonReceiveMaximumExceeded              = 147 //0x93	接收超出最大数量	DISCONNECT
-    ReasonTopicAliasInvalid                   = 148 //0x94	主题别名无效	DISCONNECT
-    ReasonPacketTooLarge                      = 149 //0x95	报文过长	CONNACK, DISCONNECT
-    ReasonMessageRateTooHigh                  = 150 //0x96	消息太过频繁	DISCONNECT
-    ReasonQuotaExceeded                       = 151 //0x97	超出配额	CONNACK, PUBACK, PUBREC, SUBACK, DISCONNECT
-    ReasonAdministrativeAction                = 152 //0x98	管理行为	DISCONNECT
-    ReasonPayloadFormatInvalid                = 153 //0x99	载荷格式无效	CONNACK, PUBACK, PUBREC, DISCONNECT
-    ReasonRetainNotSupported                  = 154 //0x9A	不支持保留	CONNACK, DISCONNECT
-    ReasonQoSNotSupported                     = 155 //0x9B	不支持的QoS等级	CONNACK, DISCONNECT
-    ReasonUseAnotherServer                    = 156 //0x9C	（临时）使用其他服务端	CONNACK, DISCONNECT
-    ReasonServerMoved                         = 157 //0x9D	服务端已（永久）移动	CONNACK, DISCONNECT
-    ReasonSharedSubscriptionsNotSupported     = 158 //0x9E	不支持共享订阅	SUBACK, DISCONNECT
-    ReasonConnectionRateExceeded              = 159 //0x9F	超出连接速率限制	CONNACK, DISCONNECT
-    ReasonMaximumConnectTime                  = 160 //0xA0	最大连接时间	DISCONNECT
-    ReasonSubscriptionIdentifiersNotSupported = 161 //0xA1	不支持订阅标识符	SUBACK, DISCONNECT
-    ReasonWildcardSubscriptionsNotSupported   = 162 //0xA2	不支持通配符订阅	SUBACK, DISCONNECT
+    ConnackAccepted                    = 0 //0x00	连接已接受
+    ConnackUnacceptableProtocolVersion = 1 //0x01	连接已拒绝，不支持的协议版本
+    ConnackIdentifierRejected          = 2 //0x02	连接已拒绝，不合格的客户端标识符
+    ConnackServerUnavailable           = 3 //0x03	连接已拒绝，服务端不可用
+    ConnackBadUserNameOrPassword       = 4 //0x04	连接已拒绝，无效的用户名或密码
+    ConnackNotAuthorized               = 5 //0x05	连接已拒绝，未授权
 )
 
+//将5.0的CONNACK原因码转换为3.1及3.1.1的CONNACK返回码。
+//3.1及3.1.1的CONNACK报文使用同一字段保存返回码，因此返回值也使用ReasonCode类型
+func ConnackReturnCode(reasonCode ReasonCode) ReasonCode {
+    switch reasonCode {
+    case ReasonSuccess:
+        return ConnackAccepted
+    case ReasonUnsupportedProtocolVersion:
+        return ConnackUnacceptableProtocolVersion
+    case ReasonClientIdentifierNotValid:
+        return ConnackIdentifierRejected
+    case ReasonBadUserNameOrPassword, ReasonBadAuthenticationMethod:
+        return ConnackBadUserNameOrPassword
+    case ReasonNotAuthorized, ReasonBanned:
+        return ConnackNotAuthorized
+    default:
+        return ConnackServerUnavailable
+    }
+}
+
+//3.1及3.1.1的CONNACK返回码对应的5.0原因码
+func ConnackReasonCode(returnCode ReasonCode) ReasonCode {
+    switch returnCode {
+    case ConnackAccepted:
+        return ReasonSuccess
+    case ConnackUnacceptableProtocolVersion:
+        return ReasonUnsupportedProtocolVersion
+    case ConnackIdentifierRejected:
+        return ReasonClientIdentifierNotValid
+    case ConnackBadUserNameOrPassword:
+        return ReasonBadUserNameOrPassword
+    case ConnackNotAuthorized:
+        return ReasonNotAuthorized
+    default:
+        return ReasonServerUnavailable
+    }
+}
+
+//原因码，值大于等于0x80表示失败
 type ReasonCode byte
+
+//原因码可以出现的报文类型，按报文类型编号置位
+const (
+    inCONNACK    = 1 << 2
+    inPUBACK     = 1 << 4
+    inPUBREC     = 1 << 5
+    inPUBREL     = 1 << 6
+    inPUBCOMP    = 1 << 7
+    inSUBACK     = 1 << 9
+    inUNSUBACK   = 1 << 11
+    inDISCONNECT = 1 << 14
+    inAUTH       = 1 << 15
+)
+
+type reasonCodeInfo struct {
+    name    string
+    packets uint16
+}
+
+var reasonCodes = map[ReasonCode]reasonCodeInfo{
+    ReasonSuccess:                             {"Success", inCONNACK | inPUBACK | inPUBREC | inPUBREL | inPUBCOMP | inSUBACK | inUNSUBACK | inDISCONNECT | inAUTH},
+    ReasonGrantedQoS1:                         {"Granted QoS 1", inSUBACK},
+    ReasonGrantedQoS2:                         {"Granted QoS 2", inSUBACK},
+    ReasonDisconnectWithWillMessage:           {"Disconnect with Will Message", inDISCONNECT},
+    ReasonNoMatchingSubscribers:               {"No matching subscribers", inPUBACK | inPUBREC},
+    ReasonNoSubscriptionExisted:               {"No subscription existed", inUNSUBACK},
+    ReasonContinueAuthentication:              {"Continue authentication", inAUTH},
+    ReasonReauthenticate:                      {"Re-authenticate", inAUTH},
+    ReasonUnspecifiedError:                    {"Unspecified error", inCONNACK | inPUBACK | inPUBREC | inSUBACK | inUNSUBACK | inDISCONNECT},
+    ReasonMalformedPacket:                     {"Malformed Packet", inCONNACK | inDISCONNECT},
+    ReasonProtocolError:                       {"Protocol Error", inCONNACK | inDISCONNECT},
+    ReasonImplementationSpecificError:         {"Implementation specific error", inCONNACK | inPUBACK | inPUBREC | inSUBACK | inUNSUBACK | inDISCONNECT},
+    ReasonUnsupportedProtocolVersion:          {"Unsupported Protocol Version", inCONNACK},
+    ReasonClientIdentifierNotValid:            {"Client Identifier not valid", inCONNACK},
+    ReasonBadUserNameOrPassword:               {"Bad User Name or Password", inCONNACK},
+    ReasonNotAuthorized:                       {"Not authorized", inCONNACK | inPUBACK | inPUBREC | inSUBACK | inUNSUBACK | inDISCONNECT},
+    ReasonServerUnavailable:                   {"Server unavailable", inCONNACK},
+    ReasonServerBusy:                          {"Server busy", inCONNACK | inDISCONNECT},
+    ReasonBanned:                              {"Banned", inCONNACK},
+    ReasonServerShuttingDown:                  {"Server shutting down", inDISCONNECT},
+    ReasonBadAuthenticationMethod:             {"Bad authentication method", inCONNACK | inDISCONNECT},
+    ReasonKeepAliveTimeout:                    {"Keep Alive timeout", inDISCONNECT},
+    ReasonSessionTakenOver:                    {"Session taken over", inDISCONNECT},
+    ReasonTopicFilterInvalid:                  {"Topic Filter invalid", inSUBACK | inUNSUBACK | inDISCONNECT},
+    ReasonTopicNameInvalid:                    {"Topic Name invalid", inCONNACK | inPUBACK | inPUBREC | inDISCONNECT},
+    ReasonPacketIdentifierInUse:               {"Packet Identifier in use", inPUBACK | inPUBREC | inSUBACK | inUNSUBACK},
+    ReasonPacketIdentifierNotFound:            {"Packet Identifier not found", inPUBREL | inPUBCOMP},
+    ReasonReceiveMaximumExceeded:              {"Receive Maximum exceeded", inDISCONNECT},
+    ReasonTopicAliasInvalid:                   {"Topic Alias invalid", inDISCONNECT},
+    ReasonPacketTooLarge:                      {"Packet too large", inCONNACK | inDISCONNECT},
+    ReasonMessageRateTooHigh:                  {"Message rate too high", inDISCONNECT},
+    ReasonQuotaExceeded:                       {"Quota exceeded", inCONNACK | inPUBACK | inPUBREC | inSUBACK | inDISCONNECT},
+    ReasonAdministrativeAction:                {"Administrative action", inDISCONNECT},
+    ReasonPayloadFormatInvalid:                {"Payload format invalid", inCONNACK | inPUBACK | inPUBREC | inDISCONNECT},
+    ReasonRetainNotSupported:                  {"Retain not supported", inCONNACK | inDISCONNECT},
+    ReasonQoSNotSupported:                     {"QoS not supported", inCONNACK | inDISCONNECT},
+    ReasonUseAnotherServer:                    {"Use another server", inCONNACK | inDISCONNECT},
+    ReasonServerMoved:                         {"Server moved", inCONNACK | inDISCONNECT},
+    ReasonSharedSubscriptionsNotSupported:     {"Shared Subscriptions not supported", inSUBACK | inDISCONNECT},
+    ReasonConnectionRateExceeded:              {"Connection rate exceeded", inCONNACK | inDISCONNECT},
+    ReasonMaximumConnectTime:                  {"Maximum connect time", inDISCONNECT},
+    ReasonSubscriptionIdentifiersNotSupported: {"Subscription Identifiers not supported", inSUBACK | inDISCONNECT},
+    ReasonWildcardSubscriptionsNotSupported:   {"Wildcard Subscriptions not supported", inSUBACK | inDISCONNECT},
+}
+
+//原因码的名称，0x00在不同报文中含义不同（成功、正常断开、授权的QoS 0），统一返回"Success"
+func (c ReasonCode) String() string {
+    if info, ok := reasonCodes[c]; ok {
+        return info.name
+    }
+    return fmt.Sprintf("Unknown reason code 0x%02X", byte(c))
+}
+
+//原因码是否表示失败（大于等于0x80）
+func (c ReasonCode) IsError() bool {
+    return c >= 0x80
+}
+
+//原因码是否可以出现在packetType类型的5.0报文中
+func (c ReasonCode) ValidFor(packetType byte) bool {
+    info, ok := reasonCodes[c]
+    if !ok || packetType > 15 {
+        return false
+    }
+    return info.packets&(1<<packetType) != 0
+}
@@ -1,3 +1,3 @@
 module mqtt
 
-go 1.12
+go 1.16
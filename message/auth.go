@@ -11,21 +11,21 @@ import (
     "io"
     "mqtt/errcode"
     "mqtt/packet"
-    "mqtt/util"
-    "strings"
 )
 
 type AuthVarHeader struct {
     //断开原因码
-    ReasonCode byte
-    props      []packet.Property
+    ReasonCode errcode.ReasonCode
+    props      packet.Properties
 }
 
 //AUTH报文被从客户端发送给服务端，或从服务端发送给客户端，作为扩展认证交换的一部分，比如质询/响应认证。
 //如果CONNECT报文不包含相同的认证方法，则客户端或服务端发送AUTH报文将造成协议错误（Protocol Error）。
 type AuthMessage struct {
     fixedHeader packet.FixedHeader
-    varHeader   DisconnectVarHeader
+    varHeader   AuthVarHeader
+    version     byte
+    codecOption
 }
 
 func (m *AuthMessage) SetFixedHeader(header packet.FixedHeader) {
@@ -33,55 +33,64 @@ func (m *AuthMessage) SetFixedHeader(header packet.FixedHeader) {
 }
 
 func (m *AuthMessage) GetFixedHeader() packet.FixedHeader {
-    w := new(util.CountWriter)
-    m.WriteVariableHeader(w)
-    m.WritePayload(w)
-    m.fixedHeader.Len = w.Count()
+    m.fixedHeader.Len = int64(m.VariableHeaderLength() + m.PayloadLength())
     return m.fixedHeader
 }
 
+func (msg *AuthMessage) VariableHeaderLength() int {
+    if !packet.IsV5(msg.version) || msg.varHeader.ReasonCode == 0 {
+        return 0
+    }
+    return 1 + msg.varHeader.props.Length()
+}
+
+func (msg *AuthMessage) PayloadLength() int {
+    return 0
+}
+
 func (msg *AuthMessage) ReadVariableHeader(r io.Reader) (int, error) {
-    if msg.fixedHeader.RemainLength() < 1 {
+    if msg.fixedHeader.RemainLength() < 1 || !packet.IsV5(msg.version) {
         return 0, nil
     }
-    buf := make([]byte, 1)
-    n, err := r.Read(buf)
+    code, n, err := packet.ReadByte(r)
     if err != nil {
         return n, err
     }
 
-    msg.varHeader.ReasonCode = buf[0]
+    msg.varHeader.ReasonCode = errcode.ReasonCode(code)
 
     if msg.fixedHeader.RemainLength() == int64(n) {
         return n, nil
     }
 
-    props, n2, err2 := packet.ReadProperties(r)
+    n2, err2 := msg.varHeader.props.Decode(r, msg.fixedHeader.Type(), msg.controlChars)
     n += n2
     if err2 != nil {
         return n, err2
     }
 
-    msg.varHeader.props = props
-
     return n, nil
 }
 
 func (msg *AuthMessage) WriteVariableHeader(w io.Writer) (int, error) {
+    if !packet.IsV5(msg.version) {
+        return 0, nil
+    }
+
     if msg.varHeader.ReasonCode == 0 {
-        if  len(msg.varHeader.props) == 0 {
+        if  msg.varHeader.props.IsEmpty() {
             return 0, nil
         } else {
             return 0, errcode.ProtocolError
         }
     }
 
-    n, err := w.Write([]byte{msg.varHeader.ReasonCode})
+    n, err := w.Write([]byte{byte(msg.varHeader.ReasonCode)})
     if err != nil {
         return n, err
     }
 
-    n2, err2 := packet.WriteProperties(w, msg.varHeader.props)
+    n2, err2 := msg.varHeader.props.Encode(w, msg.fixedHeader.Type(), msg.controlChars)
     return n + n2, err2
 }
 
@@ -93,22 +102,48 @@ func (msg *AuthMessage) WritePayload(w io.Writer) (int, error) {
     return 0, nil
 }
 
+//报文属性，可以直接读写属性字段
+func (m *AuthMessage) GetProperties() *packet.Properties {
+    return &m.varHeader.props
+}
+
 func (m *AuthMessage) Valid() bool {
-    return true
+    return m.Validate() == nil
+}
+
+func (m *AuthMessage) Validate() error {
+    if err := checkReasonCode(m.varHeader.ReasonCode, m.fixedHeader.Type(), m.version); err != nil {
+        return err
+    }
+    return m.varHeader.props.Check(m.fixedHeader.Type())
 }
 
 func NewAuthMessage() *AuthMessage {
     ret := &AuthMessage{
         fixedHeader: packet.CreateFixedHeader(packet.PktTypeAUTH, packet.PktFlagAUTH, 0),
+        version:     packet.MqttProtocolVersion,
     }
     return ret
 }
 
-func (m *AuthMessage) SetReasonCode(v byte) {
+func (m *AuthMessage) SetVersion(v byte) {
+    m.version = v
+}
+
+func (m *AuthMessage) GetVersion() byte {
+    return m.version
+}
+
+//原因码不能出现在该报文中时返回ReasonCodeInvalid，原有原因码保持不变
+func (m *AuthMessage) SetReasonCode(v errcode.ReasonCode) error {
+    if err := checkReasonCode(v, m.fixedHeader.Type(), m.version); err != nil {
+        return err
+    }
     m.varHeader.ReasonCode = v
+    return nil
 }
 
-func (m *AuthMessage) GetReasonCode() byte {
+func (m *AuthMessage) GetReasonCode() errcode.ReasonCode {
     return m.varHeader.ReasonCode
 }
 
@@ -116,100 +151,88 @@ func (m *AuthMessage) GetReasonCode() byte {
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
 func (m *AuthMessage) SetUserProperty(props map[string]string) {
     for k, v := range props {
-        p := &packet.PropUserProperty{}
         pair, err := packet.NewStringPair(k, v)
         if err == nil {
-            p.V = pair
-            m.varHeader.props = append(m.varHeader.props, p)
+            m.varHeader.props.UserProperty = append(m.varHeader.props.UserProperty, pair)
         }
     }
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
+//以map形式返回时不保持顺序，同名属性只保留最后一个值，需要完整的用户属性时使用GetUserProperties
 func (m *AuthMessage) GetUserProperty() (map[string]string, bool) {
     ret := map[string]string{}
-    packet.FindPropValues(packet.UserProperty, m.varHeader.props, func(property packet.Property) bool {
-        if property != nil {
-            p := property.(*packet.PropUserProperty)
-            ret[p.V[0].String()] = p.V[1].String()
-        }
-        return false
-    })
+    for _, p := range m.varHeader.props.UserProperty {
+        ret[p[0].String()] = p[1].String()
+    }
     return ret, len(ret) > 0
 }
 
+//按顺序追加用户属性，同一个名字可以出现多次
+func (m *AuthMessage) AddUserProperty(key, value string) error {
+    return m.varHeader.props.UserProperty.Add(key, value)
+}
+
+//按顺序获得所有用户属性
+func (m *AuthMessage) GetUserProperties() packet.UserProperties {
+    return m.varHeader.props.UserProperty
+}
+
+//替换所有用户属性，转发时保持原有顺序
+func (m *AuthMessage) SetUserProperties(props packet.UserProperties) {
+    m.varHeader.props.UserProperty = props
+}
+
 //以UTF-8编码的字符串，包含了认证方法（Authentication Method）名。
 //包含多个认证方法将造成协议错误（Protocol Error）。
 func (m *AuthMessage) SetAuthenticationMethod(v string) {
-    p := &packet.PropAuthenticationMethod{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.AuthenticationMethod = packet.StringPtr(v)
 }
 
 //以UTF-8编码的字符串，包含了认证方法（Authentication Method）名。
 //包含多个认证方法将造成协议错误（Protocol Error）。
 func (m *AuthMessage) GetAuthenticationMethod() (string, bool) {
-    p := packet.FindPropValue(packet.AuthenticationMethod, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.AuthenticationMethod == nil {
         return "", false
     }
-    return p.(*packet.PropAuthenticationMethod).V.String(), true
+    return m.varHeader.props.AuthenticationMethod.String(), true
 }
 
 
 //包含认证数据（Authentication Data）的二进制数据。此数据的内容由认证方法和已交换的认证数据状态定义。
 //包含多个认证数据将造成协议错误（Protocol Error）。
 func (m *AuthMessage) SetAuthenticationData(v []byte) {
-    p := &packet.PropAuthenticationData{}
-    s, err := packet.FromString(string(v))
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.AuthenticationData = v
 }
 
 //包含认证数据（Authentication Data）的二进制数据。此数据的内容由认证方法和已交换的认证数据状态定义。
 //包含多个认证数据将造成协议错误（Protocol Error）。
 func (m *AuthMessage) GetAuthenticationData() ([]byte, bool) {
-    p := packet.FindPropValue(packet.AuthenticationData, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.AuthenticationData == nil {
         return nil, false
     }
-    return []byte(p.(*packet.PropAuthenticationData).V.String()), true
+    return m.varHeader.props.AuthenticationData, true
 }
 
 
 //表示断开原因。此原因字符串是为诊断而设计的可读字符串，不应该被接收端所解析。
 func (m *AuthMessage) SetReasonString(v string) {
-    p := &packet.PropReasonString{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.ReasonString = packet.StringPtr(v)
 }
 
 //表示断开原因。此原因字符串是为诊断而设计的可读字符串，不应该被接收端所解析。
 func (m *AuthMessage) GetReasonString() (string, bool) {
-    p := packet.FindPropValue(packet.ReasonString, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.ReasonString == nil {
         return "", false
     }
-    return p.(*packet.PropReasonString).V.String(), true
+    return m.varHeader.props.ReasonString.String(), true
 }
 
 
 func (v *AuthVarHeader) String() string {
-    builder := strings.Builder{}
-    for i := range v.props {
-        builder.WriteString(fmt.Sprintf("\t%v\n", v.props[i]))
-    }
     return fmt.Sprintf("ReasonCode: %d \nprops:\n%s",
-        v.ReasonCode, builder.String())
+        v.ReasonCode, v.props.String())
 }
 
 func (v *AuthMessage) String() string {
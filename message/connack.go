@@ -9,74 +9,125 @@ package message
 import (
     "fmt"
     "io"
+    "mqtt/errcode"
     "mqtt/packet"
-    "mqtt/util"
-    "strings"
 )
 
 type ConnackVarHeader struct {
     //连接确认标志 Connect Acknowledge Flags
     AckFlag byte
     //连接原因码 Connect Reason Code
-    ReasonCode byte
+    ReasonCode errcode.ReasonCode
     //CONNACK属性 CONNACK Properties
-    props []packet.Property
+    props packet.Properties
 }
 
 type ConnackMessage struct {
     fixedHeader packet.FixedHeader
     varHeader   ConnackVarHeader
+    version     byte
+    codecOption
 }
 
 func NewConnackMessage() *ConnackMessage {
     ret := &ConnackMessage{
         fixedHeader: packet.CreateFixedHeader(packet.PktTypeCONNACK, packet.PktFlagCONNACK, 0),
+        version:     packet.MqttProtocolVersion,
     }
     return ret
 }
 
+//由错误创建CONNACK报文，原因码取自错误对应的原因（见errcode.ReasonOf），
+//不能出现在CONNACK报文中的原因码使用UnspecifiedError。3.1及3.1.1转换为对应的返回码，
+//5.0以错误信息作为原因字符串，err为nil时为连接已接受
+func NewConnackMessageFromError(err error, version byte) *ConnackMessage {
+    ret := NewConnackMessage()
+    ret.SetVersion(version)
+    if err == nil {
+        return ret
+    }
+    code := errcode.ReasonOf(err).Code
+    if !code.ValidFor(packet.PktTypeCONNACK) {
+        code = errcode.ReasonUnspecifiedError
+    }
+    if !packet.IsV5(version) {
+        ret.SetReasonCode(errcode.ConnackReturnCode(code))
+        return ret
+    }
+    ret.SetReasonCode(code)
+    ret.SetReasonString(err.Error())
+    return ret
+}
+
+func (m *ConnackMessage) SetVersion(v byte) {
+    m.version = v
+}
+
+func (m *ConnackMessage) GetVersion() byte {
+    return m.version
+}
+
 func (m *ConnackMessage) SetFixedHeader(header packet.FixedHeader) {
     m.fixedHeader = header
 }
 
 func (m *ConnackMessage) GetFixedHeader() packet.FixedHeader {
-    w := new(util.CountWriter)
-    m.WriteVariableHeader(w)
-    m.WritePayload(w)
-    m.fixedHeader.Len = w.Count()
+    m.fixedHeader.Len = int64(m.VariableHeaderLength() + m.PayloadLength())
     return m.fixedHeader
 }
 
+func (msg *ConnackMessage) VariableHeaderLength() int {
+    if !packet.IsV5(msg.version) {
+        return 2
+    }
+    return 2 + msg.varHeader.props.Length()
+}
+
+func (msg *ConnackMessage) PayloadLength() int {
+    return 0
+}
+
 func (msg *ConnackMessage) ReadVariableHeader(r io.Reader) (int, error) {
-    buf := make([]byte, 2)
-    n, err := r.Read(buf)
+    buf, n, err := packet.ReadN(r, 2)
     if err != nil {
         return n, err
     }
 
     msg.varHeader.AckFlag = buf[0]
-    msg.varHeader.ReasonCode = buf[1]
+    msg.varHeader.ReasonCode = errcode.ReasonCode(buf[1])
 
-    props, n2, err2 := packet.ReadProperties(r)
+    if !packet.IsV5(msg.version) {
+        return n, nil
+    }
+
+    n2, err2 := msg.varHeader.props.Decode(r, msg.fixedHeader.Type(), msg.controlChars)
     n += n2
     if err2 != nil {
         return n, err2
     }
-    msg.varHeader.props = props
 
     return n, nil
 }
 
 func (msg *ConnackMessage) WriteVariableHeader(w io.Writer) (int, error) {
+    flag := msg.varHeader.AckFlag
+    //3.1的连接确认标志为保留字节，必须为0
+    if msg.version == packet.MqttProtocolVersion31 {
+        flag = 0
+    }
     n, err := w.Write([]byte{
-        msg.varHeader.AckFlag,
-        msg.varHeader.ReasonCode,
+        flag,
+        byte(msg.varHeader.ReasonCode),
     })
     if err != nil {
         return n, err
     }
 
-    n2, err2 := packet.WriteProperties(w, msg.varHeader.props)
+    if !packet.IsV5(msg.version) {
+        return n, nil
+    }
+
+    n2, err2 := msg.varHeader.props.Encode(w, msg.fixedHeader.Type(), msg.controlChars)
     return n + n2, err2
 }
 
@@ -96,361 +147,319 @@ func (m *ConnackMessage) GetAckFlag() byte{
     return m.varHeader.AckFlag
 }
 
-func (m *ConnackMessage) SetReasonCode(v byte) {
+//5.0为连接原因码，3.1及3.1.1为连接返回码（见errcode.ConnackReturnCode），因此需要先设置协议版本。
+//原因码不能出现在CONNACK报文中时返回ReasonCodeInvalid，原有原因码保持不变
+func (m *ConnackMessage) SetReasonCode(v errcode.ReasonCode) error {
+    if err := checkReasonCode(v, m.fixedHeader.Type(), m.version); err != nil {
+        return err
+    }
     m.varHeader.ReasonCode = v
+    return nil
 }
 
-func (m *ConnackMessage) GetReasonCode() byte{
+func (m *ConnackMessage) GetReasonCode() errcode.ReasonCode {
     return m.varHeader.ReasonCode
 }
 
 // 会话过期间隔 Session Expiry Interval,四字节整数表示的以秒为单位的会话过期间隔
 func (m *ConnackMessage) SetSessionExpiryInterval(v uint32) {
-    p := &packet.PropSessionExpiryInterval{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.SessionExpiryInterval = &v
 }
 
 // 会话过期间隔 Session Expiry Interval,四字节整数表示的以秒为单位的会话过期间隔
 func (m *ConnackMessage) GetSessionExpiryInterval() (uint32, bool) {
-    p := packet.FindPropValue(packet.SessionExpiryInterval, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.SessionExpiryInterval == nil {
         return 0, false
     }
-    return p.(*packet.PropSessionExpiryInterval).V, true
+    return *m.varHeader.props.SessionExpiryInterval, true
 }
 
 //双字节整数表示的最大接收值
 func (m *ConnackMessage) SetReceiveMaximum(v uint16) {
-    p := &packet.PropReceiveMaximum{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.ReceiveMaximum = &v
 }
 
 //双字节整数表示的最大接收值
 func (m *ConnackMessage) GetReceiveMaximum() (uint16, bool) {
-    p := packet.FindPropValue(packet.ReceiveMaximum, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.ReceiveMaximum == nil {
         return 0, false
     }
-    return p.(*packet.PropReceiveMaximum).V, true
+    return *m.varHeader.props.ReceiveMaximum, true
 }
 
 //用一个字节表示的0或1。包含多个最大服务质量（Maximum QoS）或最大服务质量既不为0也不为1将造成协议错误。
 // 如果没有设置最大服务质量，客户端可使用最大QoS为2。
 func (m *ConnackMessage) SetMaximumQoS(v byte) {
-    p := &packet.PropMaximumQoS{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.MaximumQoS = &v
 }
 
 //用一个字节表示的0或1。包含多个最大服务质量（Maximum QoS）或最大服务质量既不为0也不为1将造成协议错误。
 // 如果没有设置最大服务质量，客户端可使用最大QoS为2。
 func (m *ConnackMessage) GetMaximumQoS() (byte, bool) {
-    p := packet.FindPropValue(packet.MaximumQoS, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.MaximumQoS == nil {
         return 0, false
     }
-    return p.(*packet.PropMaximumQoS).V, true
+    return *m.varHeader.props.MaximumQoS, true
 }
 
 //一个单字节字段，用来声明服务端是否支持保留消息。值为0表示不支持保留消息，为1表示支持保留消息。
 //如果没有设置保留可用字段，表示支持保留消息。包含多个保留可用字段或保留可用字段值不为0也不为1将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) SetRetainAvailable(v byte) {
-    p := &packet.PropRetainAvailable{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.RetainAvailable = &v
 }
 
 //一个单字节字段，用来声明服务端是否支持保留消息。值为0表示不支持保留消息，为1表示支持保留消息。
 //如果没有设置保留可用字段，表示支持保留消息。包含多个保留可用字段或保留可用字段值不为0也不为1将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) GetRetainAvailable() (byte, bool) {
-    p := packet.FindPropValue(packet.RetainAvailable, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.RetainAvailable == nil {
         return 0, false
     }
-    return p.(*packet.PropRetainAvailable).V, true
+    return *m.varHeader.props.RetainAvailable, true
 }
 
 //四字节整数表示的服务端愿意接收的最大报文长度（Maximum Packet Size）。
 //如果没有设置最大报文长度，则按照协议由固定报头中的剩余长度可编码最大值和协议报头对数据包的大小做限制。
 func (m *ConnackMessage) SetMaximumPacketSize(v uint32) {
-    p := &packet.PropMaximumPacketSize{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.MaximumPacketSize = &v
 }
 
 //四字节整数表示的服务端愿意接收的最大报文长度（Maximum Packet Size）。
 //如果没有设置最大报文长度，则按照协议由固定报头中的剩余长度可编码最大值和协议报头对数据包的大小做限制。
 func (m *ConnackMessage) GetMaximumPacketSize() (uint32, bool) {
-    p := packet.FindPropValue(packet.MaximumPacketSize, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.MaximumPacketSize == nil {
         return 0, false
     }
-    return p.(*packet.PropMaximumPacketSize).V, true
+    return *m.varHeader.props.MaximumPacketSize, true
 }
 
 //UTF-8编码的分配客户标识符（Assigned Client Identifier）字符串。
 //包含多个分配客户标识符将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) SetAssignedClientIdentifier(v string) {
-    p := &packet.PropAssignedClientIdentifier{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.AssignedClientIdentifier = packet.StringPtr(v)
 }
 
 //UTF-8编码的分配客户标识符（Assigned Client Identifier）字符串。
 //包含多个分配客户标识符将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) GetAssignedClientIdentifier() (string, bool) {
-    p := packet.FindPropValue(packet.AssignedClientIdentifier, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.AssignedClientIdentifier == nil {
         return "", false
     }
-    return p.(*packet.PropAssignedClientIdentifier).V.String(), true
+    return m.varHeader.props.AssignedClientIdentifier.String(), true
 }
 
 //双字节整数表示的主题别名最大值（Topic Alias Maximum）。
 // 包含多个主题别名最大值（Topic Alias Maximum）将造成协议错误（Protocol Error）。
 // 没有设置主题别名最大值属性的情况下，主题别名最大值默认为零。
 func (m *ConnackMessage) SetTopicAliasMaximum(v uint16) {
-    p := &packet.PropTopicAliasMaximum{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.TopicAliasMaximum = &v
 }
 
 //双字节整数表示的主题别名最大值（Topic Alias Maximum）。
 // 包含多个主题别名最大值（Topic Alias Maximum）将造成协议错误（Protocol Error）。
 // 没有设置主题别名最大值属性的情况下，主题别名最大值默认为零。
 func (m *ConnackMessage) GetTopicAliasMaximum() (uint16, bool) {
-    p := packet.FindPropValue(packet.TopicAliasMaximum, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.TopicAliasMaximum == nil {
         return 0, false
     }
-    return p.(*packet.PropTopicAliasMaximum).V, true
+    return *m.varHeader.props.TopicAliasMaximum, true
 }
 
 //UTF-8编码的字符串，表示此次响应相关的原因。
 // 此原因字符串（Reason String）是为诊断而设计的可读字符串，不应该被客户端所解析。
 func (m *ConnackMessage) SetReasonString(v string) {
-    p := &packet.PropReasonString{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.ReasonString = packet.StringPtr(v)
 }
 
 //UTF-8编码的字符串，表示此次响应相关的原因。
 // 此原因字符串（Reason String）是为诊断而设计的可读字符串，不应该被客户端所解析。
 func (m *ConnackMessage) GetReasonString() (string, bool) {
-    p := packet.FindPropValue(packet.ReasonString, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.ReasonString == nil {
         return "", false
     }
-    return p.(*packet.PropReasonString).V.String(), true
+    return m.varHeader.props.ReasonString.String(), true
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
 func (m *ConnackMessage) SetUserProperty(props map[string]string) {
     for k, v := range props {
-        p := &packet.PropUserProperty{}
         pair, err := packet.NewStringPair(k, v)
         if err == nil {
-            p.V = pair
-            m.varHeader.props = append(m.varHeader.props, p)
+            m.varHeader.props.UserProperty = append(m.varHeader.props.UserProperty, pair)
         }
     }
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
+//以map形式返回时不保持顺序，同名属性只保留最后一个值，需要完整的用户属性时使用GetUserProperties
 func (m *ConnackMessage) GetUserProperty() (map[string]string, bool) {
     ret := map[string]string{}
-    packet.FindPropValues(packet.UserProperty, m.varHeader.props, func(property packet.Property) bool {
-        if property != nil {
-            p := property.(*packet.PropUserProperty)
-            ret[p.V[0].String()] = p.V[1].String()
-        }
-        return false
-    })
+    for _, p := range m.varHeader.props.UserProperty {
+        ret[p[0].String()] = p[1].String()
+    }
     return ret, len(ret) > 0
 }
 
+//按顺序追加用户属性，同一个名字可以出现多次
+func (m *ConnackMessage) AddUserProperty(key, value string) error {
+    return m.varHeader.props.UserProperty.Add(key, value)
+}
+
+//按顺序获得所有用户属性
+func (m *ConnackMessage) GetUserProperties() packet.UserProperties {
+    return m.varHeader.props.UserProperty
+}
+
+//替换所有用户属性，转发时保持原有顺序
+func (m *ConnackMessage) SetUserProperties(props packet.UserProperties) {
+    m.varHeader.props.UserProperty = props
+}
+
 //单字节字段，用来声明服务器是否支持通配符订阅（Wildcard Subscriptions）。值为0表示不支持通配符订阅，值为1表示支持通配符订阅。
 //如果没有设置此值，则表示支持通配符订阅。
 //包含多个通配符订阅可用属性，或通配符订阅可用属性值不为0也不为1将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) SetWildcardSubscriptionAvailable(v byte) {
-    p := &packet.PropWildcardSubscriptionAvailable{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.WildcardSubscriptionAvailable = &v
 }
 
 //单字节字段，用来声明服务器是否支持通配符订阅（Wildcard Subscriptions）。值为0表示不支持通配符订阅，值为1表示支持通配符订阅。
 //如果没有设置此值，则表示支持通配符订阅。
 //包含多个通配符订阅可用属性，或通配符订阅可用属性值不为0也不为1将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) GetWildcardSubscriptionAvailable() (byte, bool) {
-    p := packet.FindPropValue(packet.WildcardSubscriptionAvailable, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.WildcardSubscriptionAvailable == nil {
         return 0, false
     }
-    return p.(*packet.PropWildcardSubscriptionAvailable).V, true
+    return *m.varHeader.props.WildcardSubscriptionAvailable, true
 }
 
 //单字节字段，用来声明服务端是否支持订阅标识符（Subscription Identifiers）。
 // 值为0表示不支持订阅标识符，值为1表示支持订阅标识符。如果没有设置此值，则表示支持订阅标识符。
 // 包含多个订阅标识符可用属性，或订阅标识符可用属性值不为0也不为1将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) SetSubscriptionIdentifierAvailable(v byte) {
-    p := &packet.PropSubscriptionIdentifierAvailable{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.SubscriptionIdentifierAvailable = &v
 }
 
 //单字节字段，用来声明服务端是否支持订阅标识符（Subscription Identifiers）。
 // 值为0表示不支持订阅标识符，值为1表示支持订阅标识符。如果没有设置此值，则表示支持订阅标识符。
 // 包含多个订阅标识符可用属性，或订阅标识符可用属性值不为0也不为1将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) GetSubscriptionIdentifierAvailable() (byte, bool) {
-    p := packet.FindPropValue(packet.SubscriptionIdentifierAvailable, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.SubscriptionIdentifierAvailable == nil {
         return 0, false
     }
-    return p.(*packet.PropSubscriptionIdentifierAvailable).V, true
+    return *m.varHeader.props.SubscriptionIdentifierAvailable, true
 }
 
 //单字节字段，用来声明服务端是否支持共享订阅（Shared Subscription）。
 //值为0表示不支持共享订阅，值为1表示支持共享订阅。如果没有设置此值，则表示支持共享订阅。
 //包含多个共享订阅可用（Shared Subscription Available），或共享订阅可用属性值不为0也不为1将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) SetSharedSubscriptionAvailable(v byte) {
-    p := &packet.PropSharedSubscriptionAvailable{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.SharedSubscriptionAvailable = &v
 }
 
 //单字节字段，用来声明服务端是否支持共享订阅（Shared Subscription）。
 //值为0表示不支持共享订阅，值为1表示支持共享订阅。如果没有设置此值，则表示支持共享订阅。
 //包含多个共享订阅可用（Shared Subscription Available），或共享订阅可用属性值不为0也不为1将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) GetSharedSubscriptionAvailable() (byte, bool) {
-    p := packet.FindPropValue(packet.SharedSubscriptionAvailable, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.SharedSubscriptionAvailable == nil {
         return 0, false
     }
-    return p.(*packet.PropSharedSubscriptionAvailable).V, true
+    return *m.varHeader.props.SharedSubscriptionAvailable, true
 }
 
 //保持连接（Keep Alive）时间。
 func (m *ConnackMessage) SetServerKeepAlive(v uint16) {
-    p := &packet.PropServerKeepAlive{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.ServerKeepAlive = &v
 }
 
 //保持连接（Keep Alive）时间。
 func (m *ConnackMessage) GetServerKeepAlive() (uint16, bool) {
-    p := packet.FindPropValue(packet.ServerKeepAlive, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.ServerKeepAlive == nil {
         return 0, false
     }
-    return p.(*packet.PropServerKeepAlive).V, true
+    return *m.varHeader.props.ServerKeepAlive, true
 }
 
 //以UTF-8编码的字符串，作为创建响应主题（Response Topic）的基本信息。
 // 包含多个响应信息将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) SetResponseInformation(v string) {
-    p := &packet.PropResponseInformation{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.ResponseInformation = packet.StringPtr(v)
 }
 
 //以UTF-8编码的字符串，作为创建响应主题（Response Topic）的基本信息。
 // 包含多个响应信息将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) GetResponseInformation() (string, bool) {
-    p := packet.FindPropValue(packet.ResponseInformation, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.ResponseInformation == nil {
         return "", false
     }
-    return p.(*packet.PropResponseInformation).V.String(), true
+    return m.varHeader.props.ResponseInformation.String(), true
 }
 
 //以UTF-8编码的字符串，可以被客户端用来标识其他可用的服务端。
 //包含多个服务端参考（Server Reference）将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) SetServerReference(v string) {
-    p := &packet.PropServerReference{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.ServerReference = packet.StringPtr(v)
 }
 
 //以UTF-8编码的字符串，可以被客户端用来标识其他可用的服务端。
 //包含多个服务端参考（Server Reference）将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) GetServerReference() (string, bool) {
-    p := packet.FindPropValue(packet.ServerReference, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.ServerReference == nil {
         return "", false
     }
-    return p.(*packet.PropServerReference).V.String(), true
+    return m.varHeader.props.ServerReference.String(), true
 }
 
 //以UTF-8编码的字符串，包含了认证方法（Authentication Method）名。
 //包含多个认证方法将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) SetAuthenticationMethod(v string) {
-    p := &packet.PropAuthenticationMethod{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.AuthenticationMethod = packet.StringPtr(v)
 }
 
 //以UTF-8编码的字符串，包含了认证方法（Authentication Method）名。
 //包含多个认证方法将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) GetAuthenticationMethod() (string, bool) {
-    p := packet.FindPropValue(packet.AuthenticationMethod, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.AuthenticationMethod == nil {
         return "", false
     }
-    return p.(*packet.PropAuthenticationMethod).V.String(), true
+    return m.varHeader.props.AuthenticationMethod.String(), true
 }
 
 
 //包含认证数据（Authentication Data）的二进制数据。此数据的内容由认证方法和已交换的认证数据状态定义。
 //包含多个认证数据将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) SetAuthenticationData(v []byte) {
-    p := &packet.PropAuthenticationData{}
-    s, err := packet.FromString(string(v))
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.AuthenticationData = v
 }
 
 //包含认证数据（Authentication Data）的二进制数据。此数据的内容由认证方法和已交换的认证数据状态定义。
 //包含多个认证数据将造成协议错误（Protocol Error）。
 func (m *ConnackMessage) GetAuthenticationData() ([]byte, bool) {
-    p := packet.FindPropValue(packet.AuthenticationData, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.AuthenticationData == nil {
         return nil, false
     }
-    return []byte(p.(*packet.PropAuthenticationData).V.String()), true
+    return m.varHeader.props.AuthenticationData, true
+}
+
+//报文属性，可以直接读写属性字段
+func (m *ConnackMessage) GetProperties() *packet.Properties {
+    return &m.varHeader.props
 }
 
 func (m *ConnackMessage) Valid() bool {
-    return true
+    return m.Validate() == nil
 }
 
-func (v *ConnackVarHeader) String() string {
-    builder := strings.Builder{}
-    for i := range v.props {
-        builder.WriteString(fmt.Sprintf("\t%v\n", v.props[i]))
+func (m *ConnackMessage) Validate() error {
+    if err := checkReasonCode(m.varHeader.ReasonCode, m.fixedHeader.Type(), m.version); err != nil {
+        return err
     }
+    return m.varHeader.props.Check(m.fixedHeader.Type())
+}
+
+func (v *ConnackVarHeader) String() string {
     return fmt.Sprintf("AckFlag: %d ReasonCode: %d \nprops:\n%s",
-        v.AckFlag, v.ReasonCode, builder.String())
+        v.AckFlag, v.ReasonCode, v.props.String())
 }
 
 func (v *ConnackMessage) String() string {
@@ -7,12 +7,11 @@
 package message
 
 import (
+    "encoding/binary"
     "fmt"
     "io"
     "mqtt/errcode"
     "mqtt/packet"
-    "mqtt/util"
-    "strings"
 )
 
 type ConnectVarHeader struct {
@@ -20,12 +19,12 @@ type ConnectVarHeader struct {
     ProtocolVersion byte
     Flag            byte
     KeepAlive       uint16
-    props           []packet.Property
+    props           packet.Properties
 }
 
 type ConnectPayload struct {
     ClientId    packet.String
-    WillProps   []packet.Property
+    WillProps   packet.Properties
     WillTopic   packet.String
     WillPayload packet.Bytes
     Username    packet.String
@@ -38,37 +37,49 @@ type ConnectMessage struct {
     fixedHeader packet.FixedHeader
     varHeader   ConnectVarHeader
     payload     ConnectPayload
+    codecOption
 }
 
 func (msg *ConnectMessage) ReadVariableHeader(r io.Reader) (int, error) {
-    s, n, err := packet.ParseString(r)
+    s, n, err := packet.ParseString(r, msg.controlChars)
     if err != nil {
         return n, err
     }
-    if n != 6 || packet.MqttProtocolName != s.String() {
+    //3.1.1及5.0的协议名为MQTT，3.1的协议名为MQIsdp
+    if s.String() != packet.MqttProtocolName && s.String() != packet.MqttProtocolNameV31 {
         return n, errcode.ProtocolNameError
     }
     msg.varHeader.ProtocolName = *s
-    buf := make([]byte, 4)
-    n2, err2 := r.Read(buf)
+    buf, n2, err2 := packet.ReadN(r, 4)
     if err2 != nil {
         return n + n2, err2
     }
     msg.varHeader.ProtocolVersion = buf[0]
     msg.varHeader.Flag = buf[1]
-    msg.varHeader.KeepAlive = uint16(buf[2]<<8) | uint16(buf[3])
+    msg.varHeader.KeepAlive = binary.BigEndian.Uint16(buf[2:])
 
-    props, n3, err3 := packet.ReadProperties(r)
+    //CONNECT报文的协议版本决定后续编解码方式
+    if !packet.ValidVersion(msg.varHeader.ProtocolVersion) {
+        return n + n2, errcode.UnsupportedProtocolVersion
+    }
+    expect := packet.ProtocolName(msg.varHeader.ProtocolVersion)
+    if expect.String() != s.String() {
+        return n + n2, errcode.UnsupportedProtocolVersion
+    }
+    if !packet.IsV5(msg.varHeader.ProtocolVersion) {
+        return n + n2, nil
+    }
+
+    n3, err3 := msg.varHeader.props.Decode(r, msg.fixedHeader.Type(), msg.controlChars)
     if err3 != nil {
         return n + n2 + n3, err3
     }
-    msg.varHeader.props = props
 
     return n + n2 + n3, nil
 }
 
 func (msg *ConnectMessage) WriteVariableHeader(w io.Writer) (int, error) {
-    n, err := packet.WriteString(w, msg.varHeader.ProtocolName)
+    n, err := packet.WriteString(w, msg.varHeader.ProtocolName, msg.controlChars)
     if err != nil {
         return n, err
     }
@@ -83,25 +94,33 @@ func (msg *ConnectMessage) WriteVariableHeader(w io.Writer) (int, error) {
         return n + n2, err2
     }
 
-    n3, err3 := packet.WriteProperties(w, msg.varHeader.props)
+    if !packet.IsV5(msg.varHeader.ProtocolVersion) {
+        return n + n2, nil
+    }
+
+    n3, err3 := msg.varHeader.props.Encode(w, msg.fixedHeader.Type(), msg.controlChars)
     return n + n2 + n3, err3
 }
 
 func (msg *ConnectMessage) ReadPayload(r io.Reader) (int, error) {
-    s, n, err := packet.ParseString(r)
+    s, n, err := packet.ParseString(r, msg.controlChars)
     if err != nil {
         return n, err
     }
     msg.payload.ClientId = *s
+    if !msg.validClientId() {
+        return n, errcode.ClientIdentifierNotValid
+    }
     if msg.IsWillEnable() {
-        props, n2, err2 := packet.ReadProperties(r)
-        n += n2
-        if err2 != nil {
-            return n, err2
+        if packet.IsV5(msg.varHeader.ProtocolVersion) {
+            n2, err2 := msg.payload.WillProps.Decode(r, packet.WillPropertiesOwner, msg.controlChars)
+            n += n2
+            if err2 != nil {
+                return n, err2
+            }
         }
-        msg.payload.WillProps = props
 
-        s, n3, err3 := packet.ParseString(r)
+        s, n3, err3 := packet.ParseString(r, msg.controlChars)
         n += n3
         if err3 != nil {
             return n, err3
@@ -117,7 +136,7 @@ func (msg *ConnectMessage) ReadPayload(r io.Reader) (int, error) {
     }
 
     if msg.haveUsername() {
-        s, n5, err5 := packet.ParseString(r)
+        s, n5, err5 := packet.ParseString(r, msg.controlChars)
         n += n5
         if err5 != nil {
             return n, err5
@@ -138,19 +157,24 @@ func (msg *ConnectMessage) ReadPayload(r io.Reader) (int, error) {
 }
 
 func (msg *ConnectMessage) WritePayload(w io.Writer) (int, error) {
-    n, err := packet.WriteString(w, msg.payload.ClientId)
+    if !msg.validClientId() {
+        return 0, errcode.ClientIdentifierNotValid
+    }
+    n, err := packet.WriteString(w, msg.payload.ClientId, msg.controlChars)
     if err != nil {
         return n, err
     }
 
     if msg.IsWillEnable() {
-        n2, err2 := packet.WriteProperties(w, msg.payload.WillProps)
-        n += n2
-        if err2 != nil {
-            return n, err2
+        if packet.IsV5(msg.varHeader.ProtocolVersion) {
+            n2, err2 := msg.payload.WillProps.Encode(w, packet.WillPropertiesOwner, msg.controlChars)
+            n += n2
+            if err2 != nil {
+                return n, err2
+            }
         }
 
-        n3, err3 := packet.WriteString(w, msg.payload.WillTopic)
+        n3, err3 := packet.WriteString(w, msg.payload.WillTopic, msg.controlChars)
         n += n3
         if err3 != nil {
             return n, err3
@@ -164,7 +188,7 @@ func (msg *ConnectMessage) WritePayload(w io.Writer) (int, error) {
     }
 
     if msg.haveUsername() {
-        n5, err5 := packet.WriteString(w, msg.payload.Username)
+        n5, err5 := packet.WriteString(w, msg.payload.Username, msg.controlChars)
         n += n5
         if err5 != nil {
             return n, err5
@@ -196,23 +220,57 @@ func (m *ConnectMessage) SetFixedHeader(header packet.FixedHeader) {
 }
 
 func (m *ConnectMessage) GetFixedHeader() packet.FixedHeader {
-    w := new(util.CountWriter)
-    m.WriteVariableHeader(w)
-    m.WritePayload(w)
-    m.fixedHeader.Len = w.Count()
+    m.fixedHeader.Len = int64(m.VariableHeaderLength() + m.PayloadLength())
     return m.fixedHeader
 }
 
+func (msg *ConnectMessage) VariableHeaderLength() int {
+    n := int(msg.varHeader.ProtocolName.AllLength()) + 4
+    if !packet.IsV5(msg.varHeader.ProtocolVersion) {
+        return n
+    }
+    return n + msg.varHeader.props.Length()
+}
+
+func (msg *ConnectMessage) PayloadLength() int {
+    n := int(msg.payload.ClientId.AllLength())
+    if msg.IsWillEnable() {
+        if packet.IsV5(msg.varHeader.ProtocolVersion) {
+            n += msg.payload.WillProps.Length()
+        }
+        n += int(msg.payload.WillTopic.AllLength())
+        n += int(msg.payload.WillPayload.AllLength())
+    }
+    if msg.haveUsername() {
+        n += int(msg.payload.Username.AllLength())
+    }
+    if msg.havePassword() {
+        n += int(msg.payload.Password.AllLength())
+    }
+    return n
+}
+
 //如果遗嘱标志（Will Flag）设置为0，遗嘱服务质量（Will QoS）必须也设置为0（0x00）
 //如果遗嘱标志设置为1，遗嘱服务质量可以被设置为0（0x00），1（0x01）或2（0x02）
 //设置为3（0x03）的报文是无效报文。
 func (m *ConnectMessage) SetWillQos(v byte) {
+    m.varHeader.Flag &= 0xFF & ^(0x3 << 3)
     m.varHeader.Flag |= (v & 0x3) << 3
 }
 
-//表示协议修订级别
+func (m *ConnectMessage) GetWillQos() byte {
+    return (m.varHeader.Flag >> 3) & 0x3
+}
+
+//表示协议修订级别，同时设置对应的协议名
 func (m *ConnectMessage) SetVersion(v byte) {
     m.varHeader.ProtocolVersion = v
+    m.varHeader.ProtocolName = packet.ProtocolName(v)
+}
+
+//表示协议修订级别，3.1.1为4，5.0为5
+func (m *ConnectMessage) GetVersion() byte {
+    return m.varHeader.ProtocolVersion
 }
 
 //二进制位表明此次连接是一个新的会话还是一个已存在的会话的延续。
@@ -225,7 +283,7 @@ func (m *ConnectMessage) SetCleanStart(v bool) {
 }
 
 func (m *ConnectMessage) IsCleanStart() bool{
-    return (m.varHeader.Flag) >> 1 & 0xFE != 0
+    return m.varHeader.Flag&(1<<1) != 0
 }
 
 //如果遗嘱标志（Will Flag）被设置为1，表示遗嘱消息必须已存储在服务端与此客户标识符相关的会话中
@@ -238,7 +296,7 @@ func (m *ConnectMessage) SetWillEnable(v bool) {
 }
 
 func (m *ConnectMessage) IsWillEnable() bool {
-    return int(m.varHeader.Flag) & ^(1 << 2) != 0
+    return m.varHeader.Flag&(1<<2) != 0
 }
 
 func (m *ConnectMessage) SetClientId(v string) {
@@ -249,15 +307,36 @@ func (m *ConnectMessage) GetClientId() string {
     return m.payload.ClientId.String()
 }
 
+//MQTT 3.1的客户标识符长度必须在1到23个字节之间
+func (m *ConnectMessage) validClientId() bool {
+    if m.varHeader.ProtocolVersion != packet.MqttProtocolVersion31 {
+        return true
+    }
+    l := m.payload.ClientId.Length()
+    return l > 0 && l <= packet.MaxClientIdLengthV31
+}
+
 //保持连接（Keep Alive）使用双字节整数来表示以秒为单位的时间间隔。
 //它是指在客户端传输完成一个MQTT控制报文的时刻到发送下一个报文的时刻，两者之间允许空闲的最大时间间隔。
 func (m *ConnectMessage) SetKeepAlive(v uint16) {
     m.varHeader.KeepAlive = v
 }
 
+func (m *ConnectMessage) GetKeepAlive() uint16 {
+    return m.varHeader.KeepAlive
+}
+
 //此位指定遗嘱消息（Will Message）在发布时是否会被保留。
 func (m *ConnectMessage) SetWillRetain(v bool) {
-    m.varHeader.Flag |= 1 << 5
+    if v {
+        m.varHeader.Flag |= 1 << 5
+    } else {
+        m.varHeader.Flag &= 0xFF & ^(1 << 5)
+    }
+}
+
+func (m *ConnectMessage) IsWillRetain() bool {
+    return m.varHeader.Flag&(1<<5) != 0
 }
 
 func (m *ConnectMessage) SetWillTopic(v string) {
@@ -280,7 +359,7 @@ func (m *ConnectMessage) GetUsername() string {
 }
 
 func (m *ConnectMessage) haveUsername() bool {
-    return int(m.varHeader.Flag) & ^(1 << 7) != 0
+    return m.varHeader.Flag&(1<<7) != 0
 }
 
 //如果密码标志（Password Flag）被设置为0，有效载荷中不能包含密码字段 [MQTT-3.1.2-18]。
@@ -295,324 +374,315 @@ func (m *ConnectMessage) GetPassword() []byte {
 }
 
 func (m *ConnectMessage) havePassword() bool {
-    return int(m.varHeader.Flag) & ^(1 << 6) != 0
+    return m.varHeader.Flag&(1<<6) != 0
 }
 //以秒为单位的会话过期间隔（Session Expiry Interval）。包含多个会话过期间隔（Session Expiry Interval）将造成协议错误（Protocol Error）。
 //如果会话过期间隔（Session Expiry Interval）值未指定，则使用0。如果设置为0或者未指定，会话将在网络连接（Network Connection）关闭时结束。
 //如果会话过期间隔（Session Expiry Interval）为0xFFFFFFFF (UINT_MAX)，则会话永不过期。
 //如果网络连接关闭时会话过期间隔（Session Expiry Interval）大于0，则客户端与服务端必须存储会话状态
 func (m *ConnectMessage) SetSessionExpiryInterval(v uint32) {
-    p := &packet.PropSessionExpiryInterval{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.SessionExpiryInterval = &v
 }
 
 //以秒为单位的会话过期间隔
 func (m *ConnectMessage) GetSessionExpiryInterval() (uint32, bool) {
-    p := packet.FindPropValue(packet.SessionExpiryInterval, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.SessionExpiryInterval == nil {
         return 0, false
     }
-    return p.(*packet.PropSessionExpiryInterval).V, true
+    return *m.varHeader.props.SessionExpiryInterval, true
 }
 
 //客户端使用此值限制客户端愿意同时处理的QoS为1和QoS为2的发布消息最大数量。没有机制可以限制服务端试图发送的QoS为0的发布消息。
 //接收最大值只将被应用在当前网络连接。如果没有设置最大接收值，将使用默认值65535。
 func (m *ConnectMessage) SetReceiveMaximum(v uint16) {
-    p := &packet.PropReceiveMaximum{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.ReceiveMaximum = &v
 }
 
 func (m *ConnectMessage) GetReceiveMaximum() (uint16, bool) {
-    p := packet.FindPropValue(packet.ReceiveMaximum, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.ReceiveMaximum == nil {
         return 65535, false
     }
-    return p.(*packet.PropReceiveMaximum).V, true
+    return *m.varHeader.props.ReceiveMaximum, true
 }
 
 //服务端愿意接收的最大报文长度（Maximum Packet Size）。
 //如果没有设置最大报文长度，则按照协议由固定报头中的剩余长度可编码最大值和协议报头对数据包的大小做限制。
 func (m *ConnectMessage) SetMaximumPacketSize(v uint32) {
-    p := &packet.PropMaximumPacketSize{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.MaximumPacketSize = &v
 }
 
 //服务端愿意接收的最大报文长度（Maximum Packet Size）。
 //如果没有设置最大报文长度，则按照协议由固定报头中的剩余长度可编码最大值和协议报头对数据包的大小做限制。
 func (m *ConnectMessage) GetMaximumPacketSize() (uint32, bool) {
-    p := packet.FindPropValue(packet.MaximumPacketSize, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.MaximumPacketSize == nil {
         return 0, false
     }
-    return p.(*packet.PropMaximumPacketSize).V, true
+    return *m.varHeader.props.MaximumPacketSize, true
 }
 
 //双字节整数表示的主题别名最大值（Topic Alias Maximum）。
 // 包含多个主题别名最大值（Topic Alias Maximum）将造成协议错误（Protocol Error）。
 // 没有设置主题别名最大值属性的情况下，主题别名最大值默认为零。
 func (m *ConnectMessage) SetTopicAliasMaximum(v uint16) {
-    p := &packet.PropTopicAliasMaximum{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.TopicAliasMaximum = &v
 }
 
 //双字节整数表示的主题别名最大值（Topic Alias Maximum）。
 // 包含多个主题别名最大值（Topic Alias Maximum）将造成协议错误（Protocol Error）。
 // 没有设置主题别名最大值属性的情况下，主题别名最大值默认为零。
 func (m *ConnectMessage) GetTopicAliasMaximum() (uint16, bool) {
-    p := packet.FindPropValue(packet.TopicAliasMaximum, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.TopicAliasMaximum == nil {
         return 0, false
     }
-    return p.(*packet.PropTopicAliasMaximum).V, true
+    return *m.varHeader.props.TopicAliasMaximum, true
 }
 
 func (m *ConnectMessage) SetRequestResponseInformation(v byte) {
-    p := &packet.PropRequestResponseInformation{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.RequestResponseInformation = &v
 }
 
 func (m *ConnectMessage) SetRequestProblemInformation(v byte) {
-    p := &packet.PropRequestProblemInformation{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.RequestProblemInformation = &v
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
 func (m *ConnectMessage) SetUserProperty(props map[string]string) {
     for k, v := range props {
-        p := &packet.PropUserProperty{}
         pair, err := packet.NewStringPair(k, v)
         if err == nil {
-            p.V = pair
-            m.varHeader.props = append(m.varHeader.props, p)
+            m.varHeader.props.UserProperty = append(m.varHeader.props.UserProperty, pair)
         }
     }
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
+//以map形式返回时不保持顺序，同名属性只保留最后一个值，需要完整的用户属性时使用GetUserProperties
 func (m *ConnectMessage) GetUserProperty() (map[string]string, bool) {
     ret := map[string]string{}
-    packet.FindPropValues(packet.UserProperty, m.varHeader.props, func(property packet.Property) bool {
-        if property != nil {
-            p := property.(*packet.PropUserProperty)
-            ret[p.V[0].String()] = p.V[1].String()
-        }
-        return false
-    })
+    for _, p := range m.varHeader.props.UserProperty {
+        ret[p[0].String()] = p[1].String()
+    }
     return ret, len(ret) > 0
 }
 
+//按顺序追加用户属性，同一个名字可以出现多次
+func (m *ConnectMessage) AddUserProperty(key, value string) error {
+    return m.varHeader.props.UserProperty.Add(key, value)
+}
+
+//按顺序获得所有用户属性
+func (m *ConnectMessage) GetUserProperties() packet.UserProperties {
+    return m.varHeader.props.UserProperty
+}
+
+//替换所有用户属性，转发时保持原有顺序
+func (m *ConnectMessage) SetUserProperties(props packet.UserProperties) {
+    m.varHeader.props.UserProperty = props
+}
+
 //以UTF-8编码的字符串，包含了认证方法（Authentication Method）名。
 //包含多个认证方法将造成协议错误（Protocol Error）。
 func (m *ConnectMessage) SetAuthenticationMethod(v string) {
-    p := &packet.PropAuthenticationMethod{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.AuthenticationMethod = packet.StringPtr(v)
 }
 
 //以UTF-8编码的字符串，包含了认证方法（Authentication Method）名。
 //包含多个认证方法将造成协议错误（Protocol Error）。
 func (m *ConnectMessage) GetAuthenticationMethod() (string, bool) {
-    p := packet.FindPropValue(packet.AuthenticationMethod, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.AuthenticationMethod == nil {
         return "", false
     }
-    return p.(*packet.PropAuthenticationMethod).V.String(), true
+    return m.varHeader.props.AuthenticationMethod.String(), true
 }
 
 //包含认证数据（Authentication Data）的二进制数据。此数据的内容由认证方法和已交换的认证数据状态定义。
 //包含多个认证数据将造成协议错误（Protocol Error）。
 func (m *ConnectMessage) SetAuthenticationData(v []byte) {
-    p := &packet.PropAuthenticationData{}
-    s, err := packet.FromString(string(v))
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.AuthenticationData = v
 }
 
 //包含认证数据（Authentication Data）的二进制数据。此数据的内容由认证方法和已交换的认证数据状态定义。
 //包含多个认证数据将造成协议错误（Protocol Error）。
 func (m *ConnectMessage) GetAuthenticationData() ([]byte, bool) {
-    p := packet.FindPropValue(packet.AuthenticationData, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.AuthenticationData == nil {
         return nil, false
     }
-    return []byte(p.(*packet.PropAuthenticationData).V.String()), true
+    return m.varHeader.props.AuthenticationData, true
 }
 
 func (m *ConnectMessage) GetRequestResponseInformation() (byte, bool) {
-    p := packet.FindPropValue(packet.RequestResponseInformation, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.RequestResponseInformation == nil {
         return 0, false
     }
-    return p.(*packet.PropRequestResponseInformation).V, true
+    return *m.varHeader.props.RequestResponseInformation, true
 }
 
 func (m *ConnectMessage) GetRequestProblemInformation() (byte, bool) {
-    p := packet.FindPropValue(packet.RequestProblemInformation, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.RequestProblemInformation == nil {
         return 0, false
     }
-    return p.(*packet.PropRequestProblemInformation).V, true
+    return *m.varHeader.props.RequestProblemInformation, true
 }
 
 func (m *ConnectMessage) SetWillDelayInterval(v uint32) {
-    p := &packet.PropWillDelayInterval{}
-    p.V = v
-    m.payload.WillProps = append(m.payload.WillProps, p)
+    m.payload.WillProps.WillDelayInterval = &v
 }
 
 func (m *ConnectMessage) GetWillDelayInterval() (uint32, bool) {
-    p := packet.FindPropValue(packet.WillDelayInterval, m.payload.WillProps)
-    if p == nil {
+    if m.payload.WillProps.WillDelayInterval == nil {
         return 0, false
     }
-    return p.(*packet.PropWillDelayInterval).V, true
+    return *m.payload.WillProps.WillDelayInterval, true
 }
 
 func (m *ConnectMessage) SetPayloadFormatIndicator(v byte) {
-    p := &packet.PropPayloadFormatIndicator{}
-    p.V = v
-    m.payload.WillProps = append(m.payload.WillProps, p)
+    m.payload.WillProps.PayloadFormatIndicator = &v
 }
 
 func (m *ConnectMessage) GetPayloadFormatIndicator() (byte, bool) {
-    p := packet.FindPropValue(packet.PayloadFormatIndicator, m.payload.WillProps)
-    if p == nil {
+    if m.payload.WillProps.PayloadFormatIndicator == nil {
         return 0, false
     }
-    return p.(*packet.PropPayloadFormatIndicator).V, true
+    return *m.payload.WillProps.PayloadFormatIndicator, true
 }
 
 func (m *ConnectMessage) SetMessageExpiryInterval(v uint32) {
-    p := &packet.PropMessageExpiryInterval{}
-    p.V = v
-    m.payload.WillProps = append(m.payload.WillProps, p)
+    m.payload.WillProps.MessageExpiryInterval = &v
 }
 
 func (m *ConnectMessage) GetMessageExpiryInterval() (uint32, bool) {
-    p := packet.FindPropValue(packet.MessageExpiryInterval, m.payload.WillProps)
-    if p == nil {
+    if m.payload.WillProps.MessageExpiryInterval == nil {
         return 0, false
     }
-    return p.(*packet.PropMessageExpiryInterval).V, true
+    return *m.payload.WillProps.MessageExpiryInterval, true
 }
 
 //用来描述应用消息的内容。
 //包含多个内容类型将造成协议错误（Protocol Error）。
 //内容类型的值由发送应用程序和接收应用程序确定。
 func (m *ConnectMessage) SetContentType(v string) {
-    p := &packet.PropContentType{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.payload.WillProps = append(m.payload.WillProps, p)
-    }
+    m.payload.WillProps.ContentType = packet.StringPtr(v)
 }
 
 //用来描述应用消息的内容。
 //包含多个内容类型将造成协议错误（Protocol Error）。
 //内容类型的值由发送应用程序和接收应用程序确定。
 func (m *ConnectMessage) GetContentType() (string, bool) {
-    p := packet.FindPropValue(packet.ContentType, m.payload.WillProps)
-    if p == nil {
+    if m.payload.WillProps.ContentType == nil {
         return "", false
     }
-    return p.(*packet.PropContentType).V.String(), true
+    return m.payload.WillProps.ContentType.String(), true
 }
 
 func (m *ConnectMessage) SetResponseTopic(v string) {
-    p := &packet.PropResponseTopic{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.payload.WillProps = append(m.payload.WillProps, p)
-    }
+    m.payload.WillProps.ResponseTopic = packet.StringPtr(v)
 }
 
 func (m *ConnectMessage) GetResponseTopic() (string, bool) {
-    p := packet.FindPropValue(packet.ResponseTopic, m.payload.WillProps)
-    if p == nil {
+    if m.payload.WillProps.ResponseTopic == nil {
         return "", false
     }
-    return p.(*packet.PropResponseTopic).V.String(), true
+    return m.payload.WillProps.ResponseTopic.String(), true
 }
 
 //对比数据被请求消息发送端在收到响应消息时用来标识相应的请求。包含多个对比数据将造成协议错误（Protocol Error）。
 //如果没有设置对比数据，则请求方（Requester）不需要任何对比数据。
 func (m *ConnectMessage) SetCorrelationData(v []byte) {
-    p := &packet.PropCorrelationData{}
-    s, err := packet.FromString(string(v))
-    if err == nil {
-        p.V = s
-        m.payload.WillProps = append(m.payload.WillProps, p)
-    }
+    m.payload.WillProps.CorrelationData = v
 }
 
 //对比数据被请求消息发送端在收到响应消息时用来标识相应的请求。包含多个对比数据将造成协议错误（Protocol Error）。
 //如果没有设置对比数据，则请求方（Requester）不需要任何对比数据。
 func (m *ConnectMessage) GetCorrelationData() ([]byte, bool) {
-    p := packet.FindPropValue(packet.CorrelationData, m.payload.WillProps)
-    if p == nil {
+    if m.payload.WillProps.CorrelationData == nil {
         return nil, false
     }
-    return []byte(p.(*packet.PropCorrelationData).V.String()), true
+    return m.payload.WillProps.CorrelationData, true
 }
 
 func (m *ConnectMessage) SetPayloadUserProperty(props map[string]string) {
     for k, v := range props {
-        p := &packet.PropUserProperty{}
         pair, err := packet.NewStringPair(k, v)
         if err == nil {
-            p.V = pair
-            m.payload.WillProps = append(m.payload.WillProps, p)
+            m.payload.WillProps.UserProperty = append(m.payload.WillProps.UserProperty, pair)
         }
     }
 }
 
+//以map形式返回时不保持顺序，同名属性只保留最后一个值，需要完整的用户属性时使用GetPayloadUserProperties
 func (m *ConnectMessage) GetPayloadUserProperty() (map[string]string, bool) {
     ret := map[string]string{}
-    packet.FindPropValues(packet.UserProperty, m.payload.WillProps, func(property packet.Property) bool {
-        if property != nil {
-            p := property.(*packet.PropUserProperty)
-            ret[p.V[0].String()] = p.V[1].String()
-        }
-        return false
-    })
+    for _, p := range m.payload.WillProps.UserProperty {
+        ret[p[0].String()] = p[1].String()
+    }
     return ret, len(ret) > 0
 }
 
+//按顺序追加遗嘱属性中的用户属性，同一个名字可以出现多次
+func (m *ConnectMessage) AddPayloadUserProperty(key, value string) error {
+    return m.payload.WillProps.UserProperty.Add(key, value)
+}
+
+//按顺序获得遗嘱属性中的所有用户属性
+func (m *ConnectMessage) GetPayloadUserProperties() packet.UserProperties {
+    return m.payload.WillProps.UserProperty
+}
+
+//替换遗嘱属性中的所有用户属性，转发时保持原有顺序
+func (m *ConnectMessage) SetPayloadUserProperties(props packet.UserProperties) {
+    m.payload.WillProps.UserProperty = props
+}
+
+//报文属性，可以直接读写属性字段
+func (m *ConnectMessage) GetProperties() *packet.Properties {
+    return &m.varHeader.props
+}
+
+//遗嘱属性，可以直接读写属性字段
+func (m *ConnectMessage) GetWillProperties() *packet.Properties {
+    return &m.payload.WillProps
+}
+
 func (m *ConnectMessage) Valid() bool {
-    return true
+    return m.Validate() == nil
 }
 
-func (v *ConnectVarHeader) String() string {
-    builder := strings.Builder{}
-    for i := range v.props {
-        builder.WriteString(fmt.Sprintf("\t%v\n", v.props[i]))
+func (m *ConnectMessage) Validate() error {
+    //CONNECT控制报文的保留标志位（第0位）必须为0
+    if m.varHeader.Flag&0x1 != 0 {
+        return errcode.ConnectReservedFlagInvalid
+    }
+    if m.GetWillQos() == 3 {
+        return errcode.WillQosInvalid
     }
+    if !m.IsWillEnable() {
+        if m.GetWillQos() != 0 {
+            return errcode.WillQosWithoutWill
+        }
+        if m.IsWillRetain() {
+            return errcode.WillRetainWithoutWill
+        }
+    }
+    //5.0允许只有密码没有用户名
+    if !packet.IsV5(m.varHeader.ProtocolVersion) && m.havePassword() && !m.haveUsername() {
+        return errcode.PasswordWithoutUsername
+    }
+    if err := m.varHeader.props.Check(m.fixedHeader.Type()); err != nil {
+        return err
+    }
+    return m.payload.WillProps.Check(packet.WillPropertiesOwner)
+}
+
+func (v *ConnectVarHeader) String() string {
     return fmt.Sprintf("protocal name: %s version: %d flag: %b keepAlive: %d \nprops:\n%s",
-        v.ProtocolName.String(), v.ProtocolVersion, v.Flag, v.KeepAlive, builder.String())
+        v.ProtocolName.String(), v.ProtocolVersion, v.Flag, v.KeepAlive, v.props.String())
 }
 
 func (v *ConnectPayload) String() string {
-    builder := strings.Builder{}
-    for i := range v.WillProps {
-        builder.WriteString(fmt.Sprintf("\t%v\n", v.WillProps[i]))
-    }
     return fmt.Sprintf("ClientId: %s WillTopic: %s WillPayload: %v Username: %s Password: %v \nprops:\n%s",
-        v.ClientId.String(), v.WillTopic.String(), v.WillPayload, v.Username.String(), v.Password, builder.String())
+        v.ClientId.String(), v.WillTopic.String(), v.WillPayload, v.Username.String(), v.Password, v.WillProps.String())
 }
 
 func (v *ConnectMessage) String() string {
@@ -11,14 +11,12 @@ import (
     "io"
     "mqtt/errcode"
     "mqtt/packet"
-    "mqtt/util"
-    "strings"
 )
 
 type DisconnectVarHeader struct {
     //断开原因码
-    ReasonCode byte
-    props      []packet.Property
+    ReasonCode errcode.ReasonCode
+    props      packet.Properties
 }
 
 //DISCONNECT报文是客户端发给服务端的最后一个MQTT控制报文。
@@ -27,6 +25,8 @@ type DisconnectVarHeader struct {
 type DisconnectMessage struct {
     fixedHeader packet.FixedHeader
     varHeader   DisconnectVarHeader
+    version     byte
+    codecOption
 }
 
 func (m *DisconnectMessage) SetFixedHeader(header packet.FixedHeader) {
@@ -34,55 +34,64 @@ func (m *DisconnectMessage) SetFixedHeader(header packet.FixedHeader) {
 }
 
 func (m *DisconnectMessage) GetFixedHeader() packet.FixedHeader {
-    w := new(util.CountWriter)
-    m.WriteVariableHeader(w)
-    m.WritePayload(w)
-    m.fixedHeader.Len = w.Count()
+    m.fixedHeader.Len = int64(m.VariableHeaderLength() + m.PayloadLength())
     return m.fixedHeader
 }
 
+func (msg *DisconnectMessage) VariableHeaderLength() int {
+    if !packet.IsV5(msg.version) || msg.varHeader.ReasonCode == 0 {
+        return 0
+    }
+    return 1 + msg.varHeader.props.Length()
+}
+
+func (msg *DisconnectMessage) PayloadLength() int {
+    return 0
+}
+
 func (msg *DisconnectMessage) ReadVariableHeader(r io.Reader) (int, error) {
-    if msg.fixedHeader.RemainLength() < 1 {
+    if msg.fixedHeader.RemainLength() < 1 || !packet.IsV5(msg.version) {
         return 0, nil
     }
-    buf := make([]byte, 1)
-    n, err := r.Read(buf)
+    code, n, err := packet.ReadByte(r)
     if err != nil {
         return n, err
     }
 
-    msg.varHeader.ReasonCode = buf[0]
+    msg.varHeader.ReasonCode = errcode.ReasonCode(code)
 
     if msg.fixedHeader.RemainLength() == int64(n) {
         return n, nil
     }
 
-    props, n2, err2 := packet.ReadProperties(r)
+    n2, err2 := msg.varHeader.props.Decode(r, msg.fixedHeader.Type(), msg.controlChars)
     n += n2
     if err2 != nil {
         return n, err2
     }
 
-    msg.varHeader.props = props
-
     return n, nil
 }
 
 func (msg *DisconnectMessage) WriteVariableHeader(w io.Writer) (int, error) {
+    if !packet.IsV5(msg.version) {
+        return 0, nil
+    }
+
     if msg.varHeader.ReasonCode == 0 {
-        if  len(msg.varHeader.props) == 0 {
+        if  msg.varHeader.props.IsEmpty() {
             return 0, nil
         } else {
             return 0, errcode.ProtocolError
         }
     }
 
-    n, err := w.Write([]byte{msg.varHeader.ReasonCode})
+    n, err := w.Write([]byte{byte(msg.varHeader.ReasonCode)})
     if err != nil {
         return n, err
     }
 
-    n2, err2 := packet.WriteProperties(w, msg.varHeader.props)
+    n2, err2 := msg.varHeader.props.Encode(w, msg.fixedHeader.Type(), msg.controlChars)
     return n + n2, err2
 }
 
@@ -94,119 +103,153 @@ func (msg *DisconnectMessage) WritePayload(w io.Writer) (int, error) {
     return 0, nil
 }
 
+//报文属性，可以直接读写属性字段
+func (m *DisconnectMessage) GetProperties() *packet.Properties {
+    return &m.varHeader.props
+}
+
 func (m *DisconnectMessage) Valid() bool {
-    return true
+    return m.Validate() == nil
+}
+
+func (m *DisconnectMessage) Validate() error {
+    if err := checkReasonCode(m.varHeader.ReasonCode, m.fixedHeader.Type(), m.version); err != nil {
+        return err
+    }
+    return m.varHeader.props.Check(m.fixedHeader.Type())
 }
 
 func NewDisconnectMessage() *DisconnectMessage {
     ret := &DisconnectMessage{
         fixedHeader: packet.CreateFixedHeader(packet.PktTypeDISCONNECT, packet.PktFlagDISCONNECT, 0),
+        version:     packet.MqttProtocolVersion,
     }
     return ret
 }
 
-func (m *DisconnectMessage) SetReasonCode(v byte) {
+//由错误创建DISCONNECT报文，原因码取自错误对应的原因（见errcode.ReasonOf），
+//不能出现在DISCONNECT报文中的原因码使用UnspecifiedError。5.0以错误信息作为原因字符串，err为nil时为正常断开
+func NewDisconnectMessageFromError(err error, version byte) *DisconnectMessage {
+    ret := NewDisconnectMessage()
+    ret.SetVersion(version)
+    if err == nil {
+        return ret
+    }
+    if ret.SetReasonCode(errcode.ReasonOf(err).Code) != nil {
+        ret.SetReasonCode(errcode.ReasonUnspecifiedError)
+    }
+    if packet.IsV5(version) {
+        ret.SetReasonString(err.Error())
+    }
+    return ret
+}
+
+func (m *DisconnectMessage) SetVersion(v byte) {
+    m.version = v
+}
+
+func (m *DisconnectMessage) GetVersion() byte {
+    return m.version
+}
+
+//原因码不能出现在该报文中时返回ReasonCodeInvalid，原有原因码保持不变
+func (m *DisconnectMessage) SetReasonCode(v errcode.ReasonCode) error {
+    if err := checkReasonCode(v, m.fixedHeader.Type(), m.version); err != nil {
+        return err
+    }
     m.varHeader.ReasonCode = v
+    return nil
 }
 
-func (m *DisconnectMessage) GetReasonCode() byte {
+func (m *DisconnectMessage) GetReasonCode() errcode.ReasonCode {
     return m.varHeader.ReasonCode
 }
 
 // 会话过期间隔 Session Expiry Interval,四字节整数表示的以秒为单位的会话过期间隔
 func (m *DisconnectMessage) SetSessionExpiryInterval(v uint32) {
-    p := &packet.PropSessionExpiryInterval{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.SessionExpiryInterval = &v
 }
 
 // 会话过期间隔 Session Expiry Interval,四字节整数表示的以秒为单位的会话过期间隔
 func (m *DisconnectMessage) GetSessionExpiryInterval() (uint32, bool) {
-    p := packet.FindPropValue(packet.SessionExpiryInterval, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.SessionExpiryInterval == nil {
         return 0, false
     }
-    return p.(*packet.PropSessionExpiryInterval).V, true
+    return *m.varHeader.props.SessionExpiryInterval, true
 }
 
 //UTF-8编码的字符串，表示此次响应相关的原因。
 // 此原因字符串（Reason String）是为诊断而设计的可读字符串，不应该被客户端所解析。
 func (m *DisconnectMessage) SetReasonString(v string) {
-    p := &packet.PropReasonString{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.ReasonString = packet.StringPtr(v)
 }
 
 //UTF-8编码的字符串，表示此次响应相关的原因。
 // 此原因字符串（Reason String）是为诊断而设计的可读字符串，不应该被客户端所解析。
 func (m *DisconnectMessage) GetReasonString() (string, bool) {
-    p := packet.FindPropValue(packet.ReasonString, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.ReasonString == nil {
         return "", false
     }
-    return p.(*packet.PropReasonString).V.String(), true
+    return m.varHeader.props.ReasonString.String(), true
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
 func (m *DisconnectMessage) SetUserProperty(props map[string]string) {
     for k, v := range props {
-        p := &packet.PropUserProperty{}
         pair, err := packet.NewStringPair(k, v)
         if err == nil {
-            p.V = pair
-            m.varHeader.props = append(m.varHeader.props, p)
+            m.varHeader.props.UserProperty = append(m.varHeader.props.UserProperty, pair)
         }
     }
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
+//以map形式返回时不保持顺序，同名属性只保留最后一个值，需要完整的用户属性时使用GetUserProperties
 func (m *DisconnectMessage) GetUserProperty() (map[string]string, bool) {
     ret := map[string]string{}
-    packet.FindPropValues(packet.UserProperty, m.varHeader.props, func(property packet.Property) bool {
-        if property != nil {
-            p := property.(*packet.PropUserProperty)
-            ret[p.V[0].String()] = p.V[1].String()
-        }
-        return false
-    })
+    for _, p := range m.varHeader.props.UserProperty {
+        ret[p[0].String()] = p[1].String()
+    }
     return ret, len(ret) > 0
 }
 
+//按顺序追加用户属性，同一个名字可以出现多次
+func (m *DisconnectMessage) AddUserProperty(key, value string) error {
+    return m.varHeader.props.UserProperty.Add(key, value)
+}
+
+//按顺序获得所有用户属性
+func (m *DisconnectMessage) GetUserProperties() packet.UserProperties {
+    return m.varHeader.props.UserProperty
+}
+
+//替换所有用户属性，转发时保持原有顺序
+func (m *DisconnectMessage) SetUserProperties(props packet.UserProperties) {
+    m.varHeader.props.UserProperty = props
+}
+
 //服务端参考（Server Reference）标识符
 //客户端可以使用它来识别其他要使用的服务端。
 // 包含多个服务端参考将造成协议错误（Protocol Error）。
 func (m *DisconnectMessage) SetServerReference(v string) {
-    p := &packet.PropServerReference{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.ServerReference = packet.StringPtr(v)
 }
 
 //服务端参考（Server Reference）标识符
 //客户端可以使用它来识别其他要使用的服务端。
 // 包含多个服务端参考将造成协议错误（Protocol Error）。
 func (m *DisconnectMessage) GetServerReference() (string, bool) {
-    p := packet.FindPropValue(packet.ServerReference, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.ServerReference == nil {
         return "", false
     }
-    return p.(*packet.PropServerReference).V.String(), true
+    return m.varHeader.props.ServerReference.String(), true
 }
 
 func (v *DisconnectVarHeader) String() string {
-    builder := strings.Builder{}
-    for i := range v.props {
-        builder.WriteString(fmt.Sprintf("\t%v\n", v.props[i]))
-    }
     return fmt.Sprintf("ReasonCode: %d \nprops:\n%s",
-        v.ReasonCode, builder.String())
+        v.ReasonCode, v.props.String())
 }
 
 func (v *DisconnectMessage) String() string {
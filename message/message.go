@@ -8,6 +8,7 @@ package message
 
 import (
     "io"
+    "mqtt/errcode"
     "mqtt/packet"
 )
 
@@ -15,12 +16,21 @@ type Message interface {
     //检测message控制报文是否有效
     Valid() bool
 
+    //校验message控制报文，返回违反的协议约束
+    Validate() error
+
     //设置固定头
     SetFixedHeader(header packet.FixedHeader)
 
     //获得固定头，注意remain length必须已经计算完成
     GetFixedHeader() packet.FixedHeader
 
+    //可变头编码后的长度
+    VariableHeaderLength() int
+
+    //payload编码后的长度
+    PayloadLength() int
+
     //读取可变头，注意必须在ReadPayload之前调用
     ReadVariableHeader(r io.Reader) (int, error)
 
@@ -32,4 +42,58 @@ type Message interface {
 
     //写payload，注意必须在WriteVariableHeader之后调用
     WritePayload(w io.Writer) (int, error)
+
+    //设置协议版本（protocol level），决定可变头及payload的编解码方式
+    SetVersion(v byte)
+
+    //获得协议版本（protocol level）
+    GetVersion() byte
+}
+
+//报文的编解码选项，读取时由ReadOption设置，写入时由WriteOption设置，
+//使不同的连接（如服务端的不同监听端口）及存储可以同时使用不同的选项
+type codecOption struct {
+    //UTF-8编码字符串中控制字符的处理策略
+    controlChars packet.ControlCharPolicy
+}
+
+func (o *codecOption) setControlCharPolicy(p packet.ControlCharPolicy) {
+    o.controlChars = p
+}
+
+//包含UTF-8编码字符串的报文（除PINGREQ、PINGRESP以外）
+type controlCharSetter interface {
+    setControlCharPolicy(p packet.ControlCharPolicy)
+}
+
+//校验原因码是否可以出现在该类型的报文中。
+//3.1及3.1.1的CONNACK为返回码（0-5），SUBACK返回码为0x00、0x01、0x02及0x80，其他报文按5.0的规则校验
+func checkReasonCode(code errcode.ReasonCode, pktType byte, version byte) error {
+    if !packet.IsV5(version) {
+        switch pktType {
+        case packet.PktTypeCONNACK:
+            if code > errcode.ConnackNotAuthorized {
+                return errcode.ReasonCodeInvalid
+            }
+            return nil
+        case packet.PktTypeSUBACK:
+            if code > errcode.ReasonGrantedQoS2 && code != errcode.ReasonUnspecifiedError {
+                return errcode.ReasonCodeInvalid
+            }
+            return nil
+        }
+    }
+    if !code.ValidFor(pktType) {
+        return errcode.ReasonCodeInvalid
+    }
+    return nil
+}
+
+func checkReasonCodes(codes []byte, pktType byte, version byte) error {
+    for _, v := range codes {
+        if err := checkReasonCode(errcode.ReasonCode(v), pktType, version); err != nil {
+            return err
+        }
+    }
+    return nil
 }
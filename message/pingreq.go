@@ -17,15 +17,25 @@ import (
 //3、使用网络已确认网络连接没有断开。
 type PingReqMessage struct {
     fixedHeader packet.FixedHeader
+    version     byte
 }
 
 func NewPingReqMessage() *PingReqMessage {
     ret := &PingReqMessage{
         fixedHeader: packet.CreateFixedHeader(packet.PktTypePINGREQ, packet.PktFlagPINGREQ, 0),
+        version:     packet.MqttProtocolVersion,
     }
     return ret
 }
 
+func (m *PingReqMessage) SetVersion(v byte) {
+    m.version = v
+}
+
+func (m *PingReqMessage) GetVersion() byte {
+    return m.version
+}
+
 func (m *PingReqMessage) SetFixedHeader(header packet.FixedHeader) {
     m.fixedHeader = header
 }
@@ -34,6 +44,14 @@ func (m *PingReqMessage) GetFixedHeader() packet.FixedHeader {
     return m.fixedHeader
 }
 
+func (msg *PingReqMessage) VariableHeaderLength() int {
+    return 0
+}
+
+func (msg *PingReqMessage) PayloadLength() int {
+    return 0
+}
+
 func (msg *PingReqMessage) ReadVariableHeader(r io.Reader) (int, error) {
     return 0, nil
 }
@@ -51,5 +69,9 @@ func (msg *PingReqMessage) WritePayload(w io.Writer) (int, error) {
 }
 
 func (msg *PingReqMessage) Valid() bool {
-    return true
+    return msg.Validate() == nil
+}
+
+func (msg *PingReqMessage) Validate() error {
+    return nil
 }
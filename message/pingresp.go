@@ -16,7 +16,10 @@ type PingRespMessage struct {
 
 func NewPingRespMessage() *PingRespMessage {
     ret := &PingRespMessage{
-        PingReqMessage{fixedHeader: packet.CreateFixedHeader(packet.PktTypePINGRESP, packet.PktFlagPINGRESP, 0),},
+        PingReqMessage{
+            fixedHeader: packet.CreateFixedHeader(packet.PktTypePINGRESP, packet.PktFlagPINGRESP, 0),
+            version:     packet.MqttProtocolVersion,
+        },
     }
     return ret
 }
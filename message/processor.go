@@ -7,9 +7,11 @@
 package message
 
 import (
+    "bytes"
     "io"
     "mqtt/errcode"
     "mqtt/packet"
+    "sync"
 )
 
 type Creator func() Message
@@ -48,50 +50,349 @@ var creatorMap = map[byte]Creator{
     packet.PktTypeAUTH:        AuthMessageCreator,
 }
 
-func ReadMessage(r io.Reader) (Message, int, error) {
+//报文读取选项
+type ReadOption struct {
+    //协商的协议版本，CONNECT报文以其自身携带的协议版本为准
+    Version byte
+    //严格模式，校验固定头标志位、保留位、遗嘱标志及属性重复等协议约束，
+    //违反时返回MalformedPacket或ProtocolError
+    Strict bool
+    //允许接收的最大报文长度（包括固定头），0表示不限制。
+    //读取固定头后即检查，超出时返回PacketTooLarge且不再读取报文剩余部分
+    MaxPacketSize uint32
+    //PUBLISH报文的payload超过该长度时不读取到内存，而是通过PublishMessage.GetPayloadReader以流的方式读取，
+    //返回的读取字节数不包括未读取的payload，0表示始终读取到内存。对Decode无效
+    StreamPayloadSize int64
+    //UTF-8编码字符串中控制字符及非字符的处理策略，默认允许 [MQTT-1.5.4-3]
+    ControlChars packet.ControlCharPolicy
+}
+
+//报文写入选项
+type WriteOption struct {
+    //协商的协议版本
+    Version byte
+    //对端允许接收的最大报文长度（包括固定头），超出时返回PacketTooLarge且不写入任何数据，0表示不限制
+    MaxPacketSize uint32
+    //UTF-8编码字符串中控制字符及非字符的处理策略，默认允许
+    ControlChars packet.ControlCharPolicy
+}
+
+//按协商的协议版本读取报文，CONNECT报文以其自身携带的协议版本为准
+func ReadMessage(r io.Reader, version byte) (Message, int, error) {
+    return ReadMessageWithOption(r, ReadOption{Version: version})
+}
+
+//以严格模式读取报文
+func ReadMessageStrict(r io.Reader, version byte) (Message, int, error) {
+    return ReadMessageWithOption(r, ReadOption{Version: version, Strict: true})
+}
+
+//以最大报文长度限制读取报文，limit通常为本端在CONNECT或CONNACK中声明的MaximumPacketSize
+func ReadMessageWithLimit(r io.Reader, version byte, limit uint32) (Message, int, error) {
+    return ReadMessageWithOption(r, ReadOption{Version: version, MaxPacketSize: limit})
+}
+
+//解析失败时返回*errcode.PacketError，携带报文类型、出错的部分及字节偏移，通过errors.Is判断原因。
+//读取固定头时的io错误（如io.EOF）直接返回，便于调用者判断连接关闭
+func ReadMessageWithOption(r io.Reader, opt ReadOption) (Message, int, error) {
     f, n, err := packet.ReadFixedHeader(r)
     if err != nil {
-        return nil, n, err
+        return nil, n, fixedHeaderError(err, f, n)
+    }
+
+    return readMessage(r, f, n, opt)
+}
+
+//从字节切片中解析一个完整的报文，返回报文及其占用的字节数。
+//String、Bytes及payload直接引用data中的数据而不做拷贝，在报文使用完毕前调用者不能修改或复用data。
+//data中的数据不足一个完整报文时返回io.ErrUnexpectedEOF，此时读取字节数为0
+func Decode(data []byte, version byte) (Message, int, error) {
+    return DecodeWithOption(data, ReadOption{Version: version})
+}
+
+func DecodeWithOption(data []byte, opt ReadOption) (Message, int, error) {
+    f, n, err := packet.ParseFixedHeader(data)
+    if err != nil {
+        return nil, 0, fixedHeaderError(err, f, n)
+    }
+
+    if opt.MaxPacketSize > 0 && f.PacketSize() > int64(opt.MaxPacketSize) {
+        return nil, n, fixedHeaderError(errcode.PacketTooLarge, f, 0)
+    }
+
+    if f.PacketSize() > int64(len(data)) {
+        return nil, 0, io.ErrUnexpectedEOF
+    }
+
+    r := packet.NewSliceReader(data[n:f.PacketSize()])
+    return readMessage(r, f, n, opt)
+}
+
+//从FrameReader读取一个完整的报文并解析，返回的报文引用FrameReader的内部缓冲，
+//在下一次读取前有效，需要保留时调用者应先复制或重新编码
+func ReadFrameMessage(fr *packet.FrameReader, opt ReadOption) (Message, int, error) {
+    f, frame, err := fr.ReadFrame()
+    if err != nil {
+        return nil, 0, fixedHeaderError(err, f, 0)
+    }
+    return DecodeWithOption(frame, opt)
+}
+
+//固定头的协议错误包装为*errcode.PacketError，io错误直接返回
+func fixedHeaderError(err error, f packet.FixedHeader, offset int) error {
+    if _, ok := err.(*errcode.Reason); !ok {
+        return err
+    }
+    return errcode.WrapError(err, f.Type(), "Fixed Header", offset)
+}
+
+func readMessage(r io.Reader, f packet.FixedHeader, n int, opt ReadOption) (Message, int, error) {
+    version := opt.Version
+    if opt.MaxPacketSize > 0 && f.PacketSize() > int64(opt.MaxPacketSize) {
+        return nil, n, fixedHeaderError(errcode.PacketTooLarge, f, 0)
+    }
+
+    if opt.Strict {
+        if err := f.CheckFlag(); err != nil {
+            return nil, n, fixedHeaderError(err, f, 0)
+        }
     }
 
     creator := creatorMap[f.Type()]
     if creator == nil {
-        return nil, n, errcode.MessageNotSupport
+        return nil, n, fixedHeaderError(errcode.MessageNotSupport, f, 0)
+    }
+
+    if f.Type() == packet.PktTypeAUTH && !packet.IsV5(version) {
+        return nil, n, fixedHeaderError(errcode.MessageNotSupport, f, 0)
     }
 
     msg := creator()
     msg.SetFixedHeader(f)
+    msg.SetVersion(version)
+    if s, ok := msg.(controlCharSetter); ok {
+        s.setControlCharPolicy(opt.ControlChars)
+    }
+    pub, isPub := msg.(*PublishMessage)
+    if isPub {
+        pub.SetStreamThreshold(opt.StreamPayloadSize)
+    }
 
     n2, err2 := msg.ReadVariableHeader(r)
     n += n2
     if err2 != nil {
-        return nil, n, err2
+        return nil, n, errcode.WrapError(err2, f.Type(), "Variable Header", n)
     }
 
     n3, err3 := msg.ReadPayload(r)
     n += n3
 
-    if f.RemainLength() != int64(n2+n3) {
-        return nil, n, errcode.MessageReadSizeNotMatch
+    if err3 != nil {
+        return nil, n, errcode.WrapError(err3, f.Type(), "Payload", n)
+    }
+
+    remain := int64(n2 + n3)
+    if isPub && pub.IsPayloadStream() {
+        remain += pub.GetPayloadSize()
+    }
+    if f.RemainLength() != remain {
+        return nil, n, errcode.WrapError(errcode.MessageReadSizeNotMatch, f.Type(), "Remaining Length", n)
+    }
+
+    if opt.Strict {
+        if err := msg.Validate(); err != nil {
+            return nil, n, errcode.WrapError(err, f.Type(), "Packet", 0)
+        }
     }
 
-    return msg, n, err3
+    return msg, n, nil
 }
 
-func WriteMessage(w io.Writer, m Message) (int, error) {
+//按协商的协议版本写报文
+func WriteMessage(w io.Writer, m Message, version byte) (int, error) {
+    return WriteMessageWithLimit(w, m, version, 0)
+}
+
+//以对端声明的最大报文长度写报文，超出时返回PacketTooLarge且不写入任何数据，limit为0表示不限制
+func WriteMessageWithLimit(w io.Writer, m Message, version byte, limit uint32) (int, error) {
+    return WriteMessageWithOption(w, m, WriteOption{Version: version, MaxPacketSize: limit})
+}
+
+//报文先完整编码到缓冲中，再通过一次Write写入w；流式payload的PUBLISH报文在写入报文头后直接从Reader复制payload
+func WriteMessageWithOption(w io.Writer, m Message, opt WriteOption) (int, error) {
+    m, err := encodeCopy(m, opt)
+    if err != nil {
+        return 0, err
+    }
+    buf := getBuffer()
+    defer putBuffer(buf)
+
+    pub, stream := m.(*PublishMessage)
+    stream = stream && pub.IsPayloadStream()
+
+    if _, err := encode(buf, m, opt.Version, opt.MaxPacketSize, stream); err != nil {
+        return 0, err
+    }
+    n, err := w.Write(buf.Bytes())
+    if err != nil || !stream {
+        return n, err
+    }
+
+    n2, err2 := pub.WritePayload(w)
+    return n + n2, err2
+}
+
+//按协商的协议版本将报文编码后追加到dst，返回追加后的切片
+func Encode(dst []byte, m Message, version byte) ([]byte, error) {
+    m, err := encodeCopy(m, WriteOption{Version: version})
+    if err != nil {
+        return dst, err
+    }
+    buf := bytes.NewBuffer(dst)
+    if _, err := encode(buf, m, version, 0, false); err != nil {
+        return dst, err
+    }
+    return buf.Bytes(), nil
+}
+
+//返回按opt编码的浅拷贝，编码（包括GetFixedHeader计算剩余长度）不修改调用者的报文，
+//同一报文可以同时以不同选项编码或写入多个连接。
+//CONNECT报文的协议版本决定协议名，与opt.Version不一致时返回ConnectVersionMismatch而不改写报文。
+//不是本包定义的报文类型时直接设置其协议版本
+func encodeCopy(m Message, opt WriteOption) (Message, error) {
+    var ret Message
+    switch msg := m.(type) {
+    case *ConnectMessage:
+        if msg.GetVersion() != opt.Version {
+            return nil, errcode.ConnectVersionMismatch
+        }
+        c := *msg
+        c.setControlCharPolicy(opt.ControlChars)
+        return &c, nil
+    case *ConnackMessage:
+        c := *msg
+        ret = &c
+    case *PublishMessage:
+        ret = msg.ShallowCopy()
+    case *PubAckMessage:
+        c := *msg
+        ret = &c
+    case *PubRecMessage:
+        c := *msg
+        ret = &c
+    case *PubRelMessage:
+        c := *msg
+        ret = &c
+    case *PubCompMessage:
+        c := *msg
+        ret = &c
+    case *SubscribeMessage:
+        c := *msg
+        ret = &c
+    case *SubAckMessage:
+        c := *msg
+        ret = &c
+    case *UnsubscribeMessage:
+        c := *msg
+        ret = &c
+    case *UnsubAckMessage:
+        c := *msg
+        ret = &c
+    case *PingReqMessage:
+        c := *msg
+        ret = &c
+    case *PingRespMessage:
+        c := *msg
+        ret = &c
+    case *DisconnectMessage:
+        c := *msg
+        ret = &c
+    case *AuthMessage:
+        c := *msg
+        ret = &c
+    default:
+        ret = m
+    }
+    ret.SetVersion(opt.Version)
+    if s, ok := ret.(controlCharSetter); ok {
+        s.setControlCharPolicy(opt.ControlChars)
+    }
+    return ret, nil
+}
+
+//按报文自身的协议版本编码，m应为encodeCopy返回的报文。skipPayload为true时不写payload，由调用者负责写入
+func encode(buf *bytes.Buffer, m Message, version byte, limit uint32, skipPayload bool) (int, error) {
+    if _, ok := m.(*AuthMessage); ok && !packet.IsV5(version) {
+        return 0, errcode.MessageNotSupport
+    }
+
     fixedHeader := m.GetFixedHeader()
-    n, err := packet.WriteFixedHeader(w, fixedHeader)
+    if limit > 0 && fixedHeader.PacketSize() > int64(limit) {
+        return 0, errcode.PacketTooLarge
+    }
+    if skipPayload {
+        buf.Grow(int(fixedHeader.PacketSize()) - m.PayloadLength())
+    } else {
+        buf.Grow(int(fixedHeader.PacketSize()))
+    }
+
+    n, err := packet.WriteFixedHeader(buf, fixedHeader)
     if err != nil {
         return n, err
     }
 
-    n2, err2 := m.WriteVariableHeader(w)
+    n2, err2 := m.WriteVariableHeader(buf)
     n += n2
     if err2 != nil {
         return n, err2
     }
 
-    n3, err3 := m.WritePayload(w)
-    n += n3
-    return n, err3
+    n3 := m.PayloadLength()
+    if !skipPayload {
+        var err3 error
+        n3, err3 = m.WritePayload(buf)
+        n += n3
+        if err3 != nil {
+            return n, err3
+        }
+    }
+
+    if fixedHeader.RemainLength() != int64(n2+n3) {
+        return n, errcode.MessageWriteSizeNotMatch
+    }
+    return n, nil
+}
+
+//编码缓冲超过该大小时不放回缓冲池，避免大报文长期占用内存
+const maxPooledBufferSize = 64 * 1024
+
+var bufferPool = sync.Pool{
+    New: func() interface{} {
+        return new(bytes.Buffer)
+    },
+}
+
+func getBuffer() *bytes.Buffer {
+    return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+    if buf.Cap() > maxPooledBufferSize {
+        return
+    }
+    buf.Reset()
+    bufferPool.Put(buf)
+}
+
+//获取CONNECT或CONNACK报文中声明的最大报文长度（Maximum Packet Size），
+//未声明或不是CONNECT、CONNACK报文时返回0，表示不限制
+func MaxPacketSize(m Message) uint32 {
+    var v uint32
+    switch msg := m.(type) {
+    case *ConnectMessage:
+        v, _ = msg.GetMaximumPacketSize()
+    case *ConnackMessage:
+        v, _ = msg.GetMaximumPacketSize()
+    }
+    return v
 }
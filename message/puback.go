@@ -11,75 +11,93 @@ import (
     "io"
     "mqtt/errcode"
     "mqtt/packet"
-    "mqtt/util"
-    "strings"
 )
 
 type PubAckVarHeader struct {
     //报文标识符（Packet Identifier）字段才能出现在PUBLISH报文中。
     PacketIdentifier uint16
 
-    ReasonCode byte
+    ReasonCode errcode.ReasonCode
     //PUBLISH 属性 PUBLISH Properties
-    props []packet.Property
+    props packet.Properties
 }
 
 //PUBACK报文是对QoS 1等级的PUBLISH报文的响应。
 type PubAckMessage struct {
     fixedHeader packet.FixedHeader
     varHeader   PubAckVarHeader
+    version     byte
+    codecOption
 }
 
 func NewPubAckMessage() *PubAckMessage {
     ret := &PubAckMessage{
         fixedHeader: packet.CreateFixedHeader(packet.PktTypePUBACK, packet.PktFlagPUBACK, 0),
+        version:     packet.MqttProtocolVersion,
     }
     return ret
 }
 
+func (m *PubAckMessage) SetVersion(v byte) {
+    m.version = v
+}
+
+func (m *PubAckMessage) GetVersion() byte {
+    return m.version
+}
+
 func (m *PubAckMessage) SetFixedHeader(header packet.FixedHeader) {
     m.fixedHeader = header
 }
 
 func (m *PubAckMessage) GetFixedHeader() packet.FixedHeader {
-    w := new(util.CountWriter)
-    m.WriteVariableHeader(w)
-    m.WritePayload(w)
-    m.fixedHeader.Len = w.Count()
+    m.fixedHeader.Len = int64(m.VariableHeaderLength() + m.PayloadLength())
     return m.fixedHeader
 }
 
+func (msg *PubAckMessage) VariableHeaderLength() int {
+    if !packet.IsV5(msg.version) {
+        return 2
+    }
+    if msg.varHeader.ReasonCode == errcode.ReasonSuccess && msg.varHeader.props.IsEmpty() {
+        return 2
+    }
+    return 3 + msg.varHeader.props.Length()
+}
+
+func (msg *PubAckMessage) PayloadLength() int {
+    return 0
+}
+
 func (msg *PubAckMessage) ReadVariableHeader(r io.Reader) (int, error) {
-    buf := make([]byte, 2)
-    n, err := r.Read(buf)
+    id, n, err := packet.ReadUint16(r)
     if err != nil {
         return n, err
     }
-    msg.varHeader.PacketIdentifier = uint16(buf[0]<<8 | buf[1])
+    msg.varHeader.PacketIdentifier = id
     if err := msg.fixedHeader.CheckLen(n); err != nil {
         return n, err
     }
 
-    if msg.fixedHeader.RemainLength() == int64(n) {
+    //3.1.1只包含报文标识符
+    if msg.fixedHeader.RemainLength() == int64(n) || !packet.IsV5(msg.version) {
         return n, nil
     }
 
-    n2, err2 := r.Read(buf[:1])
+    code, n2, err2 := packet.ReadByte(r)
     n += n2
     if err2 != nil {
         return n, err2
     }
 
-    msg.varHeader.ReasonCode = buf[0]
+    msg.varHeader.ReasonCode = errcode.ReasonCode(code)
 
-    props, n3, err3 := packet.ReadProperties(r)
+    n3, err3 := msg.varHeader.props.Decode(r, msg.fixedHeader.Type(), msg.controlChars)
     n += n3
     if err3 != nil {
         return n, err3
     }
 
-    msg.varHeader.props = props
-
     return n, nil
 }
 
@@ -92,17 +110,21 @@ func (msg *PubAckMessage) WriteVariableHeader(w io.Writer) (int, error) {
         return n, err
     }
 
-    if msg.varHeader.ReasonCode == errcode.ReasonSuccess && len(msg.varHeader.props) == 0 {
+    if !packet.IsV5(msg.version) {
+        return n, nil
+    }
+
+    if msg.varHeader.ReasonCode == errcode.ReasonSuccess && msg.varHeader.props.IsEmpty() {
         return n, nil
     }
 
-    n2, err2 := w.Write([]byte{msg.varHeader.ReasonCode})
+    n2, err2 := w.Write([]byte{byte(msg.varHeader.ReasonCode)})
     n += n2
     if err2 != nil {
         return n, err2
     }
 
-    n3, err3 := packet.WriteProperties(w, msg.varHeader.props)
+    n3, err3 := msg.varHeader.props.Encode(w, msg.fixedHeader.Type(), msg.controlChars)
     return n + n3, err3
 }
 
@@ -114,15 +136,36 @@ func (msg *PubAckMessage) WritePayload(w io.Writer) (int, error) {
     return 0, nil
 }
 
+//报文属性，可以直接读写属性字段
+func (msg *PubAckMessage) GetProperties() *packet.Properties {
+    return &msg.varHeader.props
+}
+
 func (msg *PubAckMessage) Valid() bool {
-    return true
+    return msg.Validate() == nil
 }
 
-func (msg *PubAckMessage) SetReasonCode(v byte) {
+func (msg *PubAckMessage) Validate() error {
+    if msg.varHeader.PacketIdentifier == 0 {
+        return errcode.PacketIdentifierInvalid
+    }
+    if err := checkReasonCode(msg.varHeader.ReasonCode, msg.fixedHeader.Type(), msg.version); err != nil {
+        return err
+    }
+    return msg.varHeader.props.Check(msg.fixedHeader.Type())
+}
+
+//PUBACK、PUBREC、PUBREL及PUBCOMP允许的原因码各不相同，
+//原因码不能出现在该报文中时返回ReasonCodeInvalid，原有原因码保持不变
+func (msg *PubAckMessage) SetReasonCode(v errcode.ReasonCode) error {
+    if err := checkReasonCode(v, msg.fixedHeader.Type(), msg.version); err != nil {
+        return err
+    }
     msg.varHeader.ReasonCode = v
+    return nil
 }
 
-func (msg *PubAckMessage) GetReasonCode() byte {
+func (msg *PubAckMessage) GetReasonCode() errcode.ReasonCode {
     return msg.varHeader.ReasonCode
 }
 
@@ -137,58 +180,58 @@ func (msg *PubAckMessage) GetPacketIdentifier() uint16 {
 //UTF-8编码的字符串，表示此次响应相关的原因。
 // 此原因字符串（Reason String）是为诊断而设计的可读字符串，不应该被客户端所解析。
 func (m *PubAckMessage) SetReasonString(v string) {
-    p := &packet.PropReasonString{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.ReasonString = packet.StringPtr(v)
 }
 
 //UTF-8编码的字符串，表示此次响应相关的原因。
 // 此原因字符串（Reason String）是为诊断而设计的可读字符串，不应该被客户端所解析。
 func (m *PubAckMessage) GetReasonString() (string, bool) {
-    p := packet.FindPropValue(packet.ReasonString, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.ReasonString == nil {
         return "", false
     }
-    return p.(*packet.PropReasonString).V.String(), true
+    return m.varHeader.props.ReasonString.String(), true
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
 func (m *PubAckMessage) SetUserProperty(props map[string]string) {
     for k, v := range props {
-        p := &packet.PropUserProperty{}
         pair, err := packet.NewStringPair(k, v)
         if err == nil {
-            p.V = pair
-            m.varHeader.props = append(m.varHeader.props, p)
+            m.varHeader.props.UserProperty = append(m.varHeader.props.UserProperty, pair)
         }
     }
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
+//以map形式返回时不保持顺序，同名属性只保留最后一个值，需要完整的用户属性时使用GetUserProperties
 func (m *PubAckMessage) GetUserProperty() (map[string]string, bool) {
     ret := map[string]string{}
-    packet.FindPropValues(packet.UserProperty, m.varHeader.props, func(property packet.Property) bool {
-        if property != nil {
-            p := property.(*packet.PropUserProperty)
-            ret[p.V[0].String()] = p.V[1].String()
-        }
-        return false
-    })
+    for _, p := range m.varHeader.props.UserProperty {
+        ret[p[0].String()] = p[1].String()
+    }
     return ret, len(ret) > 0
 }
 
+//按顺序追加用户属性，同一个名字可以出现多次
+func (m *PubAckMessage) AddUserProperty(key, value string) error {
+    return m.varHeader.props.UserProperty.Add(key, value)
+}
+
+//按顺序获得所有用户属性
+func (m *PubAckMessage) GetUserProperties() packet.UserProperties {
+    return m.varHeader.props.UserProperty
+}
+
+//替换所有用户属性，转发时保持原有顺序
+func (m *PubAckMessage) SetUserProperties(props packet.UserProperties) {
+    m.varHeader.props.UserProperty = props
+}
+
 func (v *PubAckVarHeader) String() string {
-    builder := strings.Builder{}
-    for i := range v.props {
-        builder.WriteString(fmt.Sprintf("\t%v\n", v.props[i]))
-    }
     return fmt.Sprintf("TopicName: %d ReasonCode: %d \nprops:\n%s",
-        v.PacketIdentifier, v.ReasonCode, builder.String())
+        v.PacketIdentifier, v.ReasonCode, v.props.String())
 }
 
 func (v *PubAckMessage) String() string {
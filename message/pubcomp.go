@@ -17,7 +17,10 @@ type PubCompMessage struct {
 
 func NewPubCompMessage() *PubCompMessage {
     ret := &PubCompMessage{
-        PubAckMessage{fixedHeader: packet.CreateFixedHeader(packet.PktTypePUBCOMP, packet.PktFlagPUBCOMP, 0)},
+        PubAckMessage{
+            fixedHeader: packet.CreateFixedHeader(packet.PktTypePUBCOMP, packet.PktFlagPUBCOMP, 0),
+            version:     packet.MqttProtocolVersion,
+        },
     }
     return ret
 }
\ No newline at end of file
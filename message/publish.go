@@ -10,9 +10,10 @@ import (
     "bytes"
     "fmt"
     "io"
+    "mqtt/errcode"
     "mqtt/packet"
+    "mqtt/topic"
     "mqtt/util"
-    "strings"
 )
 
 const (
@@ -25,7 +26,7 @@ type PublishVarHeader struct {
     //只有当QoS等级是1或2时，报文标识符（Packet Identifier）字段才能出现在PUBLISH报文中。
     PacketIdentifier uint16
     //PUBLISH 属性 PUBLISH Properties
-    props []packet.Property
+    props packet.Properties
 
     size int
 }
@@ -34,52 +35,86 @@ type PublishMessage struct {
     fixedHeader packet.FixedHeader
     varHeader   PublishVarHeader
     payload     []byte
+    version     byte
+    codecOption
+
+    //流式payload，payloadSize为其长度
+    payloadReader io.Reader
+    payloadSize   int64
+    //payload超过该长度时以流的方式读取，0表示始终读取到内存
+    streamThreshold int64
 }
 
 func NewPublishMessage() *PublishMessage {
     ret := &PublishMessage{
         fixedHeader: packet.CreateFixedHeader(packet.PktTypePUBLISH, packet.PktFlagPUBLISH, 0),
+        version:     packet.MqttProtocolVersion,
     }
     return ret
 }
 
+func (m *PublishMessage) SetVersion(v byte) {
+    m.version = v
+}
+
+func (m *PublishMessage) GetVersion() byte {
+    return m.version
+}
+
 func (m *PublishMessage) SetFixedHeader(header packet.FixedHeader) {
     m.fixedHeader = header
 }
 
 func (m *PublishMessage) GetFixedHeader() packet.FixedHeader {
-    w := new(util.CountWriter)
-    m.WriteVariableHeader(w)
-    m.WritePayload(w)
-    m.fixedHeader.Len = w.Count()
+    m.fixedHeader.Len = int64(m.VariableHeaderLength() + m.PayloadLength())
     return m.fixedHeader
 }
 
+func (msg *PublishMessage) VariableHeaderLength() int {
+    n := int(msg.varHeader.TopicName.AllLength())
+    if msg.HavePacketIdentifier() {
+        n += 2
+    }
+    if !packet.IsV5(msg.version) {
+        return n
+    }
+    return n + msg.varHeader.props.Length()
+}
+
+func (msg *PublishMessage) PayloadLength() int {
+    if msg.payloadReader != nil {
+        return int(msg.payloadSize)
+    }
+    return len(msg.payload)
+}
+
 func (msg *PublishMessage) ReadVariableHeader(r io.Reader) (int, error) {
-    s, n, err := packet.ParseString(r)
+    s, n, err := packet.ParseString(r, msg.controlChars)
     if err != nil {
         return n, err
     }
     msg.varHeader.TopicName = *s
 
     if msg.HavePacketIdentifier() {
-        buf := make([]byte, 2)
-        n2, err2 := r.Read(buf)
+        id, n2, err2 := packet.ReadUint16(r)
         n += n2
         if err2 != nil {
             return n, err2
         }
 
-        msg.varHeader.PacketIdentifier = uint16(buf[0]<<8 | buf[1])
+        msg.varHeader.PacketIdentifier = id
     }
 
-    props, n3, err3 := packet.ReadProperties(r)
-    n += n3
-    if err3 != nil {
-        return n, err3
+    if packet.IsV5(msg.version) {
+        n3, err3 := msg.varHeader.props.Decode(r, msg.fixedHeader.Type(), msg.controlChars)
+        n += n3
+        if err3 != nil {
+            return n, err3
+        }
+    }
+    if err := msg.checkTopicName(); err != nil {
+        return n, err
     }
-
-    msg.varHeader.props = props
 
     msg.varHeader.size = n
 
@@ -87,7 +122,14 @@ func (msg *PublishMessage) ReadVariableHeader(r io.Reader) (int, error) {
 }
 
 func (msg *PublishMessage) WriteVariableHeader(w io.Writer) (int, error) {
-    n, err := packet.WriteString(w, msg.varHeader.TopicName)
+    //先校验主题名，校验失败时不写入任何数据
+    if err := packet.ValidateString(msg.varHeader.TopicName, msg.controlChars); err != nil {
+        return 0, err
+    }
+    if err := msg.checkTopicName(); err != nil {
+        return 0, err
+    }
+    n, err := packet.WriteString(w, msg.varHeader.TopicName, msg.controlChars)
     if err != nil {
         return n, err
     }
@@ -103,7 +145,11 @@ func (msg *PublishMessage) WriteVariableHeader(w io.Writer) (int, error) {
         }
     }
 
-    n3, err3 := packet.WriteProperties(w, msg.varHeader.props)
+    if !packet.IsV5(msg.version) {
+        return n, nil
+    }
+
+    n3, err3 := msg.varHeader.props.Encode(w, msg.fixedHeader.Type(), msg.controlChars)
     return n + n3, err3
 }
 
@@ -114,20 +160,37 @@ func (msg *PublishMessage) ReadPayload(r io.Reader) (n int, err error) {
     //size = size - w.Count()
     size = size - int64(msg.varHeader.size)
 
-    if size <= PayloadBufSize {
-        buf := make([]byte, size)
-        n, err = r.Read(buf)
+    if size < 0 {
+        return 0, errcode.MessageReadSizeNotMatch
+    }
+
+    //SliceReader直接引用原数据
+    _, isSlice := r.(*packet.SliceReader)
+    if !isSlice && msg.streamThreshold > 0 && size > msg.streamThreshold {
+        //payload由调用者通过GetPayloadReader读取，这里不读取任何数据
+        msg.payload = nil
+        msg.payloadReader = io.LimitReader(r, size)
+        msg.payloadSize = size
+        return 0, nil
+    }
+
+    if isSlice || size <= PayloadBufSize {
+        buf, rn, err := packet.ReadN(r, int(size))
         if err != nil {
-            return n, err
+            return rn, err
         }
         msg.payload = buf
+        n = rn
     } else {
-        buf := bytes.NewBuffer(make([]byte, PayloadBufSize))
+        buf := bytes.NewBuffer(make([]byte, 0, PayloadBufSize))
         x, err := util.CopyN(buf, r, size)
+        n = int(x)
         if err != nil {
-            return int(n), err
+            return n, err
+        }
+        if x < size {
+            return n, io.ErrUnexpectedEOF
         }
-        n = int(x)
         msg.payload = buf.Bytes()
     }
 
@@ -135,6 +198,16 @@ func (msg *PublishMessage) ReadPayload(r io.Reader) (n int, err error) {
 }
 
 func (msg *PublishMessage) WritePayload(w io.Writer) (int, error) {
+    if msg.payloadReader != nil {
+        n, err := util.CopyN(w, msg.payloadReader, msg.payloadSize)
+        if err != nil {
+            return int(n), err
+        }
+        if n < msg.payloadSize {
+            return int(n), io.ErrUnexpectedEOF
+        }
+        return int(n), nil
+    }
     return w.Write(msg.payload)
 }
 
@@ -144,6 +217,11 @@ func (msg *PublishMessage) SetDup(v bool) {
     msg.fixedHeader.SetDup(v)
 }
 
+func (msg *PublishMessage) GetDup() bool {
+    dup, _, _ := msg.fixedHeader.PubFlag()
+    return dup
+}
+
 //QoS值	Bit2	Bit1	说明
 //0	    0	    0	最多分发一次
 //1	    0	    1	至少分发一次
@@ -153,6 +231,11 @@ func (msg *PublishMessage) SetQos(v byte) {
     msg.fixedHeader.SetQos(v)
 }
 
+func (msg *PublishMessage) GetQos() byte {
+    _, qos, _ := msg.fixedHeader.PubFlag()
+    return qos
+}
+
 //只有当QoS等级是1或2时，报文标识符（Packet Identifier）字段才能出现在PUBLISH报文中
 func (msg *PublishMessage) HavePacketIdentifier() bool {
     _, qos, _ := msg.fixedHeader.PubFlag()
@@ -165,6 +248,11 @@ func (msg *PublishMessage) SetRetain(v bool) {
     msg.fixedHeader.SetRetain(v)
 }
 
+func (msg *PublishMessage) GetRetain() bool {
+    _, _, retain := msg.fixedHeader.PubFlag()
+    return retain
+}
+
 //主题名（Topic Name）用于识别有效载荷数据应该被发布到哪一个信息通道。
 func (msg *PublishMessage) SetTopicName(v string) {
     s, err := packet.FromString(v)
@@ -190,187 +278,228 @@ func (msg *PublishMessage) GetPacketIdentifier() uint16 {
 //0 (0x00)，说明载荷是未指定格式的字节，相当于没有发送载荷格式指示。
 //1 (0x01)，说明载荷是UTF-8编码的字符数据。载荷中的UTF-8数据必须是按照Unicode [Unicode]的规范和RFC 3629 [RFC3629]的重申进行编码。
 func (m *PublishMessage) SetPayloadFormatIndicator(v byte) {
-    p := &packet.PropPayloadFormatIndicator{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.PayloadFormatIndicator = &v
 }
 
 //单字节的载荷格式指示值，可以是：
 //0 (0x00)，说明载荷是未指定格式的字节，相当于没有发送载荷格式指示。
 //1 (0x01)，说明载荷是UTF-8编码的字符数据。载荷中的UTF-8数据必须是按照Unicode [Unicode]的规范和RFC 3629 [RFC3629]的重申进行编码。
 func (m *PublishMessage) GetPayloadFormatIndicator() (byte, bool) {
-    p := packet.FindPropValue(packet.PayloadFormatIndicator, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.PayloadFormatIndicator == nil {
         return 0, false
     }
-    return p.(*packet.PropPayloadFormatIndicator).V, true
+    return *m.varHeader.props.PayloadFormatIndicator, true
 }
 
 //如果消息过期间隔存在，四字节整数表示以秒为单位的应用消息（Application Message）生命周期。
 //如果消息过期间隔（Message Expiry Interval）已过期，服务端还没开始向匹配的订阅者交付该消息，则服务端必须删除该订阅者的消息副本
 func (m *PublishMessage) SetMessageExpiryInterval(v uint32) {
-    p := &packet.PropMessageExpiryInterval{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.MessageExpiryInterval = &v
 }
 
 //如果消息过期间隔存在，四字节整数表示以秒为单位的应用消息（Application Message）生命周期。
 //如果消息过期间隔（Message Expiry Interval）已过期，服务端还没开始向匹配的订阅者交付该消息，则服务端必须删除该订阅者的消息副本
 func (m *PublishMessage) GetMessageExpiryInterval() (uint32, bool) {
-    p := packet.FindPropValue(packet.MessageExpiryInterval, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.MessageExpiryInterval == nil {
         return 0, false
     }
-    return p.(*packet.PropMessageExpiryInterval).V, true
+    return *m.varHeader.props.MessageExpiryInterval, true
 }
 
 //包含多个主题别名值将造成协议错误（Protocol Error）。
 //主题别名是一个整数，用来代替主题名对主题进行识别。
 func (m *PublishMessage) SetTopicAlias(v uint16) {
-    p := &packet.PropTopicAlias{}
-    p.V = v
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.TopicAlias = &v
 }
 
 //包含多个主题别名值将造成协议错误（Protocol Error）。
 //主题别名是一个整数，用来代替主题名对主题进行识别。
 func (m *PublishMessage) GetTopicAlias() (uint16, bool) {
-    p := packet.FindPropValue(packet.TopicAlias, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.TopicAlias == nil {
         return 0, false
     }
-    return p.(*packet.PropTopicAlias).V, true
+    return *m.varHeader.props.TopicAlias, true
 }
 
 //用作响应消息的主题名。
 //包含多个响应主题将造成协议错误（Protocol Error）。
 func (m *PublishMessage) SetResponseTopic(v string) {
-    p := &packet.PropResponseTopic{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.ResponseTopic = packet.StringPtr(v)
 }
 
 //用作响应消息的主题名。
 //包含多个响应主题将造成协议错误（Protocol Error）。
 func (m *PublishMessage) GetResponseTopic() (string, bool) {
-    p := packet.FindPropValue(packet.ResponseTopic, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.ResponseTopic == nil {
         return "", false
     }
-    return p.(*packet.PropResponseTopic).V.String(), true
+    return m.varHeader.props.ResponseTopic.String(), true
 }
 
 //对比数据被请求消息发送端在收到响应消息时用来标识相应的请求。包含多个对比数据将造成协议错误（Protocol Error）。
 //如果没有设置对比数据，则请求方（Requester）不需要任何对比数据。
 func (m *PublishMessage) SetCorrelationData(v []byte) {
-    p := &packet.PropCorrelationData{}
-    s, err := packet.FromString(string(v))
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.CorrelationData = v
 }
 
 //对比数据被请求消息发送端在收到响应消息时用来标识相应的请求。包含多个对比数据将造成协议错误（Protocol Error）。
 //如果没有设置对比数据，则请求方（Requester）不需要任何对比数据。
 func (m *PublishMessage) GetCorrelationData() ([]byte, bool) {
-    p := packet.FindPropValue(packet.CorrelationData, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.CorrelationData == nil {
         return nil, false
     }
-    return []byte(p.(*packet.PropCorrelationData).V.String()), true
+    return m.varHeader.props.CorrelationData, true
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
 func (m *PublishMessage) SetUserProperty(props map[string]string) {
     for k, v := range props {
-        p := &packet.PropUserProperty{}
         pair, err := packet.NewStringPair(k, v)
         if err == nil {
-            p.V = pair
-            m.varHeader.props = append(m.varHeader.props, p)
+            m.varHeader.props.UserProperty = append(m.varHeader.props.UserProperty, pair)
         }
     }
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
+//以map形式返回时不保持顺序，同名属性只保留最后一个值，需要完整的用户属性时使用GetUserProperties
 func (m *PublishMessage) GetUserProperty() (map[string]string, bool) {
     ret := map[string]string{}
-    packet.FindPropValues(packet.UserProperty, m.varHeader.props, func(property packet.Property) bool {
-        if property != nil {
-            p := property.(*packet.PropUserProperty)
-            ret[p.V[0].String()] = p.V[1].String()
-        }
-        return false
-    })
+    for _, p := range m.varHeader.props.UserProperty {
+        ret[p[0].String()] = p[1].String()
+    }
     return ret, len(ret) > 0
 }
 
+//按顺序追加用户属性，同一个名字可以出现多次
+func (m *PublishMessage) AddUserProperty(key, value string) error {
+    return m.varHeader.props.UserProperty.Add(key, value)
+}
+
+//按顺序获得所有用户属性
+func (m *PublishMessage) GetUserProperties() packet.UserProperties {
+    return m.varHeader.props.UserProperty
+}
+
+//替换所有用户属性，转发时保持原有顺序
+func (m *PublishMessage) SetUserProperties(props packet.UserProperties) {
+    m.varHeader.props.UserProperty = props
+}
+
 //订阅标识符取值范围从1到268,435,455。订阅标识符的值为0将造成协议错误。
 //如果某条发布消息匹配了多个订阅，则将包含多个订阅标识符。这种情况下他们的顺序并不重要。
 func (m *PublishMessage) SetSubscriptionIdentifier(v uint64) {
-    p := &packet.PropSubscriptionIdentifier{}
-    p.V.InitFromUInt64(v)
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.SubscriptionIdentifier = append(m.varHeader.props.SubscriptionIdentifier, uint32(v))
 }
 
 //订阅标识符取值范围从1到268,435,455。订阅标识符的值为0将造成协议错误。
 //如果某条发布消息匹配了多个订阅，则将包含多个订阅标识符。这种情况下他们的顺序并不重要。
 func (m *PublishMessage) GetSubscriptionIdentifier() (int64, bool) {
-    p := packet.FindPropValue(packet.SubscriptionIdentifier, m.varHeader.props)
-    if p == nil {
+    if len(m.varHeader.props.SubscriptionIdentifier) == 0 {
         return 0, false
     }
-    return p.(*packet.PropSubscriptionIdentifier).V.ToInt(), true
+    return int64(m.varHeader.props.SubscriptionIdentifier[0]), true
 }
 
 //用来描述应用消息的内容。
 //包含多个内容类型将造成协议错误（Protocol Error）。
 //内容类型的值由发送应用程序和接收应用程序确定。
 func (m *PublishMessage) SetContentType(v string) {
-    p := &packet.PropContentType{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.ContentType = packet.StringPtr(v)
 }
 
 //用来描述应用消息的内容。
 //包含多个内容类型将造成协议错误（Protocol Error）。
 //内容类型的值由发送应用程序和接收应用程序确定。
 func (m *PublishMessage) GetContentType() (string, bool) {
-    p := packet.FindPropValue(packet.ContentType, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.ContentType == nil {
         return "", false
     }
-    return p.(*packet.PropContentType).V.String(), true
+    return m.varHeader.props.ContentType.String(), true
 }
 
 func (m *PublishMessage) SetPayload(v []byte) {
     m.payload = v
+    m.payloadReader = nil
+    m.payloadSize = 0
+}
+
+//设置流式payload，写报文时从r中读取size个字节作为payload，r中的数据不足时写报文返回io.ErrUnexpectedEOF
+func (m *PublishMessage) SetPayloadReader(r io.Reader, size int64) {
+    m.payload = nil
+    m.payloadReader = r
+    m.payloadSize = size
+}
+
+//获得payload的Reader。流式读取的报文，必须在读取下一个报文前读完该Reader中的数据
+func (m *PublishMessage) GetPayloadReader() io.Reader {
+    if m.payloadReader != nil {
+        return m.payloadReader
+    }
+    return bytes.NewReader(m.payload)
+}
+
+//是否为流式payload，流式payload不能通过GetPayload获得
+func (m *PublishMessage) IsPayloadStream() bool {
+    return m.payloadReader != nil
+}
+
+//payload的长度
+func (m *PublishMessage) GetPayloadSize() int64 {
+    return int64(m.PayloadLength())
+}
+
+//设置读取时以流的方式处理payload的长度阈值，payload超过该长度时不读取到内存，0表示不使用流式读取
+func (m *PublishMessage) SetStreamThreshold(v int64) {
+    m.streamThreshold = v
 }
 
 func (m *PublishMessage) GetPayload() []byte {
     return m.payload
 }
 
+//报文属性，可以直接读写属性字段
+func (m *PublishMessage) GetProperties() *packet.Properties {
+    return &m.varHeader.props
+}
+
+//浅拷贝，payload及属性中的切片与原报文共享。
+//用于发送前修改主题名、主题别名等字段而不影响原报文（如会话中等待重发的报文）
+func (m *PublishMessage) ShallowCopy() *PublishMessage {
+    ret := *m
+    return &ret
+}
+
 func (m *PublishMessage) Valid() bool {
-    return true
+    return m.Validate() == nil
 }
 
-func (v *PublishVarHeader) String() string {
-    builder := strings.Builder{}
-    for i := range v.props {
-        builder.WriteString(fmt.Sprintf("\t%v\n", v.props[i]))
+func (m *PublishMessage) Validate() error {
+    if m.HavePacketIdentifier() && m.varHeader.PacketIdentifier == 0 {
+        return errcode.PacketIdentifierInvalid
+    }
+    if err := m.checkTopicName(); err != nil {
+        return err
+    }
+    return m.varHeader.props.Check(m.fixedHeader.Type())
+}
+
+//主题名不能包含通配符 [MQTT-3.3.2-2]，编解码时均做校验。
+//MQTT 5.0设置了主题别名时主题名可以为空 [MQTT-3.3.2-8]
+func (m *PublishMessage) checkTopicName() error {
+    name := m.varHeader.TopicName.String()
+    if name == "" && packet.IsV5(m.version) && m.varHeader.props.TopicAlias != nil {
+        return nil
     }
+    _, err := topic.ParseName(name)
+    return err
+}
+
+func (v *PublishVarHeader) String() string {
     return fmt.Sprintf("TopicName: %s ReasonCode: %d \nprops:\n%s",
-        v.TopicName.String(), v.PacketIdentifier, builder.String())
+        v.TopicName.String(), v.PacketIdentifier, v.props.String())
 }
 
 func (v *PublishMessage) String() string {
@@ -15,7 +15,10 @@ type PubRecMessage struct {
 
 func NewPubRecMessage() *PubRecMessage {
     ret := &PubRecMessage{
-        PubAckMessage{fixedHeader: packet.CreateFixedHeader(packet.PktTypePUBREC, packet.PktFlagPUBREC, 0)},
+        PubAckMessage{
+            fixedHeader: packet.CreateFixedHeader(packet.PktTypePUBREC, packet.PktFlagPUBREC, 0),
+            version:     packet.MqttProtocolVersion,
+        },
     }
     return ret
 }
\ No newline at end of file
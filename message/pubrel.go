@@ -17,7 +17,10 @@ type PubRelMessage struct {
 
 func NewPubRelMessage() *PubRelMessage {
     ret := &PubRelMessage{
-        PubAckMessage{fixedHeader: packet.CreateFixedHeader(packet.PktTypePUBREL, packet.PktFlagPUBREL, 0)},
+        PubAckMessage{
+            fixedHeader: packet.CreateFixedHeader(packet.PktTypePUBREL, packet.PktFlagPUBREL, 0),
+            version:     packet.MqttProtocolVersion,
+        },
     }
     return ret
 }
\ No newline at end of file
@@ -10,9 +10,9 @@ import (
     "bytes"
     "fmt"
     "io"
+    "mqtt/errcode"
     "mqtt/packet"
     "mqtt/util"
-    "strings"
 )
 
 type SubAckVarHeader struct {
@@ -20,7 +20,7 @@ type SubAckVarHeader struct {
     PacketIdentifier uint16
 
     //PUBLISH 属性 PUBLISH Properties
-    props []packet.Property
+    props packet.Properties
 
     size int
 }
@@ -29,50 +29,67 @@ type SubAckVarHeader struct {
 type SubAckMessage struct {
     fixedHeader packet.FixedHeader
     varHeader   SubAckVarHeader
+    version     byte
     payload     []byte
+    codecOption
 }
 
 func NewSubAckMessage() *SubAckMessage {
     ret := &SubAckMessage{
         fixedHeader: packet.CreateFixedHeader(packet.PktTypeSUBACK, packet.PktFlagSUBACK, 0),
+        version:     packet.MqttProtocolVersion,
     }
     return ret
 }
 
+func (m *SubAckMessage) SetVersion(v byte) {
+    m.version = v
+}
+
+func (m *SubAckMessage) GetVersion() byte {
+    return m.version
+}
+
 func (m *SubAckMessage) SetFixedHeader(header packet.FixedHeader) {
     m.fixedHeader = header
 }
 
 func (m *SubAckMessage) GetFixedHeader() packet.FixedHeader {
-    w := new(util.CountWriter)
-    m.WriteVariableHeader(w)
-    m.WritePayload(w)
-    m.fixedHeader.Len = w.Count()
+    m.fixedHeader.Len = int64(m.VariableHeaderLength() + m.PayloadLength())
     return m.fixedHeader
 }
 
+func (msg *SubAckMessage) VariableHeaderLength() int {
+    if !packet.IsV5(msg.version) {
+        return 2
+    }
+    return 2 + msg.varHeader.props.Length()
+}
+
+func (msg *SubAckMessage) PayloadLength() int {
+    return len(msg.payload)
+}
+
 func (msg *SubAckMessage) ReadVariableHeader(r io.Reader) (int, error) {
-    buf := make([]byte, 2)
-    n, err := r.Read(buf)
+    id, n, err := packet.ReadUint16(r)
     if err != nil {
         return n, err
     }
-    msg.varHeader.PacketIdentifier = uint16(buf[0]<<8 | buf[1])
+    msg.varHeader.PacketIdentifier = id
     if err := msg.fixedHeader.CheckLen(n); err != nil {
         return n, err
     }
 
-    if msg.fixedHeader.RemainLength() == int64(n) {
+    if msg.fixedHeader.RemainLength() == int64(n) || !packet.IsV5(msg.version) {
+        msg.varHeader.size = n
         return n, nil
     }
 
-    props, n2, err2 := packet.ReadProperties(r)
+    n2, err2 := msg.varHeader.props.Decode(r, msg.fixedHeader.Type(), msg.controlChars)
     n += n2
     if err2 != nil {
         return n, err2
     }
-
-    msg.varHeader.props = props
     msg.varHeader.size = n
 
     return n, nil
@@ -87,7 +104,11 @@ func (msg *SubAckMessage) WriteVariableHeader(w io.Writer) (int, error) {
         return n, err
     }
 
-    n2, err2 := packet.WriteProperties(w, msg.varHeader.props)
+    if !packet.IsV5(msg.version) {
+        return n, nil
+    }
+
+    n2, err2 := msg.varHeader.props.Encode(w, msg.fixedHeader.Type(), msg.controlChars)
     return n + n2, err2
 }
 
@@ -95,15 +116,16 @@ func (msg *SubAckMessage) ReadPayload(r io.Reader) (n int, err error) {
     size := msg.fixedHeader.RemainLength()
     size = size - int64(msg.varHeader.size)
 
-    if size <= PayloadBufSize {
-        buf := make([]byte, size)
-        n, err = r.Read(buf)
+    //SliceReader直接引用原数据
+    if _, ok := r.(*packet.SliceReader); ok || size <= PayloadBufSize {
+        buf, rn, err := packet.ReadN(r, int(size))
         if err != nil {
-            return n, err
+            return rn, err
         }
         msg.payload = buf
+        n = rn
     } else {
-        buf := bytes.NewBuffer(make([]byte, PayloadBufSize))
+        buf := bytes.NewBuffer(make([]byte, 0, PayloadBufSize))
         x, err := util.CopyN(buf, r, size)
         if err != nil {
             return int(n), err
@@ -119,8 +141,23 @@ func (msg *SubAckMessage) WritePayload(w io.Writer) (int, error) {
     return w.Write(msg.payload)
 }
 
+//报文属性，可以直接读写属性字段
+func (msg *SubAckMessage) GetProperties() *packet.Properties {
+    return &msg.varHeader.props
+}
+
 func (msg *SubAckMessage) Valid() bool {
-    return true
+    return msg.Validate() == nil
+}
+
+func (msg *SubAckMessage) Validate() error {
+    if msg.varHeader.PacketIdentifier == 0 {
+        return errcode.PacketIdentifierInvalid
+    }
+    if err := checkReasonCodes(msg.payload, msg.fixedHeader.Type(), msg.version); err != nil {
+        return err
+    }
+    return msg.varHeader.props.Check(msg.fixedHeader.Type())
 }
 
 func (msg *SubAckMessage) SetPacketIdentifier(v uint16) {
@@ -131,8 +168,13 @@ func (msg *SubAckMessage) GetPacketIdentifier() uint16 {
     return msg.varHeader.PacketIdentifier
 }
 
-func (m *SubAckMessage) SetPayload(v []byte) {
+//payload为每个主题过滤器对应的原因码，包含不能出现在该报文中的原因码时返回ReasonCodeInvalid，原有payload保持不变
+func (m *SubAckMessage) SetPayload(v []byte) error {
+    if err := checkReasonCodes(v, m.fixedHeader.Type(), m.version); err != nil {
+        return err
+    }
     m.payload = v
+    return nil
 }
 
 func (m *SubAckMessage) GetPayload() []byte {
@@ -142,58 +184,58 @@ func (m *SubAckMessage) GetPayload() []byte {
 //UTF-8编码的字符串，表示此次响应相关的原因。
 // 此原因字符串（Reason String）是为诊断而设计的可读字符串，不应该被客户端所解析。
 func (m *SubAckMessage) SetReasonString(v string) {
-    p := &packet.PropReasonString{}
-    s, err := packet.FromString(v)
-    if err == nil {
-        p.V = s
-        m.varHeader.props = append(m.varHeader.props, p)
-    }
+    m.varHeader.props.ReasonString = packet.StringPtr(v)
 }
 
 //UTF-8编码的字符串，表示此次响应相关的原因。
 // 此原因字符串（Reason String）是为诊断而设计的可读字符串，不应该被客户端所解析。
 func (m *SubAckMessage) GetReasonString() (string, bool) {
-    p := packet.FindPropValue(packet.ReasonString, m.varHeader.props)
-    if p == nil {
+    if m.varHeader.props.ReasonString == nil {
         return "", false
     }
-    return p.(*packet.PropReasonString).V.String(), true
+    return m.varHeader.props.ReasonString.String(), true
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
 func (m *SubAckMessage) SetUserProperty(props map[string]string) {
     for k, v := range props {
-        p := &packet.PropUserProperty{}
         pair, err := packet.NewStringPair(k, v)
         if err == nil {
-            p.V = pair
-            m.varHeader.props = append(m.varHeader.props, p)
+            m.varHeader.props.UserProperty = append(m.varHeader.props.UserProperty, pair)
         }
     }
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
+//以map形式返回时不保持顺序，同名属性只保留最后一个值，需要完整的用户属性时使用GetUserProperties
 func (m *SubAckMessage) GetUserProperty() (map[string]string, bool) {
     ret := map[string]string{}
-    packet.FindPropValues(packet.UserProperty, m.varHeader.props, func(property packet.Property) bool {
-        if property != nil {
-            p := property.(*packet.PropUserProperty)
-            ret[p.V[0].String()] = p.V[1].String()
-        }
-        return false
-    })
+    for _, p := range m.varHeader.props.UserProperty {
+        ret[p[0].String()] = p[1].String()
+    }
     return ret, len(ret) > 0
 }
 
+//按顺序追加用户属性，同一个名字可以出现多次
+func (m *SubAckMessage) AddUserProperty(key, value string) error {
+    return m.varHeader.props.UserProperty.Add(key, value)
+}
+
+//按顺序获得所有用户属性
+func (m *SubAckMessage) GetUserProperties() packet.UserProperties {
+    return m.varHeader.props.UserProperty
+}
+
+//替换所有用户属性，转发时保持原有顺序
+func (m *SubAckMessage) SetUserProperties(props packet.UserProperties) {
+    m.varHeader.props.UserProperty = props
+}
+
 func (v *SubAckVarHeader) String() string {
-    builder := strings.Builder{}
-    for i := range v.props {
-        builder.WriteString(fmt.Sprintf("\t%v\n", v.props[i]))
-    }
     return fmt.Sprintf("TopicName: %d\nprops:\n%s",
-        v.PacketIdentifier, builder.String())
+        v.PacketIdentifier, v.props.String())
 }
 
 func (v *SubAckMessage) String() string {
@@ -11,20 +11,92 @@ import (
     "io"
     "mqtt/errcode"
     "mqtt/packet"
-    "mqtt/util"
+    "mqtt/topic"
     "strings"
 )
 
+const (
+    //订阅建立时发送保留消息
+    RetainHandlingSendAtSubscribe = 0
+    //订阅建立时，如果订阅不存在则发送保留消息
+    RetainHandlingSendIfNotExist = 1
+    //订阅建立时不发送保留消息
+    RetainHandlingDoNotSend = 2
+)
+
+//订阅选项
+//bit 0-1：最大QoS；bit 2：No Local；bit 3：Retain As Published；bit 4-5：Retain Handling；bit 6-7：保留，必须为0。
+//MQTT 3.1.1只使用bit 0-1，其他位为保留位
+type SubscriptionOptions struct {
+    //服务端可以向此客户端发送的应用消息的最大QoS等级
+    QoS byte
+    //为true时，应用消息不能被转发给发布此消息的客户端（ClientID相同）
+    NoLocal bool
+    //为true时，转发应用消息时保持其发布时设置的保留标志
+    RetainAsPublished bool
+    //订阅建立时是否发送保留消息
+    RetainHandling byte
+}
+
 type SubscribeFilter struct {
-    Filter string
-    Opt    byte
+    Filter  string
+    Options SubscriptionOptions
+}
+
+//解析订阅选项，version为MQTT协议版本。
+//保留位不为0时返回SubscribeReservedInvalid，其他取值错误见SubscriptionOptions.Validate
+func DecodeSubscriptionOptions(b byte, version byte) (SubscriptionOptions, error) {
+    opts := SubscriptionOptions{QoS: b & 0x03}
+    if packet.IsV5(version) {
+        if b&0xC0 != 0 {
+            return opts, errcode.SubscribeReservedInvalid
+        }
+        opts.NoLocal = b&0x04 != 0
+        opts.RetainAsPublished = b&0x08 != 0
+        opts.RetainHandling = (b >> 4) & 0x03
+    } else if b&0xFC != 0 {
+        return opts, errcode.SubscribeReservedInvalid
+    }
+    return opts, opts.Validate()
+}
+
+//编码订阅选项，MQTT 3.1.1只编码QoS
+func (o SubscriptionOptions) Encode(version byte) byte {
+    b := o.QoS & 0x03
+    if !packet.IsV5(version) {
+        return b
+    }
+    if o.NoLocal {
+        b |= 0x04
+    }
+    if o.RetainAsPublished {
+        b |= 0x08
+    }
+    b |= (o.RetainHandling & 0x03) << 4
+    return b
+}
+
+//最大QoS为3时返回SubscribeQosInvalid，Retain Handling为3时返回RetainHandlingInvalid
+func (o SubscriptionOptions) Validate() error {
+    if o.QoS > 2 {
+        return errcode.SubscribeQosInvalid
+    }
+    if o.RetainHandling > RetainHandlingDoNotSend {
+        return errcode.RetainHandlingInvalid
+    }
+    return nil
+}
+
+func (o SubscriptionOptions) String() string {
+    return fmt.Sprintf("QoS: %d NoLocal: %t RetainAsPublished: %t RetainHandling: %d",
+        o.QoS, o.NoLocal, o.RetainAsPublished, o.RetainHandling)
 }
 
 type SubscribeVarHeader struct {
     //报文标识符（Packet Identifier）字段才能出现在PUBLISH报文中。
     PacketIdentifier uint16
     //PUBLISH 属性 PUBLISH Properties
-    props []packet.Property
+    props packet.Properties
 
     //VarHeader size
     size int
@@ -33,51 +105,72 @@ type SubscribeVarHeader struct {
 type SubscribeMessage struct {
     fixedHeader packet.FixedHeader
     varHeader   SubscribeVarHeader
+    version     byte
     payload     []SubscribeFilter
+    codecOption
 }
 
 func NewSubscribeMessage() *SubscribeMessage {
     ret := &SubscribeMessage{
         fixedHeader: packet.CreateFixedHeader(packet.PktTypeSUBSCRIBE, packet.PktFlagSUBSCRIBE, 0),
+        version:     packet.MqttProtocolVersion,
     }
     return ret
 }
 
+func (m *SubscribeMessage) SetVersion(v byte) {
+    m.version = v
+}
+
+func (m *SubscribeMessage) GetVersion() byte {
+    return m.version
+}
+
 func (m *SubscribeMessage) SetFixedHeader(header packet.FixedHeader) {
     m.fixedHeader = header
 }
 
 func (m *SubscribeMessage) GetFixedHeader() packet.FixedHeader {
-    w := new(util.CountWriter)
-    m.WriteVariableHeader(w)
-    m.WritePayload(w)
-    m.fixedHeader.Len = w.Count()
+    m.fixedHeader.Len = int64(m.VariableHeaderLength() + m.PayloadLength())
     return m.fixedHeader
 }
 
+func (msg *SubscribeMessage) VariableHeaderLength() int {
+    if !packet.IsV5(msg.version) {
+        return 2
+    }
+    return 2 + msg.varHeader.props.Length()
+}
+
+func (msg *SubscribeMessage) PayloadLength() int {
+    n := 0
+    for _, v := range msg.payload {
+        n += 2 + len(v.Filter) + 1
+    }
+    return n
+}
+
 func (msg *SubscribeMessage) ReadVariableHeader(r io.Reader) (int, error) {
-    buf := make([]byte, 2)
-    n, err := r.Read(buf)
+    id, n, err := packet.ReadUint16(r)
     if err != nil {
         return n, err
     }
-    msg.varHeader.PacketIdentifier = uint16(buf[0]<<8 | buf[1])
+    msg.varHeader.PacketIdentifier = id
     if err := msg.fixedHeader.CheckLen(n); err != nil {
         return n, err
     }
 
-    if msg.fixedHeader.RemainLength() == int64(n) {
+    if msg.fixedHeader.RemainLength() == int64(n) || !packet.IsV5(msg.version) {
+        msg.varHeader.size = n
         return n, nil
     }
 
-    props, n2, err2 := packet.ReadProperties(r)
+    n2, err2 := msg.varHeader.props.Decode(r, msg.fixedHeader.Type(), msg.controlChars)
     n += n2
     if err2 != nil {
         return n, err2
     }
 
-    msg.varHeader.props = props
-
     msg.varHeader.size = n
 
     return n, nil
@@ -92,7 +185,11 @@ func (msg *SubscribeMessage) WriteVariableHeader(w io.Writer) (int, error) {
         return n, err
     }
 
-    n2, err2 := packet.WriteProperties(w, msg.varHeader.props)
+    if !packet.IsV5(msg.version) {
+        return n, nil
+    }
+
+    n2, err2 := msg.varHeader.props.Encode(w, msg.fixedHeader.Type(), msg.controlChars)
     return n + n2, err2
 }
 
@@ -102,19 +199,25 @@ func (msg *SubscribeMessage) ReadPayload(r io.Reader) (int, error) {
 
     n := 0
     var filters []SubscribeFilter
-    buf := make([]byte, 1)
     for n < size {
-        s, rd, err := packet.ParseString(r)
+        s, rd, err := packet.ParseString(r, msg.controlChars)
         n += rd
         if err != nil {
             return n, err
         }
-        rd, err = r.Read(buf)
+        if _, err := topic.ParseFilter(s.String()); err != nil {
+            return n, err
+        }
+        b, rd, err := packet.ReadByte(r)
         n += rd
         if err != nil {
             return n, err
         }
-        filters = append(filters, SubscribeFilter{Filter: s.String(), Opt: buf[0]})
+        opts, err := DecodeSubscriptionOptions(b, msg.version)
+        if err != nil {
+            return n, err
+        }
+        filters = append(filters, SubscribeFilter{Filter: s.String(), Options: opts})
     }
 
     if n > size {
@@ -131,18 +234,28 @@ func (msg *SubscribeMessage) WritePayload(w io.Writer) (int, error) {
         return 0, errcode.ProtocolError
     }
 
+    //先校验全部订阅，校验失败时不写入任何数据
+    for _, v := range msg.payload {
+        if err := v.Options.Validate(); err != nil {
+            return 0, err
+        }
+        if err := checkFilter(v.Filter, msg.controlChars); err != nil {
+            return 0, err
+        }
+    }
+
     n := 0
     for _, v := range msg.payload {
         s, errS := packet.FromString(v.Filter)
         if errS != nil {
             return n, errS
         }
-        wt, err := packet.WriteString(w, s)
+        wt, err := packet.WriteString(w, s, msg.controlChars)
         n += wt
         if err != nil {
             return n, err
         }
-        wt, err = w.Write([]byte{v.Opt})
+        wt, err = w.Write([]byte{v.Options.Encode(msg.version)})
         n += wt
         if err != nil {
             return n, err
@@ -151,52 +264,102 @@ func (msg *SubscribeMessage) WritePayload(w io.Writer) (int, error) {
     return n, nil
 }
 
+//校验主题过滤器的长度、UTF-8编码及格式，SUBSCRIBE及UNSUBSCRIBE写入前使用
+func checkFilter(filter string, policy packet.ControlCharPolicy) error {
+    s, err := packet.FromString(filter)
+    if err != nil {
+        return err
+    }
+    if err := packet.ValidateString(s, policy); err != nil {
+        return err
+    }
+    _, err = topic.ParseFilter(filter)
+    return err
+}
+
+//报文属性，可以直接读写属性字段
+func (msg *SubscribeMessage) GetProperties() *packet.Properties {
+    return &msg.varHeader.props
+}
+
 func (msg *SubscribeMessage) Valid() bool {
-    return true
+    return msg.Validate() == nil
+}
+
+func (msg *SubscribeMessage) Validate() error {
+    if msg.varHeader.PacketIdentifier == 0 {
+        return errcode.PacketIdentifierInvalid
+    }
+    if len(msg.payload) == 0 {
+        return errcode.SubscribeMissTopicFilter
+    }
+    for _, v := range msg.payload {
+        f, err := topic.ParseFilter(v.Filter)
+        if err != nil {
+            return err
+        }
+        if err := v.Options.Validate(); err != nil {
+            return err
+        }
+        if f.IsShared() && v.Options.NoLocal {
+            return errcode.SharedSubscriptionNoLocal
+        }
+    }
+    return msg.varHeader.props.Check(msg.fixedHeader.Type())
 }
 
 //订阅标识符取值范围从1到268,435,455。
 //订阅标识符的值为0或包含多个订阅标识符将造成协议错误（Protocol Error）。
 func (m *SubscribeMessage) SetSubscriptionIdentifier(v uint64) {
-    p := &packet.PropSubscriptionIdentifier{}
-    p.V.InitFromUInt64(v)
-    m.varHeader.props = append(m.varHeader.props, p)
+    m.varHeader.props.SubscriptionIdentifier = []uint32{uint32(v)}
 }
 
 //订阅标识符取值范围从1到268,435,455。
 //订阅标识符的值为0或包含多个订阅标识符将造成协议错误（Protocol Error）。
 func (m *SubscribeMessage) GetSubscriptionIdentifier() (uint64, bool) {
-    p := packet.FindPropValue(packet.SubscriptionIdentifier, m.varHeader.props)
-    return p.(*packet.PropSubscriptionIdentifier).V.ToUint(), true
+    if len(m.varHeader.props.SubscriptionIdentifier) == 0 {
+        return 0, false
+    }
+    return uint64(m.varHeader.props.SubscriptionIdentifier[0]), true
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
 func (m *SubscribeMessage) SetUserProperty(props map[string]string) {
     for k, v := range props {
-        p := &packet.PropUserProperty{}
         pair, err := packet.NewStringPair(k, v)
         if err == nil {
-            p.V = pair
-            m.varHeader.props = append(m.varHeader.props, p)
+            m.varHeader.props.UserProperty = append(m.varHeader.props.UserProperty, pair)
         }
     }
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
+//以map形式返回时不保持顺序，同名属性只保留最后一个值，需要完整的用户属性时使用GetUserProperties
 func (m *SubscribeMessage) GetUserProperty() (map[string]string, bool) {
     ret := map[string]string{}
-    packet.FindPropValues(packet.UserProperty, m.varHeader.props, func(property packet.Property) bool {
-        if property != nil {
-            p := property.(*packet.PropUserProperty)
-            ret[p.V[0].String()] = p.V[1].String()
-        }
-        return false
-    })
+    for _, p := range m.varHeader.props.UserProperty {
+        ret[p[0].String()] = p[1].String()
+    }
     return ret, len(ret) > 0
 }
 
+//按顺序追加用户属性，同一个名字可以出现多次
+func (m *SubscribeMessage) AddUserProperty(key, value string) error {
+    return m.varHeader.props.UserProperty.Add(key, value)
+}
+
+//按顺序获得所有用户属性
+func (m *SubscribeMessage) GetUserProperties() packet.UserProperties {
+    return m.varHeader.props.UserProperty
+}
+
+//替换所有用户属性，转发时保持原有顺序
+func (m *SubscribeMessage) SetUserProperties(props packet.UserProperties) {
+    m.varHeader.props.UserProperty = props
+}
+
 func (msg *SubscribeMessage) SetPacketIdentifier(v uint16) {
     msg.varHeader.PacketIdentifier = v
 }
@@ -205,27 +368,25 @@ func (msg *SubscribeMessage) GetPacketIdentifier() uint16 {
     return msg.varHeader.PacketIdentifier
 }
 
+//主题过滤器及订阅选项，编码时校验订阅选项
 func (msg *SubscribeMessage) SetPayload(payload []SubscribeFilter) {
     msg.payload = payload
 }
 
+//主题过滤器及解析后的订阅选项，MQTT 3.1.1只有QoS有效
 func (msg *SubscribeMessage) GetPayload() []SubscribeFilter {
     return msg.payload
 }
 
 func (v *SubscribeVarHeader) String() string {
-    builder := strings.Builder{}
-    for i := range v.props {
-        builder.WriteString(fmt.Sprintf("\t%v\n", v.props[i]))
-    }
     return fmt.Sprintf("PacketIdentifier: %d \nprops:\n%s",
-        v.PacketIdentifier, builder.String())
+        v.PacketIdentifier, v.props.String())
 }
 
 func (v *SubscribeMessage) String() string {
     builder := strings.Builder{}
     for _, f := range v.payload {
-        builder.WriteString(fmt.Sprintf("filter: %s, opt %d\n", f.Filter, f.Opt))
+        builder.WriteString(fmt.Sprintf("filter: %s, opt %s\n", f.Filter, f.Options.String()))
     }
     return fmt.Sprintf("fixed header: \n%v\nvar header:\n%spayload:\n%s \n",
         v.fixedHeader, v.varHeader.String(), builder.String())
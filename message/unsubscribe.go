@@ -11,8 +11,7 @@ import (
     "io"
     "mqtt/errcode"
     "mqtt/packet"
-    "mqtt/util"
-    "strings"
+    "mqtt/topic"
 )
 
 type UnsubscribeVarHeader struct {
@@ -20,7 +19,7 @@ type UnsubscribeVarHeader struct {
     PacketIdentifier uint16
 
     //PUBLISH 属性 PUBLISH Properties
-    props []packet.Property
+    props packet.Properties
 
     size int
 }
@@ -28,50 +27,71 @@ type UnsubscribeVarHeader struct {
 type UnsubscribeMessage struct {
     fixedHeader packet.FixedHeader
     varHeader   UnsubscribeVarHeader
+    version     byte
     payload     []string
+    codecOption
 }
 
 func NewUnsubscribeMessage() *UnsubscribeMessage {
     ret := &UnsubscribeMessage{
         fixedHeader: packet.CreateFixedHeader(packet.PktTypeUNSUBSCRIBE, packet.PktFlagUNSUBSCRIBE, 0),
+        version:     packet.MqttProtocolVersion,
     }
     return ret
 }
 
+func (m *UnsubscribeMessage) SetVersion(v byte) {
+    m.version = v
+}
+
+func (m *UnsubscribeMessage) GetVersion() byte {
+    return m.version
+}
+
 func (m *UnsubscribeMessage) SetFixedHeader(header packet.FixedHeader) {
     m.fixedHeader = header
 }
 
 func (m *UnsubscribeMessage) GetFixedHeader() packet.FixedHeader {
-    w := new(util.CountWriter)
-    m.WriteVariableHeader(w)
-    m.WritePayload(w)
-    m.fixedHeader.Len = w.Count()
+    m.fixedHeader.Len = int64(m.VariableHeaderLength() + m.PayloadLength())
     return m.fixedHeader
 }
 
+func (msg *UnsubscribeMessage) VariableHeaderLength() int {
+    if !packet.IsV5(msg.version) {
+        return 2
+    }
+    return 2 + msg.varHeader.props.Length()
+}
+
+func (msg *UnsubscribeMessage) PayloadLength() int {
+    n := 0
+    for _, v := range msg.payload {
+        n += 2 + len(v)
+    }
+    return n
+}
+
 func (msg *UnsubscribeMessage) ReadVariableHeader(r io.Reader) (int, error) {
-    buf := make([]byte, 2)
-    n, err := r.Read(buf)
+    id, n, err := packet.ReadUint16(r)
     if err != nil {
         return n, err
     }
-    msg.varHeader.PacketIdentifier = uint16(buf[0]<<8 | buf[1])
+    msg.varHeader.PacketIdentifier = id
     if err := msg.fixedHeader.CheckLen(n); err != nil {
         return n, err
     }
 
-    if msg.fixedHeader.RemainLength() == int64(n) {
+    if msg.fixedHeader.RemainLength() == int64(n) || !packet.IsV5(msg.version) {
+        msg.varHeader.size = n
         return n, nil
     }
 
-    props, n2, err2 := packet.ReadProperties(r)
+    n2, err2 := msg.varHeader.props.Decode(r, msg.fixedHeader.Type(), msg.controlChars)
     n += n2
     if err2 != nil {
         return n, err2
     }
-
-    msg.varHeader.props = props
     msg.varHeader.size = n
 
     return n, nil
@@ -86,7 +106,11 @@ func (msg *UnsubscribeMessage) WriteVariableHeader(w io.Writer) (int, error) {
         return n, err
     }
 
-    n2, err2 := packet.WriteProperties(w, msg.varHeader.props)
+    if !packet.IsV5(msg.version) {
+        return n, nil
+    }
+
+    n2, err2 := msg.varHeader.props.Encode(w, msg.fixedHeader.Type(), msg.controlChars)
     return n + n2, err2
 }
 
@@ -96,11 +120,14 @@ func (msg *UnsubscribeMessage) ReadPayload(r io.Reader) (int, error) {
 
     n := 0
     for n < size {
-        s, rd, err := packet.ParseString(r)
+        s, rd, err := packet.ParseString(r, msg.controlChars)
         n += rd
         if err != nil {
             return n, err
         }
+        if _, err := topic.ParseFilter(s.String()); err != nil {
+            return n, err
+        }
         msg.payload = append( msg.payload, s.String())
     }
 
@@ -116,13 +143,20 @@ func (msg *UnsubscribeMessage) WritePayload(w io.Writer) (int, error) {
         return 0, errcode.ProtocolError
     }
 
+    //先校验全部主题过滤器，校验失败时不写入任何数据
+    for _, v := range msg.payload {
+        if err := checkFilter(v, msg.controlChars); err != nil {
+            return 0, err
+        }
+    }
+
     n := 0
     for _, v := range msg.payload {
         s, errS := packet.FromString(v)
         if errS != nil {
             return n, errS
         }
-        wt, err := packet.WriteString(w, s)
+        wt, err := packet.WriteString(w, s, msg.controlChars)
         n += wt
         if err != nil {
             return n, err
@@ -131,8 +165,28 @@ func (msg *UnsubscribeMessage) WritePayload(w io.Writer) (int, error) {
     return n, nil
 }
 
+//报文属性，可以直接读写属性字段
+func (msg *UnsubscribeMessage) GetProperties() *packet.Properties {
+    return &msg.varHeader.props
+}
+
 func (msg *UnsubscribeMessage) Valid() bool {
-    return true
+    return msg.Validate() == nil
+}
+
+func (msg *UnsubscribeMessage) Validate() error {
+    if msg.varHeader.PacketIdentifier == 0 {
+        return errcode.PacketIdentifierInvalid
+    }
+    if len(msg.payload) == 0 {
+        return errcode.UnsubscribeMissTopicFilter
+    }
+    for _, v := range msg.payload {
+        if _, err := topic.ParseFilter(v); err != nil {
+            return err
+        }
+    }
+    return msg.varHeader.props.Check(msg.fixedHeader.Type())
 }
 
 func (msg *UnsubscribeMessage) SetPacketIdentifier(v uint16) {
@@ -157,36 +211,42 @@ func (m *UnsubscribeMessage) GetPayload() []string {
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
 func (m *UnsubscribeMessage) SetUserProperty(props map[string]string) {
     for k, v := range props {
-        p := &packet.PropUserProperty{}
         pair, err := packet.NewStringPair(k, v)
         if err == nil {
-            p.V = pair
-            m.varHeader.props = append(m.varHeader.props, p)
+            m.varHeader.props.UserProperty = append(m.varHeader.props.UserProperty, pair)
         }
     }
 }
 
 //跟随其后的是UTF-8字符串对。此属性可用于向客户端提供包括诊断信息在内的附加信息。
 //如果加上用户属性之后的CONNACK报文长度超出了客户端指定的最大报文长度，则服务端不能发送此属性
+//以map形式返回时不保持顺序，同名属性只保留最后一个值，需要完整的用户属性时使用GetUserProperties
 func (m *UnsubscribeMessage) GetUserProperty() (map[string]string, bool) {
     ret := map[string]string{}
-    packet.FindPropValues(packet.UserProperty, m.varHeader.props, func(property packet.Property) bool {
-        if property != nil {
-            p := property.(*packet.PropUserProperty)
-            ret[p.V[0].String()] = p.V[1].String()
-        }
-        return false
-    })
+    for _, p := range m.varHeader.props.UserProperty {
+        ret[p[0].String()] = p[1].String()
+    }
     return ret, len(ret) > 0
 }
 
+//按顺序追加用户属性，同一个名字可以出现多次
+func (m *UnsubscribeMessage) AddUserProperty(key, value string) error {
+    return m.varHeader.props.UserProperty.Add(key, value)
+}
+
+//按顺序获得所有用户属性
+func (m *UnsubscribeMessage) GetUserProperties() packet.UserProperties {
+    return m.varHeader.props.UserProperty
+}
+
+//替换所有用户属性，转发时保持原有顺序
+func (m *UnsubscribeMessage) SetUserProperties(props packet.UserProperties) {
+    m.varHeader.props.UserProperty = props
+}
+
 func (v *UnsubscribeVarHeader) String() string {
-    builder := strings.Builder{}
-    for i := range v.props {
-        builder.WriteString(fmt.Sprintf("\t%v\n", v.props[i]))
-    }
     return fmt.Sprintf("TopicName: %d\nprops:\n%s",
-        v.PacketIdentifier, builder.String())
+        v.PacketIdentifier, v.props.String())
 }
 
 func (v *UnsubscribeMessage) String() string {
@@ -48,32 +48,92 @@ const (
     PktFlagAUTH        = 0 //Reserved	0	0	0	0
 )
 
+//固定头中的保留标志位，PUBLISH报文单独校验
+var pktFlags = map[byte]byte{
+    PktTypeCONNECT:     PktFlagCONNECT,
+    PktTypeCONNACK:     PktFlagCONNACK,
+    PktTypePUBACK:      PktFlagPUBACK,
+    PktTypePUBREC:      PktFlagPUBREC,
+    PktTypePUBREL:      PktFlagPUBREL,
+    PktTypePUBCOMP:     PktFlagPUBCOMP,
+    PktTypeSUBSCRIBE:   PktFlagSUBSCRIBE,
+    PktTypeSUBACK:      PktFlagSUBACK,
+    PktTypeUNSUBSCRIBE: PktFlagUNSUBSCRIBE,
+    PktTypeUNSUBACK:    PktFlagUNSUBACK,
+    PktTypePINGREQ:     PktFlagPINGREQ,
+    PktTypePINGRESP:    PktFlagPINGRESP,
+    PktTypeDISCONNECT:  PktFlagDISCONNECT,
+    PktTypeAUTH:        PktFlagAUTH,
+}
+
 type FixedHeader struct {
     TypeFlag byte
     Len      int64
 }
 
+//读取固定头，剩余长度编码超过4个字节或未使用最少字节数时返回RemainLengthInvalid，
+//固定头读取不完整时返回io.ErrUnexpectedEOF
 func ReadFixedHeader(r io.Reader) (FixedHeader, int, error) {
     fh := FixedHeader{}
-    buf := make([]byte, 1)
-    size := 0
-    n, err := r.Read(buf)
+    b, size, err := ReadByte(r)
     if err != nil {
-        return fh, n, err
+        return fh, size, err
     }
-    fh.TypeFlag = buf[0]
-    size += n
+    fh.TypeFlag = b
 
     v := VarInt{}
-    _, n2, err := v.LoadFromReader(r)
-    if err != nil {
+    for {
+        if v.Length() >= MaxRemainLengthSize {
+            return fh, size, errcode.RemainLengthInvalid
+        }
+        b, n, err := ReadByte(r)
+        size += n
         if err != nil {
-            return fh, size + n2, err
+            if err == io.EOF {
+                err = io.ErrUnexpectedEOF
+            }
+            return fh, size, err
+        }
+        if v.LoadByte(b) {
+            break
         }
     }
     fh.Len = v.ToInt()
+    if CalcVaruintLen(uint64(fh.Len)) != v.Length() {
+        return fh, size, errcode.RemainLengthInvalid
+    }
 
-    return fh, size + n2, nil
+    return fh, size, nil
+}
+
+//剩余长度最多使用4个字节编码
+const MaxRemainLengthSize = 4
+
+//从字节切片中解析固定头，返回固定头及其占用的字节数。
+//数据不足以解析固定头时返回io.ErrUnexpectedEOF，剩余长度编码超过4个字节时返回RemainLengthInvalid
+func ParseFixedHeader(data []byte) (FixedHeader, int, error) {
+    fh := FixedHeader{}
+    if len(data) < 2 {
+        return fh, 0, io.ErrUnexpectedEOF
+    }
+    fh.TypeFlag = data[0]
+
+    v := VarInt{}
+    for i := 1; ; i++ {
+        if i > MaxRemainLengthSize {
+            return fh, 0, errcode.RemainLengthInvalid
+        }
+        if i >= len(data) {
+            return fh, 0, io.ErrUnexpectedEOF
+        }
+        if v.LoadByte(data[i]) {
+            fh.Len = v.ToInt()
+            if CalcVaruintLen(uint64(fh.Len)) != v.Length() {
+                return fh, 0, errcode.RemainLengthInvalid
+            }
+            return fh, i + 1, nil
+        }
+    }
 }
 
 func WriteFixedHeader(w io.Writer, header FixedHeader) (int, error) {
@@ -117,6 +177,25 @@ func (h FixedHeader) Flag() byte {
     return h.TypeFlag & 0x0F
 }
 
+//校验固定头标志位，如果收到非法的标志位，接收者必须关闭网络连接 [MQTT-2.1.3-1]
+func (h FixedHeader) CheckFlag() error {
+    if h.Type() == PktTypePUBLISH {
+        dup, qos, _ := h.PubFlag()
+        if qos == 3 {
+            return errcode.PublishQosInvalid
+        }
+        if qos == 0 && dup {
+            return errcode.PublishDupInvalid
+        }
+        return nil
+    }
+
+    if flag, ok := pktFlags[h.Type()]; !ok || flag != h.Flag() {
+        return errcode.FixedHeaderFlagInvalid
+    }
+    return nil
+}
+
 //return dup, QoS, retain
 func (h FixedHeader) PubFlag() (bool, uint8, bool) {
     flag := h.TypeFlag & 0x0F
@@ -148,3 +227,8 @@ func (h *FixedHeader) SetRetain(v bool) {
 func (h FixedHeader) RemainLength() int64 {
     return h.Len
 }
+
+//整个报文的长度，包括固定头
+func (h FixedHeader) PacketSize() int64 {
+    return 1 + int64(CalcVaruintLen(uint64(h.Len))) + h.Len
+}
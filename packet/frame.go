@@ -0,0 +1,143 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package packet
+
+import (
+    "bufio"
+    "io"
+    "mqtt/errcode"
+)
+
+const (
+    FrameReaderBufSize = 4 * 1024
+)
+
+//按报文边界读取数据，通常包装net.Conn使用。
+//每次读取一个完整的报文（包括固定头），TCP分段导致的不完整读取按io.ReadFull语义处理。
+//读取出错后FrameReader保持该错误。PacketTooLarge时报文长度已知，调用Resync可以跳过该报文继续读取；
+//RemainLengthInvalid等错误无法确定报文边界，不能恢复，网络连接应直接关闭 [MQTT-4.13.1-1]。
+//固定头标志位等报文内容的错误由解码时返回，ReadFrame已读取完整的报文，不影响后续读取
+type FrameReader struct {
+    r     *bufio.Reader
+    c     io.Closer
+    buf   []byte
+    limit uint32
+
+    //已读取的总字节数
+    count int64
+    //出错报文中尚未读取的字节数
+    skip int64
+    err  error
+}
+
+func NewFrameReader(r io.Reader) *FrameReader {
+    return NewFrameReaderSize(r, FrameReaderBufSize)
+}
+
+func NewFrameReaderSize(r io.Reader, size int) *FrameReader {
+    fr := &FrameReader{
+        r: bufio.NewReaderSize(r, size),
+    }
+    if c, ok := r.(io.Closer); ok {
+        fr.c = c
+    }
+    return fr
+}
+
+//设置允许读取的最大报文长度（包括固定头），0表示不限制
+func (fr *FrameReader) SetMaxPacketSize(limit uint32) {
+    fr.limit = limit
+}
+
+//读取一个完整的报文，返回固定头及报文数据（包括固定头），len(frame)即该报文占用的字节数。
+//frame引用FrameReader内部缓冲，在下一次调用ReadFrame前有效
+func (fr *FrameReader) ReadFrame() (FixedHeader, []byte, error) {
+    if fr.err != nil {
+        return FixedHeader{}, nil, fr.err
+    }
+
+    fh, n, err := ReadFixedHeader(fr.r)
+    fr.count += int64(n)
+    if err != nil {
+        return fh, nil, fr.fail(err)
+    }
+
+    size := fh.PacketSize()
+    if fr.limit > 0 && size > int64(fr.limit) {
+        fr.skip = fh.RemainLength()
+        return fh, nil, fr.fail(errcode.PacketTooLarge)
+    }
+
+    if int64(cap(fr.buf)) < size {
+        fr.buf = make([]byte, size)
+    }
+    frame := fr.buf[:size]
+    EncodeVaruint(frame[1:], uint64(fh.Len))
+    frame[0] = fh.TypeFlag
+
+    rn, err := io.ReadFull(fr.r, frame[n:])
+    fr.count += int64(rn)
+    if err != nil {
+        if err == io.EOF {
+            err = io.ErrUnexpectedEOF
+        }
+        return fh, nil, fr.fail(err)
+    }
+
+    return fh, frame, nil
+}
+
+func (fr *FrameReader) fail(err error) error {
+    if err != io.EOF {
+        fr.err = err
+    }
+    return err
+}
+
+//跳过出错的报文，使后续的ReadFrame可以继续读取。
+//只有剩余长度合法的错误（PacketTooLarge）可以恢复，丢弃该报文的剩余部分。
+//其他错误（如RemainLengthInvalid）之后的数据无法确定报文边界，在后续数据中查找报文开头可能把payload当作报文，
+//直接返回该错误，FrameReader保持该错误
+func (fr *FrameReader) Resync() error {
+    switch fr.err {
+    case nil:
+        return nil
+    case errcode.PacketTooLarge:
+        n, err := fr.r.Discard(int(fr.skip))
+        fr.count += int64(n)
+        if err != nil {
+            fr.err = err
+            return err
+        }
+    default:
+        return fr.err
+    }
+    fr.skip = 0
+    fr.err = nil
+    return nil
+}
+
+//已读取的总字节数
+func (fr *FrameReader) Count() int64 {
+    return fr.count
+}
+
+//读取过程中保持的错误
+func (fr *FrameReader) Err() error {
+    return fr.err
+}
+
+//关闭底层连接
+func (fr *FrameReader) Close() error {
+    if fr.err == nil {
+        fr.err = io.ErrClosedPipe
+    }
+    if fr.c != nil {
+        return fr.c.Close()
+    }
+    return nil
+}
@@ -0,0 +1,354 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package packet
+
+import (
+    "fmt"
+    "io"
+    "math"
+    "mqtt/errcode"
+    "strings"
+)
+
+//报文属性，可选属性使用指针表示，nil为未设置；二进制数据nil为未设置。
+//UserProperty和SubscriptionIdentifier可以出现多次，使用切片保存。
+//从SliceReader解码时字符串及二进制数据直接引用原数据，不做拷贝
+type Properties struct {
+    PayloadFormatIndicator          *byte
+    MessageExpiryInterval           *uint32
+    ContentType                     *String
+    ResponseTopic                   *String
+    CorrelationData                 []byte
+    SubscriptionIdentifier          []uint32
+    SessionExpiryInterval           *uint32
+    AssignedClientIdentifier        *String
+    ServerKeepAlive                 *uint16
+    AuthenticationMethod            *String
+    AuthenticationData              []byte
+    RequestProblemInformation       *byte
+    WillDelayInterval               *uint32
+    RequestResponseInformation      *byte
+    ResponseInformation             *String
+    ServerReference                 *String
+    ReasonString                    *String
+    ReceiveMaximum                  *uint16
+    TopicAliasMaximum               *uint16
+    TopicAlias                      *uint16
+    MaximumQoS                      *byte
+    RetainAvailable                 *byte
+    UserProperty                    UserProperties
+    MaximumPacketSize               *uint32
+    WildcardSubscriptionAvailable   *byte
+    SubscriptionIdentifierAvailable *byte
+    SharedSubscriptionAvailable     *byte
+}
+
+//由属性列表创建
+func NewProperties(props []Property) *Properties {
+    p := &Properties{}
+    p.SetList(props)
+    return p
+}
+
+//读取属性并按owner（报文类型或WillPropertiesOwner）的属性规则校验，字符串按policy处理控制字符
+func (p *Properties) Decode(r io.Reader, owner byte, policy ControlCharPolicy) (int, error) {
+    props, n, err := ReadPropertiesOf(r, owner, policy)
+    if err != nil {
+        return n, err
+    }
+    p.SetList(props)
+    return n, nil
+}
+
+//按owner（报文类型或WillPropertiesOwner）的属性规则校验并写属性，字符串按policy处理控制字符
+func (p *Properties) Encode(w io.Writer, owner byte, policy ControlCharPolicy) (int, error) {
+    props, err := p.List()
+    if err != nil {
+        return 0, err
+    }
+    return WritePropertiesOf(w, owner, props, policy)
+}
+
+//属性编码后的长度，包括属性长度字段。直接按字段计算，不创建属性列表；
+//超出长度的字符串同样计入，与Encode写入的内容一致，此时Encode返回StringOutOfRange
+func (p *Properties) Length() int {
+    n := p.dataLength()
+    return CalcVaruintLen(uint64(n)) + n
+}
+
+//属性编码后的长度，不包括属性长度字段
+func (p *Properties) dataLength() int {
+    n := 0
+    n += fixedPropLength(p.PayloadFormatIndicator != nil, 1)
+    n += fixedPropLength(p.MessageExpiryInterval != nil, 4)
+    n += stringPropLength(p.ContentType)
+    n += stringPropLength(p.ResponseTopic)
+    n += bytesPropLength(p.CorrelationData)
+    for _, v := range p.SubscriptionIdentifier {
+        n += PROPERTY_DECHEX_SIZE + CalcVaruintLen(uint64(v))
+    }
+    n += fixedPropLength(p.SessionExpiryInterval != nil, 4)
+    n += stringPropLength(p.AssignedClientIdentifier)
+    n += fixedPropLength(p.ServerKeepAlive != nil, 2)
+    n += stringPropLength(p.AuthenticationMethod)
+    n += bytesPropLength(p.AuthenticationData)
+    n += fixedPropLength(p.RequestProblemInformation != nil, 1)
+    n += fixedPropLength(p.WillDelayInterval != nil, 4)
+    n += fixedPropLength(p.RequestResponseInformation != nil, 1)
+    n += stringPropLength(p.ResponseInformation)
+    n += stringPropLength(p.ServerReference)
+    n += stringPropLength(p.ReasonString)
+    n += fixedPropLength(p.ReceiveMaximum != nil, 2)
+    n += fixedPropLength(p.TopicAliasMaximum != nil, 2)
+    n += fixedPropLength(p.TopicAlias != nil, 2)
+    n += fixedPropLength(p.MaximumQoS != nil, 1)
+    n += fixedPropLength(p.RetainAvailable != nil, 1)
+    for _, v := range p.UserProperty {
+        n += PROPERTY_DECHEX_SIZE + int(v[0].AllLength()+v[1].AllLength())
+    }
+    n += fixedPropLength(p.MaximumPacketSize != nil, 4)
+    n += fixedPropLength(p.WildcardSubscriptionAvailable != nil, 1)
+    n += fixedPropLength(p.SubscriptionIdentifierAvailable != nil, 1)
+    n += fixedPropLength(p.SharedSubscriptionAvailable != nil, 1)
+    return n
+}
+
+func fixedPropLength(set bool, size int) int {
+    if !set {
+        return 0
+    }
+    return PROPERTY_DECHEX_SIZE + size
+}
+
+func stringPropLength(v *String) int {
+    if v == nil {
+        return 0
+    }
+    return PROPERTY_DECHEX_SIZE + 2 + len(v.data)
+}
+
+func bytesPropLength(v []byte) int {
+    if v == nil {
+        return 0
+    }
+    return PROPERTY_DECHEX_SIZE + 2 + len(v)
+}
+
+//按属性规则校验属性，字符串或二进制数据超出长度时返回StringOutOfRange
+func (p *Properties) Check(owner byte) error {
+    props, err := p.List()
+    if err != nil {
+        return err
+    }
+    return CheckProperties(owner, props)
+}
+
+//没有设置任何属性，每个已设置的属性至少占用标识符的长度
+func (p *Properties) IsEmpty() bool {
+    return p.dataLength() == 0
+}
+
+//清除所有属性
+func (p *Properties) Reset() {
+    *p = Properties{}
+}
+
+//转换为属性列表，按属性标识符排序。字符串或二进制数据超出长度时返回StringOutOfRange，
+//此时列表不包括这些属性
+func (p *Properties) List() ([]Property, error) {
+    var props []Property
+    var err error
+    addString := func(prop Property, v *String) {
+        if len(v.data) > math.MaxUint16 {
+            err = errcode.StringOutOfRange
+            return
+        }
+        prop.Set(*v)
+        props = append(props, prop)
+    }
+    addBytes := func(prop Property, v []byte) {
+        s, e := FromBytes(v)
+        if e != nil {
+            err = e
+            return
+        }
+        prop.Set(s)
+        props = append(props, prop)
+    }
+
+    if p.PayloadFormatIndicator != nil {
+        props = append(props, &PropPayloadFormatIndicator{ByteProperty{*p.PayloadFormatIndicator}})
+    }
+    if p.MessageExpiryInterval != nil {
+        props = append(props, &PropMessageExpiryInterval{Uint32Property{*p.MessageExpiryInterval}})
+    }
+    if p.ContentType != nil {
+        addString(&PropContentType{}, p.ContentType)
+    }
+    if p.ResponseTopic != nil {
+        addString(&PropResponseTopic{}, p.ResponseTopic)
+    }
+    if p.CorrelationData != nil {
+        addBytes(&PropCorrelationData{}, p.CorrelationData)
+    }
+    for _, v := range p.SubscriptionIdentifier {
+        prop := &PropSubscriptionIdentifier{}
+        prop.V.InitFromUInt64(uint64(v))
+        props = append(props, prop)
+    }
+    if p.SessionExpiryInterval != nil {
+        props = append(props, &PropSessionExpiryInterval{Uint32Property{*p.SessionExpiryInterval}})
+    }
+    if p.AssignedClientIdentifier != nil {
+        addString(&PropAssignedClientIdentifier{}, p.AssignedClientIdentifier)
+    }
+    if p.ServerKeepAlive != nil {
+        props = append(props, &PropServerKeepAlive{Uint16Property{*p.ServerKeepAlive}})
+    }
+    if p.AuthenticationMethod != nil {
+        addString(&PropAuthenticationMethod{}, p.AuthenticationMethod)
+    }
+    if p.AuthenticationData != nil {
+        addBytes(&PropAuthenticationData{}, p.AuthenticationData)
+    }
+    if p.RequestProblemInformation != nil {
+        props = append(props, &PropRequestProblemInformation{ByteProperty{*p.RequestProblemInformation}})
+    }
+    if p.WillDelayInterval != nil {
+        props = append(props, &PropWillDelayInterval{Uint32Property{*p.WillDelayInterval}})
+    }
+    if p.RequestResponseInformation != nil {
+        props = append(props, &PropRequestResponseInformation{ByteProperty{*p.RequestResponseInformation}})
+    }
+    if p.ResponseInformation != nil {
+        addString(&PropResponseInformation{}, p.ResponseInformation)
+    }
+    if p.ServerReference != nil {
+        addString(&PropServerReference{}, p.ServerReference)
+    }
+    if p.ReasonString != nil {
+        addString(&PropReasonString{}, p.ReasonString)
+    }
+    if p.ReceiveMaximum != nil {
+        props = append(props, &PropReceiveMaximum{Uint16Property{*p.ReceiveMaximum}})
+    }
+    if p.TopicAliasMaximum != nil {
+        props = append(props, &PropTopicAliasMaximum{Uint16Property{*p.TopicAliasMaximum}})
+    }
+    if p.TopicAlias != nil {
+        props = append(props, &PropTopicAlias{Uint16Property{*p.TopicAlias}})
+    }
+    if p.MaximumQoS != nil {
+        props = append(props, &PropMaximumQoS{ByteProperty{*p.MaximumQoS}})
+    }
+    if p.RetainAvailable != nil {
+        props = append(props, &PropRetainAvailable{ByteProperty{*p.RetainAvailable}})
+    }
+    for _, v := range p.UserProperty {
+        props = append(props, &PropUserProperty{StringPairProperty{v}})
+    }
+    if p.MaximumPacketSize != nil {
+        props = append(props, &PropMaximumPacketSize{Uint32Property{*p.MaximumPacketSize}})
+    }
+    if p.WildcardSubscriptionAvailable != nil {
+        props = append(props, &PropWildcardSubscriptionAvailable{ByteProperty{*p.WildcardSubscriptionAvailable}})
+    }
+    if p.SubscriptionIdentifierAvailable != nil {
+        props = append(props, &PropSubscriptionIdentifierAvailable{ByteProperty{*p.SubscriptionIdentifierAvailable}})
+    }
+    if p.SharedSubscriptionAvailable != nil {
+        props = append(props, &PropSharedSubscriptionAvailable{ByteProperty{*p.SharedSubscriptionAvailable}})
+    }
+    return props, err
+}
+
+//由属性列表设置，原有属性被清除。只能出现一次的属性如果出现多次，以最后一个为准
+func (p *Properties) SetList(props []Property) {
+    p.Reset()
+    for _, prop := range props {
+        switch v := prop.(type) {
+        case *PropPayloadFormatIndicator:
+            p.PayloadFormatIndicator = bytePtr(v.V)
+        case *PropMessageExpiryInterval:
+            p.MessageExpiryInterval = uint32Ptr(v.V)
+        case *PropContentType:
+            p.ContentType = stringRef(v.V)
+        case *PropResponseTopic:
+            p.ResponseTopic = stringRef(v.V)
+        case *PropCorrelationData:
+            p.CorrelationData = bytesOf(v.V)
+        case *PropSubscriptionIdentifier:
+            p.SubscriptionIdentifier = append(p.SubscriptionIdentifier, uint32(v.V.ToUint()))
+        case *PropSessionExpiryInterval:
+            p.SessionExpiryInterval = uint32Ptr(v.V)
+        case *PropAssignedClientIdentifier:
+            p.AssignedClientIdentifier = stringRef(v.V)
+        case *PropServerKeepAlive:
+            p.ServerKeepAlive = uint16Ptr(v.V)
+        case *PropAuthenticationMethod:
+            p.AuthenticationMethod = stringRef(v.V)
+        case *PropAuthenticationData:
+            p.AuthenticationData = bytesOf(v.V)
+        case *PropRequestProblemInformation:
+            p.RequestProblemInformation = bytePtr(v.V)
+        case *PropWillDelayInterval:
+            p.WillDelayInterval = uint32Ptr(v.V)
+        case *PropRequestResponseInformation:
+            p.RequestResponseInformation = bytePtr(v.V)
+        case *PropResponseInformation:
+            p.ResponseInformation = stringRef(v.V)
+        case *PropServerReference:
+            p.ServerReference = stringRef(v.V)
+        case *PropReasonString:
+            p.ReasonString = stringRef(v.V)
+        case *PropReceiveMaximum:
+            p.ReceiveMaximum = uint16Ptr(v.V)
+        case *PropTopicAliasMaximum:
+            p.TopicAliasMaximum = uint16Ptr(v.V)
+        case *PropTopicAlias:
+            p.TopicAlias = uint16Ptr(v.V)
+        case *PropMaximumQoS:
+            p.MaximumQoS = bytePtr(v.V)
+        case *PropRetainAvailable:
+            p.RetainAvailable = bytePtr(v.V)
+        case *PropUserProperty:
+            p.UserProperty = append(p.UserProperty, v.V)
+        case *PropMaximumPacketSize:
+            p.MaximumPacketSize = uint32Ptr(v.V)
+        case *PropWildcardSubscriptionAvailable:
+            p.WildcardSubscriptionAvailable = bytePtr(v.V)
+        case *PropSubscriptionIdentifierAvailable:
+            p.SubscriptionIdentifierAvailable = bytePtr(v.V)
+        case *PropSharedSubscriptionAvailable:
+            p.SharedSubscriptionAvailable = bytePtr(v.V)
+        }
+    }
+}
+
+func bytePtr(v byte) *byte       { return &v }
+func uint16Ptr(v uint16) *uint16 { return &v }
+func uint32Ptr(v uint32) *uint32 { return &v }
+
+//字符串属性直接引用解析结果
+func stringRef(s String) *String { return &s }
+
+//二进制数据直接引用解析结果，空数据返回非nil的空切片以区分未设置
+func bytesOf(b Bytes) []byte {
+    if b.data == nil {
+        return []byte{}
+    }
+    return b.data
+}
+
+func (p *Properties) String() string {
+    builder := strings.Builder{}
+    props, _ := p.List()
+    for _, prop := range props {
+        builder.WriteString(fmt.Sprintf("\t%v\n", prop))
+    }
+    return builder.String()
+}
@@ -78,6 +78,92 @@ const (
     PROPERTY_DECHEX_SIZE = 1
 )
 
+const (
+    //遗嘱属性（Will Properties）不属于任何报文，使用保留的报文类型标识
+    WillPropertiesOwner = PktTypeReserved
+)
+
+//属性规则：allow为可以包含该属性的报文类型，repeat为可以包含多个该属性的报文类型，
+//按位表示，第n位对应报文类型n，第0位对应遗嘱属性
+type propertyRule struct {
+    allow  uint16
+    repeat uint16
+}
+
+func ownerMask(owners ...byte) uint16 {
+    var m uint16
+    for _, o := range owners {
+        m |= 1 << o
+    }
+    return m
+}
+
+var propRules = map[byte]propertyRule{
+    PayloadFormatIndicator:          {allow: ownerMask(PktTypePUBLISH, WillPropertiesOwner)},
+    MessageExpiryInterval:           {allow: ownerMask(PktTypePUBLISH, WillPropertiesOwner)},
+    ContentType:                     {allow: ownerMask(PktTypePUBLISH, WillPropertiesOwner)},
+    ResponseTopic:                   {allow: ownerMask(PktTypePUBLISH, WillPropertiesOwner)},
+    CorrelationData:                 {allow: ownerMask(PktTypePUBLISH, WillPropertiesOwner)},
+    SubscriptionIdentifier:          {allow: ownerMask(PktTypePUBLISH, PktTypeSUBSCRIBE), repeat: ownerMask(PktTypePUBLISH)},
+    SessionExpiryInterval:           {allow: ownerMask(PktTypeCONNECT, PktTypeCONNACK, PktTypeDISCONNECT)},
+    AssignedClientIdentifier:        {allow: ownerMask(PktTypeCONNACK)},
+    ServerKeepAlive:                 {allow: ownerMask(PktTypeCONNACK)},
+    AuthenticationMethod:            {allow: ownerMask(PktTypeCONNECT, PktTypeCONNACK, PktTypeAUTH)},
+    AuthenticationData:              {allow: ownerMask(PktTypeCONNECT, PktTypeCONNACK, PktTypeAUTH)},
+    RequestProblemInformation:       {allow: ownerMask(PktTypeCONNECT)},
+    WillDelayInterval:               {allow: ownerMask(WillPropertiesOwner)},
+    RequestResponseInformation:      {allow: ownerMask(PktTypeCONNECT)},
+    ResponseInformation:             {allow: ownerMask(PktTypeCONNACK)},
+    ServerReference:                 {allow: ownerMask(PktTypeCONNACK, PktTypeDISCONNECT)},
+    ReasonString:                    {allow: ownerMask(PktTypeCONNACK, PktTypePUBACK, PktTypePUBREC, PktTypePUBREL, PktTypePUBCOMP, PktTypeSUBACK, PktTypeUNSUBACK, PktTypeDISCONNECT, PktTypeAUTH)},
+    ReceiveMaximum:                  {allow: ownerMask(PktTypeCONNECT, PktTypeCONNACK)},
+    TopicAliasMaximum:               {allow: ownerMask(PktTypeCONNECT, PktTypeCONNACK)},
+    TopicAlias:                      {allow: ownerMask(PktTypePUBLISH)},
+    MaximumQoS:                      {allow: ownerMask(PktTypeCONNACK)},
+    RetainAvailable:                 {allow: ownerMask(PktTypeCONNACK)},
+    UserProperty:                    {allow: userPropertyOwners, repeat: userPropertyOwners},
+    MaximumPacketSize:               {allow: ownerMask(PktTypeCONNECT, PktTypeCONNACK)},
+    WildcardSubscriptionAvailable:   {allow: ownerMask(PktTypeCONNACK)},
+    SubscriptionIdentifierAvailable: {allow: ownerMask(PktTypeCONNACK)},
+    SharedSubscriptionAvailable:     {allow: ownerMask(PktTypeCONNACK)},
+}
+
+var userPropertyOwners = ownerMask(PktTypeCONNECT, PktTypeCONNACK, PktTypePUBLISH, WillPropertiesOwner,
+    PktTypePUBACK, PktTypePUBREC, PktTypePUBREL, PktTypePUBCOMP, PktTypeSUBSCRIBE, PktTypeSUBACK,
+    PktTypeUNSUBSCRIBE, PktTypeUNSUBACK, PktTypeDISCONNECT, PktTypeAUTH)
+
+//属性是否可以出现在owner（报文类型或WillPropertiesOwner）中
+func PropertyAllowed(owner byte, id byte) bool {
+    rule, ok := propRules[id]
+    return ok && rule.allow&(1<<owner) != 0
+}
+
+//属性是否可以在owner中出现多次
+func PropertyRepeatable(owner byte, id byte) bool {
+    rule, ok := propRules[id]
+    return ok && rule.repeat&(1<<owner) != 0
+}
+
+//按属性规则校验属性：不允许出现在该报文中的属性为无效报文（Malformed Packet），
+//不允许重复的属性出现多次将造成协议错误（Protocol Error）。
+func CheckProperties(owner byte, props []Property) error {
+    var seen [SharedSubscriptionAvailable + 1]bool
+    for _, p := range props {
+        id := byte(p.Id())
+        if !PropertyAllowed(owner, id) {
+            return errcode.PropertyNotAllowed
+        }
+        if PropertyRepeatable(owner, id) {
+            continue
+        }
+        if seen[id] {
+            return errcode.DuplicateProperty
+        }
+        seen[id] = true
+    }
+    return nil
+}
+
 type Setter interface {
     Set(interface{})
 }
@@ -89,8 +175,9 @@ type Getter interface {
 type Property interface {
     Id() int64
     DataLen() int32
-    UnmarshalData(io.Reader) (int, error)
-    MarshalData(io.Writer) (int, error)
+    //读写属性值，UTF-8编码字符串按policy处理控制字符
+    UnmarshalData(r io.Reader, policy ControlCharPolicy) (int, error)
+    MarshalData(w io.Writer, policy ControlCharPolicy) (int, error)
 
     Setter
     Getter
@@ -100,13 +187,13 @@ type PropPayloadFormatIndicator struct{ ByteProperty }
 type PropMessageExpiryInterval struct{ Uint32Property }
 type PropContentType struct{ StringProperty }
 type PropResponseTopic struct{ StringProperty }
-type PropCorrelationData struct{ StringProperty } //ByteDataProperty
+type PropCorrelationData struct{ BinaryDataProperty }
 type PropSubscriptionIdentifier struct{ VarIntProperty }
 type PropSessionExpiryInterval struct{ Uint32Property }
 type PropAssignedClientIdentifier struct{ StringProperty }
 type PropServerKeepAlive struct{ Uint16Property }
 type PropAuthenticationMethod struct{ StringProperty }
-type PropAuthenticationData struct{ StringProperty } //ByteDataProperty
+type PropAuthenticationData struct{ BinaryDataProperty }
 type PropRequestProblemInformation struct{ ByteProperty }
 type PropWillDelayInterval struct{ Uint32Property }
 type PropRequestResponseInformation struct{ ByteProperty }
@@ -184,31 +271,30 @@ var propFac = map[byte]PropertyCreator{
     SharedSubscriptionAvailable:     PropSharedSubscriptionAvailableCreator,
 }
 
-func UnmarshalProp(r io.Reader) (Property, int, error) {
-    b := make([]byte, 1)
-    n1, err := io.ReadFull(r, b)
+func UnmarshalProp(r io.Reader, policy ControlCharPolicy) (Property, int, error) {
+    b, n1, err := ReadByte(r)
     if err != nil {
         return nil, n1, err
     }
-    prop := CreateProperty(b[0])
+    prop := CreateProperty(b)
     if prop == nil {
         return nil, n1, errcode.UnknownProperty
     }
 
-    n2, err := prop.UnmarshalData(r)
+    n2, err := prop.UnmarshalData(r, policy)
     if err != nil {
         return nil, n1 + n2, err
     }
     return prop, n1 + n2, nil
 }
 
-func MarshalProp(w io.Writer, prop Property) (int, error) {
+func MarshalProp(w io.Writer, prop Property, policy ControlCharPolicy) (int, error) {
     n1, err := w.Write([]byte{byte(prop.Id())})
     if err != nil {
         return n1, err
     }
 
-    n2, err := prop.MarshalData(w)
+    n2, err := prop.MarshalData(w, policy)
     return n1 + n2, err
 }
 
@@ -235,18 +321,17 @@ func (prop *Uint16Property) DataLen() int32 {
     return 2
 }
 
-func (prop *Uint16Property) UnmarshalData(r io.Reader) (int, error) {
-    buf := make([]byte, 2)
-    n, err := io.ReadFull(r, buf)
+func (prop *Uint16Property) UnmarshalData(r io.Reader, policy ControlCharPolicy) (int, error) {
+    v, n, err := ReadUint16(r)
     if err != nil {
         return n, err
     }
 
-    prop.V = binary.BigEndian.Uint16(buf)
+    prop.V = v
     return n, nil
 }
 
-func (prop *Uint16Property) MarshalData(w io.Writer) (int, error) {
+func (prop *Uint16Property) MarshalData(w io.Writer, policy ControlCharPolicy) (int, error) {
     buf := make([]byte, 2)
     binary.BigEndian.PutUint16(buf, prop.V)
     return w.Write(buf)
@@ -268,18 +353,17 @@ func (prop *Uint32Property) DataLen() int32 {
     return 4
 }
 
-func (prop *Uint32Property) UnmarshalData(r io.Reader) (int, error) {
-    buf := make([]byte, 4)
-    n, err := io.ReadFull(r, buf)
+func (prop *Uint32Property) UnmarshalData(r io.Reader, policy ControlCharPolicy) (int, error) {
+    v, n, err := ReadUint32(r)
     if err != nil {
         return n, err
     }
 
-    prop.V = binary.BigEndian.Uint32(buf)
+    prop.V = v
     return n, nil
 }
 
-func (prop *Uint32Property) MarshalData(w io.Writer) (int, error) {
+func (prop *Uint32Property) MarshalData(w io.Writer, policy ControlCharPolicy) (int, error) {
     buf := make([]byte, 4)
     binary.BigEndian.PutUint32(buf, prop.V)
     return w.Write(buf)
@@ -301,18 +385,17 @@ func (prop *ByteProperty) DataLen() int32 {
     return 1
 }
 
-func (prop *ByteProperty) UnmarshalData(r io.Reader) (int, error) {
-    buf := make([]byte, 1)
-    n, err := io.ReadFull(r, buf)
+func (prop *ByteProperty) UnmarshalData(r io.Reader, policy ControlCharPolicy) (int, error) {
+    v, n, err := ReadByte(r)
     if err != nil {
         return n, err
     }
 
-    prop.V = buf[0]
+    prop.V = v
     return n, nil
 }
 
-func (prop *ByteProperty) MarshalData(w io.Writer) (int, error) {
+func (prop *ByteProperty) MarshalData(w io.Writer, policy ControlCharPolicy) (int, error) {
     return w.Write([]byte{prop.V})
 }
 
@@ -332,7 +415,7 @@ func (prop *VarIntProperty) DataLen() int32 {
     return int32(prop.V.Length())
 }
 
-func (prop *VarIntProperty) UnmarshalData(r io.Reader) (int, error) {
+func (prop *VarIntProperty) UnmarshalData(r io.Reader, policy ControlCharPolicy) (int, error) {
     size := 0
     for {
         b, n, err := prop.V.LoadFromReader(r)
@@ -347,7 +430,7 @@ func (prop *VarIntProperty) UnmarshalData(r io.Reader) (int, error) {
     return size, nil
 }
 
-func (prop *VarIntProperty) MarshalData(w io.Writer) (int, error) {
+func (prop *VarIntProperty) MarshalData(w io.Writer, policy ControlCharPolicy) (int, error) {
     return w.Write(prop.V.Bytes())
 }
 
@@ -367,8 +450,8 @@ func (prop *StringProperty) DataLen() int32 {
     return int32(prop.V.AllLength())
 }
 
-func (prop *StringProperty) UnmarshalData(r io.Reader) (int, error) {
-    s, n, err := ParseString(r)
+func (prop *StringProperty) UnmarshalData(r io.Reader, policy ControlCharPolicy) (int, error) {
+    s, n, err := ParseString(r, policy)
     if err != nil {
         return n, err
     }
@@ -376,8 +459,38 @@ func (prop *StringProperty) UnmarshalData(r io.Reader) (int, error) {
     return n, nil
 }
 
-func (prop *StringProperty) MarshalData(w io.Writer) (int, error) {
-    return WriteString(w, prop.V)
+func (prop *StringProperty) MarshalData(w io.Writer, policy ControlCharPolicy) (int, error) {
+    return WriteString(w, prop.V, policy)
+}
+
+//二进制数据（Binary Data），由双字节整数表示的长度和其后的字节组成，不做UTF-8校验
+type BinaryDataProperty struct {
+    V Bytes
+}
+
+func (prop *BinaryDataProperty) Set(v interface{}) {
+    prop.V = v.(Bytes)
+}
+
+func (prop *BinaryDataProperty) Get() interface{} {
+    return prop.V
+}
+
+func (prop *BinaryDataProperty) DataLen() int32 {
+    return int32(prop.V.AllLength())
+}
+
+func (prop *BinaryDataProperty) UnmarshalData(r io.Reader, policy ControlCharPolicy) (int, error) {
+    b, n, err := ParseBytes(r)
+    if err != nil {
+        return n, err
+    }
+    prop.V = *b
+    return n, nil
+}
+
+func (prop *BinaryDataProperty) MarshalData(w io.Writer, policy ControlCharPolicy) (int, error) {
+    return WriteBytes(w, prop.V)
 }
 
 type StringPairProperty struct {
@@ -396,14 +509,14 @@ func (prop *StringPairProperty) DataLen() int32 {
     return int32(prop.V[0].AllLength() + prop.V[1].AllLength())
 }
 
-func (prop *StringPairProperty) UnmarshalData(r io.Reader) (int, error) {
-    s1, n1, err := ParseString(r)
+func (prop *StringPairProperty) UnmarshalData(r io.Reader, policy ControlCharPolicy) (int, error) {
+    s1, n1, err := ParseString(r, policy)
     if err != nil {
         return n1, err
     }
     prop.V[0] = *s1
 
-    s2, n2, err := ParseString(r)
+    s2, n2, err := ParseString(r, policy)
     if err != nil {
         return n1 + n2, err
     }
@@ -411,12 +524,12 @@ func (prop *StringPairProperty) UnmarshalData(r io.Reader) (int, error) {
     return n1 + n2, nil
 }
 
-func (prop *StringPairProperty) MarshalData(w io.Writer) (int, error) {
-    n1, err := WriteString(w, prop.V[0])
+func (prop *StringPairProperty) MarshalData(w io.Writer, policy ControlCharPolicy) (int, error) {
+    n1, err := WriteString(w, prop.V[0], policy)
     if err != nil {
         return n1, err
     }
-    n2, err := WriteString(w, prop.V[1])
+    n2, err := WriteString(w, prop.V[1], policy)
     return n1 + n2, err
 }
 
@@ -429,6 +542,17 @@ func FindPropValue(t int64, props []Property) interface{} {
     return nil
 }
 
+//设置属性，如果已存在相同属性则替换
+func ReplacePropValue(props []Property, prop Property) []Property {
+    for i := range props {
+        if props[i].Id() == prop.Id() {
+            props[i] = prop
+            return props
+        }
+    }
+    return append(props, prop)
+}
+
 //f return true, stop find
 func FindPropValues(t int64, props []Property, f func(Property) bool) {
     for i := range props {
@@ -450,51 +574,75 @@ func FindAndSetPropValue(prop Property, props []Property) bool {
     return false
 }
 
-func ReadProperties(r io.Reader) ([]Property, int, error) {
-    v := NewFromReader(r)
-    if v == nil {
-        return nil, 0, errcode.ParseVarIntFailed
-    }
-    length := int(v.ToInt())
-    size := v.Length()
+func ReadProperties(r io.Reader, policy ControlCharPolicy) ([]Property, int, error) {
     var propList []Property
-    for size < length {
-        p, n, err := UnmarshalProp(r)
-        if err != nil {
-            return nil, size, err
-        }
+    n, err := readProperties(r, policy, func(p Property) {
         propList = append(propList, p)
-        size += n
+    })
+    if err != nil {
+        return nil, n, err
     }
-
-    return propList, size, nil
+    return propList, n, nil
 }
 
-func ReadPropertyMap(r io.Reader) (map[int64]Property, int, error) {
+//读取属性长度及属性，返回读取的字节数（包括属性长度字段）。
+//属性长度不包括属性长度字段本身，最后一个属性超出属性长度时返回MalformedPacket
+func readProperties(r io.Reader, policy ControlCharPolicy, add func(p Property)) (int, error) {
     v := NewFromReader(r)
     if v == nil {
-        return nil, 0, errcode.ParseVarIntFailed
+        return 0, errcode.ParseVarIntFailed
     }
     length := int(v.ToInt())
-    size := v.Length()
-    propMap := map[int64]Property{}
-    for size < length {
-        p, n, err := UnmarshalProp(r)
+    prefix := v.Length()
+    read := 0
+    for read < length {
+        p, n, err := UnmarshalProp(r, policy)
+        read += n
         if err != nil {
-            return nil, size, err
+            return prefix + read, err
         }
-        propMap[p.Id()] = p
-        size += n
+        if read > length {
+            return prefix + read, errcode.MalformedPacket
+        }
+        add(p)
     }
+    return prefix + read, nil
+}
 
-    return propMap, size, nil
+//读取属性并按owner（报文类型或WillPropertiesOwner）的属性规则校验
+func ReadPropertiesOf(r io.Reader, owner byte, policy ControlCharPolicy) ([]Property, int, error) {
+    props, n, err := ReadProperties(r, policy)
+    if err != nil {
+        return nil, n, err
+    }
+    if err := CheckProperties(owner, props); err != nil {
+        return nil, n, err
+    }
+    return props, n, nil
 }
 
-func WriteProperties(w io.Writer, props []Property) (int, error) {
-    if len(props) == 0 {
-        return 0, nil
+func ReadPropertyMap(r io.Reader, policy ControlCharPolicy) (map[int64]Property, int, error) {
+    propMap := map[int64]Property{}
+    n, err := readProperties(r, policy, func(p Property) {
+        propMap[p.Id()] = p
+    })
+    if err != nil {
+        return nil, n, err
     }
+    return propMap, n, nil
+}
 
+//属性编码后的长度，包括属性长度字段
+func PropertiesLength(props []Property) int {
+    propLen := 0
+    for _, p := range props {
+        propLen += PROPERTY_DECHEX_SIZE + int(p.DataLen())
+    }
+    return CalcVaruintLen(uint64(propLen)) + propLen
+}
+
+//属性长度始终写入，没有属性时属性长度为0
+func WriteProperties(w io.Writer, props []Property, policy ControlCharPolicy) (int, error) {
     v := VarInt{}
     propLen := 0
     for _, p := range props {
@@ -509,7 +657,7 @@ func WriteProperties(w io.Writer, props []Property) (int, error) {
     }
     size += n
     for _, v := range props {
-        n, err := MarshalProp(w, v)
+        n, err := MarshalProp(w, v, policy)
         if err != nil {
             return size + n, err
         }
@@ -518,6 +666,14 @@ func WriteProperties(w io.Writer, props []Property) (int, error) {
     return size, nil
 }
 
+//按owner（报文类型或WillPropertiesOwner）的属性规则校验并写入属性
+func WritePropertiesOf(w io.Writer, owner byte, props []Property, policy ControlCharPolicy) (int, error) {
+    if err := CheckProperties(owner, props); err != nil {
+        return 0, err
+    }
+    return WriteProperties(w, props, policy)
+}
+
 func (p *PropPayloadFormatIndicator) Id() int64          { return PayloadFormatIndicator }
 func (p *PropMessageExpiryInterval) Id() int64           { return MessageExpiryInterval }
 func (p *PropContentType) Id() int64                     { return ContentType }
@@ -550,13 +706,13 @@ func (p *PropPayloadFormatIndicator) String() string          { return fmt.Sprin
 func (p *PropMessageExpiryInterval) String() string           { return fmt.Sprintf("MessageExpiryInterval %v", p.V) }
 func (p *PropContentType) String() string                     { return fmt.Sprintf("ContentType %s", p.V.String()) }
 func (p *PropResponseTopic) String() string                   { return fmt.Sprintf("ResponseTopic %s", p.V.String()) }
-func (p *PropCorrelationData) String() string                 { return fmt.Sprintf("CorrelationData %v", p.V) }
+func (p *PropCorrelationData) String() string                 { return fmt.Sprintf("CorrelationData %x", p.V.Get()) }
 func (p *PropSubscriptionIdentifier) String() string          { return fmt.Sprintf("SubscriptionIdentifier %v", p.V) }
 func (p *PropSessionExpiryInterval) String() string           { return fmt.Sprintf("SessionExpiryInterval %v", p.V) }
 func (p *PropAssignedClientIdentifier) String() string        { return fmt.Sprintf("AssignedClientIdentifier %s", p.V.String()) }
 func (p *PropServerKeepAlive) String() string                 { return fmt.Sprintf("ServerKeepAlive %v", p.V) }
 func (p *PropAuthenticationMethod) String() string            { return fmt.Sprintf("AuthenticationMethod %s", p.V.String()) }
-func (p *PropAuthenticationData) String() string              { return fmt.Sprintf("AuthenticationData %s", p.V.String()) }
+func (p *PropAuthenticationData) String() string              { return fmt.Sprintf("AuthenticationData %x", p.V.Get()) }
 func (p *PropRequestProblemInformation) String() string       { return fmt.Sprintf("RequestProblemInformation %v", p.V) }
 func (p *PropWillDelayInterval) String() string               { return fmt.Sprintf("WillDelayInterval %v", p.V) }
 func (p *PropRequestResponseInformation) String() string      { return fmt.Sprintf("RequestResponseInformation %v", p.V) }
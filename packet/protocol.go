@@ -6,13 +6,52 @@
 
 package packet
 
+const (
+    //MQTT 3.1
+    MqttProtocolVersion31 = 3
+    //MQTT 3.1.1
+    MqttProtocolVersion311 = 4
+    //MQTT 5.0
+    MqttProtocolVersion5 = 5
+)
+
 //4 - MQTT
 var (
-    MqttProtocolVersion = byte(5)
+    MqttProtocolVersion = byte(MqttProtocolVersion5)
     MqttProtocolNameBytes  = []byte{0, 4, 77, 81, 84, 84}
     MqttProtocolName       = "MQTT"
     MqttProtocolNameString = String{
         length: 4,
         data: []byte(MqttProtocolName),
     }
+
+    //3 - MQIsdp
+    MqttProtocolNameV31       = "MQIsdp"
+    MqttProtocolNameV31String = String{
+        length: 6,
+        data: []byte(MqttProtocolNameV31),
+    }
+)
+
+const (
+    //MQTT 3.1客户标识符长度必须在1到23个字节之间
+    MaxClientIdLengthV31 = 23
 )
+
+//协议版本是否支持属性、原因码及AUTH报文（MQTT 5.0新增）
+func IsV5(version byte) bool {
+    return version >= MqttProtocolVersion5
+}
+
+//是否为支持的协议版本
+func ValidVersion(version byte) bool {
+    return version == MqttProtocolVersion31 || version == MqttProtocolVersion311 || version == MqttProtocolVersion5
+}
+
+//协议版本对应的协议名，3.1为MQIsdp，3.1.1及5.0为MQTT
+func ProtocolName(version byte) String {
+    if version == MqttProtocolVersion31 {
+        return MqttProtocolNameV31String
+    }
+    return MqttProtocolNameString
+}
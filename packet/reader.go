@@ -0,0 +1,124 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package packet
+
+import (
+    "encoding/binary"
+    "io"
+    "mqtt/errcode"
+)
+
+//基于字节切片的Reader，通过SliceReader解析时String、Bytes及payload直接引用输入数据，不做拷贝
+type SliceReader struct {
+    data []byte
+    cur  int
+}
+
+func NewSliceReader(data []byte) *SliceReader {
+    return &SliceReader{data: data}
+}
+
+func (r *SliceReader) Reset(data []byte) {
+    r.data = data
+    r.cur = 0
+}
+
+func (r *SliceReader) Read(p []byte) (int, error) {
+    if r.cur >= len(r.data) {
+        if len(p) == 0 {
+            return 0, nil
+        }
+        return 0, io.EOF
+    }
+    n := copy(p, r.data[r.cur:])
+    r.cur += n
+    return n, nil
+}
+
+func (r *SliceReader) ReadByte() (byte, error) {
+    if r.cur >= len(r.data) {
+        return 0, io.EOF
+    }
+    b := r.data[r.cur]
+    r.cur++
+    return b, nil
+}
+
+//返回接下来的n个字节（引用原数据），数据不足时返回io.ErrUnexpectedEOF
+func (r *SliceReader) Next(n int) ([]byte, error) {
+    if n > len(r.data)-r.cur {
+        r.cur = len(r.data)
+        return nil, io.ErrUnexpectedEOF
+    }
+    b := r.data[r.cur : r.cur+n : r.cur+n]
+    r.cur += n
+    return b, nil
+}
+
+//剩余未读取的字节数
+func (r *SliceReader) Len() int {
+    return len(r.data) - r.cur
+}
+
+//已读取的字节数
+func (r *SliceReader) Offset() int {
+    return r.cur
+}
+
+//读取n个字节，如果r是SliceReader则直接引用原数据，否则按io.ReadFull语义读取
+func ReadN(r io.Reader, n int) ([]byte, int, error) {
+    if n < 0 {
+        return nil, 0, errcode.MalformedPacket
+    }
+    if sr, ok := r.(*SliceReader); ok {
+        b, err := sr.Next(n)
+        if err != nil {
+            return nil, 0, err
+        }
+        return b, n, nil
+    }
+    buf := make([]byte, n)
+    rn, err := io.ReadFull(r, buf)
+    if err != nil {
+        return nil, rn, err
+    }
+    return buf, rn, nil
+}
+
+//读取单字节
+func ReadByte(r io.Reader) (byte, int, error) {
+    if br, ok := r.(io.ByteReader); ok {
+        b, err := br.ReadByte()
+        if err != nil {
+            return 0, 0, err
+        }
+        return b, 1, nil
+    }
+    b, n, err := ReadN(r, 1)
+    if err != nil {
+        return 0, n, err
+    }
+    return b[0], n, nil
+}
+
+//读取双字节整数（大端）
+func ReadUint16(r io.Reader) (uint16, int, error) {
+    b, n, err := ReadN(r, 2)
+    if err != nil {
+        return 0, n, err
+    }
+    return binary.BigEndian.Uint16(b), n, nil
+}
+
+//读取四字节整数（大端）
+func ReadUint32(r io.Reader) (uint32, int, error) {
+    b, n, err := ReadN(r, 4)
+    if err != nil {
+        return 0, n, err
+    }
+    return binary.BigEndian.Uint32(b), n, nil
+}
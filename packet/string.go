@@ -43,6 +43,19 @@ func FromString(s string) (String, error) {
     return ret, nil
 }
 
+//创建字符串属性的值，不检查长度，超出65535字节时由Properties.List及Encode返回StringOutOfRange
+func StringPtr(s string) *String {
+    return &String{length: uint16(len(s)), data: []byte(s)}
+}
+
+//二进制数据直接引用v，不做拷贝
+func FromBytes(v []byte) (Bytes, error) {
+    if len(v) > math.MaxUint16 {
+        return Bytes{}, errcode.StringOutOfRange
+    }
+    return Bytes{length: uint16(len(v)), data: v}, nil
+}
+
 func NewStringPair(s1, s2 string) (StringPair, error) {
     if len(s1) > math.MaxUint16 || len(s2) > math.MaxUint16 {
         return [2]String{}, errcode.StringOutOfRange
@@ -64,10 +77,13 @@ func (p StringPair) Equals(other StringPair) bool {
     return p[0].String() == other[0].String() && p[1].String() == other[1].String()
 }
 
-func EncodeString(w io.Writer, s string) (int, error) {
+func EncodeString(w io.Writer, s string, policy ControlCharPolicy) (int, error) {
     if len(s) > math.MaxUint16 {
         return 0, errcode.StringOutOfRange
     }
+    if err := ValidateUTF8([]byte(s), policy); err != nil {
+        return 0, err
+    }
     b := make([]byte, 2)
     l := len(s)
     b[0] = byte(l >> 8)
@@ -77,7 +93,7 @@ func EncodeString(w io.Writer, s string) (int, error) {
         return n, err
     }
     n2, err2 := w.Write([]byte(s))
-    if err != nil {
+    if err2 != nil {
         return n + n2, err2
     }
 
@@ -85,10 +101,23 @@ func EncodeString(w io.Writer, s string) (int, error) {
 }
 
 func WriteBytes(w io.Writer, s Bytes) (int, error) {
-    return WriteString(w, String(s))
+    return writeData(w, String(s))
+}
+
+//写UTF-8编码字符串，字符串不符合UTF-8编码规则（控制字符按policy处理）时返回MalformedPacket
+func WriteString(w io.Writer, s String, policy ControlCharPolicy) (int, error) {
+    if err := ValidateUTF8(s.data, policy); err != nil {
+        return 0, err
+    }
+    return writeData(w, s)
+}
+
+//校验字符串是否为合法的UTF-8编码字符串，见ValidateUTF8
+func ValidateString(s String, policy ControlCharPolicy) error {
+    return ValidateUTF8(s.data, policy)
 }
 
-func WriteString(w io.Writer, s String) (int, error) {
+func writeData(w io.Writer, s String) (int, error) {
     b := make([]byte, 2)
     l := s.length
     b[0] = byte(l >> 8)
@@ -98,7 +127,7 @@ func WriteString(w io.Writer, s String) (int, error) {
         return n, err
     }
     n2, err2 := w.Write([]byte(s.data))
-    if err != nil {
+    if err2 != nil {
         return n + n2, err2
     }
 
@@ -106,35 +135,35 @@ func WriteString(w io.Writer, s String) (int, error) {
 }
 
 func ParseBytes(r io.Reader) (ret *Bytes, n int, err error) {
-    s, n, e := ParseString(r)
+    s, n, e := parseData(r)
     return (*Bytes)(s), n, e
 }
 
-func ParseString(r io.Reader) (ret *String, n int, err error) {
-    header := make([]byte, 1)
-    var size uint16
-    readSize := 0
-    n, err = r.Read(header)
+//解析UTF-8编码字符串，如果r是SliceReader则字符串直接引用原数据。
+//字符串不符合UTF-8编码规则（控制字符按policy处理）时返回MalformedPacket
+func ParseString(r io.Reader, policy ControlCharPolicy) (ret *String, n int, err error) {
+    s, n, err := parseData(r)
     if err != nil {
         return nil, n, err
     }
-    readSize += n
-    size = uint16(header[0] << 8)
-    n, err = r.Read(header)
+    if err := ValidateUTF8(s.data, policy); err != nil {
+        return nil, n, err
+    }
+    return s, n, nil
+}
+
+func parseData(r io.Reader) (ret *String, n int, err error) {
+    size, n, err := ReadUint16(r)
     if err != nil {
-        return nil, readSize + n, err
+        return nil, n, err
     }
-    readSize += n
-    size |= uint16(header[0])
 
-    buf := make([]byte, size)
-    n, err = r.Read(buf)
+    buf, n2, err := ReadN(r, int(size))
     if err != nil {
-        return nil, readSize + n, err
+        return nil, n + n2, err
     }
-    readSize += n
 
-    return &String{length: size, data: buf}, readSize, nil
+    return &String{length: size, data: buf}, n + n2, nil
 }
 
 func (s *Bytes) Reset(v []byte) error {
@@ -150,6 +179,10 @@ func (s *Bytes) Get() []byte {
     return s.data
 }
 
+func (s *Bytes) AllLength() uint16 {
+    return s.length + 2
+}
+
 func (s *String) Reset(v string) error {
     if len(v) > math.MaxUint16 {
         return errcode.StringOutOfRange
@@ -159,6 +192,12 @@ func (s *String) Reset(v string) error {
     return nil
 }
 
+func (s String) clone() String {
+    data := make([]byte, len(s.data))
+    copy(data, s.data)
+    return String{length: s.length, data: data}
+}
+
 func (s *String) Length() uint16 {
     return s.length
 }
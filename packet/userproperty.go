@@ -0,0 +1,93 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package packet
+
+//用户属性列表，保持属性的顺序，同一个名字可以出现多次。
+//服务端在转发应用消息时必须保持用户属性的顺序不变 [MQTT-3.3.2-18]
+type UserProperties []StringPair
+
+func (p StringPair) Key() string {
+    return p[0].String()
+}
+
+func (p StringPair) Value() string {
+    return p[1].String()
+}
+
+//追加用户属性，字符串超出长度时返回StringOutOfRange
+func (u *UserProperties) Add(key, value string) error {
+    pair, err := NewStringPair(key, value)
+    if err != nil {
+        return err
+    }
+    *u = append(*u, pair)
+    return nil
+}
+
+//获得第一个名字为key的用户属性值
+func (u UserProperties) Get(key string) (string, bool) {
+    for _, p := range u {
+        if p.Key() == key {
+            return p.Value(), true
+        }
+    }
+    return "", false
+}
+
+//按顺序获得所有名字为key的用户属性值
+func (u UserProperties) GetAll(key string) []string {
+    var ret []string
+    for _, p := range u {
+        if p.Key() == key {
+            ret = append(ret, p.Value())
+        }
+    }
+    return ret
+}
+
+//删除所有名字为key的用户属性，其余属性保持原有顺序
+func (u *UserProperties) Del(key string) {
+    ret := (*u)[:0]
+    for _, p := range *u {
+        if p.Key() != key {
+            ret = append(ret, p)
+        }
+    }
+    *u = ret
+}
+
+//复制用户属性及其数据，用于原样转发。Decode得到的属性引用输入数据，转发前需要复制
+func (u UserProperties) Clone() UserProperties {
+    if u == nil {
+        return nil
+    }
+    ret := make(UserProperties, len(u))
+    for i, p := range u {
+        ret[i] = StringPair{p[0].clone(), p[1].clone()}
+    }
+    return ret
+}
+
+//转换为属性列表
+func (u UserProperties) List() []Property {
+    props := make([]Property, 0, len(u))
+    for _, p := range u {
+        props = append(props, &PropUserProperty{StringPairProperty{p}})
+    }
+    return props
+}
+
+//由属性列表中的用户属性创建，保持原有顺序
+func UserPropertiesOf(props []Property) UserProperties {
+    var ret UserProperties
+    for _, p := range props {
+        if up, ok := p.(*PropUserProperty); ok {
+            ret = append(ret, up.V)
+        }
+    }
+    return ret
+}
@@ -0,0 +1,66 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package packet
+
+import (
+    "mqtt/errcode"
+    "unicode/utf8"
+)
+
+//UTF-8编码字符串中控制字符及非字符的处理策略，协议规定这些字符不应该（should not）出现，
+//接收端可以视为无效报文 [MQTT-1.5.4-3]。策略由编解码的调用者传入（如message.ReadOption），
+//同一进程中不同的连接或存储可以使用不同的策略
+type ControlCharPolicy byte
+
+const (
+    //允许控制字符（U+0001..U+001F、U+007F..U+009F）及非字符（如U+FFFF）
+    ControlCharAllow ControlCharPolicy = iota
+    //控制字符及非字符视为无效报文（Malformed Packet）
+    ControlCharReject
+)
+
+//校验UTF-8编码字符串：
+//字符数据必须是格式正确的UTF-8，不能包含U+D800到U+DFFF之间的编码 [MQTT-1.5.4-1]；
+//不能包含空字符U+0000 [MQTT-1.5.4-2]；
+//控制字符及非字符按policy处理。违反时返回MalformedPacket
+func ValidateUTF8(b []byte, policy ControlCharPolicy) error {
+    reject := policy == ControlCharReject
+    for i := 0; i < len(b); {
+        c := b[i]
+        if c < utf8.RuneSelf {
+            if c == 0 {
+                return errcode.MalformedPacket
+            }
+            if reject && (c < 0x20 || c == 0x7F) {
+                return errcode.MalformedPacket
+            }
+            i++
+            continue
+        }
+
+        //utf8.DecodeRune对代理项编码及过长编码返回RuneError
+        r, size := utf8.DecodeRune(b[i:])
+        if r == utf8.RuneError && size == 1 {
+            return errcode.MalformedPacket
+        }
+        if reject && isControlOrNonChar(r) {
+            return errcode.MalformedPacket
+        }
+        i += size
+    }
+    return nil
+}
+
+func isControlOrNonChar(r rune) bool {
+    if r >= 0x80 && r <= 0x9F {
+        return true
+    }
+    if r >= 0xFDD0 && r <= 0xFDEF {
+        return true
+    }
+    return r&0xFFFE == 0xFFFE
+}
@@ -6,7 +6,10 @@
 
 package packet
 
-import "io"
+import (
+    "io"
+    "mqtt/errcode"
+)
 
 const (
     MaxVarUintBufSize = 10
@@ -58,18 +61,18 @@ func (v *VarInt) InitFromUInt64(x uint64) {
 func (v *VarInt) LoadFromReader(r io.Reader) (bool, int, error) {
     size := 0
     for {
-        n, err := r.Read(v.data[v.cur : v.cur+1])
-        if err != nil {
-            return false, n, err
+        if v.cur >= MaxVarUintBufSize {
+            return false, size, errcode.ParseVarIntFailed
         }
+        b, n, err := ReadByte(r)
         size += n
-        if v.data[v.cur]>>7 == 0 {
-            v.cur++
+        if err != nil {
+            return false, size, err
+        }
+        if v.LoadByte(b) {
             return true, size, nil
         }
-        v.cur++
     }
-    return false, size, nil
 }
 
 //长度
@@ -0,0 +1,250 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package session
+
+import (
+    "container/list"
+    "mqtt/errcode"
+    "mqtt/message"
+    "sync"
+)
+
+//主题别名只在单个网络连接内有效，每个连接需创建新的映射 [MQTT-3.3.2-7]
+
+//记录发送次数的未分配别名的主题数上限，超过时清空计数，避免主题很多时占用过多内存
+const maxAliasCandidates = 1024
+
+type aliasEntry struct {
+    name  string
+    alias uint16
+}
+
+//发送方向的主题别名映射，可并发使用。
+//别名在对端CONNECT或CONNACK中声明的主题别名最大值（Topic Alias Maximum）内分配 [MQTT-3.3.2-8]，
+//主题的发送次数达到阈值后才分配别名，别名用完后复用最久未使用的主题的别名（LRU）
+type OutboundAliases struct {
+    lock      sync.Mutex
+    max       uint16
+    threshold int
+    //按最近使用排序，队首为最近使用的主题，已删除映射的别名在队尾
+    lru *list.List
+    //key为主题名
+    entries map[string]*list.Element
+    //key为别名，包括已删除映射的别名
+    aliases map[uint16]*list.Element
+    //尚未分配别名的主题的发送次数
+    counts map[string]int
+}
+
+//max为对端声明的主题别名最大值，0表示不使用主题别名。默认主题第一次发送时即分配别名
+func NewOutboundAliases(max uint16) *OutboundAliases {
+    return &OutboundAliases{
+        max:       max,
+        threshold: 1,
+        lru:       list.New(),
+        entries:   map[string]*list.Element{},
+        aliases:   map[uint16]*list.Element{},
+        counts:    map[string]int{},
+    }
+}
+
+func (a *OutboundAliases) GetMaximum() uint16 {
+    return a.max
+}
+
+//设置分配别名的阈值：主题的第n次发送才分配别名，只为频繁发送的主题使用别名，小于1按1处理
+func (a *OutboundAliases) SetThreshold(n int) {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    if n < 1 {
+        n = 1
+    }
+    a.threshold = n
+}
+
+//返回发送msg时实际写入的报文，不修改msg：
+//主题已有别名时返回设置了别名且主题名为空的拷贝；发送次数达到阈值时分配别名，返回同时包含主题名及别名的拷贝以建立映射。
+//msg已设置主题别名时按对端将建立的映射更新映射表，该别名原有的映射被替换，之后返回msg本身；
+//该别名为0或超出对端的主题别名最大值时返回TopicAliasInvalid。
+//主题别名最大值为0、主题名为空或发送次数未达到阈值时返回msg本身。
+//映射在调用时即生效，报文须按调用顺序写入，写入失败时应调用Remove
+func (a *OutboundAliases) Apply(msg *message.PublishMessage) (*message.PublishMessage, error) {
+    alias, hasAlias := msg.GetTopicAlias()
+    if hasAlias && (alias == 0 || alias > a.max) {
+        return nil, errcode.TopicAliasInvalid
+    }
+    if a.max == 0 {
+        return msg, nil
+    }
+    name := msg.GetTopicName()
+
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    if hasAlias {
+        a.claim(name, alias)
+        return msg, nil
+    }
+    if name == "" {
+        return msg, nil
+    }
+
+    if el, ok := a.entries[name]; ok {
+        a.lru.MoveToFront(el)
+        ret := msg.ShallowCopy()
+        ret.SetTopicName("")
+        ret.SetTopicAlias(el.Value.(*aliasEntry).alias)
+        return ret, nil
+    }
+
+    if a.threshold > 1 {
+        n := a.counts[name] + 1
+        if n < a.threshold {
+            if len(a.counts) >= maxAliasCandidates {
+                a.counts = map[string]int{}
+            }
+            a.counts[name] = n
+            return msg, nil
+        }
+        delete(a.counts, name)
+    }
+
+    ret := msg.ShallowCopy()
+    ret.SetTopicAlias(a.allocate(name).alias)
+    return ret, nil
+}
+
+//为主题分配别名：优先复用已删除映射的别名，其次分配新的别名，别名用完后复用最久未使用的主题的别名
+func (a *OutboundAliases) allocate(name string) *aliasEntry {
+    el := a.lru.Back()
+    if el == nil || (el.Value.(*aliasEntry).name != "" && a.lru.Len() < int(a.max)) {
+        alias := a.unusedAlias()
+        el = a.lru.PushFront(&aliasEntry{alias: alias})
+        a.aliases[alias] = el
+    }
+    a.bind(el, name)
+    return el.Value.(*aliasEntry)
+}
+
+//最小的未分配的别名，只在别名未用完时调用
+func (a *OutboundAliases) unusedAlias() uint16 {
+    alias := uint16(1)
+    for {
+        if _, ok := a.aliases[alias]; !ok {
+            return alias
+        }
+        alias++
+    }
+}
+
+//调用者设置了主题别名：主题名不为空时对端将别名映射到该主题，本端同样更新映射，
+//主题原有的别名不再使用，优先被复用；主题名为空时只更新别名的最近使用。别名已由Apply检查范围
+func (a *OutboundAliases) claim(name string, alias uint16) {
+    el, ok := a.aliases[alias]
+    if name == "" {
+        if ok && el.Value.(*aliasEntry).name != "" {
+            a.lru.MoveToFront(el)
+        }
+        return
+    }
+
+    if old, exists := a.entries[name]; exists && old != el {
+        delete(a.entries, name)
+        old.Value.(*aliasEntry).name = ""
+        a.lru.MoveToBack(old)
+    }
+    //别名在范围内且未分配时映射的数量一定小于最大值
+    if !ok {
+        el = a.lru.PushFront(&aliasEntry{alias: alias})
+        a.aliases[alias] = el
+    }
+    delete(a.counts, name)
+    a.bind(el, name)
+}
+
+//将别名映射到主题，替换别名原有的映射，并移动到队首
+func (a *OutboundAliases) bind(el *list.Element, name string) {
+    entry := el.Value.(*aliasEntry)
+    if entry.name != "" {
+        delete(a.entries, entry.name)
+    }
+    entry.name = name
+    a.entries[name] = el
+    a.lru.MoveToFront(el)
+}
+
+//删除主题的映射，用于建立映射的报文未能发送时，之后该主题重新分配别名并发送主题名
+func (a *OutboundAliases) Remove(name string) {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    el, ok := a.entries[name]
+    if !ok {
+        return
+    }
+    delete(a.entries, name)
+    //别名保留在队尾，优先被复用
+    el.Value.(*aliasEntry).name = ""
+    a.lru.MoveToBack(el)
+}
+
+//映射的主题数
+func (a *OutboundAliases) Len() int {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    return len(a.entries)
+}
+
+//接收方向的主题别名映射，可并发使用。
+//别名的范围为1到本端在CONNECT或CONNACK中声明的主题别名最大值
+type InboundAliases struct {
+    lock  sync.Mutex
+    max   uint16
+    names map[uint16]string
+}
+
+//max为本端声明的主题别名最大值，0表示不接受主题别名
+func NewInboundAliases(max uint16) *InboundAliases {
+    return &InboundAliases{
+        max:   max,
+        names: map[uint16]string{},
+    }
+}
+
+func (a *InboundAliases) GetMaximum() uint16 {
+    return a.max
+}
+
+//解析收到的PUBLISH报文的主题别名：主题名不为空时建立或更新别名的映射 [MQTT-3.3.2-11]；
+//主题名为空时按映射设置主题名。
+//别名为0或超过主题别名最大值 [MQTT-3.3.2-9] [MQTT-3.3.2-10]，或主题名为空且别名没有映射时返回TopicAliasInvalid，
+//此时应以该原因码断开连接。未设置别名时不做处理
+func (a *InboundAliases) Resolve(msg *message.PublishMessage) error {
+    alias, ok := msg.GetTopicAlias()
+    if !ok {
+        return nil
+    }
+    if alias == 0 || alias > a.max {
+        return errcode.TopicAliasInvalid
+    }
+
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    if name := msg.GetTopicName(); name != "" {
+        a.names[alias] = name
+        return nil
+    }
+    name, ok := a.names[alias]
+    if !ok {
+        return errcode.TopicAliasInvalid
+    }
+    msg.SetTopicName(name)
+    return nil
+}
@@ -0,0 +1,288 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package session
+
+import (
+    "context"
+    "math"
+    "mqtt/errcode"
+    "sync"
+)
+
+//未设置接收最大值时默认为65535
+const DefaultReceiveMaximum = math.MaxUint16
+
+//发送方向的报文标识符分配器，可并发使用。
+//报文标识符从1开始循环分配，不会分配0，也不会分配仍在使用中的标识符 [MQTT-2.2.1-3] [MQTT-2.2.1-4]。
+//QoS 1及QoS 2的PUBLISH报文受对端接收最大值（Receive Maximum）限制，SUBSCRIBE及UNSUBSCRIBE报文不受限制 [MQTT-3.3.4-7] [MQTT-3.3.4-9]
+type IDAllocator struct {
+    lock sync.Mutex
+    next uint16
+    //使用中的报文标识符，value表示是否占用流控窗口
+    inUse map[uint16]bool
+    //流控窗口中的PUBLISH报文数量
+    inFlight int
+    max      int
+    //有标识符释放时关闭，用于等待流控窗口
+    notify chan struct{}
+}
+
+//receiveMaximum为对端在CONNECT或CONNACK中声明的接收最大值，0表示未设置
+func NewIDAllocator(receiveMaximum uint16) *IDAllocator {
+    ret := &IDAllocator{
+        next:   1,
+        inUse:  map[uint16]bool{},
+        notify: make(chan struct{}),
+    }
+    ret.SetReceiveMaximum(receiveMaximum)
+    return ret
+}
+
+//设置对端的接收最大值，0表示未设置（65535）。已发送的报文不受影响
+func (a *IDAllocator) SetReceiveMaximum(v uint16) {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    if v == 0 {
+        v = DefaultReceiveMaximum
+    }
+    a.max = int(v)
+    a.wakeup()
+}
+
+func (a *IDAllocator) GetReceiveMaximum() uint16 {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    return uint16(a.max)
+}
+
+//为SUBSCRIBE及UNSUBSCRIBE报文分配报文标识符，所有标识符都在使用中时返回PacketIdentifierInUse
+func (a *IDAllocator) Allocate() (uint16, error) {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    return a.allocate(false)
+}
+
+//为QoS 1及QoS 2的PUBLISH报文分配报文标识符，
+//流控窗口已满时返回ReceiveMaximumExceeded，所有标识符都在使用中时返回PacketIdentifierInUse
+func (a *IDAllocator) AllocatePublish() (uint16, error) {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    if a.inFlight >= a.max {
+        return 0, errcode.ReceiveMaximumExceeded
+    }
+    return a.allocate(true)
+}
+
+//为QoS 1及QoS 2的PUBLISH报文分配报文标识符，流控窗口已满时等待其他报文确认，直到ctx结束
+func (a *IDAllocator) AllocatePublishContext(ctx context.Context) (uint16, error) {
+    for {
+        a.lock.Lock()
+        if a.inFlight < a.max {
+            id, err := a.allocate(true)
+            a.lock.Unlock()
+            return id, err
+        }
+        notify := a.notify
+        a.lock.Unlock()
+
+        select {
+        case <-notify:
+        case <-ctx.Done():
+            return 0, ctx.Err()
+        }
+    }
+}
+
+func (a *IDAllocator) allocate(flowControl bool) (uint16, error) {
+    if len(a.inUse) >= math.MaxUint16 {
+        return 0, errcode.PacketIdentifierInUse
+    }
+    for {
+        id := a.next
+        a.next++
+        if a.next == 0 {
+            a.next = 1
+        }
+        if _, ok := a.inUse[id]; !ok {
+            a.use(id, flowControl)
+            return id, nil
+        }
+    }
+}
+
+func (a *IDAllocator) use(id uint16, flowControl bool) {
+    a.inUse[id] = flowControl
+    if flowControl {
+        a.inFlight++
+    }
+}
+
+//标记指定的报文标识符为使用中，用于恢复会话时重发未完成的报文。
+//id为0时返回PacketIdentifierInvalid，已在使用中时返回PacketIdentifierInUse。
+//恢复的PUBLISH报文占用流控窗口，但不受接收最大值限制
+func (a *IDAllocator) Use(id uint16, publish bool) error {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    if id == 0 {
+        return errcode.PacketIdentifierInvalid
+    }
+    if _, ok := a.inUse[id]; ok {
+        return errcode.PacketIdentifierInUse
+    }
+    a.use(id, publish)
+    return nil
+}
+
+//报文交互完成（收到PUBACK、PUBCOMP、SUBACK、UNSUBACK或失败的PUBREC）后释放报文标识符，
+//标识符不在使用中时返回false
+func (a *IDAllocator) Release(id uint16) bool {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    flowControl, ok := a.inUse[id]
+    if !ok {
+        return false
+    }
+    delete(a.inUse, id)
+    if flowControl {
+        a.inFlight--
+        a.wakeup()
+    }
+    return true
+}
+
+func (a *IDAllocator) wakeup() {
+    close(a.notify)
+    a.notify = make(chan struct{})
+}
+
+//报文标识符是否在使用中
+func (a *IDAllocator) InUse(id uint16) bool {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    _, ok := a.inUse[id]
+    return ok
+}
+
+//流控窗口中的PUBLISH报文数量
+func (a *IDAllocator) InFlight() int {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    return a.inFlight
+}
+
+//下一个尝试分配的报文标识符，用于持久化会话
+func (a *IDAllocator) Next() uint16 {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    return a.next
+}
+
+//设置下一个尝试分配的报文标识符，用于恢复会话，0按1处理
+func (a *IDAllocator) SetNext(id uint16) {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    if id == 0 {
+        id = 1
+    }
+    a.next = id
+}
+
+//释放所有报文标识符，用于开始新会话
+func (a *IDAllocator) Reset() {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+
+    a.inUse = map[uint16]bool{}
+    a.inFlight = 0
+    a.next = 1
+    a.wakeup()
+}
+
+//接收方向的报文标识符，记录对端发送的尚未完成交互的QoS 1及QoS 2 PUBLISH报文，可并发使用
+type InboundIDs struct {
+    lock  sync.Mutex
+    inUse map[uint16]struct{}
+    max   int
+}
+
+//receiveMaximum为本端在CONNECT或CONNACK中声明的接收最大值，0表示未设置
+func NewInboundIDs(receiveMaximum uint16) *InboundIDs {
+    if receiveMaximum == 0 {
+        receiveMaximum = DefaultReceiveMaximum
+    }
+    return &InboundIDs{
+        inUse: map[uint16]struct{}{},
+        max:   int(receiveMaximum),
+    }
+}
+
+//记录收到的PUBLISH报文标识符。
+//id为0时返回PacketIdentifierInvalid；标识符已在使用中（如重发的QoS 2报文）时返回PacketIdentifierInUse；
+//超出接收最大值时返回ReceiveMaximumExceeded，此时应以该原因码断开连接
+func (ids *InboundIDs) Add(id uint16) error {
+    ids.lock.Lock()
+    defer ids.lock.Unlock()
+
+    if id == 0 {
+        return errcode.PacketIdentifierInvalid
+    }
+    if _, ok := ids.inUse[id]; ok {
+        return errcode.PacketIdentifierInUse
+    }
+    if len(ids.inUse) >= ids.max {
+        return errcode.ReceiveMaximumExceeded
+    }
+    ids.inUse[id] = struct{}{}
+    return nil
+}
+
+//报文交互完成（发送PUBACK或PUBCOMP）后释放报文标识符，标识符不在使用中时返回false
+func (ids *InboundIDs) Remove(id uint16) bool {
+    ids.lock.Lock()
+    defer ids.lock.Unlock()
+
+    if _, ok := ids.inUse[id]; !ok {
+        return false
+    }
+    delete(ids.inUse, id)
+    return true
+}
+
+//报文标识符是否在使用中
+func (ids *InboundIDs) Contains(id uint16) bool {
+    ids.lock.Lock()
+    defer ids.lock.Unlock()
+
+    _, ok := ids.inUse[id]
+    return ok
+}
+
+//尚未完成交互的报文数量
+func (ids *InboundIDs) Len() int {
+    ids.lock.Lock()
+    defer ids.lock.Unlock()
+
+    return len(ids.inUse)
+}
+
+//释放所有报文标识符，用于开始新会话
+func (ids *InboundIDs) Reset() {
+    ids.lock.Lock()
+    defer ids.lock.Unlock()
+
+    ids.inUse = map[uint16]struct{}{}
+}
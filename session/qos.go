@@ -0,0 +1,345 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package session
+
+import (
+    "container/list"
+    "context"
+    "mqtt/errcode"
+    "mqtt/message"
+    "sync"
+)
+
+//发送方的消息状态
+type State byte
+
+const (
+    //未发送或交互已完成
+    StateNone State = iota
+    //QoS 1已发送PUBLISH，等待PUBACK
+    StateWaitPubAck
+    //QoS 2已发送PUBLISH，等待PUBREC
+    StateWaitPubRec
+    //QoS 2已发送PUBREL，等待PUBCOMP
+    StateWaitPubComp
+)
+
+type outboundEntry struct {
+    state State
+    msg   *message.PublishMessage
+}
+
+//发送方的QoS 1及QoS 2状态机，只根据报文驱动状态，不负责网络读写，可并发使用：
+//QoS 1：PUBLISH -> PUBACK；QoS 2：PUBLISH -> PUBREC -> PUBREL -> PUBCOMP。
+//未完成的消息按发送顺序保存，会话恢复时按原顺序重发 [MQTT-4.6.0-1]
+type Outbound struct {
+    lock    sync.Mutex
+    ids     *IDAllocator
+    version byte
+    queue   *list.List
+    entries map[uint16]*list.Element
+}
+
+func NewOutbound(ids *IDAllocator, version byte) *Outbound {
+    return &Outbound{
+        ids:     ids,
+        version: version,
+        queue:   list.New(),
+        entries: map[uint16]*list.Element{},
+    }
+}
+
+//发送PUBLISH报文前调用。QoS 0不做处理；QoS 1及QoS 2分配报文标识符并记录状态，
+//流控窗口已满时返回ReceiveMaximumExceeded
+func (o *Outbound) Publish(msg *message.PublishMessage) error {
+    if msg.GetQos() == 0 {
+        return nil
+    }
+    id, err := o.ids.AllocatePublish()
+    if err != nil {
+        return err
+    }
+    o.add(id, msg)
+    return nil
+}
+
+//同Publish，流控窗口已满时等待其他消息完成，直到ctx结束
+func (o *Outbound) PublishContext(ctx context.Context, msg *message.PublishMessage) error {
+    if msg.GetQos() == 0 {
+        return nil
+    }
+    id, err := o.ids.AllocatePublishContext(ctx)
+    if err != nil {
+        return err
+    }
+    o.add(id, msg)
+    return nil
+}
+
+func (o *Outbound) add(id uint16, msg *message.PublishMessage) {
+    msg.SetPacketIdentifier(id)
+    msg.SetDup(false)
+    state := StateWaitPubAck
+    if msg.GetQos() == 2 {
+        state = StateWaitPubRec
+    }
+
+    o.lock.Lock()
+    defer o.lock.Unlock()
+
+    o.entries[id] = o.queue.PushBack(&outboundEntry{state: state, msg: msg})
+}
+
+//恢复会话中未完成的PUBLISH报文，state为持久化时的状态，用于重启后继续交互。
+//报文标识符已在使用中时返回PacketIdentifierInUse
+func (o *Outbound) Restore(msg *message.PublishMessage, state State) error {
+    if state == StateNone {
+        return nil
+    }
+    id := msg.GetPacketIdentifier()
+    if err := o.ids.Use(id, true); err != nil {
+        return err
+    }
+
+    o.lock.Lock()
+    defer o.lock.Unlock()
+
+    o.entries[id] = o.queue.PushBack(&outboundEntry{state: state, msg: msg})
+    return nil
+}
+
+//恢复会话中等待PUBCOMP的PUBREL，此时已不需要原PUBLISH报文，只保存报文标识符。
+//报文标识符已在使用中时返回PacketIdentifierInUse
+func (o *Outbound) RestorePubRel(id uint16) error {
+    msg := message.NewPublishMessage()
+    msg.SetQos(2)
+    msg.SetPacketIdentifier(id)
+    return o.Restore(msg, StateWaitPubComp)
+}
+
+//收到PUBACK，交互完成，返回对应的PUBLISH报文，调用者可以通过ack的原因码判断对端是否接受。
+//没有等待PUBACK的对应报文时返回PacketIdentifierNotFound
+func (o *Outbound) HandlePubAck(ack *message.PubAckMessage) (*message.PublishMessage, error) {
+    o.lock.Lock()
+    defer o.lock.Unlock()
+
+    e := o.get(ack.GetPacketIdentifier(), StateWaitPubAck)
+    if e == nil {
+        return nil, errcode.PacketIdentifierNotFound
+    }
+    o.remove(ack.GetPacketIdentifier())
+    return e.msg, nil
+}
+
+//收到PUBREC，返回应发送的PUBREL报文：
+//原因码小于0x80时进入等待PUBCOMP状态，之后PUBLISH报文不再重发 [MQTT-4.3.3-6]；
+//重复的PUBREC再次返回PUBREL；没有对应报文时返回原因码为PacketIdentifierNotFound的PUBREL。
+//原因码大于等于0x80表示消息交付失败，交互结束并释放报文标识符，返回nil
+func (o *Outbound) HandlePubRec(rec *message.PubRecMessage) (*message.PubRelMessage, error) {
+    id := rec.GetPacketIdentifier()
+
+    o.lock.Lock()
+    defer o.lock.Unlock()
+
+    el, ok := o.entries[id]
+    if !ok {
+        rel := o.pubRel(id)
+        rel.SetReasonCode(errcode.ReasonPacketIdentifierNotFound)
+        return rel, nil
+    }
+
+    e := el.Value.(*outboundEntry)
+    switch e.state {
+    case StateWaitPubRec:
+        if rec.GetReasonCode().IsError() {
+            o.remove(id)
+            return nil, nil
+        }
+        e.state = StateWaitPubComp
+        return o.pubRel(id), nil
+    case StateWaitPubComp:
+        return o.pubRel(id), nil
+    default:
+        //QoS 1的报文标识符
+        return nil, errcode.PacketIdentifierNotFound
+    }
+}
+
+//收到PUBCOMP，交互完成，返回对应的PUBLISH报文。
+//没有等待PUBCOMP的对应报文时返回PacketIdentifierNotFound
+func (o *Outbound) HandlePubComp(comp *message.PubCompMessage) (*message.PublishMessage, error) {
+    o.lock.Lock()
+    defer o.lock.Unlock()
+
+    e := o.get(comp.GetPacketIdentifier(), StateWaitPubComp)
+    if e == nil {
+        return nil, errcode.PacketIdentifierNotFound
+    }
+    o.remove(comp.GetPacketIdentifier())
+    return e.msg, nil
+}
+
+//放弃未完成的消息并释放报文标识符，用于PUBLISH报文无法发送（如超出对端的最大报文长度）时。
+//没有对应的消息时返回false
+func (o *Outbound) Discard(id uint16) bool {
+    o.lock.Lock()
+    defer o.lock.Unlock()
+
+    if _, ok := o.entries[id]; !ok {
+        return false
+    }
+    o.remove(id)
+    return true
+}
+
+//会话恢复（Clean Start为0）时按原顺序返回需要重发的报文：
+//等待PUBACK或PUBREC的PUBLISH报文设置DUP标志后重发，等待PUBCOMP的重发PUBREL [MQTT-4.4.0-1] [MQTT-3.3.1-1]
+func (o *Outbound) Resend() []message.Message {
+    o.lock.Lock()
+    defer o.lock.Unlock()
+
+    ret := make([]message.Message, 0, o.queue.Len())
+    for el := o.queue.Front(); el != nil; el = el.Next() {
+        e := el.Value.(*outboundEntry)
+        if e.state == StateWaitPubComp {
+            ret = append(ret, o.pubRel(e.msg.GetPacketIdentifier()))
+        } else {
+            e.msg.SetDup(true)
+            ret = append(ret, e.msg)
+        }
+    }
+    return ret
+}
+
+//报文标识符对应的状态
+func (o *Outbound) State(id uint16) State {
+    o.lock.Lock()
+    defer o.lock.Unlock()
+
+    if el, ok := o.entries[id]; ok {
+        return el.Value.(*outboundEntry).state
+    }
+    return StateNone
+}
+
+//未完成的消息数量
+func (o *Outbound) Len() int {
+    o.lock.Lock()
+    defer o.lock.Unlock()
+
+    return o.queue.Len()
+}
+
+//丢弃所有未完成的消息并释放报文标识符，用于开始新会话（Clean Start为1）
+func (o *Outbound) Clear() {
+    o.lock.Lock()
+    defer o.lock.Unlock()
+
+    for id := range o.entries {
+        o.ids.Release(id)
+    }
+    o.queue.Init()
+    o.entries = map[uint16]*list.Element{}
+}
+
+func (o *Outbound) get(id uint16, state State) *outboundEntry {
+    el, ok := o.entries[id]
+    if !ok {
+        return nil
+    }
+    e := el.Value.(*outboundEntry)
+    if e.state != state {
+        return nil
+    }
+    return e
+}
+
+func (o *Outbound) remove(id uint16) {
+    o.queue.Remove(o.entries[id])
+    delete(o.entries, id)
+    o.ids.Release(id)
+}
+
+func (o *Outbound) pubRel(id uint16) *message.PubRelMessage {
+    rel := message.NewPubRelMessage()
+    rel.SetVersion(o.version)
+    rel.SetPacketIdentifier(id)
+    return rel
+}
+
+//接收方的QoS 1及QoS 2状态机，只根据报文驱动状态，不负责网络读写，可并发使用。
+//QoS 2收到PUBLISH后立即投递并记录报文标识符，在收到PUBREL前不再投递相同标识符的报文，
+//即[MQTT-4.3.3]中的Method B
+type Inbound struct {
+    ids     *InboundIDs
+    version byte
+}
+
+func NewInbound(ids *InboundIDs, version byte) *Inbound {
+    return &Inbound{
+        ids:     ids,
+        version: version,
+    }
+}
+
+//收到PUBLISH报文，返回是否应向应用投递及应发送的应答报文（QoS 0为nil）：
+//QoS 1投递并返回PUBACK；QoS 2新消息投递并返回PUBREC，重复的消息不再投递，只返回PUBREC [MQTT-4.3.3-10]。
+//超出本端接收最大值时返回ReceiveMaximumExceeded，此时应以该原因码断开连接
+func (in *Inbound) HandlePublish(msg *message.PublishMessage) (bool, message.Message, error) {
+    id := msg.GetPacketIdentifier()
+    switch msg.GetQos() {
+    case 0:
+        return true, nil, nil
+    case 1:
+        ack := message.NewPubAckMessage()
+        ack.SetVersion(in.version)
+        ack.SetPacketIdentifier(id)
+        return true, ack, nil
+    }
+
+    err := in.ids.Add(id)
+    if err != nil && err != errcode.PacketIdentifierInUse {
+        return false, nil, err
+    }
+    rec := message.NewPubRecMessage()
+    rec.SetVersion(in.version)
+    rec.SetPacketIdentifier(id)
+    return err == nil, rec, nil
+}
+
+//收到PUBREL，释放报文标识符并返回PUBCOMP，
+//没有对应的报文标识符时返回原因码为PacketIdentifierNotFound的PUBCOMP
+func (in *Inbound) HandlePubRel(rel *message.PubRelMessage) *message.PubCompMessage {
+    id := rel.GetPacketIdentifier()
+    comp := message.NewPubCompMessage()
+    comp.SetVersion(in.version)
+    comp.SetPacketIdentifier(id)
+    if !in.ids.Remove(id) {
+        comp.SetReasonCode(errcode.ReasonPacketIdentifierNotFound)
+    }
+    return comp
+}
+
+//恢复会话中尚未收到PUBREL的QoS 2报文标识符，之后相同标识符的PUBLISH报文不再投递
+func (in *Inbound) Restore(id uint16) error {
+    return in.ids.Add(id)
+}
+
+//放弃尚未投递的QoS 2消息，释放报文标识符，对端重发时重新投递
+func (in *Inbound) Release(id uint16) {
+    in.ids.Remove(id)
+}
+
+//是否有尚未收到PUBREL的QoS 2消息
+func (in *Inbound) Pending(id uint16) bool {
+    return in.ids.Contains(id)
+}
+
+//丢弃所有未完成的QoS 2消息，用于开始新会话（Clean Start为1）
+func (in *Inbound) Clear() {
+    in.ids.Reset()
+}
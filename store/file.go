@@ -0,0 +1,237 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package store
+
+import (
+    "bytes"
+    "mqtt/message"
+    "mqtt/packet"
+    "sort"
+    "sync"
+)
+
+//基于追加日志的文件存储，每次修改追加一条记录，打开时重放日志恢复状态。
+//保存报文时记录的数据为WriteMessage编码的报文；保存订阅时为主题过滤器（2字节长度+UTF-8）及订阅选项（1字节）；
+//删除订阅时为主题过滤器
+type FileStore struct {
+    lock sync.Mutex
+    log  *logFile
+    mem  *Memory
+}
+
+//打开或创建日志文件并恢复状态
+func OpenFile(path string, opts FileOptions) (*FileStore, error) {
+    fs := &FileStore{
+        mem: NewMemory(),
+    }
+    l, err := openLog(path, opts, fs.prepare, fs.snapshot)
+    if err != nil {
+        return nil, err
+    }
+    fs.log = l
+    return fs, nil
+}
+
+//解码记录，返回将其应用到内存状态的函数
+func (fs *FileStore) prepare(rec record) (func(), error) {
+    var apply func(m *Memory)
+    switch rec.op {
+    case opPut:
+        msg, _, err := message.Decode(rec.data, recordVersion)
+        if err != nil {
+            return nil, err
+        }
+        id, err := packetIdentifier(msg)
+        if err != nil {
+            return nil, err
+        }
+        apply = func(m *Memory) { m.put(rec.clientID, rec.dir, id, msg) }
+    case opDelete:
+        apply = func(m *Memory) { m.delete(rec.clientID, rec.dir, rec.id) }
+    case opSubscribe:
+        f, err := decodeSubscription(rec.data)
+        if err != nil {
+            return nil, err
+        }
+        apply = func(m *Memory) { m.session(rec.clientID, true).subs[f.Filter] = f }
+    case opUnsubscribe:
+        filter, _, err := packet.ParseString(packet.NewSliceReader(rec.data), packet.ControlCharAllow)
+        if err != nil {
+            return nil, err
+        }
+        apply = func(m *Memory) { m.unsubscribe(rec.clientID, filter.String()) }
+    case opNextID:
+        apply = func(m *Memory) { m.setNextID(rec.clientID, rec.id) }
+    case opDeleteSession:
+        apply = func(m *Memory) { m.deleteSession(rec.clientID) }
+    default:
+        return nil, ErrMessageType
+    }
+    return func() {
+        m := fs.mem
+        m.lock.Lock()
+        defer m.lock.Unlock()
+
+        apply(m)
+    }, nil
+}
+
+//按客户端标识符顺序生成当前状态的记录
+func (fs *FileStore) snapshot(emit func(rec record) error) error {
+    m := fs.mem
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    for _, clientID := range m.clientIDs() {
+        s := m.sessions[clientID]
+        if s.nextID != 1 {
+            if err := emit(record{op: opNextID, id: s.nextID, clientID: clientID}); err != nil {
+                return err
+            }
+        }
+        filters := make([]string, 0, len(s.subs))
+        for filter := range s.subs {
+            filters = append(filters, filter)
+        }
+        sort.Strings(filters)
+        for _, filter := range filters {
+            data, err := encodeSubscription(s.subs[filter])
+            if err != nil {
+                return err
+            }
+            if err := emit(record{op: opSubscribe, clientID: clientID, data: data}); err != nil {
+                return err
+            }
+        }
+        for _, dir := range []Direction{Outbound, Inbound} {
+            for el := s.msgs[dir].queue.Front(); el != nil; el = el.Next() {
+                msg := el.Value.(message.Message)
+                id, _ := packetIdentifier(msg)
+                data, err := message.Encode(nil, msg, recordVersion)
+                if err != nil {
+                    return err
+                }
+                if err := emit(record{op: opPut, dir: dir, id: id, clientID: clientID, data: data}); err != nil {
+                    return err
+                }
+            }
+        }
+    }
+    return nil
+}
+
+func encodeSubscription(f message.SubscribeFilter) ([]byte, error) {
+    buf := bytes.Buffer{}
+    if _, err := packet.EncodeString(&buf, f.Filter, packet.ControlCharAllow); err != nil {
+        return nil, err
+    }
+    buf.WriteByte(f.Options.Encode(recordVersion))
+    return buf.Bytes(), nil
+}
+
+func decodeSubscription(b []byte) (message.SubscribeFilter, error) {
+    r := packet.NewSliceReader(b)
+    filter, _, err := packet.ParseString(r, packet.ControlCharAllow)
+    if err != nil {
+        return message.SubscribeFilter{}, err
+    }
+    opt, _, err := packet.ReadByte(r)
+    if err != nil {
+        return message.SubscribeFilter{}, err
+    }
+    options, err := message.DecodeSubscriptionOptions(opt, recordVersion)
+    if err != nil {
+        return message.SubscribeFilter{}, err
+    }
+    return message.SubscribeFilter{Filter: filter.String(), Options: options}, nil
+}
+
+func (fs *FileStore) append(rec record) error {
+    fs.lock.Lock()
+    defer fs.lock.Unlock()
+
+    return fs.log.append(rec)
+}
+
+func (fs *FileStore) Put(clientID string, dir Direction, msg message.Message) error {
+    id, err := packetIdentifier(msg)
+    if err != nil {
+        return err
+    }
+    data, err := message.Encode(nil, msg, recordVersion)
+    if err != nil {
+        return err
+    }
+    return fs.append(record{op: opPut, dir: dir, id: id, clientID: clientID, data: data})
+}
+
+func (fs *FileStore) Delete(clientID string, dir Direction, id uint16) error {
+    return fs.append(record{op: opDelete, dir: dir, id: id, clientID: clientID})
+}
+
+func (fs *FileStore) Messages(clientID string, dir Direction) ([]message.Message, error) {
+    return fs.mem.Messages(clientID, dir)
+}
+
+func (fs *FileStore) Subscribe(clientID string, f message.SubscribeFilter) error {
+    data, err := encodeSubscription(f)
+    if err != nil {
+        return err
+    }
+    return fs.append(record{op: opSubscribe, clientID: clientID, data: data})
+}
+
+func (fs *FileStore) Unsubscribe(clientID string, filter string) error {
+    buf := bytes.Buffer{}
+    if _, err := packet.EncodeString(&buf, filter, packet.ControlCharAllow); err != nil {
+        return err
+    }
+    return fs.append(record{op: opUnsubscribe, clientID: clientID, data: buf.Bytes()})
+}
+
+func (fs *FileStore) Subscriptions(clientID string) ([]message.SubscribeFilter, error) {
+    return fs.mem.Subscriptions(clientID)
+}
+
+func (fs *FileStore) SetNextID(clientID string, id uint16) error {
+    return fs.append(record{op: opNextID, id: id, clientID: clientID})
+}
+
+func (fs *FileStore) NextID(clientID string) (uint16, error) {
+    return fs.mem.NextID(clientID)
+}
+
+func (fs *FileStore) DeleteSession(clientID string) error {
+    return fs.append(record{op: opDeleteSession, clientID: clientID})
+}
+
+func (fs *FileStore) ClientIDs() ([]string, error) {
+    return fs.mem.ClientIDs()
+}
+
+//日志中的记录数
+func (fs *FileStore) Records() int {
+    fs.lock.Lock()
+    defer fs.lock.Unlock()
+
+    return fs.log.records
+}
+
+//压缩日志，只保留当前状态对应的记录
+func (fs *FileStore) Compact() error {
+    fs.lock.Lock()
+    defer fs.lock.Unlock()
+
+    return fs.log.compact()
+}
+
+func (fs *FileStore) Close() error {
+    fs.lock.Lock()
+    defer fs.lock.Unlock()
+
+    return fs.log.close()
+}
@@ -0,0 +1,346 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package store
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "mqtt/packet"
+    "os"
+    "path/filepath"
+)
+
+const (
+    //废弃记录超过该数量时自动压缩
+    DefaultCompactThreshold = 1024
+
+    //记录长度超过该值时视为损坏，大于最大报文长度加上记录头
+    maxRecordSize = 1 << 29
+    //记录头：操作、方向、报文标识符及客户端标识符长度
+    recordHeaderSize = 6
+
+    //压缩时的临时文件后缀
+    compactSuffix = ".compact"
+)
+
+//日志记录的操作
+const (
+    opPut byte = iota + 1
+    opDelete
+    opSubscribe
+    opUnsubscribe
+    opNextID
+    opDeleteSession
+    opPush
+    opPop
+)
+
+//报文统一以5.0编码保存，与会话协商的协议版本无关，发送时由WriteMessage按协商的版本重新编码
+const recordVersion = packet.MqttProtocolVersion5
+
+//文件存储选项
+type FileOptions struct {
+    //每次写入后调用fsync，保证操作系统崩溃或断电后不丢失已返回的写入；
+    //为false时只保证进程崩溃后不丢失
+    Sync bool
+    //废弃记录数超过该值且超过有效记录数时自动压缩，0表示DefaultCompactThreshold，负数表示不自动压缩
+    CompactThreshold int
+}
+
+//日志中校验通过的记录无法解码或应用，通常是由不兼容的版本写入或文件被修改，
+//打开日志时返回该错误且不修改日志文件
+type RecordError struct {
+    //记录在日志文件中的偏移
+    Offset int64
+    Err    error
+}
+
+func (e *RecordError) Error() string {
+    return fmt.Sprintf("store: invalid record at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+    return e.Err
+}
+
+type record struct {
+    op       byte
+    dir      Direction
+    id       uint16
+    clientID string
+    data     []byte
+}
+
+//追加日志，记录格式（整数均为大端）：
+//  长度（4字节，不包括长度及校验字段）| 操作（1字节）| 方向（1字节）| 报文标识符（2字节）|
+//  客户端标识符（2字节长度+UTF-8）| 数据 | CRC32（4字节，IEEE，校验长度之后的数据）
+//写入过程中进程被终止时日志末尾可能只有部分记录，打开时截断第一条长度无效、不完整或校验失败的记录及之后的数据；
+//校验通过的记录无法解码或应用时返回*RecordError。
+//不加锁，由使用者串行调用
+type logFile struct {
+    path string
+    opts FileOptions
+    f    *os.File
+    //有效数据的长度，写入失败时截断到该长度
+    size int64
+    //日志中的记录数
+    records int
+    //上次压缩或打开后的有效记录数
+    base   int
+    buf    bytes.Buffer
+    closed bool
+
+    //解码并校验记录，返回将其应用到使用者状态的函数，应用时不会失败
+    prepare func(rec record) (func(), error)
+    //压缩时按当前状态生成记录
+    snapshot func(emit func(rec record) error) error
+}
+
+//打开或创建日志文件，按顺序将记录交给prepare并应用以恢复状态
+func openLog(path string, opts FileOptions, prepare func(rec record) (func(), error),
+    snapshot func(emit func(rec record) error) error) (*logFile, error) {
+    //压缩未完成时原日志仍然有效
+    os.Remove(path + compactSuffix)
+
+    f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+    if err != nil {
+        return nil, err
+    }
+    l := &logFile{
+        path:     path,
+        opts:     opts,
+        f:        f,
+        prepare:  prepare,
+        snapshot: snapshot,
+    }
+    if err := l.recover(); err != nil {
+        f.Close()
+        return nil, err
+    }
+    return l, nil
+}
+
+//重放日志，截断末尾不完整的记录。记录无法解码或应用时不修改文件，
+//避免将有效记录之后的数据当作损坏的末尾删除
+func (l *logFile) recover() error {
+    r := bufio.NewReader(l.f)
+    var offset int64
+    head := make([]byte, 4)
+    for {
+        if _, err := io.ReadFull(r, head); err != nil {
+            if err != io.EOF && err != io.ErrUnexpectedEOF {
+                return err
+            }
+            break
+        }
+        size := binary.BigEndian.Uint32(head)
+        if size < recordHeaderSize || size > maxRecordSize {
+            break
+        }
+        //每条记录使用新的缓冲，解码的报文会引用该缓冲
+        body := make([]byte, size+4)
+        if _, err := io.ReadFull(r, body); err != nil {
+            if err != io.EOF && err != io.ErrUnexpectedEOF {
+                return err
+            }
+            break
+        }
+        if crc32.ChecksumIEEE(body[:size]) != binary.BigEndian.Uint32(body[size:]) {
+            break
+        }
+        rec, err := decodeRecord(body[:size])
+        if err != nil {
+            return &RecordError{Offset: offset, Err: err}
+        }
+        apply, err := l.prepare(rec)
+        if err != nil {
+            return &RecordError{Offset: offset, Err: err}
+        }
+        apply()
+        offset += int64(size) + 8
+        l.records++
+    }
+
+    if err := l.f.Truncate(offset); err != nil {
+        return err
+    }
+    if _, err := l.f.Seek(offset, io.SeekStart); err != nil {
+        return err
+    }
+    l.size = offset
+    l.base = l.records
+    return nil
+}
+
+func encodeRecord(buf *bytes.Buffer, rec record) error {
+    buf.Reset()
+    buf.Write([]byte{0, 0, 0, 0, rec.op, byte(rec.dir), byte(rec.id >> 8), byte(rec.id)})
+    if _, err := packet.EncodeString(buf, rec.clientID, packet.ControlCharAllow); err != nil {
+        return err
+    }
+    buf.Write(rec.data)
+
+    b := buf.Bytes()
+    binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+    crc := make([]byte, 4)
+    binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(b[4:]))
+    buf.Write(crc)
+    return nil
+}
+
+func decodeRecord(b []byte) (record, error) {
+    r := packet.NewSliceReader(b[4:])
+    clientID, _, err := packet.ParseString(r, packet.ControlCharAllow)
+    if err != nil {
+        return record{}, err
+    }
+    rest, _ := r.Next(r.Len())
+    return record{
+        op:       b[0],
+        dir:      Direction(b[1]),
+        id:       binary.BigEndian.Uint16(b[2:]),
+        clientID: clientID.String(),
+        data:     rest,
+    }, nil
+}
+
+//追加记录，写入前先校验，不写入重放时无法应用的记录，写入成功后应用到使用者的状态
+func (l *logFile) append(rec record) error {
+    if l.closed {
+        return ErrClosed
+    }
+    apply, err := l.prepare(rec)
+    if err != nil {
+        return err
+    }
+    if err := encodeRecord(&l.buf, rec); err != nil {
+        return err
+    }
+    if err := l.write(l.buf.Bytes()); err != nil {
+        return err
+    }
+    l.records++
+    apply()
+    l.maybeCompact()
+    return nil
+}
+
+//写入失败时截断已写入的部分，避免不完整的记录之后再追加记录
+func (l *logFile) write(b []byte) error {
+    n, err := l.f.Write(b)
+    if err == nil && l.opts.Sync {
+        err = l.f.Sync()
+    }
+    if err != nil {
+        if n > 0 {
+            l.f.Truncate(l.size)
+            l.f.Seek(l.size, io.SeekStart)
+        }
+        return err
+    }
+    l.size += int64(n)
+    return nil
+}
+
+func (l *logFile) maybeCompact() {
+    threshold := l.opts.CompactThreshold
+    if threshold == 0 {
+        threshold = DefaultCompactThreshold
+    }
+    if threshold < 0 || l.records <= 2*l.base+threshold {
+        return
+    }
+    //压缩失败不影响已写入的记录，下次写入时重试
+    l.compact()
+}
+
+//压缩日志：将当前状态写入临时文件后替换原日志，压缩过程中崩溃时原日志仍然有效
+func (l *logFile) compact() error {
+    if l.closed {
+        return ErrClosed
+    }
+
+    tmp := l.path + compactSuffix
+    f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    count, err := l.writeSnapshot(f)
+    if err == nil {
+        err = f.Sync()
+    }
+    if e := f.Close(); err == nil {
+        err = e
+    }
+    if err == nil {
+        err = os.Rename(tmp, l.path)
+    }
+    if err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    syncDir(filepath.Dir(l.path))
+
+    //原文件已被替换，重新打开新日志继续追加
+    nf, err := os.OpenFile(l.path, os.O_RDWR, 0644)
+    if err != nil {
+        l.close()
+        return err
+    }
+    size, err := nf.Seek(0, io.SeekEnd)
+    if err != nil {
+        nf.Close()
+        l.close()
+        return err
+    }
+    l.f.Close()
+    l.f = nf
+    l.size = size
+    l.records = count
+    l.base = count
+    return nil
+}
+
+//写入当前状态，返回写入的记录数
+func (l *logFile) writeSnapshot(w io.Writer) (int, error) {
+    bw := bufio.NewWriter(w)
+    count := 0
+    err := l.snapshot(func(rec record) error {
+        if err := encodeRecord(&l.buf, rec); err != nil {
+            return err
+        }
+        count++
+        _, err := bw.Write(l.buf.Bytes())
+        return err
+    })
+    if err != nil {
+        return count, err
+    }
+    return count, bw.Flush()
+}
+
+func (l *logFile) close() error {
+    if l.closed {
+        return nil
+    }
+    l.closed = true
+    return l.f.Close()
+}
+
+//同步目录，保证重命名在断电后仍然有效，不支持时忽略
+func syncDir(dir string) {
+    d, err := os.Open(dir)
+    if err != nil {
+        return
+    }
+    d.Sync()
+    d.Close()
+}
@@ -0,0 +1,236 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package store
+
+import (
+    "encoding/binary"
+    "errors"
+    "mqtt/message"
+    "sync"
+    "time"
+)
+
+var ErrMessageFormat = errors.New("store: invalid queued message")
+
+//离线消息队列，按入队顺序保存未连接时发布的PUBLISH报文及入队时间，
+//入队时间用于计算消息过期间隔（MessageExpiryInterval）。实现需可并发使用
+type Queue interface {
+    //在队尾追加消息
+    Push(msg *message.PublishMessage, enqueued time.Time) error
+    //返回队首的消息及入队时间，队列为空时返回nil
+    Peek() (*message.PublishMessage, time.Time, error)
+    //删除队首的消息，队列为空时不做处理
+    Pop() error
+    //队列中的消息数
+    Len() int
+
+    Close() error
+}
+
+type queueEntry struct {
+    msg      *message.PublishMessage
+    enqueued time.Time
+}
+
+//按入队顺序保存的消息，出队只移动队首位置，已出队的部分超过一半时再整理
+type entryList struct {
+    entries []queueEntry
+    head    int
+}
+
+func (l *entryList) push(e queueEntry) {
+    l.entries = append(l.entries, e)
+}
+
+func (l *entryList) peek() (queueEntry, bool) {
+    if l.head == len(l.entries) {
+        return queueEntry{}, false
+    }
+    return l.entries[l.head], true
+}
+
+func (l *entryList) pop() {
+    if l.head == len(l.entries) {
+        return
+    }
+    l.entries[l.head] = queueEntry{}
+    l.head++
+    if l.head == len(l.entries) {
+        l.entries = l.entries[:0]
+        l.head = 0
+    } else if l.head > len(l.entries)/2 {
+        n := copy(l.entries, l.entries[l.head:])
+        l.entries = l.entries[:n]
+        l.head = 0
+    }
+}
+
+func (l *entryList) len() int {
+    return len(l.entries) - l.head
+}
+
+//内存队列，进程退出后消息丢失。保存及返回的报文不做拷贝，调用者不应在入队后修改
+type MemoryQueue struct {
+    lock sync.Mutex
+    list entryList
+}
+
+func NewMemoryQueue() *MemoryQueue {
+    return &MemoryQueue{}
+}
+
+func (q *MemoryQueue) Push(msg *message.PublishMessage, enqueued time.Time) error {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    q.list.push(queueEntry{msg: msg, enqueued: enqueued})
+    return nil
+}
+
+func (q *MemoryQueue) Peek() (*message.PublishMessage, time.Time, error) {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    e, _ := q.list.peek()
+    return e.msg, e.enqueued, nil
+}
+
+func (q *MemoryQueue) Pop() error {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    q.list.pop()
+    return nil
+}
+
+func (q *MemoryQueue) Len() int {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    return q.list.len()
+}
+
+func (q *MemoryQueue) Close() error {
+    return nil
+}
+
+//基于追加日志的文件队列，日志格式与FileStore相同：
+//入队记录的数据为入队时间（8字节，Unix纳秒）及WriteMessage编码的报文，出队记录没有数据
+type FileQueue struct {
+    lock sync.Mutex
+    log  *logFile
+    list entryList
+}
+
+//打开或创建队列文件并恢复未出队的消息
+func OpenFileQueue(path string, opts FileOptions) (*FileQueue, error) {
+    q := &FileQueue{}
+    l, err := openLog(path, opts, q.prepare, q.snapshot)
+    if err != nil {
+        return nil, err
+    }
+    q.log = l
+    return q, nil
+}
+
+//解码记录，返回将其应用到队列的函数，由持有q.lock的调用者或恢复过程调用
+func (q *FileQueue) prepare(rec record) (func(), error) {
+    switch rec.op {
+    case opPush:
+        if len(rec.data) < 8 {
+            return nil, ErrMessageFormat
+        }
+        m, _, err := message.Decode(rec.data[8:], recordVersion)
+        if err != nil {
+            return nil, err
+        }
+        msg, ok := m.(*message.PublishMessage)
+        if !ok {
+            return nil, ErrMessageFormat
+        }
+        enqueued := time.Unix(0, int64(binary.BigEndian.Uint64(rec.data)))
+        return func() { q.list.push(queueEntry{msg: msg, enqueued: enqueued}) }, nil
+    case opPop:
+        return func() { q.list.pop() }, nil
+    default:
+        return nil, ErrMessageType
+    }
+}
+
+func (q *FileQueue) snapshot(emit func(rec record) error) error {
+    for _, e := range q.list.entries[q.list.head:] {
+        data, err := encodeQueueEntry(e.msg, e.enqueued)
+        if err != nil {
+            return err
+        }
+        if err := emit(record{op: opPush, data: data}); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func encodeQueueEntry(msg *message.PublishMessage, enqueued time.Time) ([]byte, error) {
+    data := make([]byte, 8, 8+msg.GetFixedHeader().PacketSize())
+    binary.BigEndian.PutUint64(data, uint64(enqueued.UnixNano()))
+    return message.Encode(data, msg, recordVersion)
+}
+
+func (q *FileQueue) Push(msg *message.PublishMessage, enqueued time.Time) error {
+    data, err := encodeQueueEntry(msg, enqueued)
+    if err != nil {
+        return err
+    }
+
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    return q.log.append(record{op: opPush, data: data})
+}
+
+func (q *FileQueue) Peek() (*message.PublishMessage, time.Time, error) {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    if q.log.closed {
+        return nil, time.Time{}, ErrClosed
+    }
+    e, _ := q.list.peek()
+    return e.msg, e.enqueued, nil
+}
+
+func (q *FileQueue) Pop() error {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    if q.list.len() == 0 {
+        return nil
+    }
+    return q.log.append(record{op: opPop})
+}
+
+func (q *FileQueue) Len() int {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    return q.list.len()
+}
+
+//压缩日志，只保留未出队的消息
+func (q *FileQueue) Compact() error {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    return q.log.compact()
+}
+
+func (q *FileQueue) Close() error {
+    q.lock.Lock()
+    defer q.lock.Unlock()
+
+    return q.log.close()
+}
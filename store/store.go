@@ -0,0 +1,319 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package store
+
+import (
+    "container/list"
+    "errors"
+    "mqtt/errcode"
+    "mqtt/message"
+    "sort"
+    "sync"
+)
+
+//未完成报文的方向
+type Direction byte
+
+const (
+    //本端发送的PUBLISH（等待PUBACK或PUBREC）及PUBREL（等待PUBCOMP）
+    Outbound Direction = iota
+    //对端发送的QoS 2 PUBLISH（等待PUBREL）
+    Inbound
+)
+
+var (
+    ErrClosed      = errors.New("store: closed")
+    ErrMessageType = errors.New("store: only PUBLISH and PUBREL can be stored")
+)
+
+//会话状态存储，保存断开连接或进程重启后需要恢复的会话状态 [MQTT-4.1.0]：
+//未完成的QoS 1及QoS 2报文、等待PUBCOMP的PUBREL、订阅及下一个报文标识符。
+//同一方向的未完成报文按首次保存的顺序返回，以便会话恢复时按原顺序重发 [MQTT-4.6.0-1]。
+//实现需可并发使用
+type Store interface {
+    //保存未完成的PUBLISH或PUBREL报文，相同方向及报文标识符的报文被替换且保持原有顺序（如PUBLISH被PUBREL替换）。
+    //报文标识符为0时返回PacketIdentifierInvalid，其他类型的报文返回ErrMessageType
+    Put(clientID string, dir Direction, msg message.Message) error
+    //删除未完成的报文，不存在时不做处理
+    Delete(clientID string, dir Direction, id uint16) error
+    //按首次保存的顺序返回未完成的报文
+    Messages(clientID string, dir Direction) ([]message.Message, error)
+
+    //保存订阅，相同的主题过滤器被替换
+    Subscribe(clientID string, f message.SubscribeFilter) error
+    //删除订阅，不存在时不做处理
+    Unsubscribe(clientID string, filter string) error
+    //按主题过滤器排序返回订阅
+    Subscriptions(clientID string) ([]message.SubscribeFilter, error)
+
+    //保存下一个报文标识符
+    SetNextID(clientID string, id uint16) error
+    //下一个报文标识符，未保存时返回1
+    NextID(clientID string) (uint16, error)
+
+    //删除客户端的全部会话状态，用于会话结束（Clean Start为1或会话过期）
+    DeleteSession(clientID string) error
+    //按顺序返回保存了会话状态的客户端标识符
+    ClientIDs() ([]string, error)
+
+    Close() error
+}
+
+//检查报文是否可以保存，返回报文标识符
+func packetIdentifier(msg message.Message) (uint16, error) {
+    var id uint16
+    switch m := msg.(type) {
+    case *message.PublishMessage:
+        if m.GetQos() == 0 {
+            return 0, ErrMessageType
+        }
+        id = m.GetPacketIdentifier()
+    case *message.PubRelMessage:
+        id = m.GetPacketIdentifier()
+    default:
+        return 0, ErrMessageType
+    }
+    if id == 0 {
+        return 0, errcode.PacketIdentifierInvalid
+    }
+    return id, nil
+}
+
+//按首次保存顺序排列的未完成报文
+type messageList struct {
+    queue   *list.List
+    entries map[uint16]*list.Element
+}
+
+func newMessageList() *messageList {
+    return &messageList{
+        queue:   list.New(),
+        entries: map[uint16]*list.Element{},
+    }
+}
+
+type sessionState struct {
+    msgs   [2]*messageList
+    subs   map[string]message.SubscribeFilter
+    nextID uint16
+}
+
+func newSessionState() *sessionState {
+    return &sessionState{
+        msgs:   [2]*messageList{newMessageList(), newMessageList()},
+        subs:   map[string]message.SubscribeFilter{},
+        nextID: 1,
+    }
+}
+
+//没有任何需要保存的状态
+func (s *sessionState) isEmpty() bool {
+    return s.msgs[Outbound].queue.Len() == 0 && s.msgs[Inbound].queue.Len() == 0 &&
+        len(s.subs) == 0 && s.nextID == 1
+}
+
+//内存存储，进程退出后状态丢失，适用于测试或不需要持久化的场景。
+//保存及返回的报文不做拷贝，调用者不应在保存后修改
+type Memory struct {
+    lock     sync.Mutex
+    sessions map[string]*sessionState
+}
+
+func NewMemory() *Memory {
+    return &Memory{
+        sessions: map[string]*sessionState{},
+    }
+}
+
+//获得客户端的会话状态，create为true时不存在则创建
+func (m *Memory) session(clientID string, create bool) *sessionState {
+    s, ok := m.sessions[clientID]
+    if !ok && create {
+        s = newSessionState()
+        m.sessions[clientID] = s
+    }
+    return s
+}
+
+//会话为空时删除，避免保存大量已结束的会话
+func (m *Memory) release(clientID string, s *sessionState) {
+    if s.isEmpty() {
+        delete(m.sessions, clientID)
+    }
+}
+
+func (m *Memory) Put(clientID string, dir Direction, msg message.Message) error {
+    id, err := packetIdentifier(msg)
+    if err != nil {
+        return err
+    }
+
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    m.put(clientID, dir, id, msg)
+    return nil
+}
+
+func (m *Memory) put(clientID string, dir Direction, id uint16, msg message.Message) {
+    l := m.session(clientID, true).msgs[dir&1]
+    if el, ok := l.entries[id]; ok {
+        el.Value = msg
+        return
+    }
+    l.entries[id] = l.queue.PushBack(msg)
+}
+
+func (m *Memory) Delete(clientID string, dir Direction, id uint16) error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    m.delete(clientID, dir, id)
+    return nil
+}
+
+func (m *Memory) delete(clientID string, dir Direction, id uint16) bool {
+    s := m.session(clientID, false)
+    if s == nil {
+        return false
+    }
+    l := s.msgs[dir&1]
+    el, ok := l.entries[id]
+    if !ok {
+        return false
+    }
+    l.queue.Remove(el)
+    delete(l.entries, id)
+    m.release(clientID, s)
+    return true
+}
+
+func (m *Memory) Messages(clientID string, dir Direction) ([]message.Message, error) {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    s := m.session(clientID, false)
+    if s == nil {
+        return nil, nil
+    }
+    l := s.msgs[dir&1]
+    ret := make([]message.Message, 0, l.queue.Len())
+    for el := l.queue.Front(); el != nil; el = el.Next() {
+        ret = append(ret, el.Value.(message.Message))
+    }
+    return ret, nil
+}
+
+func (m *Memory) Subscribe(clientID string, f message.SubscribeFilter) error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    m.session(clientID, true).subs[f.Filter] = f
+    return nil
+}
+
+func (m *Memory) Unsubscribe(clientID string, filter string) error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    m.unsubscribe(clientID, filter)
+    return nil
+}
+
+func (m *Memory) unsubscribe(clientID string, filter string) bool {
+    s := m.session(clientID, false)
+    if s == nil {
+        return false
+    }
+    if _, ok := s.subs[filter]; !ok {
+        return false
+    }
+    delete(s.subs, filter)
+    m.release(clientID, s)
+    return true
+}
+
+func (m *Memory) Subscriptions(clientID string) ([]message.SubscribeFilter, error) {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    s := m.session(clientID, false)
+    if s == nil {
+        return nil, nil
+    }
+    ret := make([]message.SubscribeFilter, 0, len(s.subs))
+    for _, f := range s.subs {
+        ret = append(ret, f)
+    }
+    sort.Slice(ret, func(i, j int) bool {
+        return ret[i].Filter < ret[j].Filter
+    })
+    return ret, nil
+}
+
+func (m *Memory) SetNextID(clientID string, id uint16) error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    m.setNextID(clientID, id)
+    return nil
+}
+
+func (m *Memory) setNextID(clientID string, id uint16) {
+    if id == 0 {
+        id = 1
+    }
+    s := m.session(clientID, true)
+    s.nextID = id
+    m.release(clientID, s)
+}
+
+func (m *Memory) NextID(clientID string) (uint16, error) {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    if s := m.session(clientID, false); s != nil {
+        return s.nextID, nil
+    }
+    return 1, nil
+}
+
+func (m *Memory) DeleteSession(clientID string) error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    m.deleteSession(clientID)
+    return nil
+}
+
+func (m *Memory) deleteSession(clientID string) bool {
+    if _, ok := m.sessions[clientID]; !ok {
+        return false
+    }
+    delete(m.sessions, clientID)
+    return true
+}
+
+func (m *Memory) ClientIDs() ([]string, error) {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+
+    return m.clientIDs(), nil
+}
+
+func (m *Memory) clientIDs() []string {
+    ret := make([]string, 0, len(m.sessions))
+    for id := range m.sessions {
+        ret = append(ret, id)
+    }
+    sort.Strings(ret)
+    return ret
+}
+
+func (m *Memory) Close() error {
+    return nil
+}
@@ -0,0 +1,269 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "errors"
+    "mqtt/client"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "mqtt/session"
+    "testing"
+    "time"
+)
+
+func checkAlias(t *testing.T, msg *message.PublishMessage, name string, alias uint16) {
+    t.Helper()
+    v, ok := msg.GetTopicAlias()
+    if !ok || v != alias || msg.GetTopicName() != name {
+        t.Fatalf("expect topic %q alias %d, got %q %d %v", name, alias, msg.GetTopicName(), v, ok)
+    }
+}
+
+func apply(t *testing.T, a *session.OutboundAliases, msg *message.PublishMessage) *message.PublishMessage {
+    t.Helper()
+    ret, err := a.Apply(msg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return ret
+}
+
+func TestOutboundAliases(t *testing.T) {
+    a := session.NewOutboundAliases(2)
+
+    msg := publishMessage("a", 0, "1")
+    checkAlias(t, apply(t, a, msg), "a", 1)
+    //不修改原报文
+    if _, ok := msg.GetTopicAlias(); ok || msg.GetTopicName() != "a" {
+        t.Fatal("original message must not be modified")
+    }
+    checkAlias(t, apply(t, a, msg), "", 1)
+    checkAlias(t, apply(t, a, publishMessage("b", 0, "")), "b", 2)
+
+    //a最近使用，别名用完后复用b的别名
+    checkAlias(t, apply(t, a, publishMessage("a", 0, "")), "", 1)
+    checkAlias(t, apply(t, a, publishMessage("c", 0, "")), "c", 2)
+    checkAlias(t, apply(t, a, publishMessage("b", 0, "")), "b", 1)
+    checkAlias(t, apply(t, a, publishMessage("c", 0, "")), "", 2)
+    if a.Len() != 2 {
+        t.Fatal("expect 2 mappings, got", a.Len())
+    }
+
+    //删除的映射重新发送主题名，别名优先复用
+    a.Remove("c")
+    checkAlias(t, apply(t, a, publishMessage("d", 0, "")), "d", 2)
+    checkAlias(t, apply(t, a, publishMessage("d", 0, "")), "", 2)
+    checkAlias(t, apply(t, a, publishMessage("b", 0, "")), "", 1)
+
+    //已设置别名的报文原样发送，并替换该别名原有的映射：e占用d的别名2
+    msg = publishMessage("e", 0, "")
+    msg.SetTopicAlias(2)
+    if apply(t, a, msg) != msg {
+        t.Fatal("message with topic alias must be returned as is")
+    }
+    checkAlias(t, apply(t, a, publishMessage("e", 0, "")), "", 2)
+    checkAlias(t, apply(t, a, publishMessage("d", 0, "")), "d", 1)
+    checkAlias(t, apply(t, a, publishMessage("b", 0, "")), "b", 2)
+    //主题改用调用者设置的别名，原有的别名优先复用
+    msg = publishMessage("d", 0, "")
+    msg.SetTopicAlias(2)
+    apply(t, a, msg)
+    checkAlias(t, apply(t, a, publishMessage("d", 0, "")), "", 2)
+    checkAlias(t, apply(t, a, publishMessage("f", 0, "")), "f", 1)
+    if a.Len() != 2 {
+        t.Fatal("expect 2 mappings, got", a.Len())
+    }
+
+    //发送次数达到阈值后才分配别名
+    hot := session.NewOutboundAliases(2)
+    hot.SetThreshold(3)
+    msg = publishMessage("a", 0, "")
+    if apply(t, hot, msg) != msg || apply(t, hot, msg) != msg {
+        t.Fatal("topic alias must not be used before threshold")
+    }
+    checkAlias(t, apply(t, hot, msg), "a", 1)
+    checkAlias(t, apply(t, hot, msg), "", 1)
+
+    disabled := session.NewOutboundAliases(0)
+    msg = publishMessage("a", 0, "")
+    if apply(t, disabled, msg) != msg || apply(t, disabled, msg) != msg {
+        t.Fatal("topic alias must not be used when maximum is 0")
+    }
+
+    //调用者设置的别名为0或超出对端的最大值
+    for _, v := range []struct {
+        max   uint16
+        alias uint16
+    }{{2, 0}, {2, 3}, {0, 1}} {
+        msg = publishMessage("a", 0, "")
+        msg.SetTopicAlias(v.alias)
+        if _, err := session.NewOutboundAliases(v.max).Apply(msg); !errors.Is(err, errcode.TopicAliasInvalid) {
+            t.Fatal("expect TopicAliasInvalid for alias", v.alias, "maximum", v.max, "got", err)
+        }
+    }
+}
+
+func TestInboundAliases(t *testing.T) {
+    a := session.NewInboundAliases(2)
+
+    msg := publishMessage("a", 0, "")
+    if err := a.Resolve(msg); err != nil || msg.GetTopicName() != "a" {
+        t.Fatal("message without topic alias must be accepted", err)
+    }
+
+    msg.SetTopicAlias(1)
+    if err := a.Resolve(msg); err != nil {
+        t.Fatal(err)
+    }
+    msg = publishMessage("", 0, "")
+    msg.SetTopicAlias(1)
+    if err := a.Resolve(msg); err != nil || msg.GetTopicName() != "a" {
+        t.Fatal("expect topic a, got", msg.GetTopicName(), err)
+    }
+
+    //更新映射
+    msg = publishMessage("b", 0, "")
+    msg.SetTopicAlias(1)
+    a.Resolve(msg)
+    msg = publishMessage("", 0, "")
+    msg.SetTopicAlias(1)
+    if err := a.Resolve(msg); err != nil || msg.GetTopicName() != "b" {
+        t.Fatal("expect topic b, got", msg.GetTopicName(), err)
+    }
+
+    for _, alias := range []uint16{0, 3} {
+        msg = publishMessage("c", 0, "")
+        msg.SetTopicAlias(alias)
+        if err := a.Resolve(msg); !errors.Is(err, errcode.TopicAliasInvalid) {
+            t.Fatal("expect TopicAliasInvalid for alias", alias, "got", err)
+        }
+    }
+    msg = publishMessage("", 0, "")
+    msg.SetTopicAlias(2)
+    if err := a.Resolve(msg); !errors.Is(err, errcode.TopicAliasInvalid) {
+        t.Fatal("expect TopicAliasInvalid for unknown alias, got", err)
+    }
+
+    msg = publishMessage("a", 0, "")
+    msg.SetTopicAlias(1)
+    if err := session.NewInboundAliases(0).Resolve(msg); !errors.Is(err, errcode.TopicAliasInvalid) {
+        t.Fatal("expect TopicAliasInvalid when maximum is 0, got", err)
+    }
+}
+
+func TestClientTopicAlias(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{topicAliasMaximum: 2})
+    sub := connectClient(t, b, client.Options{ClientID: "sub", TopicAliasMaximum: 2})
+    pub := connectClient(t, b, client.Options{ClientID: "pub"})
+
+    ch := make(chan *message.PublishMessage, 10)
+    handler := func(c *client.Client, msg *message.PublishMessage) {
+        ch <- msg
+    }
+    if _, err := sub.Subscribe(testContext(t), handler, subscribeFilter("a/+", 1)); err != nil {
+        t.Fatal(err)
+    }
+
+    topics := []string{"a/1", "a/1", "a/2", "a/1", "a/3", "a/2", "a/1"}
+    for i, name := range topics {
+        msg := publishMessage(name, 1, string('0'+byte(i)))
+        if err := pub.Publish(testContext(t), msg); err != nil {
+            t.Fatal(err)
+        }
+        if msg.GetTopicName() != name {
+            t.Fatal("published message must not be modified")
+        }
+        got := receive(t, ch)
+        if got.GetTopicName() != name || string(got.GetPayload()) != string('0'+byte(i)) {
+            t.Fatal("message mismatch", got.GetTopicName(), string(got.GetPayload()))
+        }
+    }
+    //默认第二次发送时分配别名：a/1、a/1（分配别名1）、a/2、a/1（只有别名）、a/3、a/2（分配别名2）、a/1（只有别名）
+    if n := b.aliasOnlyCount(); n != 2 {
+        t.Fatal("expect 2 PUBLISH with topic alias only, got", n)
+    }
+    aliased := 0
+    for _, m := range b.receivedOf(packet.PktTypePUBLISH) {
+        v, ok := m.(*message.PublishMessage).GetTopicAlias()
+        if !ok {
+            continue
+        }
+        if v == 0 || v > 2 {
+            t.Fatal("expect topic alias within maximum, got", v)
+        }
+        aliased++
+    }
+    if aliased != 4 {
+        t.Fatal("expect 4 PUBLISH with topic alias, got", aliased)
+    }
+}
+
+//调用者设置的别名超出服务端的最大值时Publish返回TopicAliasInvalid，不发送报文也不断开连接
+func TestClientPublishTopicAliasInvalid(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{topicAliasMaximum: 2})
+    c := connectClient(t, b, client.Options{ClientID: "c1"})
+
+    for _, v := range []struct {
+        qos   byte
+        alias uint16
+    }{{0, 3}, {1, 0}, {2, 3}} {
+        msg := publishMessage("a", v.qos, "")
+        msg.SetTopicAlias(v.alias)
+        if err := c.Publish(testContext(t), msg); !errors.Is(err, errcode.TopicAliasInvalid) {
+            t.Fatal("expect TopicAliasInvalid for alias", v.alias, "got", err)
+        }
+    }
+    if n := len(b.receivedOf(packet.PktTypePUBLISH)); n != 0 || !c.IsConnected() {
+        t.Fatal("invalid topic alias must not be sent", n, c.IsConnected())
+    }
+
+    msg := publishMessage("a", 1, "")
+    msg.SetTopicAlias(2)
+    if err := c.Publish(testContext(t), msg); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestClientTopicAliasInvalid(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    lost := make(chan error, 1)
+    c := connectClient(t, b, client.Options{
+        ClientID:          "c1",
+        TopicAliasMaximum: 1,
+        OnConnectionLost: func(c *client.Client, err error) {
+            lost <- err
+        },
+    })
+
+    msg := publishMessage("", 0, "")
+    msg.SetTopicAlias(1)
+    if err := b.send("c1", msg); err != nil {
+        t.Fatal(err)
+    }
+    select {
+    case err := <-lost:
+        if !errors.Is(err, errcode.TopicAliasInvalid) {
+            t.Fatal("expect TopicAliasInvalid, got", err)
+        }
+    case <-time.After(3 * time.Second):
+        t.Fatal("connection must be closed on unknown topic alias")
+    }
+    if c.IsConnected() {
+        t.Fatal("expect disconnected")
+    }
+
+    deadline := time.Now().Add(3 * time.Second)
+    for len(b.receivedOf(packet.PktTypeDISCONNECT)) == 0 && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+    disconnects := b.receivedOf(packet.PktTypeDISCONNECT)
+    if len(disconnects) != 1 || disconnects[0].(*message.DisconnectMessage).GetReasonCode() != errcode.ReasonTopicAliasInvalid {
+        t.Fatal("expect DISCONNECT with TopicAliasInvalid", disconnects)
+    }
+}
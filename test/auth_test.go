@@ -8,13 +8,15 @@ package test
 
 import (
     "bytes"
+    "mqtt/errcode"
     "mqtt/message"
+    "mqtt/packet"
     "testing"
 )
 
 func TestAuth1(t *testing.T) {
     msg := message.NewAuthMessage()
-    msg.SetReasonCode(6)
+    msg.SetReasonCode(errcode.ReasonReauthenticate)
     msg.GetFixedHeader()
 
     t.Log("before")
@@ -52,7 +54,7 @@ func TestAuth1(t *testing.T) {
 func TestAuth2(t *testing.T) {
     msg := message.NewAuthMessage()
     msg.SetReasonString("test")
-    msg.SetReasonCode(1)
+    msg.SetReasonCode(errcode.ReasonContinueAuthentication)
     msg.SetAuthenticationMethod("dsd")
     msg.SetUserProperty(map[string]string{
         "test1": "1234567890qwertyuiopasdfghjklzxcvbnm",
@@ -66,12 +68,12 @@ func TestAuth2(t *testing.T) {
     t.Log(msg)
 
     buf := bytes.NewBuffer(nil)
-    n, err := message.WriteMessage(buf, msg)
+    n, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
     if err != nil {
         t.Fatal(err)
     }
 
-    msg2, n2, err2 := message.ReadMessage(buf)
+    msg2, n2, err2 := message.ReadMessage(buf, packet.MqttProtocolVersion5)
     if err2 != nil {
         t.Fatal(err2)
     }
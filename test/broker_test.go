@@ -0,0 +1,462 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "mqtt/session"
+    "mqtt/topic/trie"
+    "net"
+    "strings"
+    "sync"
+    "testing"
+)
+
+//测试服务端的行为选项，需在客户端连接前设置
+type brokerOptions struct {
+    //CONNACK的原因码（3.1.1为返回码）
+    connackCode errcode.ReasonCode
+    //CONNACK中返回的ServerKeepAlive，0表示不返回
+    serverKeepAlive uint16
+    //CONNACK中返回的TopicAliasMaximum，0表示不接受主题别名
+    topicAliasMaximum uint16
+    //CONNACK中返回的ReceiveMaximum，0表示不返回
+    receiveMaximum uint16
+}
+
+//进程内的测试服务端，只实现客户端测试需要的功能：
+//订阅及消息路由（QoS取发布与订阅的较小值）、QoS 1及QoS 2应答、心跳、会话保持，
+//以"deny/"开头的主题过滤器以NotAuthorized拒绝订阅
+type testBroker struct {
+    t    *testing.T
+    opts brokerOptions
+    ln   net.Listener
+    subs *trie.Trie
+
+    lock     sync.Mutex
+    conns    map[string]*brokerConn
+    sessions map[string]*brokerSession
+    //不处理的报文类型
+    ignored  map[byte]bool
+    connects []*message.ConnectMessage
+    received []message.Message
+    //received中报文的类型，GetFixedHeader会修改报文，不能与处理报文的协程并发调用
+    receivedTypes []byte
+    //收到的只有主题别名、主题名为空的PUBLISH数量
+    aliasOnly int
+    assigned  int
+    wg            sync.WaitGroup
+}
+
+//客户端会话，Clean Start为0时在连接之间保持（订阅保存在testBroker.subs中）
+type brokerSession struct {
+    out *session.Outbound
+    in  *session.Inbound
+}
+
+type brokerConn struct {
+    b        *testBroker
+    conn     net.Conn
+    clientID string
+    version  byte
+    //连接断开后是否保留会话
+    keep bool
+    s    *brokerSession
+    //按客户端CONNECT中的TopicAliasMaximum为转发的消息分配主题别名
+    sendAliases *session.OutboundAliases
+    recvAliases *session.InboundAliases
+
+    writeLock sync.Mutex
+}
+
+func newTestBroker(t *testing.T, opts brokerOptions) *testBroker {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    b := &testBroker{
+        t:     t,
+        opts:  opts,
+        ln:    ln,
+        subs:     trie.New(),
+        conns:    map[string]*brokerConn{},
+        sessions: map[string]*brokerSession{},
+        ignored:  map[byte]bool{},
+    }
+    b.wg.Add(1)
+    go b.accept()
+    t.Cleanup(b.close)
+    return b
+}
+
+func (b *testBroker) addr() string {
+    return b.ln.Addr().String()
+}
+
+func (b *testBroker) close() {
+    b.ln.Close()
+    b.lock.Lock()
+    for _, bc := range b.conns {
+        bc.conn.Close()
+    }
+    b.lock.Unlock()
+    b.wg.Wait()
+}
+
+func (b *testBroker) accept() {
+    defer b.wg.Done()
+    for {
+        conn, err := b.ln.Accept()
+        if err != nil {
+            return
+        }
+        b.wg.Add(1)
+        go func() {
+            defer b.wg.Done()
+            b.serve(conn)
+        }()
+    }
+}
+
+//设置是否忽略（不处理也不应答）该类型的报文
+func (b *testBroker) ignore(pktType byte, v bool) {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+
+    b.ignored[pktType] = v
+}
+
+//断开客户端的网络连接（不发送DISCONNECT），会话按连接时的设置保留
+func (b *testBroker) kick(clientID string) {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+
+    if bc, ok := b.conns[clientID]; ok {
+        bc.conn.Close()
+    }
+}
+
+//不经过会话及主题别名映射直接向客户端发送报文
+func (b *testBroker) send(clientID string, m message.Message) error {
+    b.lock.Lock()
+    bc := b.conns[clientID]
+    b.lock.Unlock()
+    if bc == nil {
+        return errors.New("client not connected")
+    }
+    return bc.writeRaw(m)
+}
+
+//收到的只有主题别名的PUBLISH数量
+func (b *testBroker) aliasOnlyCount() int {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+
+    return b.aliasOnly
+}
+
+//客户端是否已连接
+func (b *testBroker) connected(clientID string) bool {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+
+    _, ok := b.conns[clientID]
+    return ok
+}
+
+//丢弃所有未连接客户端的会话
+func (b *testBroker) dropSessions() {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+
+    for id := range b.sessions {
+        if _, ok := b.conns[id]; !ok {
+            delete(b.sessions, id)
+            b.subs.UnsubscribeAll(id)
+        }
+    }
+}
+
+//收到的CONNECT报文
+func (b *testBroker) connectMessages() []*message.ConnectMessage {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+
+    return append([]*message.ConnectMessage(nil), b.connects...)
+}
+
+//收到的PINGREQ数量
+func (b *testBroker) pingCount() int {
+    return len(b.receivedOf(packet.PktTypePINGREQ))
+}
+
+//收到的指定类型的报文
+func (b *testBroker) receivedOf(pktType byte) []message.Message {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+
+    var ret []message.Message
+    for i, m := range b.received {
+        if b.receivedTypes[i] == pktType {
+            ret = append(ret, m)
+        }
+    }
+    return ret
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+    defer conn.Close()
+
+    r := bufio.NewReader(conn)
+    m, _, err := message.ReadMessage(r, packet.MqttProtocolVersion5)
+    if err != nil {
+        return
+    }
+    connect, ok := m.(*message.ConnectMessage)
+    if !ok {
+        return
+    }
+
+    bc := &brokerConn{
+        b:        b,
+        conn:     conn,
+        clientID: connect.GetClientId(),
+        version:  connect.GetVersion(),
+        keep:     !connect.IsCleanStart(),
+    }
+    if packet.IsV5(bc.version) {
+        expiry, _ := connect.GetSessionExpiryInterval()
+        bc.keep = expiry > 0
+    }
+    aliasMax, _ := connect.GetTopicAliasMaximum()
+    bc.sendAliases = session.NewOutboundAliases(aliasMax)
+    bc.recvAliases = session.NewInboundAliases(b.opts.topicAliasMaximum)
+
+    ack := message.NewConnackMessage()
+    ack.SetVersion(bc.version)
+    ack.SetReasonCode(b.opts.connackCode)
+
+    b.lock.Lock()
+    b.connects = append(b.connects, connect)
+    if bc.clientID == "" {
+        b.assigned++
+        bc.clientID = fmt.Sprintf("auto-%d", b.assigned)
+        if packet.IsV5(bc.version) {
+            ack.SetAssignedClientIdentifier(bc.clientID)
+        }
+    }
+    if b.opts.connackCode == 0 {
+        s, present := b.sessions[bc.clientID]
+        if !present || connect.IsCleanStart() {
+            s = &brokerSession{
+                out: session.NewOutbound(session.NewIDAllocator(0), bc.version),
+                in:  session.NewInbound(session.NewInboundIDs(0), bc.version),
+            }
+            b.sessions[bc.clientID] = s
+            b.subs.UnsubscribeAll(bc.clientID)
+        } else {
+            ack.SetAckFlag(1)
+        }
+        bc.s = s
+        b.conns[bc.clientID] = bc
+    }
+    b.lock.Unlock()
+
+    if packet.IsV5(bc.version) && b.opts.serverKeepAlive > 0 {
+        ack.SetServerKeepAlive(b.opts.serverKeepAlive)
+    }
+    if packet.IsV5(bc.version) && b.opts.topicAliasMaximum > 0 {
+        ack.SetTopicAliasMaximum(b.opts.topicAliasMaximum)
+    }
+    if packet.IsV5(bc.version) && b.opts.receiveMaximum > 0 {
+        ack.SetReceiveMaximum(b.opts.receiveMaximum)
+    }
+    if bc.write(ack) != nil || b.opts.connackCode != 0 {
+        return
+    }
+    defer b.remove(bc)
+    for _, m := range bc.s.out.Resend() {
+        bc.write(m)
+    }
+
+    for {
+        m, _, err := message.ReadMessage(r, bc.version)
+        if err != nil {
+            return
+        }
+        b.lock.Lock()
+        pktType := m.GetFixedHeader().Type()
+        //在记录前恢复主题名，避免与读取received的测试并发修改报文
+        if msg, ok := m.(*message.PublishMessage); ok {
+            if msg.GetTopicName() == "" {
+                b.aliasOnly++
+            }
+            if err := bc.recvAliases.Resolve(msg); err != nil {
+                b.lock.Unlock()
+                bc.writeRaw(message.NewDisconnectMessageFromError(err, bc.version))
+                return
+            }
+        }
+        b.received = append(b.received, m)
+        b.receivedTypes = append(b.receivedTypes, pktType)
+        ignored := b.ignored[pktType]
+        b.lock.Unlock()
+        if ignored {
+            continue
+        }
+        if _, ok := m.(*message.DisconnectMessage); ok {
+            return
+        }
+        bc.handle(m)
+    }
+}
+
+func (b *testBroker) remove(bc *brokerConn) {
+    b.lock.Lock()
+    defer b.lock.Unlock()
+
+    if b.conns[bc.clientID] == bc {
+        delete(b.conns, bc.clientID)
+        if !bc.keep {
+            delete(b.sessions, bc.clientID)
+            b.subs.UnsubscribeAll(bc.clientID)
+        }
+    }
+}
+
+//写报文，PUBLISH按写入顺序分配主题别名
+func (bc *brokerConn) write(m message.Message) error {
+    bc.writeLock.Lock()
+    defer bc.writeLock.Unlock()
+
+    if msg, ok := m.(*message.PublishMessage); ok {
+        var err error
+        if m, err = bc.sendAliases.Apply(msg); err != nil {
+            return err
+        }
+    }
+    _, err := message.WriteMessage(bc.conn, m, bc.version)
+    return err
+}
+
+//写报文，不分配主题别名
+func (bc *brokerConn) writeRaw(m message.Message) error {
+    bc.writeLock.Lock()
+    defer bc.writeLock.Unlock()
+
+    _, err := message.WriteMessage(bc.conn, m, bc.version)
+    return err
+}
+
+func (bc *brokerConn) handle(m message.Message) {
+    b := bc.b
+    switch msg := m.(type) {
+    case *message.PublishMessage:
+        deliver, resp, err := bc.s.in.HandlePublish(msg)
+        if err != nil {
+            bc.conn.Close()
+            return
+        }
+        if resp != nil {
+            bc.write(resp)
+        }
+        if deliver {
+            b.route(msg)
+        }
+    case *message.PubAckMessage:
+        bc.s.out.HandlePubAck(msg)
+    case *message.PubRecMessage:
+        if rel, _ := bc.s.out.HandlePubRec(msg); rel != nil {
+            bc.write(rel)
+        }
+    case *message.PubCompMessage:
+        bc.s.out.HandlePubComp(msg)
+    case *message.PubRelMessage:
+        bc.write(bc.s.in.HandlePubRel(msg))
+    case *message.SubscribeMessage:
+        codes := make([]byte, 0, len(msg.GetPayload()))
+        for _, f := range msg.GetPayload() {
+            if strings.HasPrefix(f.Filter, "deny/") {
+                codes = append(codes, byte(errcode.ReasonNotAuthorized))
+                continue
+            }
+            if _, err := b.subs.Subscribe(bc.clientID, f, 0); err != nil {
+                codes = append(codes, byte(errcode.ReasonTopicFilterInvalid))
+                continue
+            }
+            codes = append(codes, f.Options.QoS)
+        }
+        if !packet.IsV5(bc.version) {
+            for i, code := range codes {
+                if code >= 0x80 {
+                    codes[i] = 0x80
+                }
+            }
+        }
+        ack := message.NewSubAckMessage()
+        ack.SetVersion(bc.version)
+        ack.SetPacketIdentifier(msg.GetPacketIdentifier())
+        ack.SetPayload(codes)
+        bc.write(ack)
+    case *message.UnsubscribeMessage:
+        var codes []byte
+        for _, f := range msg.GetPayload() {
+            if b.subs.Unsubscribe(bc.clientID, f) {
+                codes = append(codes, byte(errcode.ReasonSuccess))
+            } else {
+                codes = append(codes, byte(errcode.ReasonNoSubscriptionExisted))
+            }
+        }
+        ack := message.NewUnsubAckMessage()
+        ack.SetVersion(bc.version)
+        ack.SetPacketIdentifier(msg.GetPacketIdentifier())
+        if packet.IsV5(bc.version) {
+            ack.SetPayload(codes)
+        }
+        bc.write(ack)
+    case *message.PingReqMessage:
+        bc.write(message.NewPingRespMessage())
+    }
+}
+
+//按订阅转发消息，共享订阅只发给组内的第一个订阅者
+func (b *testBroker) route(msg *message.PublishMessage) {
+    result, err := b.subs.Match(msg.GetTopicName())
+    if err != nil {
+        return
+    }
+    subs := result.Subscriptions
+    for _, group := range result.Shared {
+        subs = append(subs, group[0])
+    }
+
+    for _, s := range subs {
+        b.lock.Lock()
+        target := b.conns[s.ClientID]
+        b.lock.Unlock()
+        if target == nil {
+            continue
+        }
+
+        qos := msg.GetQos()
+        if s.Options.QoS < qos {
+            qos = s.Options.QoS
+        }
+        out := message.NewPublishMessage()
+        out.SetTopicName(msg.GetTopicName())
+        out.SetQos(qos)
+        out.SetPayload(append([]byte(nil), msg.GetPayload()...))
+        if target.s.out.Publish(out) == nil {
+            target.write(out)
+        }
+    }
+}
@@ -0,0 +1,303 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "context"
+    "errors"
+    "mqtt/client"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "testing"
+    "time"
+)
+
+func connectClient(t *testing.T, b *testBroker, opts client.Options) *client.Client {
+    opts.Address = b.addr()
+    c := client.New(opts)
+    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    defer cancel()
+    if _, err := c.Connect(ctx); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { c.Disconnect(context.Background()) })
+    return c
+}
+
+func subscribeFilter(filter string, qos byte) message.SubscribeFilter {
+    return message.SubscribeFilter{Filter: filter, Options: message.SubscriptionOptions{QoS: qos}}
+}
+
+func publishMessage(name string, qos byte, payload string) *message.PublishMessage {
+    msg := message.NewPublishMessage()
+    msg.SetTopicName(name)
+    msg.SetQos(qos)
+    msg.SetPayload([]byte(payload))
+    return msg
+}
+
+func receive(t *testing.T, ch chan *message.PublishMessage) *message.PublishMessage {
+    select {
+    case msg := <-ch:
+        return msg
+    case <-time.After(3 * time.Second):
+        t.Fatal("message not received")
+        return nil
+    }
+}
+
+func testContext(t *testing.T) context.Context {
+    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    t.Cleanup(cancel)
+    return ctx
+}
+
+func TestClientConnect(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    c := connectClient(t, b, client.Options{KeepAlive: 30, SessionExpiryInterval: 60, Username: "user", Password: []byte("pwd")})
+
+    if !c.IsConnected() || c.ClientID() != "auto-1" {
+        t.Fatal("expect connected with assigned client identifier, got", c.ClientID())
+    }
+    connects := b.connectMessages()
+    if len(connects) != 1 {
+        t.Fatal("expect 1 CONNECT, got", len(connects))
+    }
+    connect := connects[0]
+    if connect.GetVersion() != packet.MqttProtocolVersion5 || connect.GetKeepAlive() != 30 || connect.GetUsername() != "user" {
+        t.Fatal("CONNECT fields mismatch", connect)
+    }
+    if v, ok := connect.GetSessionExpiryInterval(); !ok || v != 60 {
+        t.Fatal("expect SessionExpiryInterval 60, got", v, ok)
+    }
+
+    if _, err := c.Connect(testContext(t)); err != client.ErrAlreadyConnected {
+        t.Fatal("expect ErrAlreadyConnected, got", err)
+    }
+}
+
+func TestClientConnectRefused(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{connackCode: errcode.ReasonNotAuthorized})
+    c := client.New(client.Options{Address: b.addr(), ClientID: "c1"})
+    ack, err := c.Connect(testContext(t))
+    if !errors.Is(err, errcode.NotAuthorized) || ack == nil || c.IsConnected() {
+        t.Fatal("expect NotAuthorized, got", err)
+    }
+
+    b3 := newTestBroker(t, brokerOptions{connackCode: errcode.ConnackBadUserNameOrPassword})
+    c3 := client.New(client.Options{Address: b3.addr(), ClientID: "c1", Version: packet.MqttProtocolVersion311})
+    if _, err := c3.Connect(testContext(t)); !errors.Is(err, errcode.BadUserNameOrPassword) {
+        t.Fatal("expect BadUserNameOrPassword, got", err)
+    }
+}
+
+func TestClientPublishSubscribe(t *testing.T) {
+    for _, version := range []byte{packet.MqttProtocolVersion311, packet.MqttProtocolVersion5} {
+        b := newTestBroker(t, brokerOptions{})
+        sub := connectClient(t, b, client.Options{ClientID: "sub", Version: version})
+        pub := connectClient(t, b, client.Options{ClientID: "pub", Version: version})
+
+        ch := make(chan *message.PublishMessage, 10)
+        handler := func(c *client.Client, msg *message.PublishMessage) {
+            ch <- msg
+        }
+        if _, err := sub.Subscribe(testContext(t), handler, subscribeFilter("a/+", 2)); err != nil {
+            t.Fatal(err)
+        }
+
+        for qos := byte(0); qos <= 2; qos++ {
+            payload := string('0' + qos)
+            if err := pub.Publish(testContext(t), publishMessage("a/b", qos, payload)); err != nil {
+                t.Fatal(err)
+            }
+            msg := receive(t, ch)
+            if msg.GetTopicName() != "a/b" || msg.GetQos() != qos || string(msg.GetPayload()) != payload {
+                t.Fatal("message mismatch", msg)
+            }
+        }
+        if n := len(b.receivedOf(packet.PktTypePUBREL)); n != 1 {
+            t.Fatal("expect 1 PUBREL from publisher, got", n)
+        }
+    }
+}
+
+func TestClientHandlers(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    c := connectClient(t, b, client.Options{ClientID: "c1"})
+
+    chA := make(chan *message.PublishMessage, 10)
+    chB := make(chan *message.PublishMessage, 10)
+    if _, err := c.Subscribe(testContext(t), func(c *client.Client, msg *message.PublishMessage) { chA <- msg },
+        subscribeFilter("a/#", 1)); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := c.Subscribe(testContext(t), func(c *client.Client, msg *message.PublishMessage) { chB <- msg },
+        subscribeFilter("b/+", 1)); err != nil {
+        t.Fatal(err)
+    }
+
+    c.Publish(testContext(t), publishMessage("b/1", 1, "b"))
+    c.Publish(testContext(t), publishMessage("a/1/2", 1, "a"))
+    if msg := receive(t, chB); msg.GetTopicName() != "b/1" {
+        t.Fatal("b/+ handler got", msg.GetTopicName())
+    }
+    if msg := receive(t, chA); msg.GetTopicName() != "a/1/2" {
+        t.Fatal("a/# handler got", msg.GetTopicName())
+    }
+
+    if _, err := c.Unsubscribe(testContext(t), "b/+"); err != nil {
+        t.Fatal(err)
+    }
+    c.Publish(testContext(t), publishMessage("b/1", 1, "b"))
+    c.Publish(testContext(t), publishMessage("a/1", 1, "a"))
+    receive(t, chA)
+    if len(chB) != 0 {
+        t.Fatal("unsubscribed handler must not be called")
+    }
+
+    ack, err := c.Unsubscribe(testContext(t), "x/y")
+    if err != nil || ack.GetPayload()[0] != byte(errcode.ReasonNoSubscriptionExisted) {
+        t.Fatal("expect NoSubscriptionExisted, got", ack, err)
+    }
+}
+
+func TestClientSubscribeRejected(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    c := connectClient(t, b, client.Options{ClientID: "c1"})
+
+    ch := make(chan *message.PublishMessage, 10)
+    handler := func(c *client.Client, msg *message.PublishMessage) { ch <- msg }
+    ack, err := c.Subscribe(testContext(t), handler, subscribeFilter("ok", 1), subscribeFilter("deny/x", 1))
+    if !errors.Is(err, errcode.NotAuthorized) || ack == nil || len(ack.GetPayload()) != 2 {
+        t.Fatal("expect NotAuthorized, got", err)
+    }
+    if ack.GetPayload()[0] != 1 {
+        t.Fatal("expect granted QoS 1, got", ack.GetPayload()[0])
+    }
+
+    if _, err := c.Subscribe(testContext(t), handler, subscribeFilter("a/#/b", 0)); !errors.Is(err, errcode.TopicFilterInvalid) {
+        t.Fatal("expect TopicFilterInvalid, got", err)
+    }
+}
+
+func TestClientPublishInHandler(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    c := connectClient(t, b, client.Options{ClientID: "c1"})
+
+    //处理函数中发布QoS 1消息并等待PUBACK，不能阻塞读取协程
+    errCh := make(chan error, 1)
+    ch := make(chan *message.PublishMessage, 1)
+    c.Subscribe(testContext(t), func(c *client.Client, msg *message.PublishMessage) {
+        errCh <- c.Publish(testContext(t), publishMessage("response", 1, "pong"))
+    }, subscribeFilter("request", 1))
+    c.Subscribe(testContext(t), func(c *client.Client, msg *message.PublishMessage) {
+        ch <- msg
+    }, subscribeFilter("response", 1))
+
+    if err := c.Publish(testContext(t), publishMessage("request", 1, "ping")); err != nil {
+        t.Fatal(err)
+    }
+    if msg := receive(t, ch); string(msg.GetPayload()) != "pong" {
+        t.Fatal("expect pong, got", string(msg.GetPayload()))
+    }
+    if err := <-errCh; err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestClientPublishContext(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    b.ignore(packet.PktTypePUBLISH, true)
+    c := connectClient(t, b, client.Options{ClientID: "c1"})
+
+    ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancel()
+    if err := c.Publish(ctx, publishMessage("a", 1, "1")); err != context.DeadlineExceeded {
+        t.Fatal("expect DeadlineExceeded, got", err)
+    }
+    if err := c.Publish(testContext(t), publishMessage("a/+", 0, "1")); !errors.Is(err, errcode.TopicNameInvalid) {
+        t.Fatal("expect TopicNameInvalid, got", err)
+    }
+}
+
+func TestClientKeepAlive(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    connectClient(t, b, client.Options{ClientID: "c1", KeepAlive: 1})
+
+    time.Sleep(1500 * time.Millisecond)
+    if n := b.pingCount(); n < 1 {
+        t.Fatal("expect PINGREQ, got", n)
+    }
+
+    //以CONNACK的ServerKeepAlive为准
+    bs := newTestBroker(t, brokerOptions{serverKeepAlive: 1})
+    connectClient(t, bs, client.Options{ClientID: "c2", KeepAlive: 60})
+    time.Sleep(1500 * time.Millisecond)
+    if n := bs.pingCount(); n < 1 {
+        t.Fatal("expect PINGREQ with ServerKeepAlive, got", n)
+    }
+}
+
+func TestClientPingTimeout(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    b.ignore(packet.PktTypePINGREQ, true)
+    lost := make(chan error, 1)
+    c := connectClient(t, b, client.Options{
+        ClientID:  "c1",
+        KeepAlive: 1,
+        OnConnectionLost: func(c *client.Client, err error) {
+            lost <- err
+        },
+    })
+
+    select {
+    case err := <-lost:
+        if err != client.ErrPingTimeout {
+            t.Fatal("expect ErrPingTimeout, got", err)
+        }
+    case <-time.After(4 * time.Second):
+        t.Fatal("connection must be closed without PINGRESP")
+    }
+    if c.IsConnected() {
+        t.Fatal("expect disconnected")
+    }
+}
+
+func TestClientDisconnect(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    lost := make(chan error, 1)
+    c := connectClient(t, b, client.Options{
+        ClientID: "c1",
+        OnConnectionLost: func(c *client.Client, err error) {
+            lost <- err
+        },
+    })
+
+    if err := c.Disconnect(testContext(t)); err != nil {
+        t.Fatal(err)
+    }
+    if c.IsConnected() {
+        t.Fatal("expect disconnected")
+    }
+    if err := c.Publish(testContext(t), publishMessage("a", 0, "")); err != client.ErrNotConnected {
+        t.Fatal("expect ErrNotConnected, got", err)
+    }
+    if err := c.Disconnect(testContext(t)); err != client.ErrNotConnected {
+        t.Fatal("expect ErrNotConnected, got", err)
+    }
+
+    time.Sleep(100 * time.Millisecond)
+    if n := len(b.receivedOf(packet.PktTypeDISCONNECT)); n != 1 {
+        t.Fatal("expect DISCONNECT, got", n)
+    }
+    select {
+    case err := <-lost:
+        t.Fatal("OnConnectionLost must not be called on Disconnect", err)
+    default:
+    }
+}
@@ -9,6 +9,7 @@ package test
 import (
     "bytes"
     "mqtt/message"
+    "mqtt/packet"
     "testing"
 )
 
@@ -64,12 +65,12 @@ func TestConnack2(t *testing.T) {
     t.Log(msg)
 
     buf := bytes.NewBuffer(nil)
-    n, err := message.WriteMessage(buf, msg)
+    n, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
     if err != nil {
         t.Fatal(err)
     }
 
-    msg2, n2, err2 := message.ReadMessage(buf)
+    msg2, n2, err2 := message.ReadMessage(buf, packet.MqttProtocolVersion5)
     if err2 != nil {
         t.Fatal(err2)
     }
@@ -0,0 +1,134 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "testing"
+)
+
+func encodePublish(t testing.TB) []byte {
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("sensor/1/temperature")
+    msg.SetQos(1)
+    msg.SetPacketIdentifier(100)
+    msg.SetContentType("application/json")
+    msg.SetCorrelationData([]byte("123456"))
+    msg.SetUserProperty(map[string]string{"k": "v"})
+    msg.SetPayload([]byte(`{"value": 25.5}`))
+
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+    data := encodePublish(t)
+    msg, n, err := message.Decode(data, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != len(data) {
+        t.Fatal("size not match")
+    }
+    p := msg.(*message.PublishMessage)
+    if p.GetTopicName() != "sensor/1/temperature" || p.GetPacketIdentifier() != 100 {
+        t.Fatal("not match")
+    }
+    if v, ok := p.GetContentType(); !ok || v != "application/json" {
+        t.Fatal("property not match")
+    }
+    if string(p.GetPayload()) != `{"value": 25.5}` {
+        t.Fatal("payload not match")
+    }
+
+    //payload引用原数据
+    data[len(data)-2] = '6'
+    if string(p.GetPayload()) != `{"value": 25.6}` {
+        t.Fatal("payload must reference input")
+    }
+    //字符串属性同样引用原数据
+    i := bytes.Index(data, []byte("application/json"))
+    data[i] = 'A'
+    if v, _ := p.GetContentType(); v != "Application/json" {
+        t.Fatal("property must reference input", v)
+    }
+}
+
+func TestDecodeMulti(t *testing.T) {
+    data := encodePublish(t)
+    ack := message.NewPubAckMessage()
+    ack.SetPacketIdentifier(100)
+    buf := bytes.NewBuffer(data)
+    _, err := message.WriteMessage(buf, ack, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data = buf.Bytes()
+
+    var types []byte
+    for len(data) > 0 {
+        msg, n, err := message.Decode(data, packet.MqttProtocolVersion5)
+        if err != nil {
+            t.Fatal(err)
+        }
+        types = append(types, msg.GetFixedHeader().Type())
+        data = data[n:]
+    }
+    if !bytes.Equal(types, []byte{packet.PktTypePUBLISH, packet.PktTypePUBACK}) {
+        t.Fatal("not match", types)
+    }
+}
+
+func TestDecodeIncomplete(t *testing.T) {
+    data := encodePublish(t)
+    for i := 0; i < len(data); i++ {
+        _, n, err := message.Decode(data[:i], packet.MqttProtocolVersion5)
+        if !errors.Is(err, io.ErrUnexpectedEOF) || n != 0 {
+            t.Fatal("expect ErrUnexpectedEOF", i, err)
+        }
+    }
+
+    _, _, err := message.Decode([]byte{0x30, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}, packet.MqttProtocolVersion5)
+    if !errors.Is(err, errcode.RemainLengthInvalid) {
+        t.Fatal("expect RemainLengthInvalid")
+    }
+}
+
+func BenchmarkReadMessage(b *testing.B) {
+    data := encodePublish(b)
+    r := bytes.NewReader(data)
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        r.Reset(data)
+        _, _, err := message.ReadMessage(r, packet.MqttProtocolVersion5)
+        if err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+func BenchmarkDecode(b *testing.B) {
+    data := encodePublish(b)
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        _, _, err := message.Decode(data, packet.MqttProtocolVersion5)
+        if err != nil {
+            b.Fatal(err)
+        }
+    }
+}
@@ -8,13 +8,15 @@ package test
 
 import (
     "bytes"
+    "mqtt/errcode"
     "mqtt/message"
+    "mqtt/packet"
     "testing"
 )
 
 func TestDisconnect1(t *testing.T) {
     msg := message.NewDisconnectMessage()
-    msg.SetReasonCode(6)
+    msg.SetReasonCode(errcode.ReasonDisconnectWithWillMessage)
     msg.GetFixedHeader()
 
     t.Log("before")
@@ -52,7 +54,7 @@ func TestDisconnect1(t *testing.T) {
 func TestDisconnect2(t *testing.T) {
     msg := message.NewDisconnectMessage()
     msg.SetSessionExpiryInterval(100)
-    msg.SetReasonCode(1)
+    msg.SetReasonCode(errcode.ReasonServerShuttingDown)
     msg.SetUserProperty(map[string]string{
         "test1": "1234567890qwertyuiopasdfghjklzxcvbnm",
         "test2": "1234567890qwertyuiopasdfghjklzxcvbnm",
@@ -65,12 +67,12 @@ func TestDisconnect2(t *testing.T) {
     t.Log(msg)
 
     buf := bytes.NewBuffer(nil)
-    n, err := message.WriteMessage(buf, msg)
+    n, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
     if err != nil {
         t.Fatal(err)
     }
 
-    msg2, n2, err2 := message.ReadMessage(buf)
+    msg2, n2, err2 := message.ReadMessage(buf, packet.MqttProtocolVersion5)
     if err2 != nil {
         t.Fatal(err2)
     }
@@ -0,0 +1,159 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "errors"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "testing"
+)
+
+//统计Write调用次数
+type callWriter struct {
+    bytes.Buffer
+    calls int
+}
+
+func (w *callWriter) Write(p []byte) (int, error) {
+    w.calls++
+    return w.Buffer.Write(p)
+}
+
+func encodeMessages() []message.Message {
+    connect := message.NewConnectMessage()
+    connect.SetClientId("device-001")
+    connect.SetWillEnable(true)
+    connect.SetWillTopic("device/offline")
+    connect.SetWillPayload([]byte("bye"))
+    connect.SetUsername("test")
+    connect.SetPassword([]byte("123"))
+    connect.SetSessionExpiryInterval(60)
+
+    publish := message.NewPublishMessage()
+    publish.SetTopicName("a/b")
+    publish.SetQos(1)
+    publish.SetPacketIdentifier(1)
+    publish.SetContentType("text/plain")
+    publish.SetPayload([]byte("hello"))
+
+    puback := message.NewPubAckMessage()
+    puback.SetPacketIdentifier(1)
+    puback.SetReasonString("ok")
+
+    subscribe := message.NewSubscribeMessage()
+    subscribe.SetPacketIdentifier(2)
+    subscribe.SetPayload([]message.SubscribeFilter{{Filter: "a/+", Options: message.SubscriptionOptions{QoS: 1}}})
+
+    unsubscribe := message.NewUnsubscribeMessage()
+    unsubscribe.SetPacketIdentifier(3)
+    unsubscribe.SetPayload([]string{"a/+"})
+
+    suback := message.NewSubAckMessage()
+    suback.SetPacketIdentifier(2)
+    suback.SetPayload([]byte{1})
+
+    disconnect := message.NewDisconnectMessage()
+    disconnect.SetReasonCode(0x8B)
+
+    return []message.Message{
+        connect, message.NewConnackMessage(), publish, puback, message.NewPubRecMessage(),
+        subscribe, suback, unsubscribe, message.NewUnsubAckMessage(),
+        message.NewPingReqMessage(), disconnect, message.NewAuthMessage(),
+    }
+}
+
+func TestEncodeLength(t *testing.T) {
+    for _, version := range []byte{packet.MqttProtocolVersion311, packet.MqttProtocolVersion5} {
+        for _, msg := range encodeMessages() {
+            if _, ok := msg.(*message.AuthMessage); ok && !packet.IsV5(version) {
+                continue
+            }
+            msg.SetVersion(version)
+            w := &callWriter{}
+            n, err := message.WriteMessage(w, msg, version)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if w.calls != 1 {
+                t.Fatal("expect single write", w.calls)
+            }
+            if int64(n) != msg.GetFixedHeader().PacketSize() || n != w.Len() {
+                t.Fatal("size not match", n, msg.GetFixedHeader().PacketSize())
+            }
+
+            _, n2, err := message.ReadMessage(w, version)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if n != n2 {
+                t.Fatal("read size not match")
+            }
+        }
+    }
+}
+
+func TestEncode(t *testing.T) {
+    msg := message.NewPingReqMessage()
+    dst, err := message.Encode([]byte{0xFF}, msg, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(dst, []byte{0xFF, 0xC0, 0}) {
+        t.Fatal("not match", dst)
+    }
+}
+
+//写报文不修改调用者的报文，CONNECT的协议版本不一致时返回错误
+func TestEncodeKeepsMessage(t *testing.T) {
+    publish := message.NewPublishMessage()
+    publish.SetTopicName("a/b")
+    publish.SetVersion(packet.MqttProtocolVersion311)
+    if _, err := message.Encode(nil, publish, packet.MqttProtocolVersion5); err != nil {
+        t.Fatal(err)
+    }
+    if publish.GetVersion() != packet.MqttProtocolVersion311 {
+        t.Fatal("message version must not be changed by encoding, got", publish.GetVersion())
+    }
+
+    connect := message.NewConnectMessage()
+    connect.SetVersion(packet.MqttProtocolVersion31)
+    connect.SetClientId("c1")
+    buf := &bytes.Buffer{}
+    if _, err := message.WriteMessage(buf, connect, packet.MqttProtocolVersion5); !errors.Is(err, errcode.ConnectVersionMismatch) || buf.Len() != 0 {
+        t.Fatal("expect ConnectVersionMismatch, got", err)
+    }
+    if _, err := message.WriteMessage(buf, connect, packet.MqttProtocolVersion31); err != nil {
+        t.Fatal(err)
+    }
+    m, _, err := message.ReadMessage(buf, packet.MqttProtocolVersion5)
+    if err != nil || m.(*message.ConnectMessage).GetVersion() != packet.MqttProtocolVersion31 {
+        t.Fatal("expect MQTT 3.1 CONNECT", err)
+    }
+}
+
+func BenchmarkWriteMessage(b *testing.B) {
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("sensor/1/temperature")
+    msg.SetQos(1)
+    msg.SetPacketIdentifier(100)
+    msg.SetContentType("application/json")
+    msg.SetPayload([]byte(`{"value": 25.5}`))
+
+    w := &callWriter{}
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        w.Reset()
+        _, err := message.WriteMessage(w, msg, packet.MqttProtocolVersion5)
+        if err != nil {
+            b.Fatal(err)
+        }
+    }
+}
@@ -0,0 +1,118 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "testing"
+)
+
+func TestPacketError(t *testing.T) {
+    //PUBLISH主题名包含非法UTF-8
+    data := []byte{0x30, 5, 0, 3, 'a', 0xC0, 0x80}
+    _, _, err := message.Decode(data, packet.MqttProtocolVersion311)
+
+    var pe *errcode.PacketError
+    if !errors.As(err, &pe) {
+        t.Fatal("expect PacketError", err)
+    }
+    if pe.PacketType != packet.PktTypePUBLISH || pe.Field != "Variable Header" || pe.Reason != errcode.MalformedPacket {
+        t.Fatal(pe.PacketType, pe.Field, pe.Reason)
+    }
+    if !errors.Is(err, errcode.MalformedPacket) {
+        t.Fatal("expect MalformedPacket")
+    }
+    var r *errcode.Reason
+    if !errors.As(err, &r) || r != errcode.MalformedPacket {
+        t.Fatal("expect Reason")
+    }
+    t.Log(err)
+
+    //具体原因按原因码匹配通用原因，具体原因之间不匹配
+    data = []byte{0x82, 7, 0, 1, 0, 0, 1, 'a', 0xC0}
+    _, _, err = message.Decode(data, packet.MqttProtocolVersion5)
+    if !errors.Is(err, errcode.SubscribeReservedInvalid) || !errors.Is(err, errcode.MalformedPacket) {
+        t.Fatal("expect SubscribeReservedInvalid", err)
+    }
+    if errors.Is(err, errcode.RemainLengthInvalid) || errors.Is(err, errcode.ProtocolError) {
+        t.Fatal("expect not match", err)
+    }
+    errors.As(err, &pe)
+    if pe.Field != "Payload" || pe.Offset != 9 {
+        t.Fatal(pe.Field, pe.Offset)
+    }
+
+    //数据不完整
+    data = []byte{0x30, 5, 0, 3, 'a'}
+    _, _, err = message.ReadMessage(bytes.NewReader(data), packet.MqttProtocolVersion311)
+    if !errors.Is(err, io.ErrUnexpectedEOF) {
+        t.Fatal("expect ErrUnexpectedEOF", err)
+    }
+    if errcode.ReasonOf(err) != errcode.MalformedPacket {
+        t.Fatal(errcode.ReasonOf(err))
+    }
+
+    //连接关闭时固定头的io.EOF直接返回
+    _, _, err = message.ReadMessage(bytes.NewReader(nil), packet.MqttProtocolVersion311)
+    if err != io.EOF {
+        t.Fatal("expect EOF", err)
+    }
+
+    if errcode.ReasonOf(errors.New("test")) != errcode.UnspecifiedError {
+        t.Fatal("expect UnspecifiedError")
+    }
+    if errcode.WrapError(nil, packet.PktTypePUBLISH, "Payload", 0) != nil {
+        t.Fatal("expect nil")
+    }
+}
+
+func TestErrorToMessage(t *testing.T) {
+    err := errcode.WrapError(errcode.TopicAliasInvalid, packet.PktTypePUBLISH, "Variable Header", 2)
+    disconnect := message.NewDisconnectMessageFromError(err, packet.MqttProtocolVersion5)
+    if disconnect.GetReasonCode() != errcode.ReasonTopicAliasInvalid {
+        t.Fatal(disconnect.GetReasonCode())
+    }
+    if s, ok := disconnect.GetReasonString(); !ok || s != err.Error() {
+        t.Fatal(s)
+    }
+
+    //不能出现在DISCONNECT中的原因码
+    disconnect = message.NewDisconnectMessageFromError(errcode.Banned, packet.MqttProtocolVersion5)
+    if disconnect.GetReasonCode() != errcode.ReasonUnspecifiedError {
+        t.Fatal(disconnect.GetReasonCode())
+    }
+
+    buf := bytes.NewBuffer(nil)
+    if _, err := message.WriteMessage(buf, disconnect, packet.MqttProtocolVersion5); err != nil {
+        t.Fatal(err)
+    }
+
+    connack := message.NewConnackMessageFromError(errcode.BadUserNameOrPassword, packet.MqttProtocolVersion5)
+    if connack.GetReasonCode() != errcode.ReasonBadUserNameOrPassword {
+        t.Fatal(connack.GetReasonCode())
+    }
+    connack = message.NewConnackMessageFromError(errcode.TopicAliasInvalid, packet.MqttProtocolVersion5)
+    if connack.GetReasonCode() != errcode.ReasonUnspecifiedError {
+        t.Fatal(connack.GetReasonCode())
+    }
+    connack = message.NewConnackMessageFromError(errcode.ClientIdentifierNotValid, packet.MqttProtocolVersion311)
+    if connack.GetReasonCode() != errcode.ConnackIdentifierRejected {
+        t.Fatal(connack.GetReasonCode())
+    }
+    if _, ok := connack.GetReasonString(); ok {
+        t.Fatal("3.1.1 has no reason string")
+    }
+    connack = message.NewConnackMessageFromError(nil, packet.MqttProtocolVersion5)
+    if connack.GetReasonCode() != errcode.ReasonSuccess {
+        t.Fatal(connack.GetReasonCode())
+    }
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "io"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "testing"
+    "testing/iotest"
+)
+
+func frameData(t *testing.T) ([]byte, int) {
+    buf := bytes.NewBuffer(nil)
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("a/b")
+    msg.SetPayload(make([]byte, 300))
+    n, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = message.WriteMessage(buf, message.NewPingReqMessage(), packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes(), n
+}
+
+func TestFrameReaderShortRead(t *testing.T) {
+    data, n := frameData(t)
+    fr := packet.NewFrameReaderSize(iotest.OneByteReader(bytes.NewReader(data)), 16)
+
+    fh, frame, err := fr.ReadFrame()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fh.Type() != packet.PktTypePUBLISH || len(frame) != n || !bytes.Equal(frame, data[:n]) {
+        t.Fatal("frame not match", len(frame), n)
+    }
+
+    msg, _, err := message.ReadFrameMessage(fr, message.ReadOption{Version: packet.MqttProtocolVersion5})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if msg.GetFixedHeader().Type() != packet.PktTypePINGREQ {
+        t.Fatal("not match")
+    }
+    if fr.Count() != int64(len(data)) {
+        t.Fatal("count not match")
+    }
+
+    _, _, err = fr.ReadFrame()
+    if err != io.EOF {
+        t.Fatal("expect EOF", err)
+    }
+}
+
+func TestFrameReaderTruncated(t *testing.T) {
+    data, n := frameData(t)
+    fr := packet.NewFrameReader(iotest.HalfReader(bytes.NewReader(data[:n-1])))
+    _, _, err := fr.ReadFrame()
+    if err != io.ErrUnexpectedEOF {
+        t.Fatal("expect ErrUnexpectedEOF", err)
+    }
+
+    fr = packet.NewFrameReader(bytes.NewReader([]byte{0x30, 0x80}))
+    _, _, err = fr.ReadFrame()
+    if err != io.ErrUnexpectedEOF {
+        t.Fatal("expect ErrUnexpectedEOF", err)
+    }
+}
+
+func TestFrameReaderMalformed(t *testing.T) {
+    //剩余长度编码超过4个字节，之后是PINGREQ
+    data := []byte{0x30, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F, 0xC0, 0}
+    fr := packet.NewFrameReader(bytes.NewReader(data))
+    _, _, err := fr.ReadFrame()
+    if err != errcode.RemainLengthInvalid {
+        t.Fatal("expect RemainLengthInvalid", err)
+    }
+    //无法确定报文边界，Resync不能恢复，错误保持
+    if err := fr.Resync(); err != errcode.RemainLengthInvalid {
+        t.Fatal("expect RemainLengthInvalid from Resync", err)
+    }
+    _, _, err = fr.ReadFrame()
+    if err != errcode.RemainLengthInvalid {
+        t.Fatal("expect RemainLengthInvalid", err)
+    }
+
+    //未使用最少字节数编码
+    fr = packet.NewFrameReader(bytes.NewReader([]byte{0xC0, 0x80, 0x00}))
+    _, _, err = fr.ReadFrame()
+    if err != errcode.RemainLengthInvalid {
+        t.Fatal("expect RemainLengthInvalid", err)
+    }
+}
+
+func TestFrameReaderTooLarge(t *testing.T) {
+    data, n := frameData(t)
+    fr := packet.NewFrameReader(bytes.NewReader(data))
+    fr.SetMaxPacketSize(uint32(n - 1))
+    _, _, err := fr.ReadFrame()
+    if err != errcode.PacketTooLarge {
+        t.Fatal("expect PacketTooLarge", err)
+    }
+    if err := fr.Resync(); err != nil {
+        t.Fatal(err)
+    }
+    fh, _, err := fr.ReadFrame()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fh.Type() != packet.PktTypePINGREQ || fr.Count() != int64(len(data)) {
+        t.Fatal("not match")
+    }
+}
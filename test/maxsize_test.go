@@ -0,0 +1,91 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "testing"
+)
+
+//统计读取的字节数
+type countReader struct {
+    r io.Reader
+    n int
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+    n, err := r.r.Read(p)
+    r.n += n
+    return n, err
+}
+
+func TestMaxPacketSizeRead(t *testing.T) {
+    //固定头声明剩余长度为268,435,455
+    data := []byte{0x30, 0xFF, 0xFF, 0xFF, 0x7F, 0, 1, 'a'}
+    r := &countReader{r: bytes.NewReader(data)}
+    _, n, err := message.ReadMessageWithLimit(r, packet.MqttProtocolVersion5, 1024)
+    if !errors.Is(err, errcode.PacketTooLarge) {
+        t.Fatal("expect PacketTooLarge")
+    }
+    if n != 5 || r.n != 5 {
+        t.Fatal("must not read after fixed header", n, r.n)
+    }
+}
+
+func TestMaxPacketSizeBoundary(t *testing.T) {
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("a/b")
+    msg.SetPayload(make([]byte, 100))
+
+    buf := bytes.NewBuffer(nil)
+    n, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data := buf.Bytes()
+
+    _, _, err = message.ReadMessageWithLimit(bytes.NewReader(data), packet.MqttProtocolVersion5, uint32(n))
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, _, err = message.ReadMessageWithLimit(bytes.NewReader(data), packet.MqttProtocolVersion5, uint32(n-1))
+    if !errors.Is(err, errcode.PacketTooLarge) {
+        t.Fatal("expect PacketTooLarge")
+    }
+}
+
+func TestMaxPacketSizeWrite(t *testing.T) {
+    connack := message.NewConnackMessage()
+    connack.SetMaximumPacketSize(64)
+    limit := message.MaxPacketSize(connack)
+    if limit != 64 {
+        t.Fatal("not match")
+    }
+
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("a/b")
+    msg.SetPayload(make([]byte, 64))
+
+    buf := bytes.NewBuffer(nil)
+    n, err := message.WriteMessageWithLimit(buf, msg, packet.MqttProtocolVersion5, limit)
+    if !errors.Is(err, errcode.PacketTooLarge) {
+        t.Fatal("expect PacketTooLarge")
+    }
+    if n != 0 || buf.Len() != 0 {
+        t.Fatal("must not write")
+    }
+
+    msg.SetPayload(make([]byte, 32))
+    if _, err := message.WriteMessageWithLimit(buf, msg, packet.MqttProtocolVersion5, limit); err != nil {
+        t.Fatal(err)
+    }
+}
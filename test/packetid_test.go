@@ -0,0 +1,150 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "context"
+    "math"
+    "mqtt/errcode"
+    "mqtt/session"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestIDAllocator(t *testing.T) {
+    a := session.NewIDAllocator(0)
+    if a.GetReceiveMaximum() != session.DefaultReceiveMaximum {
+        t.Fatal(a.GetReceiveMaximum())
+    }
+
+    id, err := a.Allocate()
+    if err != nil || id != 1 {
+        t.Fatal(id, err)
+    }
+    if err := a.Use(2, true); err != nil {
+        t.Fatal(err)
+    }
+    if err := a.Use(2, true); err != errcode.PacketIdentifierInUse {
+        t.Fatal("expect PacketIdentifierInUse", err)
+    }
+    if err := a.Use(0, true); err != errcode.PacketIdentifierInvalid {
+        t.Fatal("expect PacketIdentifierInvalid", err)
+    }
+    //跳过使用中的标识符
+    id, _ = a.AllocatePublish()
+    if id != 3 || a.InFlight() != 2 {
+        t.Fatal(id, a.InFlight())
+    }
+
+    if !a.Release(2) || a.Release(2) || a.InUse(2) {
+        t.Fatal("release not match")
+    }
+    if a.InFlight() != 1 {
+        t.Fatal(a.InFlight())
+    }
+}
+
+func TestIDAllocatorWrap(t *testing.T) {
+    a := session.NewIDAllocator(0)
+    seen := map[uint16]bool{}
+    for i := 0; i < math.MaxUint16; i++ {
+        id, err := a.Allocate()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if id == 0 || seen[id] {
+            t.Fatal("invalid id", id)
+        }
+        seen[id] = true
+    }
+    if _, err := a.Allocate(); err != errcode.PacketIdentifierInUse {
+        t.Fatal("expect PacketIdentifierInUse", err)
+    }
+
+    a.Release(100)
+    id, err := a.Allocate()
+    if err != nil || id != 100 {
+        t.Fatal(id, err)
+    }
+}
+
+func TestIDAllocatorReceiveMaximum(t *testing.T) {
+    a := session.NewIDAllocator(2)
+    a.AllocatePublish()
+    id, _ := a.AllocatePublish()
+    if _, err := a.AllocatePublish(); err != errcode.ReceiveMaximumExceeded {
+        t.Fatal("expect ReceiveMaximumExceeded", err)
+    }
+    //SUBSCRIBE及UNSUBSCRIBE不受限制
+    if _, err := a.Allocate(); err != nil {
+        t.Fatal(err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+    if _, err := a.AllocatePublishContext(ctx); err != context.DeadlineExceeded {
+        t.Fatal("expect DeadlineExceeded", err)
+    }
+
+    wg := sync.WaitGroup{}
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        if _, err := a.AllocatePublishContext(context.Background()); err != nil {
+            t.Error(err)
+        }
+    }()
+    time.Sleep(10 * time.Millisecond)
+    a.Release(id)
+    wg.Wait()
+    if a.InFlight() != 2 {
+        t.Fatal(a.InFlight())
+    }
+
+    a.SetReceiveMaximum(3)
+    if _, err := a.AllocatePublish(); err != nil {
+        t.Fatal(err)
+    }
+    a.Reset()
+    if a.InFlight() != 0 {
+        t.Fatal(a.InFlight())
+    }
+
+    //恢复持久化的下一个报文标识符
+    a.SetNext(100)
+    if id, _ := a.Allocate(); id != 100 || a.Next() != 101 {
+        t.Fatal("expect allocate from restored id", id, a.Next())
+    }
+    a.SetNext(0)
+    if a.Next() != 1 {
+        t.Fatal("expect 0 treated as 1", a.Next())
+    }
+}
+
+func TestInboundIDs(t *testing.T) {
+    ids := session.NewInboundIDs(2)
+    if err := ids.Add(0); err != errcode.PacketIdentifierInvalid {
+        t.Fatal("expect PacketIdentifierInvalid", err)
+    }
+    if err := ids.Add(10); err != nil {
+        t.Fatal(err)
+    }
+    if err := ids.Add(10); err != errcode.PacketIdentifierInUse {
+        t.Fatal("expect PacketIdentifierInUse", err)
+    }
+    ids.Add(11)
+    if err := ids.Add(12); err != errcode.ReceiveMaximumExceeded {
+        t.Fatal("expect ReceiveMaximumExceeded", err)
+    }
+    if !ids.Remove(10) || ids.Remove(10) || ids.Contains(10) || ids.Len() != 1 {
+        t.Fatal("remove not match")
+    }
+    if err := ids.Add(12); err != nil {
+        t.Fatal(err)
+    }
+}
@@ -0,0 +1,249 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "errors"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "testing"
+)
+
+func TestProperties(t *testing.T) {
+    expiry := uint32(60)
+    method := "SCRAM-SHA-1"
+    pair, _ := packet.NewStringPair("k", "v")
+    props := packet.Properties{
+        SessionExpiryInterval: &expiry,
+        AuthenticationMethod:  packet.StringPtr(method),
+        AuthenticationData:    []byte{1, 2, 3},
+        UserProperty:          []packet.StringPair{pair, pair},
+    }
+
+    buf := bytes.NewBuffer(nil)
+    n, err := props.Encode(buf, packet.PktTypeCONNECT, packet.ControlCharAllow)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != props.Length() {
+        t.Fatal("length not match")
+    }
+
+    props2 := packet.Properties{}
+    n2, err := props2.Decode(buf, packet.PktTypeCONNECT, packet.ControlCharAllow)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != n2 {
+        t.Fatal("size not match")
+    }
+    if *props2.SessionExpiryInterval != 60 || props2.AuthenticationMethod.String() != method ||
+        !bytes.Equal(props2.AuthenticationData, []byte{1, 2, 3}) || len(props2.UserProperty) != 2 {
+        t.Fatal("not match", props2.String())
+    }
+    if props2.ReceiveMaximum != nil || props2.CorrelationData != nil {
+        t.Fatal("must not set")
+    }
+
+    //不允许出现在CONNACK以外的属性
+    if _, err := props.Encode(buf, packet.PktTypePUBLISH, packet.ControlCharAllow); err != errcode.PropertyNotAllowed {
+        t.Fatal("expect PropertyNotAllowed")
+    }
+}
+
+func TestPropertiesList(t *testing.T) {
+    p1 := &packet.PropTopicAlias{}
+    p1.V = 10
+    p2 := &packet.PropSubscriptionIdentifier{}
+    p2.V.InitFromUInt64(100)
+    p3 := &packet.PropSubscriptionIdentifier{}
+    p3.V.InitFromUInt64(200)
+
+    props := packet.NewProperties([]packet.Property{p1, p2, p3})
+    if *props.TopicAlias != 10 || len(props.SubscriptionIdentifier) != 2 || props.SubscriptionIdentifier[1] != 200 {
+        t.Fatal("not match")
+    }
+    //不引用原属性
+    p1.V = 11
+    if *props.TopicAlias != 10 {
+        t.Fatal("must copy value")
+    }
+
+    list, err := props.List()
+    if err != nil || len(list) != 3 {
+        t.Fatal("list not match")
+    }
+    if packet.FindPropValue(packet.TopicAlias, list).(*packet.PropTopicAlias).V != 10 {
+        t.Fatal("not match")
+    }
+
+    if props.Check(packet.PktTypePUBLISH) != nil {
+        t.Fatal("expect valid")
+    }
+    if props.Check(packet.PktTypeCONNACK) != errcode.PropertyNotAllowed {
+        t.Fatal("expect PropertyNotAllowed")
+    }
+    props.TopicAlias = nil
+    if props.Check(packet.PktTypeSUBSCRIBE) != errcode.DuplicateProperty {
+        t.Fatal("expect DuplicateProperty")
+    }
+
+    props.Reset()
+    if !props.IsEmpty() {
+        t.Fatal("expect empty")
+    }
+}
+
+//按字段计算的长度与编码结果一致
+func TestPropertiesLength(t *testing.T) {
+    b := byte(1)
+    u16 := uint16(10)
+    u32 := uint32(1000)
+    s := "text"
+    pair, _ := packet.NewStringPair("k", "v")
+    props := packet.Properties{
+        PayloadFormatIndicator:          &b,
+        MessageExpiryInterval:           &u32,
+        ContentType:                     packet.StringPtr(s),
+        ResponseTopic:                   packet.StringPtr(s),
+        CorrelationData:                 []byte{},
+        SubscriptionIdentifier:          []uint32{1, 200, 268435455},
+        SessionExpiryInterval:           &u32,
+        AssignedClientIdentifier:        packet.StringPtr(s),
+        ServerKeepAlive:                 &u16,
+        AuthenticationMethod:            packet.StringPtr(s),
+        AuthenticationData:              []byte{1, 2},
+        RequestProblemInformation:       &b,
+        WillDelayInterval:               &u32,
+        RequestResponseInformation:      &b,
+        ResponseInformation:             packet.StringPtr(s),
+        ServerReference:                 packet.StringPtr(s),
+        ReasonString:                    packet.StringPtr(s),
+        ReceiveMaximum:                  &u16,
+        TopicAliasMaximum:               &u16,
+        TopicAlias:                      &u16,
+        MaximumQoS:                      &b,
+        RetainAvailable:                 &b,
+        UserProperty:                    []packet.StringPair{pair},
+        MaximumPacketSize:               &u32,
+        WildcardSubscriptionAvailable:   &b,
+        SubscriptionIdentifierAvailable: &b,
+        SharedSubscriptionAvailable:     &b,
+    }
+    list, err := props.List()
+    if err != nil {
+        t.Fatal(err)
+    }
+    n, err := packet.WriteProperties(bytes.NewBuffer(nil), list, packet.ControlCharAllow)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != props.Length() || n != packet.PropertiesLength(list) {
+        t.Fatal("length not match", n, props.Length())
+    }
+
+    //空的二进制数据也是已设置的属性
+    empty := packet.Properties{CorrelationData: []byte{}}
+    if empty.IsEmpty() || empty.Length() != 4 {
+        t.Fatal("expect CorrelationData set", empty.Length())
+    }
+}
+
+//超出长度的字符串不会被忽略
+func TestPropertiesStringOutOfRange(t *testing.T) {
+    long := string(make([]byte, 65536))
+    props := packet.Properties{ReasonString: packet.StringPtr(long)}
+    if props.IsEmpty() || props.Length() != 1+1+2+65536+2 {
+        t.Fatal("expect oversized string counted", props.Length())
+    }
+    if _, err := props.List(); err != errcode.StringOutOfRange {
+        t.Fatal("expect StringOutOfRange from List", err)
+    }
+    if err := props.Check(packet.PktTypePUBACK); err != errcode.StringOutOfRange {
+        t.Fatal("expect StringOutOfRange from Check", err)
+    }
+    if _, err := props.Encode(bytes.NewBuffer(nil), packet.PktTypePUBACK, packet.ControlCharAllow); err != errcode.StringOutOfRange {
+        t.Fatal("expect StringOutOfRange from Encode", err)
+    }
+}
+
+func TestMessageProperties(t *testing.T) {
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("a")
+    msg.SetSubscriptionIdentifier(1)
+    msg.SetSubscriptionIdentifier(2)
+    ct := "text/plain"
+    msg.GetProperties().ContentType = packet.StringPtr(ct)
+
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    msg2, _, err := message.ReadMessage(buf, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    p := msg2.(*message.PublishMessage)
+    if v, ok := p.GetContentType(); !ok || v != ct {
+        t.Fatal("not match")
+    }
+    ids := p.GetProperties().SubscriptionIdentifier
+    if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+        t.Fatal("not match", ids)
+    }
+}
+
+//属性长度超过127字节时，属性长度字段占用2字节
+func TestLongPropertiesRoundTrip(t *testing.T) {
+    msg := message.NewConnackMessage()
+    msg.SetReasonString(string(bytes.Repeat([]byte{'r'}, 123)))
+    msg.SetSharedSubscriptionAvailable(1)
+
+    buf := bytes.NewBuffer(nil)
+    n, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data := append([]byte(nil), buf.Bytes()...)
+
+    check := func(m message.Message, size int) {
+        if size != n {
+            t.Fatal("size not match", size, n)
+        }
+        ack := m.(*message.ConnackMessage)
+        if v, ok := ack.GetReasonString(); !ok || len(v) != 123 {
+            t.Fatal("reason string not match", v)
+        }
+        if v, ok := ack.GetSharedSubscriptionAvailable(); !ok || v != 1 {
+            t.Fatal("shared subscription available not match")
+        }
+    }
+    m, size, err := message.ReadMessage(buf, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    check(m, size)
+    m, size, err = message.Decode(data, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    check(m, size)
+}
+
+//最后一个属性超出属性长度
+func TestPropertiesOverrun(t *testing.T) {
+    data := []byte{0x20, 0x05, 0x00, 0x00, 0x01, byte(packet.SharedSubscriptionAvailable), 0x01}
+    if _, _, err := message.Decode(data, packet.MqttProtocolVersion5); !errors.Is(err, errcode.MalformedPacket) {
+        t.Fatal("expect MalformedPacket", err)
+    }
+    if _, _, err := message.ReadMessage(bytes.NewReader(data), packet.MqttProtocolVersion5); !errors.Is(err, errcode.MalformedPacket) {
+        t.Fatal("expect MalformedPacket", err)
+    }
+}
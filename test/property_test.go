@@ -8,6 +8,9 @@ package test
 
 import (
     "bytes"
+    "errors"
+    "mqtt/errcode"
+    "mqtt/message"
     "mqtt/packet"
     "testing"
 )
@@ -23,7 +26,7 @@ func TestProperty1(t *testing.T) {
     buf := bytes.NewBuffer(nil)
     n, err := packet.WriteProperties(buf, []packet.Property{
         p1, p2,
-    })
+    }, packet.ControlCharAllow)
     if err != nil {
         t.Fatal(err)
     }
@@ -31,7 +34,7 @@ func TestProperty1(t *testing.T) {
 
     t.Log(buf.Bytes())
 
-    props, n, err := packet.ReadPropertyMap(buf)
+    props, n, err := packet.ReadPropertyMap(buf, packet.ControlCharAllow)
     if err != nil {
         t.Fatal(err)
     }
@@ -60,7 +63,7 @@ func TestProperty2(t *testing.T) {
     buf := bytes.NewBuffer(nil)
     n, err := packet.WriteProperties(buf, []packet.Property{
         p1, p2,
-    })
+    }, packet.ControlCharAllow)
     if err != nil {
         t.Fatal(err)
     }
@@ -68,7 +71,7 @@ func TestProperty2(t *testing.T) {
 
     t.Log(buf.Bytes())
 
-    props, n, err := packet.ReadProperties(buf)
+    props, n, err := packet.ReadProperties(buf, packet.ControlCharAllow)
     if err != nil {
         t.Fatal(err)
     }
@@ -90,7 +93,7 @@ func TestProperty3(t *testing.T) {
     buf := bytes.NewBuffer(nil)
     n, err := packet.WriteProperties(buf, []packet.Property{
         p1, p2,
-    })
+    }, packet.ControlCharAllow)
     if err != nil {
         t.Fatal(err)
     }
@@ -98,7 +101,7 @@ func TestProperty3(t *testing.T) {
 
     t.Log(buf.Bytes())
 
-    props, n, err := packet.ReadProperties(buf)
+    props, n, err := packet.ReadProperties(buf, packet.ControlCharAllow)
     if err != nil {
         t.Fatal(err)
     }
@@ -115,3 +118,111 @@ func TestProperty3(t *testing.T) {
         t.Fatal("not match")
     }
 }
+
+func TestPropertyAllowList(t *testing.T) {
+    alias := &packet.PropTopicAlias{}
+    alias.V = 1
+    if err := packet.CheckProperties(packet.PktTypePUBLISH, []packet.Property{alias}); err != nil {
+        t.Fatal(err)
+    }
+    if err := packet.CheckProperties(packet.PktTypeCONNACK, []packet.Property{alias}); !errors.Is(err, errcode.PropertyNotAllowed) {
+        t.Fatal("expect PropertyNotAllowed")
+    }
+
+    delay := &packet.PropWillDelayInterval{}
+    if err := packet.CheckProperties(packet.WillPropertiesOwner, []packet.Property{delay}); err != nil {
+        t.Fatal(err)
+    }
+    if err := packet.CheckProperties(packet.PktTypeCONNECT, []packet.Property{delay}); !errors.Is(err, errcode.PropertyNotAllowed) {
+        t.Fatal("expect PropertyNotAllowed")
+    }
+
+    //PUBLISH可以包含多个订阅标识符，SUBSCRIBE不可以
+    id1 := &packet.PropSubscriptionIdentifier{}
+    id1.V.InitFromUInt64(1)
+    id2 := &packet.PropSubscriptionIdentifier{}
+    id2.V.InitFromUInt64(2)
+    if err := packet.CheckProperties(packet.PktTypePUBLISH, []packet.Property{id1, id2}); err != nil {
+        t.Fatal(err)
+    }
+    if err := packet.CheckProperties(packet.PktTypeSUBSCRIBE, []packet.Property{id1, id2}); !errors.Is(err, errcode.DuplicateProperty) {
+        t.Fatal("expect DuplicateProperty")
+    }
+}
+
+func TestPropertyAllowListMessage(t *testing.T) {
+    //CONNACK包含主题别名
+    data := []byte{0x20, 6, 0, 0, 3, 0x23, 0, 1}
+    _, _, err := message.ReadMessage(bytes.NewReader(data), packet.MqttProtocolVersion5)
+    if !errors.Is(err, errcode.PropertyNotAllowed) {
+        t.Fatal("expect PropertyNotAllowed")
+    }
+    if errcode.ReasonOf(err).Code != errcode.ReasonMalformedPacket {
+        t.Fatal("expect MalformedPacket")
+    }
+
+    //遗嘱属性中包含会话过期间隔
+    data = []byte{0x10, 27, 0, 4, 'M', 'Q', 'T', 'T', 5, 0x06, 0, 10, 0, 0, 1, 'a',
+        5, 0x11, 0, 0, 0, 1, 0, 1, 't', 0, 1, 'p'}
+    _, _, err = message.ReadMessage(bytes.NewReader(data), packet.MqttProtocolVersion5)
+    if !errors.Is(err, errcode.PropertyNotAllowed) {
+        t.Fatal("expect PropertyNotAllowed", err)
+    }
+
+    msg := message.NewConnectMessage()
+    msg.SetClientId("a")
+    msg.SetWillEnable(true)
+    msg.SetWillDelayInterval(10)
+    msg.SetWillTopic("t")
+    buf := bytes.NewBuffer(nil)
+    if _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5); err != nil {
+        t.Fatal(err)
+    }
+    if _, _, err := message.ReadMessage(buf, packet.MqttProtocolVersion5); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestBinaryDataProperty(t *testing.T) {
+    data := []byte{0x00, 0xFF, 0xFE, 0xC0, 0x80}
+    p := &packet.PropCorrelationData{}
+    v, err := packet.FromBytes(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    p.Set(v)
+
+    buf := bytes.NewBuffer(nil)
+    n, err := packet.WriteProperties(buf, []packet.Property{p}, packet.ControlCharAllow)
+    if err != nil {
+        t.Fatal(err)
+    }
+    props, n2, err := packet.ReadProperties(buf, packet.ControlCharAllow)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != n2 {
+        t.Fatal("size not match")
+    }
+    p2 := packet.FindPropValue(packet.CorrelationData, props).(*packet.PropCorrelationData)
+    if !bytes.Equal(p2.V.Get(), data) {
+        t.Fatal("not match", p2.V.Get())
+    }
+
+    msg := message.NewAuthMessage()
+    msg.SetReasonCode(errcode.ReasonContinueAuthentication)
+    msg.SetAuthenticationMethod("GS2-KRB5")
+    msg.SetAuthenticationData(data)
+    buf.Reset()
+    _, err = message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    msg2, _, err := message.ReadMessage(buf, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if v, ok := msg2.(*message.AuthMessage).GetAuthenticationData(); !ok || !bytes.Equal(v, data) {
+        t.Fatal("not match", v)
+    }
+}
@@ -8,7 +8,9 @@ package test
 
 import (
     "bytes"
+    "mqtt/errcode"
     "mqtt/message"
+    "mqtt/packet"
     "testing"
 )
 
@@ -51,7 +53,7 @@ func TestPuback1(t *testing.T) {
 
 func TestPuback2(t *testing.T) {
     msg := message.NewPubAckMessage()
-    msg.SetReasonCode(100)
+    msg.SetReasonCode(errcode.ReasonNoMatchingSubscribers)
     msg.SetUserProperty(map[string]string{
         "test1": "1234567890qwertyuiopasdfghjklzxcvbnm",
         "test2": "1234567890qwertyuiopasdfghjklzxcvbnm",
@@ -64,12 +66,12 @@ func TestPuback2(t *testing.T) {
     t.Log(msg)
 
     buf := bytes.NewBuffer(nil)
-    n, err := message.WriteMessage(buf, msg)
+    n, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
     if err != nil {
         t.Fatal(err)
     }
 
-    msg2, n2, err2 := message.ReadMessage(buf)
+    msg2, n2, err2 := message.ReadMessage(buf, packet.MqttProtocolVersion5)
     if err2 != nil {
         t.Fatal(err2)
     }
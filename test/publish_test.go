@@ -9,11 +9,13 @@ package test
 import (
     "bytes"
     "mqtt/message"
+    "mqtt/packet"
     "testing"
 )
 
 func TestPublish1(t *testing.T) {
     msg := message.NewPublishMessage()
+    msg.SetTopicName("test/topic")
     msg.SetMessageExpiryInterval(1000)
     msg.SetPayload([]byte(`
         This is a test Message! This is a test Message! This is a test Message! 
@@ -62,6 +64,7 @@ func TestPublish1(t *testing.T) {
 
 func TestPublish2(t *testing.T) {
     msg := message.NewPublishMessage()
+    msg.SetTopicName("test/topic")
     msg.SetPayload([]byte(`
         This is a test Message! This is a test Message! This is a test Message! 
         This is a test Message! This is a test Message! This is a test Message! 
@@ -86,12 +89,12 @@ func TestPublish2(t *testing.T) {
     t.Log(msg)
 
     buf := bytes.NewBuffer(nil)
-    n, err := message.WriteMessage(buf, msg)
+    n, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
     if err != nil {
         t.Fatal(err)
     }
 
-    msg2, n2, err2 := message.ReadMessage(buf)
+    msg2, n2, err2 := message.ReadMessage(buf, packet.MqttProtocolVersion5)
     if err2 != nil {
         t.Fatal(err2)
     }
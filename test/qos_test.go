@@ -0,0 +1,247 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "mqtt/session"
+    "testing"
+)
+
+func newPublish(qos byte, payload string) *message.PublishMessage {
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("a/b")
+    msg.SetQos(qos)
+    msg.SetPayload([]byte(payload))
+    return msg
+}
+
+func pubAck(id uint16) *message.PubAckMessage {
+    msg := message.NewPubAckMessage()
+    msg.SetPacketIdentifier(id)
+    return msg
+}
+
+func pubRec(id uint16, code errcode.ReasonCode) *message.PubRecMessage {
+    msg := message.NewPubRecMessage()
+    msg.SetPacketIdentifier(id)
+    msg.SetReasonCode(code)
+    return msg
+}
+
+func pubRel(id uint16) *message.PubRelMessage {
+    msg := message.NewPubRelMessage()
+    msg.SetPacketIdentifier(id)
+    return msg
+}
+
+func pubComp(id uint16) *message.PubCompMessage {
+    msg := message.NewPubCompMessage()
+    msg.SetPacketIdentifier(id)
+    return msg
+}
+
+func TestOutboundQos1(t *testing.T) {
+    out := session.NewOutbound(session.NewIDAllocator(0), packet.MqttProtocolVersion5)
+
+    qos0 := newPublish(0, "0")
+    if err := out.Publish(qos0); err != nil || qos0.GetPacketIdentifier() != 0 || out.Len() != 0 {
+        t.Fatal("QoS 0 must not be tracked", err)
+    }
+
+    msg := newPublish(1, "1")
+    if err := out.Publish(msg); err != nil {
+        t.Fatal(err)
+    }
+    id := msg.GetPacketIdentifier()
+    if id == 0 || out.State(id) != session.StateWaitPubAck {
+        t.Fatal(id, out.State(id))
+    }
+
+    if _, err := out.HandlePubComp(pubComp(id)); err != errcode.PacketIdentifierNotFound {
+        t.Fatal("expect PacketIdentifierNotFound", err)
+    }
+    done, err := out.HandlePubAck(pubAck(id))
+    if err != nil || done != msg {
+        t.Fatal(err)
+    }
+    if out.State(id) != session.StateNone || out.Len() != 0 {
+        t.Fatal(out.State(id))
+    }
+    if _, err := out.HandlePubAck(pubAck(id)); err != errcode.PacketIdentifierNotFound {
+        t.Fatal("expect PacketIdentifierNotFound", err)
+    }
+}
+
+func TestOutboundQos2(t *testing.T) {
+    ids := session.NewIDAllocator(0)
+    out := session.NewOutbound(ids, packet.MqttProtocolVersion5)
+
+    msg := newPublish(2, "2")
+    out.Publish(msg)
+    id := msg.GetPacketIdentifier()
+    if out.State(id) != session.StateWaitPubRec {
+        t.Fatal(out.State(id))
+    }
+
+    if _, err := out.HandlePubAck(pubAck(id)); err != errcode.PacketIdentifierNotFound {
+        t.Fatal("expect PacketIdentifierNotFound", err)
+    }
+
+    rel, err := out.HandlePubRec(pubRec(id, errcode.ReasonSuccess))
+    if err != nil || rel.GetPacketIdentifier() != id || rel.GetReasonCode() != errcode.ReasonSuccess {
+        t.Fatal(err)
+    }
+    if out.State(id) != session.StateWaitPubComp {
+        t.Fatal(out.State(id))
+    }
+
+    //重复的PUBREC
+    rel, err = out.HandlePubRec(pubRec(id, errcode.ReasonSuccess))
+    if err != nil || rel.GetPacketIdentifier() != id {
+        t.Fatal(err)
+    }
+
+    done, err := out.HandlePubComp(pubComp(id))
+    if err != nil || done != msg || ids.InUse(id) {
+        t.Fatal(err)
+    }
+
+    //未知的报文标识符
+    rel, err = out.HandlePubRec(pubRec(100, errcode.ReasonSuccess))
+    if err != nil || rel.GetReasonCode() != errcode.ReasonPacketIdentifierNotFound {
+        t.Fatal(err)
+    }
+
+    //交付失败
+    msg = newPublish(2, "3")
+    out.Publish(msg)
+    id = msg.GetPacketIdentifier()
+    rel, err = out.HandlePubRec(pubRec(id, errcode.ReasonQuotaExceeded))
+    if err != nil || rel != nil || out.Len() != 0 || ids.InUse(id) {
+        t.Fatal(rel, err)
+    }
+}
+
+func TestOutboundResend(t *testing.T) {
+    ids := session.NewIDAllocator(0)
+    out := session.NewOutbound(ids, packet.MqttProtocolVersion5)
+
+    m1 := newPublish(1, "1")
+    m2 := newPublish(2, "2")
+    m3 := newPublish(2, "3")
+    out.Publish(m1)
+    out.Publish(m2)
+    out.Publish(m3)
+    out.HandlePubRec(pubRec(m2.GetPacketIdentifier(), errcode.ReasonSuccess))
+
+    resend := out.Resend()
+    if len(resend) != 3 {
+        t.Fatal(len(resend))
+    }
+    if resend[0] != m1 || !m1.GetDup() {
+        t.Fatal("expect m1 with DUP")
+    }
+    rel, ok := resend[1].(*message.PubRelMessage)
+    if !ok || rel.GetPacketIdentifier() != m2.GetPacketIdentifier() || m2.GetDup() {
+        t.Fatal("expect PUBREL for m2")
+    }
+    if resend[2] != m3 || !m3.GetDup() {
+        t.Fatal("expect m3 with DUP")
+    }
+
+    //重启后恢复状态
+    out2 := session.NewOutbound(session.NewIDAllocator(0), packet.MqttProtocolVersion5)
+    if err := out2.Restore(m2, session.StateWaitPubComp); err != nil {
+        t.Fatal(err)
+    }
+    if err := out2.Restore(m2, session.StateWaitPubComp); err != errcode.PacketIdentifierInUse {
+        t.Fatal("expect PacketIdentifierInUse", err)
+    }
+    if _, err := out2.HandlePubComp(pubComp(m2.GetPacketIdentifier())); err != nil {
+        t.Fatal(err)
+    }
+
+    out.Clear()
+    if out.Len() != 0 || ids.InFlight() != 0 {
+        t.Fatal(out.Len(), ids.InFlight())
+    }
+}
+
+func TestOutboundReceiveMaximum(t *testing.T) {
+    out := session.NewOutbound(session.NewIDAllocator(1), packet.MqttProtocolVersion5)
+    m1 := newPublish(1, "1")
+    if err := out.Publish(m1); err != nil {
+        t.Fatal(err)
+    }
+    if err := out.Publish(newPublish(1, "2")); err != errcode.ReceiveMaximumExceeded {
+        t.Fatal("expect ReceiveMaximumExceeded", err)
+    }
+    out.HandlePubAck(pubAck(m1.GetPacketIdentifier()))
+    if err := out.Publish(newPublish(1, "2")); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestInbound(t *testing.T) {
+    in := session.NewInbound(session.NewInboundIDs(2), packet.MqttProtocolVersion5)
+
+    deliver, resp, err := in.HandlePublish(newPublish(0, "0"))
+    if !deliver || resp != nil || err != nil {
+        t.Fatal(deliver, resp, err)
+    }
+
+    msg := newPublish(1, "1")
+    msg.SetPacketIdentifier(1)
+    deliver, resp, err = in.HandlePublish(msg)
+    if ack, ok := resp.(*message.PubAckMessage); !deliver || !ok || ack.GetPacketIdentifier() != 1 || err != nil {
+        t.Fatal(deliver, resp, err)
+    }
+
+    msg = newPublish(2, "2")
+    msg.SetPacketIdentifier(2)
+    deliver, resp, err = in.HandlePublish(msg)
+    if rec, ok := resp.(*message.PubRecMessage); !deliver || !ok || rec.GetPacketIdentifier() != 2 || err != nil {
+        t.Fatal(deliver, resp, err)
+    }
+    if !in.Pending(2) {
+        t.Fatal("expect pending")
+    }
+
+    //重发的QoS 2消息不再投递
+    msg.SetDup(true)
+    deliver, resp, err = in.HandlePublish(msg)
+    if _, ok := resp.(*message.PubRecMessage); deliver || !ok || err != nil {
+        t.Fatal(deliver, resp, err)
+    }
+
+    m3 := newPublish(2, "3")
+    m3.SetPacketIdentifier(3)
+    in.HandlePublish(m3)
+    m4 := newPublish(2, "4")
+    m4.SetPacketIdentifier(4)
+    if _, _, err := in.HandlePublish(m4); err != errcode.ReceiveMaximumExceeded {
+        t.Fatal("expect ReceiveMaximumExceeded", err)
+    }
+
+    comp := in.HandlePubRel(pubRel(2))
+    if comp.GetPacketIdentifier() != 2 || comp.GetReasonCode() != errcode.ReasonSuccess || in.Pending(2) {
+        t.Fatal(comp)
+    }
+    comp = in.HandlePubRel(pubRel(2))
+    if comp.GetReasonCode() != errcode.ReasonPacketIdentifierNotFound {
+        t.Fatal(comp)
+    }
+
+    //PUBREL之后相同的报文标识符是新消息
+    deliver, _, _ = in.HandlePublish(msg)
+    if !deliver {
+        t.Fatal("expect deliver")
+    }
+}
@@ -0,0 +1,431 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "context"
+    "fmt"
+    "mqtt/client"
+    "mqtt/message"
+    "mqtt/packet"
+    "mqtt/store"
+    "path/filepath"
+    "sync"
+    "testing"
+    "time"
+)
+
+func checkQueue(t *testing.T, q store.Queue, start time.Time, expect ...string) {
+    if q.Len() != len(expect) {
+        t.Fatalf("expect %d queued messages, got %d", len(expect), q.Len())
+    }
+    for i, payload := range expect {
+        msg, enqueued, err := q.Peek()
+        if err != nil || msg == nil {
+            t.Fatal("expect queued message", err)
+        }
+        if string(msg.GetPayload()) != payload || msg.GetTopicName() != "a/b" {
+            t.Fatalf("message %d: expect %q, got %q", i, payload, msg.GetPayload())
+        }
+        if !enqueued.Equal(start.Add(time.Duration(i) * time.Second)) {
+            t.Fatal("enqueue time mismatch", enqueued)
+        }
+        if err := q.Pop(); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if msg, _, _ := q.Peek(); msg != nil || q.Pop() != nil {
+        t.Fatal("expect empty queue")
+    }
+}
+
+func pushQueue(t *testing.T, q store.Queue, start time.Time, payloads ...string) {
+    for i, payload := range payloads {
+        if err := q.Push(publishMessage("a/b", 1, payload), start.Add(time.Duration(i)*time.Second)); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func TestMemoryQueue(t *testing.T) {
+    q := store.NewMemoryQueue()
+    start := time.Now()
+    pushQueue(t, q, start, "1", "2", "3")
+    checkQueue(t, q, start, "1", "2", "3")
+
+    //出队与入队交替
+    for i := 0; i < 100; i++ {
+        q.Push(publishMessage("a/b", 0, fmt.Sprint(i)), start)
+        if i%2 == 1 {
+            q.Pop()
+        }
+    }
+    if q.Len() != 50 {
+        t.Fatal("expect 50 messages, got", q.Len())
+    }
+    if msg, _, _ := q.Peek(); string(msg.GetPayload()) != "50" {
+        t.Fatal("expect head 50, got", string(msg.GetPayload()))
+    }
+}
+
+func TestFileQueue(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "queue.log")
+    q, err := store.OpenFileQueue(path, store.FileOptions{CompactThreshold: 4})
+    if err != nil {
+        t.Fatal(err)
+    }
+    start := time.Unix(1000, 500)
+    pushQueue(t, q, start.Add(-2*time.Second), "0", "1")
+    q.Pop()
+    q.Pop()
+    pushQueue(t, q, start, "a", "b", "c")
+    msg := publishMessage("a/b", 1, "expiry")
+    msg.SetMessageExpiryInterval(60)
+    q.Push(msg, start.Add(3*time.Second))
+    q.Close()
+    if err := q.Push(msg, start); err != store.ErrClosed {
+        t.Fatal("expect ErrClosed, got", err)
+    }
+
+    q, err = store.OpenFileQueue(path, store.FileOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer q.Close()
+    if last, _, _ := q.Peek(); last.GetQos() != 1 {
+        t.Fatal("expect QoS restored", last.GetQos())
+    }
+    if err := q.Compact(); err != nil {
+        t.Fatal(err)
+    }
+    q.Pop()
+    q.Close()
+
+    q, _ = store.OpenFileQueue(path, store.FileOptions{})
+    defer q.Close()
+    for i := 0; i < 2; i++ {
+        q.Pop()
+    }
+    restored, _, _ := q.Peek()
+    if v, ok := restored.GetMessageExpiryInterval(); !ok || v != 60 {
+        t.Fatal("expect MessageExpiryInterval restored, got", v, ok)
+    }
+}
+
+func queueOptions(id string, q store.Queue) client.Options {
+    return client.Options{
+        ClientID:              id,
+        SessionExpiryInterval: 60,
+        OfflineQueue:          q,
+    }
+}
+
+func TestClientOfflineQueue(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    sub := connectClient(t, b, client.Options{ClientID: "sub"})
+    ch := make(chan *message.PublishMessage, 100)
+    sub.Subscribe(testContext(t), func(c *client.Client, msg *message.PublishMessage) { ch <- msg }, subscribeFilter("a/b", 2))
+
+    q := store.NewMemoryQueue()
+    c := client.New(queueOptions("c1", q))
+    for i := 0; i < 30; i++ {
+        if err := c.Publish(testContext(t), publishMessage("a/b", byte(i%3), fmt.Sprint(i))); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if q.Len() != 30 {
+        t.Fatal("expect 30 queued messages, got", q.Len())
+    }
+    if err := c.Publish(testContext(t), publishMessage("a/+", 1, "")); err == nil {
+        t.Fatal("invalid topic name must not be queued")
+    }
+
+    b.ignore(packet.PktTypePUBLISH, true)
+    opts := queueOptions("c1", q)
+    opts.Address = b.addr()
+    c = client.New(opts)
+    if _, err := c.Connect(testContext(t)); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { c.Disconnect(context.Background()) })
+    waitFor(t, "queue drained", func() bool { return q.Len() == 0 })
+    b.ignore(packet.PktTypePUBLISH, false)
+    //队列清空后直接发送
+    if err := c.Publish(testContext(t), publishMessage("a/b", 1, "direct")); err != nil {
+        t.Fatal(err)
+    }
+
+    publishes := b.receivedOf(packet.PktTypePUBLISH)
+    if len(publishes) != 31 {
+        t.Fatal("expect 31 PUBLISH, got", len(publishes))
+    }
+    for i, m := range publishes[:30] {
+        msg := m.(*message.PublishMessage)
+        if string(msg.GetPayload()) != fmt.Sprint(i) || msg.GetQos() != byte(i%3) {
+            t.Fatal("queued message out of order", i, msg)
+        }
+    }
+}
+
+func TestClientOfflineQueueExpiry(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    var lock sync.Mutex
+    var dropped []string
+    q := store.NewMemoryQueue()
+    now := time.Now()
+    for _, v := range []struct {
+        payload string
+        expiry  uint32
+        waited  time.Duration
+    }{{"expired", 5, 5 * time.Second}, {"keep", 10, 3500 * time.Millisecond}, {"forever", 0, time.Hour}} {
+        msg := publishMessage("a/b", 1, v.payload)
+        if v.expiry > 0 {
+            msg.SetMessageExpiryInterval(v.expiry)
+        }
+        q.Push(msg, now.Add(-v.waited))
+    }
+
+    opts := queueOptions("c1", q)
+    opts.OnQueueDrop = func(c *client.Client, msg *message.PublishMessage, err error) {
+        lock.Lock()
+        defer lock.Unlock()
+        if err == client.ErrMessageExpired {
+            dropped = append(dropped, string(msg.GetPayload()))
+        }
+    }
+    connectClient(t, b, opts)
+    waitFor(t, "queue drained", func() bool { return len(b.receivedOf(packet.PktTypePUBLISH)) == 2 })
+
+    lock.Lock()
+    if len(dropped) != 1 || dropped[0] != "expired" {
+        t.Fatal("expect expired message dropped, got", dropped)
+    }
+    lock.Unlock()
+    publishes := b.receivedOf(packet.PktTypePUBLISH)
+    keep := publishes[0].(*message.PublishMessage)
+    if v, ok := keep.GetMessageExpiryInterval(); string(keep.GetPayload()) != "keep" || !ok || v != 7 {
+        t.Fatal("expect MessageExpiryInterval decremented to 7, got", v, ok)
+    }
+    if _, ok := publishes[1].(*message.PublishMessage).GetMessageExpiryInterval(); ok {
+        t.Fatal("message without expiry must not be changed")
+    }
+}
+
+func TestClientOfflineQueueOverflow(t *testing.T) {
+    var lock sync.Mutex
+    var dropped []string
+    opts := queueOptions("c1", store.NewMemoryQueue())
+    opts.OfflineQueueSize = 2
+    opts.OnQueueDrop = func(c *client.Client, msg *message.PublishMessage, err error) {
+        lock.Lock()
+        defer lock.Unlock()
+        if err == client.ErrQueueFull {
+            dropped = append(dropped, string(msg.GetPayload()))
+        }
+    }
+    c := client.New(opts)
+    for i := 0; i < 4; i++ {
+        if err := c.Publish(testContext(t), publishMessage("a/b", 0, fmt.Sprint(i))); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if len(dropped) != 2 || dropped[0] != "0" || dropped[1] != "1" || opts.OfflineQueue.Len() != 2 {
+        t.Fatal("expect oldest messages dropped, got", dropped)
+    }
+
+    opts = queueOptions("c2", store.NewMemoryQueue())
+    opts.OfflineQueueSize = 2
+    opts.OverflowPolicy = client.DropNewest
+    //新发布的消息由Publish返回错误，不通知OnQueueDrop
+    opts.OnQueueDrop = func(c *client.Client, msg *message.PublishMessage, err error) {
+        t.Error("unexpected OnQueueDrop", string(msg.GetPayload()))
+    }
+    c = client.New(opts)
+    c.Publish(testContext(t), publishMessage("a/b", 0, "0"))
+    c.Publish(testContext(t), publishMessage("a/b", 0, "1"))
+    if err := c.Publish(testContext(t), publishMessage("a/b", 0, "2")); err != client.ErrQueueFull {
+        t.Fatal("expect ErrQueueFull, got", err)
+    }
+    if msg, _, _ := opts.OfflineQueue.Peek(); string(msg.GetPayload()) != "0" {
+        t.Fatal("expect oldest message kept")
+    }
+}
+
+func TestClientOfflineQueueBlock(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    q := store.NewMemoryQueue()
+    opts := queueOptions("c1", q)
+    opts.OfflineQueueSize = 1
+    opts.OverflowPolicy = client.Block
+    opts.Address = b.addr()
+    c := client.New(opts)
+    t.Cleanup(func() { c.Disconnect(context.Background()) })
+
+    c.Publish(testContext(t), publishMessage("a/b", 1, "0"))
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+    if err := c.Publish(ctx, publishMessage("a/b", 1, "x")); err != context.DeadlineExceeded {
+        t.Fatal("expect DeadlineExceeded, got", err)
+    }
+
+    //连接后队列有空闲位置，等待中的Publish继续
+    errs := make(chan error, 1)
+    go func() { errs <- c.Publish(testContext(t), publishMessage("a/b", 1, "1")) }()
+    time.Sleep(20 * time.Millisecond)
+    if _, err := c.Connect(testContext(t)); err != nil {
+        t.Fatal(err)
+    }
+    if err := <-errs; err != nil {
+        t.Fatal(err)
+    }
+    waitFor(t, "messages sent", func() bool { return len(b.receivedOf(packet.PktTypePUBLISH)) == 2 })
+    publishes := b.receivedOf(packet.PktTypePUBLISH)
+    if string(publishes[0].(*message.PublishMessage).GetPayload()) != "0" ||
+        string(publishes[1].(*message.PublishMessage).GetPayload()) != "1" {
+        t.Fatal("messages out of order")
+    }
+}
+
+func TestClientOfflineQueueReconnect(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    reconnected := make(chan *message.ConnackMessage, 1)
+    opts := reconnectOptions("c1", reconnected)
+    opts.Backoff = client.Backoff{Min: 200 * time.Millisecond, Max: 200 * time.Millisecond}
+    opts.OfflineQueue, _ = store.OpenFileQueue(filepath.Join(t.TempDir(), "queue.log"), store.FileOptions{})
+    t.Cleanup(func() { opts.OfflineQueue.Close() })
+    c := connectClient(t, b, opts)
+
+    b.kick("c1")
+    waitFor(t, "connection lost", func() bool { return !c.IsConnected() })
+    for i := 0; i < 3; i++ {
+        if err := c.Publish(testContext(t), publishMessage("a/b", 2, fmt.Sprint(i))); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if n := opts.OfflineQueue.Len(); n != 3 {
+        t.Fatal("expect messages queued while disconnected, got", n)
+    }
+
+    waitReconnect(t, reconnected)
+    waitFor(t, "queued messages completed", func() bool { return len(b.receivedOf(packet.PktTypePUBREL)) == 3 })
+    for i, m := range b.receivedOf(packet.PktTypePUBLISH) {
+        if string(m.(*message.PublishMessage).GetPayload()) != fmt.Sprint(i) {
+            t.Fatal("queued message out of order", i)
+        }
+    }
+}
+
+//发送窗口已满时队首消息被DropOldest丢弃：通知OnQueueDrop，不再发送，之后的消息不丢失
+func TestClientOfflineQueueDropWhileDraining(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{receiveMaximum: 1})
+    var lock sync.Mutex
+    var dropped []string
+    q := store.NewMemoryQueue()
+    opts := queueOptions("c1", q)
+    opts.OfflineQueueSize = 2
+    opts.OnQueueDrop = func(c *client.Client, msg *message.PublishMessage, err error) {
+        lock.Lock()
+        defer lock.Unlock()
+        dropped = append(dropped, string(msg.GetPayload()))
+    }
+    opts.Address = b.addr()
+    c := client.New(opts)
+    t.Cleanup(func() { c.Disconnect(context.Background()) })
+    for i := 0; i < 2; i++ {
+        if err := c.Publish(testContext(t), publishMessage("a/b", 1, fmt.Sprint(i))); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    //"0"等待PUBACK，"1"等待发送窗口
+    b.ignore(packet.PktTypePUBLISH, true)
+    if _, err := c.Connect(testContext(t)); err != nil {
+        t.Fatal(err)
+    }
+    waitFor(t, "first PUBLISH", func() bool { return len(b.receivedOf(packet.PktTypePUBLISH)) == 1 })
+    for i := 2; i < 4; i++ {
+        if err := c.Publish(testContext(t), publishMessage("a/b", 1, fmt.Sprint(i))); err != nil {
+            t.Fatal(err)
+        }
+    }
+    lock.Lock()
+    if len(dropped) != 1 || dropped[0] != "1" {
+        t.Fatal("expect message 1 dropped, got", dropped)
+    }
+    lock.Unlock()
+
+    b.ignore(packet.PktTypePUBLISH, false)
+    ack := message.NewPubAckMessage()
+    ack.SetVersion(packet.MqttProtocolVersion5)
+    ack.SetPacketIdentifier(b.receivedOf(packet.PktTypePUBLISH)[0].(*message.PublishMessage).GetPacketIdentifier())
+    if err := b.send("c1", ack); err != nil {
+        t.Fatal(err)
+    }
+    waitFor(t, "queue drained", func() bool { return q.Len() == 0 && len(b.receivedOf(packet.PktTypePUBLISH)) == 3 })
+    if err := c.Publish(testContext(t), publishMessage("a/b", 1, "4")); err != nil {
+        t.Fatal(err)
+    }
+
+    publishes := b.receivedOf(packet.PktTypePUBLISH)
+    expect := []string{"0", "2", "3", "4"}
+    if len(publishes) != len(expect) {
+        t.Fatal("expect 4 PUBLISH, got", len(publishes))
+    }
+    for i, m := range publishes {
+        if v := string(m.(*message.PublishMessage).GetPayload()); v != expect[i] {
+            t.Fatal("expect", expect[i], "got", v)
+        }
+    }
+    lock.Lock()
+    defer lock.Unlock()
+    if len(dropped) != 1 {
+        t.Fatal("expect only message 1 dropped, got", dropped)
+    }
+}
+
+//发送队列期间连接断开：已进入会话的消息按会话重发，其余消息重新连接后继续按顺序发送
+func TestClientOfflineQueueDrainAcrossReconnect(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{receiveMaximum: 1})
+    reconnected := make(chan *message.ConnackMessage, 1)
+    opts := reconnectOptions("c1", reconnected)
+    opts.Backoff = client.Backoff{Min: 100 * time.Millisecond, Max: 100 * time.Millisecond}
+    opts.OfflineQueue, _ = store.OpenFileQueue(filepath.Join(t.TempDir(), "queue.log"), store.FileOptions{})
+    t.Cleanup(func() { opts.OfflineQueue.Close() })
+    opts.Address = b.addr()
+    c := client.New(opts)
+    t.Cleanup(func() { c.Disconnect(context.Background()) })
+    for i := 0; i < 5; i++ {
+        if err := c.Publish(testContext(t), publishMessage("a/b", byte(1+i%2), fmt.Sprint(i))); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    b.ignore(packet.PktTypePUBLISH, true)
+    if _, err := c.Connect(testContext(t)); err != nil {
+        t.Fatal(err)
+    }
+    waitFor(t, "first PUBLISH", func() bool { return len(b.receivedOf(packet.PktTypePUBLISH)) == 1 })
+    if n := opts.OfflineQueue.Len(); n != 4 {
+        t.Fatal("expect 4 messages left in queue, got", n)
+    }
+
+    b.ignore(packet.PktTypePUBLISH, false)
+    b.kick("c1")
+    waitReconnect(t, reconnected)
+    waitFor(t, "queue drained", func() bool {
+        return opts.OfflineQueue.Len() == 0 && len(b.receivedOf(packet.PktTypePUBLISH)) == 6
+    })
+
+    //第一个消息在连接断开后重发
+    var payloads []string
+    for _, m := range b.receivedOf(packet.PktTypePUBLISH) {
+        payloads = append(payloads, string(m.(*message.PublishMessage).GetPayload()))
+    }
+    if fmt.Sprint(payloads) != "[0 0 1 2 3 4]" {
+        t.Fatal("queued messages out of order", payloads)
+    }
+}
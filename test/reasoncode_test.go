@@ -0,0 +1,121 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "errors"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "testing"
+)
+
+func TestReasonCode(t *testing.T) {
+    if errcode.ReasonSuccess.String() != "Success" || errcode.ReasonPacketTooLarge.String() != "Packet too large" {
+        t.Fatal(errcode.ReasonSuccess.String(), errcode.ReasonPacketTooLarge.String())
+    }
+    if errcode.ReasonCode(0x7F).String() != "Unknown reason code 0x7F" {
+        t.Fatal(errcode.ReasonCode(0x7F).String())
+    }
+    if errcode.ReasonNoMatchingSubscribers.IsError() || !errcode.ReasonUnspecifiedError.IsError() {
+        t.Fatal("IsError not match")
+    }
+
+    cases := []struct {
+        code    errcode.ReasonCode
+        pktType byte
+        valid   bool
+    }{
+        {errcode.ReasonSuccess, packet.PktTypePUBACK, true},
+        {errcode.ReasonNormalDisconnection, packet.PktTypeDISCONNECT, true},
+        {errcode.ReasonGrantedQoS2, packet.PktTypeSUBACK, true},
+        {errcode.ReasonGrantedQoS2, packet.PktTypePUBACK, false},
+        {errcode.ReasonNoMatchingSubscribers, packet.PktTypePUBREC, true},
+        {errcode.ReasonNoMatchingSubscribers, packet.PktTypePUBREL, false},
+        {errcode.ReasonPacketIdentifierNotFound, packet.PktTypePUBCOMP, true},
+        {errcode.ReasonPacketIdentifierNotFound, packet.PktTypePUBACK, false},
+        {errcode.ReasonContinueAuthentication, packet.PktTypeAUTH, true},
+        {errcode.ReasonContinueAuthentication, packet.PktTypeCONNACK, false},
+        {errcode.ReasonServerShuttingDown, packet.PktTypeDISCONNECT, true},
+        {errcode.ReasonServerShuttingDown, packet.PktTypeCONNACK, false},
+        {errcode.ReasonBanned, packet.PktTypeCONNACK, true},
+        {errcode.ReasonTopicFilterInvalid, packet.PktTypeUNSUBACK, true},
+        {errcode.ReasonSharedSubscriptionsNotSupported, packet.PktTypeUNSUBACK, false},
+        {errcode.ReasonCode(0x7F), packet.PktTypeDISCONNECT, false},
+        {errcode.ReasonSuccess, packet.PktTypePUBLISH, false},
+    }
+    for _, c := range cases {
+        if c.code.ValidFor(c.pktType) != c.valid {
+            t.Fatal(c.code, c.pktType, "expect", c.valid)
+        }
+    }
+}
+
+func TestSetReasonCode(t *testing.T) {
+    puback := message.NewPubAckMessage()
+    if err := puback.SetReasonCode(errcode.ReasonServerBusy); !errors.Is(err, errcode.ReasonCodeInvalid) {
+        t.Fatal("expect ReasonCodeInvalid", err)
+    }
+    if puback.GetReasonCode() != errcode.ReasonSuccess {
+        t.Fatal("reason code changed")
+    }
+    pubrel := message.NewPubRelMessage()
+    if err := pubrel.SetReasonCode(errcode.ReasonPacketIdentifierNotFound); err != nil {
+        t.Fatal(err)
+    }
+    if err := pubrel.SetReasonCode(errcode.ReasonQuotaExceeded); !errors.Is(err, errcode.ReasonCodeInvalid) {
+        t.Fatal("expect ReasonCodeInvalid", err)
+    }
+
+    disconnect := message.NewDisconnectMessage()
+    if err := disconnect.SetReasonCode(errcode.ReasonBanned); !errors.Is(err, errcode.ReasonCodeInvalid) {
+        t.Fatal("expect ReasonCodeInvalid", err)
+    }
+    auth := message.NewAuthMessage()
+    if err := auth.SetReasonCode(errcode.ReasonUnspecifiedError); !errors.Is(err, errcode.ReasonCodeInvalid) {
+        t.Fatal("expect ReasonCodeInvalid", err)
+    }
+
+    connack := message.NewConnackMessage()
+    if err := connack.SetReasonCode(errcode.ReasonServerMoved); err != nil {
+        t.Fatal(err)
+    }
+    if err := connack.SetReasonCode(errcode.ConnackServerUnavailable); !errors.Is(err, errcode.ReasonCodeInvalid) {
+        t.Fatal("expect ReasonCodeInvalid", err)
+    }
+    connack.SetVersion(packet.MqttProtocolVersion311)
+    if err := connack.SetReasonCode(errcode.ConnackServerUnavailable); err != nil {
+        t.Fatal(err)
+    }
+    if err := connack.SetReasonCode(errcode.ReasonServerMoved); !errors.Is(err, errcode.ReasonCodeInvalid) {
+        t.Fatal("expect ReasonCodeInvalid", err)
+    }
+
+    suback := message.NewSubAckMessage()
+    if err := suback.SetPayload([]byte{0, byte(errcode.ReasonNoSubscriptionExisted)}); !errors.Is(err, errcode.ReasonCodeInvalid) {
+        t.Fatal("expect ReasonCodeInvalid", err)
+    }
+    suback.SetVersion(packet.MqttProtocolVersion311)
+    if err := suback.SetPayload([]byte{0, 1, 2, 0x80}); err != nil {
+        t.Fatal(err)
+    }
+    if err := suback.SetPayload([]byte{byte(errcode.ReasonQuotaExceeded)}); !errors.Is(err, errcode.ReasonCodeInvalid) {
+        t.Fatal("expect ReasonCodeInvalid", err)
+    }
+
+    unsuback := message.NewUnsubAckMessage()
+    if err := unsuback.SetPayload([]byte{byte(errcode.ReasonNoSubscriptionExisted), byte(errcode.ReasonGrantedQoS1)}); !errors.Is(err, errcode.ReasonCodeInvalid) {
+        t.Fatal("expect ReasonCodeInvalid", err)
+    }
+
+    //严格模式下解析时校验原因码
+    data := []byte{0x40, 4, 0, 1, byte(errcode.ReasonServerBusy), 0}
+    _, _, err := message.DecodeWithOption(data, message.ReadOption{Version: packet.MqttProtocolVersion5, Strict: true})
+    if !errors.Is(err, errcode.ReasonCodeInvalid) {
+        t.Fatal("expect ReasonCodeInvalid", err)
+    }
+}
@@ -0,0 +1,367 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "context"
+    "errors"
+    "io"
+    "mqtt/client"
+    "mqtt/message"
+    "mqtt/packet"
+    "mqtt/store"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func waitFor(t *testing.T, desc string, cond func() bool) {
+    deadline := time.Now().Add(3 * time.Second)
+    for !cond() {
+        if time.Now().After(deadline) {
+            t.Fatal("timeout waiting for", desc)
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+}
+
+func reconnectOptions(id string, reconnected chan *message.ConnackMessage) client.Options {
+    return client.Options{
+        ClientID:              id,
+        AutoReconnect:         true,
+        SessionExpiryInterval: 60,
+        Backoff:               client.Backoff{Min: 10 * time.Millisecond, Max: 50 * time.Millisecond},
+        OnReconnect: func(c *client.Client, ack *message.ConnackMessage) {
+            reconnected <- ack
+        },
+    }
+}
+
+func waitReconnect(t *testing.T, reconnected chan *message.ConnackMessage) *message.ConnackMessage {
+    select {
+    case ack := <-reconnected:
+        return ack
+    case <-time.After(3 * time.Second):
+        t.Fatal("client not reconnected")
+        return nil
+    }
+}
+
+func TestBackoff(t *testing.T) {
+    b := client.Backoff{Min: 100 * time.Millisecond, Max: time.Second}
+    for i := 0; i < 100; i++ {
+        if d := b.Delay(0); d < 50*time.Millisecond || d > 100*time.Millisecond {
+            t.Fatal("attempt 0 delay out of range", d)
+        }
+        if d := b.Delay(3); d < 400*time.Millisecond || d > 800*time.Millisecond {
+            t.Fatal("attempt 3 delay out of range", d)
+        }
+        if d := b.Delay(100); d < 500*time.Millisecond || d > time.Second {
+            t.Fatal("delay must be capped by Max", d)
+        }
+        if d := (client.Backoff{}).Delay(0); d < client.DefaultReconnectMinDelay/2 || d > client.DefaultReconnectMinDelay {
+            t.Fatal("default delay out of range", d)
+        }
+    }
+}
+
+func TestClientReconnectResend(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    reconnected := make(chan *message.ConnackMessage, 1)
+    c := connectClient(t, b, reconnectOptions("c1", reconnected))
+
+    //服务端不应答，两条消息都处于未完成状态
+    b.ignore(packet.PktTypePUBLISH, true)
+    errs := make(chan error, 2)
+    go func() { errs <- c.Publish(testContext(t), publishMessage("a", 1, "1")) }()
+    waitFor(t, "first PUBLISH", func() bool { return len(b.receivedOf(packet.PktTypePUBLISH)) == 1 })
+    go func() { errs <- c.Publish(testContext(t), publishMessage("a", 2, "2")) }()
+    waitFor(t, "second PUBLISH", func() bool { return len(b.receivedOf(packet.PktTypePUBLISH)) == 2 })
+
+    b.ignore(packet.PktTypePUBLISH, false)
+    b.kick("c1")
+    ack := waitReconnect(t, reconnected)
+    if ack.GetAckFlag()&0x01 == 0 {
+        t.Fatal("expect Session Present")
+    }
+    for i := 0; i < 2; i++ {
+        if err := <-errs; err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    connects := b.connectMessages()
+    if len(connects) != 2 || connects[1].IsCleanStart() || connects[1].GetClientId() != "c1" {
+        t.Fatal("expect reconnect with Clean Start 0 and the same client identifier")
+    }
+    publishes := b.receivedOf(packet.PktTypePUBLISH)
+    if len(publishes) != 4 {
+        t.Fatal("expect 2 retransmitted PUBLISH, got", len(publishes)-2)
+    }
+    for i := 0; i < 2; i++ {
+        first := publishes[i].(*message.PublishMessage)
+        resent := publishes[i+2].(*message.PublishMessage)
+        if !resent.GetDup() || resent.GetPacketIdentifier() != first.GetPacketIdentifier() ||
+            string(resent.GetPayload()) != string(first.GetPayload()) {
+            t.Fatal("retransmitted PUBLISH mismatch", resent)
+        }
+    }
+}
+
+func TestClientReconnectPubRel(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    reconnected := make(chan *message.ConnackMessage, 1)
+    c := connectClient(t, b, reconnectOptions("c1", reconnected))
+
+    b.ignore(packet.PktTypePUBREL, true)
+    errs := make(chan error, 1)
+    go func() { errs <- c.Publish(testContext(t), publishMessage("a", 2, "2")) }()
+    waitFor(t, "PUBREL", func() bool { return len(b.receivedOf(packet.PktTypePUBREL)) == 1 })
+
+    b.ignore(packet.PktTypePUBREL, false)
+    b.kick("c1")
+    waitReconnect(t, reconnected)
+    if err := <-errs; err != nil {
+        t.Fatal(err)
+    }
+    if n := len(b.receivedOf(packet.PktTypePUBREL)); n != 2 {
+        t.Fatal("expect PUBREL retransmitted, got", n)
+    }
+    if n := len(b.receivedOf(packet.PktTypePUBLISH)); n != 1 {
+        t.Fatal("PUBLISH must not be retransmitted after PUBREC, got", n)
+    }
+}
+
+func TestClientReconnectSessionLost(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    reconnected := make(chan *message.ConnackMessage, 1)
+    opts := reconnectOptions("c1", reconnected)
+    opts.Backoff = client.Backoff{Min: 200 * time.Millisecond, Max: 200 * time.Millisecond}
+    c := connectClient(t, b, opts)
+
+    b.ignore(packet.PktTypePUBLISH, true)
+    errs := make(chan error, 1)
+    go func() { errs <- c.Publish(testContext(t), publishMessage("a", 1, "1")) }()
+    waitFor(t, "PUBLISH", func() bool { return len(b.receivedOf(packet.PktTypePUBLISH)) == 1 })
+
+    b.kick("c1")
+    waitFor(t, "connection closed", func() bool { return !b.connected("c1") })
+    b.dropSessions()
+
+    ack := waitReconnect(t, reconnected)
+    if ack.GetAckFlag()&0x01 != 0 {
+        t.Fatal("expect no Session Present")
+    }
+    if err := <-errs; err != client.ErrSessionLost {
+        t.Fatal("expect ErrSessionLost, got", err)
+    }
+}
+
+func TestClientReconnectSubscription(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    reconnected := make(chan *message.ConnackMessage, 1)
+    c := connectClient(t, b, reconnectOptions("c1", reconnected))
+    pub := connectClient(t, b, client.Options{ClientID: "pub"})
+
+    ch := make(chan *message.PublishMessage, 10)
+    if _, err := c.Subscribe(testContext(t), func(c *client.Client, msg *message.PublishMessage) {
+        ch <- msg
+    }, subscribeFilter("t", 1)); err != nil {
+        t.Fatal(err)
+    }
+
+    b.kick("c1")
+    waitReconnect(t, reconnected)
+    if err := pub.Publish(testContext(t), publishMessage("t", 1, "after")); err != nil {
+        t.Fatal(err)
+    }
+    if msg := receive(t, ch); string(msg.GetPayload()) != "after" {
+        t.Fatal("expect message after reconnect, got", string(msg.GetPayload()))
+    }
+}
+
+func TestClientRestartWithStore(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    path := filepath.Join(t.TempDir(), "session.log")
+    fs := openFileStore(t, path, store.FileOptions{})
+    opts := client.Options{ClientID: "c1", SessionExpiryInterval: 60, Store: fs}
+    c := connectClient(t, b, opts)
+    if _, err := c.Subscribe(testContext(t), nil, subscribeFilter("a/+", 1)); err != nil {
+        t.Fatal(err)
+    }
+
+    //QoS 2消息等待PUBCOMP，QoS 1消息等待PUBACK
+    b.ignore(packet.PktTypePUBREL, true)
+    go c.Publish(testContext(t), publishMessage("b", 2, "1"))
+    waitFor(t, "PUBREL", func() bool { return len(b.receivedOf(packet.PktTypePUBREL)) == 1 })
+    b.ignore(packet.PktTypePUBLISH, true)
+    go c.Publish(testContext(t), publishMessage("b", 1, "2"))
+    waitFor(t, "PUBLISH", func() bool { return len(b.receivedOf(packet.PktTypePUBLISH)) == 2 })
+
+    //模拟进程重启：断开连接后重新打开存储并创建新的客户端
+    c.Disconnect(context.Background())
+    fs.Close()
+    b.ignore(packet.PktTypePUBREL, false)
+    b.ignore(packet.PktTypePUBLISH, false)
+
+    fs = openFileStore(t, path, store.FileOptions{})
+    checkMessages(t, fs, "c1", store.Outbound, "pubrel 2", "publish 3 2")
+    if subs, _ := fs.Subscriptions("c1"); len(subs) != 1 || subs[0].Filter != "a/+" {
+        t.Fatal("expect subscription saved", subs)
+    }
+    opts.Store = fs
+    c = connectClient(t, b, opts)
+
+    waitFor(t, "session resumed", func() bool {
+        msgs, _ := fs.Messages("c1", store.Outbound)
+        return len(msgs) == 0
+    })
+    if n := len(b.receivedOf(packet.PktTypePUBREL)); n != 2 {
+        t.Fatal("expect PUBREL retransmitted after restart, got", n)
+    }
+    publishes := b.receivedOf(packet.PktTypePUBLISH)
+    resent := publishes[len(publishes)-1].(*message.PublishMessage)
+    if len(publishes) != 3 || !resent.GetDup() || resent.GetPacketIdentifier() != 3 {
+        t.Fatal("expect PUBLISH retransmitted after restart", len(publishes), resent)
+    }
+
+    //报文标识符从保存的位置继续分配，SUBSCRIBE使用了报文标识符1
+    if err := c.Publish(testContext(t), publishMessage("b", 1, "3")); err != nil {
+        t.Fatal(err)
+    }
+    publishes = b.receivedOf(packet.PktTypePUBLISH)
+    if id := publishes[len(publishes)-1].(*message.PublishMessage).GetPacketIdentifier(); id != 4 {
+        t.Fatal("expect packet identifier 4, got", id)
+    }
+}
+
+//流式payload保存及重发时使用同一份数据
+func TestClientStreamPayloadResend(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    reconnected := make(chan *message.ConnackMessage, 1)
+    opts := reconnectOptions("c1", reconnected)
+    opts.Store = openFileStore(t, filepath.Join(t.TempDir(), "session.log"), store.FileOptions{})
+    c := connectClient(t, b, opts)
+
+    b.ignore(packet.PktTypePUBLISH, true)
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("b")
+    msg.SetQos(1)
+    msg.SetPayloadReader(strings.NewReader("streamed"), 8)
+    done := make(chan error, 1)
+    go func() { done <- c.Publish(testContext(t), msg) }()
+    waitFor(t, "PUBLISH", func() bool { return len(b.receivedOf(packet.PktTypePUBLISH)) == 1 })
+    checkMessages(t, opts.Store, "c1", store.Outbound, "publish 1 streamed")
+
+    b.ignore(packet.PktTypePUBLISH, false)
+    b.kick("c1")
+    waitReconnect(t, reconnected)
+    if err := <-done; err != nil {
+        t.Fatal(err)
+    }
+    for i, m := range b.receivedOf(packet.PktTypePUBLISH) {
+        if p := m.(*message.PublishMessage); string(p.GetPayload()) != "streamed" {
+            t.Fatal(i, "expect payload sent again, got", string(p.GetPayload()))
+        }
+    }
+
+    //数据不足时不保存也不发送
+    msg = message.NewPublishMessage()
+    msg.SetTopicName("b")
+    msg.SetQos(1)
+    msg.SetPayloadReader(strings.NewReader("short"), 8)
+    if err := c.Publish(testContext(t), msg); err != io.ErrUnexpectedEOF {
+        t.Fatal("expect io.ErrUnexpectedEOF, got", err)
+    }
+    if n := len(b.receivedOf(packet.PktTypePUBLISH)); n != 2 {
+        t.Fatal("expect short payload not sent, got", n)
+    }
+}
+
+//保存接收方向的报文失败的存储
+type failingStore struct {
+    store.Store
+    lock sync.Mutex
+    fail bool
+}
+
+func (s *failingStore) setFail(v bool) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.fail = v
+}
+
+func (s *failingStore) Put(clientID string, dir store.Direction, msg message.Message) error {
+    s.lock.Lock()
+    fail := s.fail && dir == store.Inbound
+    s.lock.Unlock()
+    if fail {
+        return errors.New("put failed")
+    }
+    return s.Store.Put(clientID, dir, msg)
+}
+
+//QoS 2报文标识符保存失败时不投递也不发送PUBREC，对端重发时重新投递
+func TestClientInboundPersistFailed(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    fs := &failingStore{Store: store.NewMemory(), fail: true}
+    reconnected := make(chan *message.ConnackMessage, 1)
+    ch := make(chan *message.PublishMessage, 2)
+    opts := reconnectOptions("c1", reconnected)
+    opts.Store = fs
+    opts.DefaultHandler = func(c *client.Client, msg *message.PublishMessage) {
+        ch <- msg
+    }
+    c := connectClient(t, b, opts)
+
+    msg := publishMessage("t", 2, "1")
+    msg.SetPacketIdentifier(7)
+    if err := b.send("c1", msg); err != nil {
+        t.Fatal(err)
+    }
+    waitFor(t, "connection lost", func() bool { return !c.IsConnected() })
+    fs.setFail(false)
+    waitReconnect(t, reconnected)
+    select {
+    case <-ch:
+        t.Fatal("message must not be delivered when persist failed")
+    default:
+    }
+    if n := len(b.receivedOf(packet.PktTypePUBREC)); n != 0 {
+        t.Fatal("PUBREC must not be sent when persist failed, got", n)
+    }
+
+    msg.SetDup(true)
+    if err := b.send("c1", msg); err != nil {
+        t.Fatal(err)
+    }
+    if got := receive(t, ch); string(got.GetPayload()) != "1" {
+        t.Fatal("expect message delivered after resend")
+    }
+    waitFor(t, "PUBREC", func() bool { return len(b.receivedOf(packet.PktTypePUBREC)) == 1 })
+}
+
+func TestClientDisconnectStopsReconnect(t *testing.T) {
+    b := newTestBroker(t, brokerOptions{})
+    reconnected := make(chan *message.ConnackMessage, 1)
+    opts := reconnectOptions("c1", reconnected)
+    opts.Backoff = client.Backoff{Min: 300 * time.Millisecond, Max: 300 * time.Millisecond}
+    c := connectClient(t, b, opts)
+
+    b.kick("c1")
+    waitFor(t, "connection lost", func() bool { return !c.IsConnected() })
+    if err := c.Disconnect(testContext(t)); err != nil {
+        t.Fatal(err)
+    }
+
+    time.Sleep(500 * time.Millisecond)
+    if c.IsConnected() || len(b.connectMessages()) != 1 {
+        t.Fatal("client must not reconnect after Disconnect")
+    }
+}
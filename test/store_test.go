@@ -0,0 +1,357 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "mqtt/store"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "syscall"
+    "testing"
+    "time"
+)
+
+const storeCrashEnv = "MQTT_STORE_CRASH_FILE"
+
+func storedPublish(id uint16, qos byte, payload string) *message.PublishMessage {
+    msg := publishMessage("a/b", qos, payload)
+    msg.SetPacketIdentifier(id)
+    return msg
+}
+
+func storedPubRel(id uint16) *message.PubRelMessage {
+    msg := message.NewPubRelMessage()
+    msg.SetPacketIdentifier(id)
+    return msg
+}
+
+func openFileStore(t *testing.T, path string, opts store.FileOptions) *store.FileStore {
+    fs, err := store.OpenFile(path, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { fs.Close() })
+    return fs
+}
+
+func checkMessages(t *testing.T, s store.Store, clientID string, dir store.Direction, expect ...string) {
+    msgs, err := s.Messages(clientID, dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(msgs) != len(expect) {
+        t.Fatalf("expect %d messages, got %d", len(expect), len(msgs))
+    }
+    for i, msg := range msgs {
+        var got string
+        switch m := msg.(type) {
+        case *message.PublishMessage:
+            got = fmt.Sprintf("publish %d %s", m.GetPacketIdentifier(), m.GetPayload())
+        case *message.PubRelMessage:
+            got = fmt.Sprintf("pubrel %d", m.GetPacketIdentifier())
+        }
+        if got != expect[i] {
+            t.Fatalf("message %d: expect %q, got %q", i, expect[i], got)
+        }
+    }
+}
+
+//写入测试数据，之后的状态由checkStoreState检查
+func fillStore(t *testing.T, s store.Store) {
+    steps := []error{
+        s.Put("c1", store.Outbound, storedPublish(1, 1, "1")),
+        s.Put("c1", store.Outbound, storedPublish(2, 2, "2")),
+        s.Put("c1", store.Outbound, storedPublish(3, 1, "3")),
+        //PUBLISH被PUBREL替换后保持原有顺序
+        s.Put("c1", store.Outbound, storedPubRel(2)),
+        s.Delete("c1", store.Outbound, 1),
+        s.Delete("c1", store.Outbound, 100),
+        s.Put("c1", store.Inbound, storedPublish(2, 2, "in")),
+        s.Subscribe("c1", subscribeFilter("b/#", 1)),
+        s.Subscribe("c1", subscribeFilter("a/+", 0)),
+        s.Subscribe("c1", subscribeFilter("b/#", 2)),
+        s.Subscribe("c1", subscribeFilter("c", 1)),
+        s.Unsubscribe("c1", "c"),
+        s.SetNextID("c1", 4),
+        s.Put("c2", store.Outbound, storedPublish(1, 1, "x")),
+        s.Subscribe("c3", subscribeFilter("x", 1)),
+        s.DeleteSession("c3"),
+    }
+    for i, err := range steps {
+        if err != nil {
+            t.Fatal("step", i, err)
+        }
+    }
+}
+
+func checkStoreState(t *testing.T, s store.Store) {
+    checkMessages(t, s, "c1", store.Outbound, "pubrel 2", "publish 3 3")
+    checkMessages(t, s, "c1", store.Inbound, "publish 2 in")
+    checkMessages(t, s, "c2", store.Outbound, "publish 1 x")
+    checkMessages(t, s, "c3", store.Outbound)
+
+    subs, err := s.Subscriptions("c1")
+    if err != nil || len(subs) != 2 {
+        t.Fatal("expect 2 subscriptions, got", subs, err)
+    }
+    if subs[0].Filter != "a/+" || subs[0].Options.QoS != 0 || subs[1].Filter != "b/#" || subs[1].Options.QoS != 2 {
+        t.Fatal("subscriptions mismatch", subs)
+    }
+    if id, _ := s.NextID("c1"); id != 4 {
+        t.Fatal("expect next id 4, got", id)
+    }
+    if id, _ := s.NextID("c2"); id != 1 {
+        t.Fatal("expect default next id 1, got", id)
+    }
+    ids, err := s.ClientIDs()
+    if err != nil || len(ids) != 2 || ids[0] != "c1" || ids[1] != "c2" {
+        t.Fatal("expect client ids [c1 c2], got", ids, err)
+    }
+}
+
+func TestMemoryStore(t *testing.T) {
+    s := store.NewMemory()
+    fillStore(t, s)
+    checkStoreState(t, s)
+
+    if err := s.Put("c1", store.Outbound, storedPublish(5, 0, "")); err != store.ErrMessageType {
+        t.Fatal("expect ErrMessageType for QoS 0, got", err)
+    }
+    if err := s.Put("c1", store.Outbound, message.NewPubAckMessage()); err != store.ErrMessageType {
+        t.Fatal("expect ErrMessageType for PUBACK, got", err)
+    }
+    if err := s.Put("c1", store.Outbound, storedPublish(0, 1, "")); err != errcode.PacketIdentifierInvalid {
+        t.Fatal("expect PacketIdentifierInvalid, got", err)
+    }
+
+    //会话状态全部删除后不再返回客户端标识符
+    s.Delete("c2", store.Outbound, 1)
+    if ids, _ := s.ClientIDs(); len(ids) != 1 {
+        t.Fatal("expect empty session released, got", ids)
+    }
+}
+
+func TestFileStore(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "session.log")
+    fs := openFileStore(t, path, store.FileOptions{Sync: true})
+    fillStore(t, fs)
+    checkStoreState(t, fs)
+    if err := fs.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if err := fs.Put("c1", store.Outbound, storedPublish(9, 1, "")); err != store.ErrClosed {
+        t.Fatal("expect ErrClosed, got", err)
+    }
+
+    fs = openFileStore(t, path, store.FileOptions{})
+    checkStoreState(t, fs)
+    msgs, _ := fs.Messages("c1", store.Outbound)
+    if pub := msgs[1].(*message.PublishMessage); pub.GetQos() != 1 || pub.GetTopicName() != "a/b" {
+        t.Fatal("restored PUBLISH mismatch", pub)
+    }
+
+    //重新打开后继续追加
+    if err := fs.Put("c1", store.Outbound, storedPublish(4, 1, "4")); err != nil {
+        t.Fatal(err)
+    }
+    fs.Close()
+    fs = openFileStore(t, path, store.FileOptions{})
+    checkMessages(t, fs, "c1", store.Outbound, "pubrel 2", "publish 3 3", "publish 4 4")
+}
+
+func TestFileStoreCompact(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "session.log")
+    fs := openFileStore(t, path, store.FileOptions{CompactThreshold: -1})
+    fillStore(t, fs)
+    for i := 0; i < 100; i++ {
+        fs.Put("c2", store.Outbound, storedPublish(100, 1, "tmp"))
+        fs.Delete("c2", store.Outbound, 100)
+    }
+    before, _ := os.Stat(path)
+    if err := fs.Compact(); err != nil {
+        t.Fatal(err)
+    }
+    after, _ := os.Stat(path)
+    if after.Size() >= before.Size() || fs.Records() != 7 {
+        t.Fatal("expect log compacted, got", before.Size(), after.Size(), fs.Records())
+    }
+    checkStoreState(t, fs)
+
+    //压缩后继续追加
+    fs.Delete("c2", store.Outbound, 1)
+    fs.Close()
+    fs = openFileStore(t, path, store.FileOptions{})
+    checkMessages(t, fs, "c2", store.Outbound)
+    checkMessages(t, fs, "c1", store.Outbound, "pubrel 2", "publish 3 3")
+
+    //自动压缩
+    auto := openFileStore(t, filepath.Join(t.TempDir(), "auto.log"), store.FileOptions{CompactThreshold: 10})
+    for i := 0; i < 100; i++ {
+        auto.Put("c1", store.Outbound, storedPublish(1, 1, "tmp"))
+        auto.Delete("c1", store.Outbound, 1)
+    }
+    if n := auto.Records(); n > 12 {
+        t.Fatal("expect log compacted automatically, got records", n)
+    }
+}
+
+func TestFileStoreTruncated(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "session.log")
+    fs := openFileStore(t, path, store.FileOptions{})
+    fs.Put("c1", store.Outbound, storedPublish(1, 1, "1"))
+    fs.Put("c1", store.Outbound, storedPublish(2, 1, "2"))
+    info, _ := os.Stat(path)
+    prefix := info.Size()
+    fs.Put("c1", store.Outbound, storedPublish(3, 1, "3"))
+    fs.Close()
+
+    data, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    //最后一条记录在任意位置中断时恢复之前的记录
+    for n := prefix; n < int64(len(data)); n++ {
+        p := filepath.Join(dir, fmt.Sprintf("torn-%d.log", n))
+        os.WriteFile(p, data[:n], 0644)
+        torn := openFileStore(t, p, store.FileOptions{})
+        checkMessages(t, torn, "c1", store.Outbound, "publish 1 1", "publish 2 2")
+        if err := torn.Put("c1", store.Outbound, storedPublish(4, 1, "4")); err != nil {
+            t.Fatal(err)
+        }
+        torn.Close()
+        torn = openFileStore(t, p, store.FileOptions{})
+        checkMessages(t, torn, "c1", store.Outbound, "publish 1 1", "publish 2 2", "publish 4 4")
+        torn.Close()
+    }
+
+    //末尾数据损坏
+    corrupt := append([]byte{}, data...)
+    corrupt[len(corrupt)-1] ^= 0xff
+    p := filepath.Join(dir, "corrupt.log")
+    os.WriteFile(p, append(corrupt, 1, 2, 3), 0644)
+    checkMessages(t, openFileStore(t, p, store.FileOptions{}), "c1", store.Outbound, "publish 1 1", "publish 2 2")
+}
+
+func TestFileStoreInvalidRecord(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "session.log")
+    fs := openFileStore(t, path, store.FileOptions{})
+    fs.Put("c1", store.Outbound, storedPublish(1, 1, "1"))
+    fs.Put("c1", store.Outbound, storedPublish(2, 1, "2"))
+    fs.Close()
+
+    data, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    //校验通过但报文无法解码的记录：操作为保存报文，客户端标识符为c1，数据不是合法的报文
+    body := []byte{1, 0, 0, 3, 0, 2, 'c', '1', 0xF0, 0x00}
+    bad := make([]byte, len(body)+8)
+    binary.BigEndian.PutUint32(bad, uint32(len(body)))
+    copy(bad[4:], body)
+    binary.BigEndian.PutUint32(bad[4+len(body):], crc32.ChecksumIEEE(body))
+
+    //插入到两条有效记录之间
+    first := int64(binary.BigEndian.Uint32(data)) + 8
+    corrupt := append(append(append([]byte{}, data[:first]...), bad...), data[first:]...)
+    os.WriteFile(path, corrupt, 0644)
+
+    _, err = store.OpenFile(path, store.FileOptions{})
+    var recErr *store.RecordError
+    if !errors.As(err, &recErr) || recErr.Offset != first {
+        t.Fatal("expect RecordError at offset", first, "got", err)
+    }
+    after, _ := os.ReadFile(path)
+    if !bytes.Equal(after, corrupt) {
+        t.Fatal("log must not be modified when a record can not be applied")
+    }
+}
+
+//由TestFileStoreCrash启动的子进程，持续写入直到被终止
+func TestFileStoreCrashHelper(t *testing.T) {
+    path := os.Getenv(storeCrashEnv)
+    if path == "" {
+        t.Skip("run by TestFileStoreCrash")
+    }
+    fs, err := store.OpenFile(path, store.FileOptions{CompactThreshold: -1})
+    if err != nil {
+        os.Exit(1)
+    }
+    //报文标识符用完后等待被终止
+    for i := 1; i < 65535; i++ {
+        if err := fs.Put("c1", store.Outbound, storedPublish(uint16(i+1), 1, fmt.Sprint(i))); err != nil {
+            os.Exit(1)
+        }
+    }
+    time.Sleep(time.Minute)
+}
+
+func TestFileStoreCrash(t *testing.T) {
+    if testing.Short() {
+        t.Skip("skip crash test in short mode")
+    }
+    path := filepath.Join(t.TempDir(), "crash.log")
+    cmd := exec.Command(os.Args[0], "-test.run=^TestFileStoreCrashHelper$")
+    cmd.Env = append(os.Environ(), storeCrashEnv+"="+path)
+    if err := cmd.Start(); err != nil {
+        t.Fatal(err)
+    }
+    waitFor(t, "log written", func() bool {
+        info, err := os.Stat(path)
+        return err == nil && info.Size() > 64*1024
+    })
+    cmd.Process.Signal(syscall.SIGKILL)
+    cmd.Wait()
+    time.Sleep(10 * time.Millisecond)
+
+    fs := openFileStore(t, path, store.FileOptions{})
+    msgs, err := fs.Messages("c1", store.Outbound)
+    if err != nil || len(msgs) == 0 {
+        t.Fatal("expect messages recovered", len(msgs), err)
+    }
+    for i, msg := range msgs {
+        pub := msg.(*message.PublishMessage)
+        if pub.GetPacketIdentifier() != uint16(i+2) || string(pub.GetPayload()) != fmt.Sprint(i+1) {
+            t.Fatal("recovered message mismatch at", i, pub.GetPacketIdentifier(), string(pub.GetPayload()))
+        }
+    }
+    if err := fs.Put("c1", store.Outbound, storedPublish(1, 1, "after")); err != nil {
+        t.Fatal(err)
+    }
+}
+
+//保存时以5.0编码，不修改调用者报文的协议版本
+func TestFileQueueKeepsMessage(t *testing.T) {
+    dir := t.TempDir()
+    q, err := store.OpenFileQueue(filepath.Join(dir, "queue.log"), store.FileOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer q.Close()
+    fs := openFileStore(t, filepath.Join(dir, "session.log"), store.FileOptions{})
+
+    msg := storedPublish(1, 1, "1")
+    msg.SetVersion(packet.MqttProtocolVersion311)
+    if err := q.Push(msg, time.Now()); err != nil {
+        t.Fatal(err)
+    }
+    if err := fs.Put("c1", store.Outbound, msg); err != nil {
+        t.Fatal(err)
+    }
+    if v := msg.GetVersion(); v != packet.MqttProtocolVersion311 {
+        t.Fatal("expect version not modified, got", v)
+    }
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "io/ioutil"
+    "mqtt/message"
+    "mqtt/packet"
+    "testing"
+)
+
+func TestPublishStream(t *testing.T) {
+    payload := bytes.Repeat([]byte("0123456789"), 100*1024)
+
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("firmware/v2")
+    msg.SetQos(1)
+    msg.SetPacketIdentifier(7)
+    msg.SetPayloadReader(bytes.NewReader(payload), int64(len(payload)))
+
+    buf := bytes.NewBuffer(nil)
+    n, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if int64(n) != msg.GetFixedHeader().PacketSize() {
+        t.Fatal("size not match")
+    }
+    _, err = message.WriteMessage(buf, message.NewPingReqMessage(), packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    opt := message.ReadOption{Version: packet.MqttProtocolVersion5, StreamPayloadSize: 1024}
+    msg2, n2, err := message.ReadMessageWithOption(buf, opt)
+    if err != nil {
+        t.Fatal(err)
+    }
+    p := msg2.(*message.PublishMessage)
+    if !p.IsPayloadStream() || p.GetPayload() != nil || p.GetPayloadSize() != int64(len(payload)) {
+        t.Fatal("expect stream payload")
+    }
+    if n2 != n-len(payload) {
+        t.Fatal("read size not match", n2)
+    }
+    data, err := ioutil.ReadAll(p.GetPayloadReader())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(data, payload) {
+        t.Fatal("payload not match")
+    }
+
+    msg3, _, err := message.ReadMessageWithOption(buf, opt)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if msg3.GetFixedHeader().Type() != packet.PktTypePINGREQ {
+        t.Fatal("not match")
+    }
+}
+
+func TestPublishStreamSmall(t *testing.T) {
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("a")
+    msg.SetPayloadReader(bytes.NewReader([]byte("hello")), 5)
+
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion311)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    //未超过阈值时读取到内存
+    msg2, _, err := message.ReadMessageWithOption(buf, message.ReadOption{Version: packet.MqttProtocolVersion311, StreamPayloadSize: 1024})
+    if err != nil {
+        t.Fatal(err)
+    }
+    p := msg2.(*message.PublishMessage)
+    if p.IsPayloadStream() || string(p.GetPayload()) != "hello" {
+        t.Fatal("not match")
+    }
+}
+
+func TestPublishStreamShort(t *testing.T) {
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("a")
+    msg.SetPayloadReader(bytes.NewReader([]byte("hello")), 10)
+
+    _, err := message.WriteMessage(ioutil.Discard, msg, packet.MqttProtocolVersion5)
+    if !errors.Is(err, io.ErrUnexpectedEOF) {
+        t.Fatal("expect ErrUnexpectedEOF", err)
+    }
+
+    //报文数据不完整
+    data := []byte{0x30, 0xFF, 0x7F, 0, 1, 'a', 0, 'x'}
+    _, _, err = message.ReadMessage(bytes.NewReader(data), packet.MqttProtocolVersion5)
+    if !errors.Is(err, io.ErrUnexpectedEOF) {
+        t.Fatal("expect ErrUnexpectedEOF", err)
+    }
+}
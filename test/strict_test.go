@@ -0,0 +1,125 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "errors"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "testing"
+)
+
+func TestStrictFixedHeader(t *testing.T) {
+    //PUBREL标志位必须为0010
+    data := []byte{0x60, 2, 0, 1}
+    _, _, err := message.ReadMessage(bytes.NewReader(data), packet.MqttProtocolVersion311)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, _, err = message.ReadMessageStrict(bytes.NewReader(data), packet.MqttProtocolVersion311)
+    if !errors.Is(err, errcode.FixedHeaderFlagInvalid) {
+        t.Fatal("expect FixedHeaderFlagInvalid")
+    }
+    t.Log(err)
+
+    data = []byte{0x62, 2, 0, 1}
+    _, _, err = message.ReadMessageStrict(bytes.NewReader(data), packet.MqttProtocolVersion311)
+    if err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestStrictPublishQos(t *testing.T) {
+    //QoS 3
+    data := []byte{0x36, 5, 0, 1, 'a', 0, 1}
+    _, _, err := message.ReadMessageStrict(bytes.NewReader(data), packet.MqttProtocolVersion311)
+    if !errors.Is(err, errcode.PublishQosInvalid) {
+        t.Fatal("expect PublishQosInvalid")
+    }
+
+    //QoS 0, DUP 1
+    data = []byte{0x38, 3, 0, 1, 'a'}
+    _, _, err = message.ReadMessageStrict(bytes.NewReader(data), packet.MqttProtocolVersion311)
+    if !errors.Is(err, errcode.PublishDupInvalid) {
+        t.Fatal("expect PublishDupInvalid")
+    }
+}
+
+func TestStrictConnectFlag(t *testing.T) {
+    cases := []struct {
+        flag byte
+        err  error
+    }{
+        {0x02, nil},
+        {0x03, errcode.ConnectReservedFlagInvalid},
+        {0x0A, errcode.WillQosWithoutWill},
+        {0x22, errcode.WillRetainWithoutWill},
+        {0x42, errcode.PasswordWithoutUsername},
+    }
+    for _, c := range cases {
+        data := []byte{0x10, 13, 0, 4, 'M', 'Q', 'T', 'T', 4, c.flag, 0, 10, 0, 1, 'a'}
+        if c.flag&0x40 != 0 {
+            data = append(data, 0, 1, 'p')
+            data[1] += 3
+        }
+        _, _, err := message.ReadMessageStrict(bytes.NewReader(data), packet.MqttProtocolVersion311)
+        if !errors.Is(err, c.err) {
+            t.Fatalf("flag %b expect %v got %v", c.flag, c.err, err)
+        }
+    }
+}
+
+func TestStrictWillQos(t *testing.T) {
+    msg := message.NewConnectMessage()
+    msg.SetClientId("a")
+    msg.SetWillEnable(true)
+    msg.SetWillQos(3)
+    msg.SetWillTopic("will")
+
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, _, err = message.ReadMessageStrict(buf, packet.MqttProtocolVersion5)
+    if !errors.Is(err, errcode.WillQosInvalid) {
+        t.Fatal("expect WillQosInvalid")
+    }
+}
+
+func TestStrictDuplicateProperty(t *testing.T) {
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("a")
+    msg.SetMessageExpiryInterval(10)
+    msg.SetMessageExpiryInterval(20)
+
+    //重复设置单值属性时替换原有属性
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    msg2, _, err := message.ReadMessageStrict(buf, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if v, _ := msg2.(*message.PublishMessage).GetMessageExpiryInterval(); v != 20 {
+        t.Fatal("not match")
+    }
+
+    //PUBLISH包含两个消息过期间隔
+    data := []byte{0x30, 14, 0, 1, 'a', 10, 0x02, 0, 0, 0, 10, 0x02, 0, 0, 0, 20}
+    _, _, err = message.ReadMessage(bytes.NewReader(data), packet.MqttProtocolVersion5)
+    if !errors.Is(err, errcode.DuplicateProperty) {
+        t.Fatal("expect DuplicateProperty")
+    }
+    if errcode.ReasonOf(err).Code != errcode.ReasonProtocolError {
+        t.Fatal("expect ProtocolError")
+    }
+}
@@ -9,13 +9,14 @@ package test
 import (
     "bytes"
     "mqtt/message"
+    "mqtt/packet"
     "testing"
 )
 
 func TestSuback1(t *testing.T) {
     msg := message.NewSubAckMessage()
     msg.SetPacketIdentifier(6)
-    msg.SetPayload([]byte{1,2,3,4,5,6})
+    msg.SetPayload([]byte{0, 1, 2, 0x80, 0x87, 0xA2})
     msg.GetFixedHeader()
 
     t.Log("before")
@@ -53,17 +54,7 @@ func TestSuback1(t *testing.T) {
 func TestSuback2(t *testing.T) {
     msg := message.NewSubAckMessage()
     msg.SetReasonString("test")
-    msg.SetPayload([]byte(`
-        This is a test Message! This is a test Message! This is a test Message! 
-        This is a test Message! This is a test Message! This is a test Message! 
-        This is a test Message! This is a test Message! This is a test Message! 
-        This is a test Message! This is a test Message! This is a test Message! 
-        This is a test Message! This is a test Message! This is a test Message! 
-        This is a test Message! This is a test Message! This is a test Message! 
-        This is a test Message! This is a test Message! This is a test Message! 
-        This is a test Message! This is a test Message! This is a test Message! 
-        This is a test Message! This is a test Message! This is a test Message! 
-    `))
+    msg.SetPayload(bytes.Repeat([]byte{0, 1, 2, 0x80, 0x8F, 0x9E}, 200))
     msg.SetUserProperty(map[string]string{
         "test1": "1234567890qwertyuiopasdfghjklzxcvbnm",
         "test2": "1234567890qwertyuiopasdfghjklzxcvbnm",
@@ -76,12 +67,12 @@ func TestSuback2(t *testing.T) {
     t.Log(msg)
 
     buf := bytes.NewBuffer(nil)
-    n, err := message.WriteMessage(buf, msg)
+    n, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
     if err != nil {
         t.Fatal(err)
     }
 
-    msg2, n2, err2 := message.ReadMessage(buf)
+    msg2, n2, err2 := message.ReadMessage(buf, packet.MqttProtocolVersion5)
     if err2 != nil {
         t.Fatal(err2)
     }
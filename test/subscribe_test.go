@@ -8,7 +8,10 @@ package test
 
 import (
     "bytes"
+    "errors"
+    "mqtt/errcode"
     "mqtt/message"
+    "mqtt/packet"
     "testing"
 )
 
@@ -16,9 +19,9 @@ func TestSubscribe1(t *testing.T) {
     msg := message.NewSubscribeMessage()
     msg.SetSubscriptionIdentifier(1000)
     msg.SetPayload([]message.SubscribeFilter{
-        {"test", 1},
-        {"test2", 2},
-        {"test3", 3},
+        {Filter: "test", Options: message.SubscriptionOptions{QoS: 1}},
+        {Filter: "test2", Options: message.SubscriptionOptions{QoS: 2}},
+        {Filter: "test3", Options: message.SubscriptionOptions{QoS: 2, NoLocal: true, RetainAsPublished: true, RetainHandling: 2}},
     })
     msg.GetFixedHeader()
 
@@ -57,9 +60,9 @@ func TestSubscribe1(t *testing.T) {
 func TestSubscribe2(t *testing.T) {
     msg := message.NewSubscribeMessage()
     msg.SetPayload([]message.SubscribeFilter{
-        {"test", 1},
-        {"test2", 2},
-        {"test3", 3},
+        {Filter: "test", Options: message.SubscriptionOptions{QoS: 1}},
+        {Filter: "test2", Options: message.SubscriptionOptions{QoS: 2}},
+        {Filter: "test3", Options: message.SubscriptionOptions{QoS: 2, NoLocal: true, RetainAsPublished: true, RetainHandling: 2}},
     })
     msg.SetSubscriptionIdentifier(123)
     msg.SetUserProperty(map[string]string{
@@ -74,12 +77,12 @@ func TestSubscribe2(t *testing.T) {
     t.Log(msg)
 
     buf := bytes.NewBuffer(nil)
-    n, err := message.WriteMessage(buf, msg)
+    n, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
     if err != nil {
         t.Fatal(err)
     }
 
-    msg2, n2, err2 := message.ReadMessage(buf)
+    msg2, n2, err2 := message.ReadMessage(buf, packet.MqttProtocolVersion5)
     if err2 != nil {
         t.Fatal(err2)
     }
@@ -93,3 +96,56 @@ func TestSubscribe2(t *testing.T) {
 
     t.Log(msg2.(*message.SubscribeMessage).GetSubscriptionIdentifier())
 }
+
+func TestSubscriptionOptions(t *testing.T) {
+    opts := message.SubscriptionOptions{QoS: 1, NoLocal: true, RetainAsPublished: true, RetainHandling: 2}
+    b := opts.Encode(packet.MqttProtocolVersion5)
+    if b != 0x2D {
+        t.Fatalf("expect 0x2D got %x", b)
+    }
+    opts2, err := message.DecodeSubscriptionOptions(b, packet.MqttProtocolVersion5)
+    if err != nil || opts2 != opts {
+        t.Fatal(opts2, err)
+    }
+
+    //MQTT 3.1.1只编码QoS
+    if b := opts.Encode(packet.MqttProtocolVersion311); b != 0x01 {
+        t.Fatalf("expect 0x01 got %x", b)
+    }
+    if _, err := message.DecodeSubscriptionOptions(0x2D, packet.MqttProtocolVersion311); !errors.Is(err, errcode.SubscribeReservedInvalid) {
+        t.Fatal("expect SubscribeReservedInvalid", err)
+    }
+
+    if _, err := message.DecodeSubscriptionOptions(0x41, packet.MqttProtocolVersion5); !errors.Is(err, errcode.SubscribeReservedInvalid) {
+        t.Fatal("expect SubscribeReservedInvalid", err)
+    }
+    if _, err := message.DecodeSubscriptionOptions(0x03, packet.MqttProtocolVersion5); !errors.Is(err, errcode.SubscribeQosInvalid) {
+        t.Fatal("expect SubscribeQosInvalid", err)
+    }
+    if _, err := message.DecodeSubscriptionOptions(0x30, packet.MqttProtocolVersion5); !errors.Is(err, errcode.RetainHandlingInvalid) {
+        t.Fatal("expect RetainHandlingInvalid", err)
+    }
+
+    msg := message.NewSubscribeMessage()
+    msg.SetPacketIdentifier(1)
+    msg.SetPayload([]message.SubscribeFilter{
+        {Filter: "a/b", Options: message.SubscriptionOptions{RetainHandling: 3}},
+    })
+    buf := bytes.NewBuffer(nil)
+    if _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5); !errors.Is(err, errcode.RetainHandlingInvalid) {
+        t.Fatal("expect RetainHandlingInvalid", err)
+    }
+
+    msg.SetPayload([]message.SubscribeFilter{
+        {Filter: "$share/g/a/b", Options: message.SubscriptionOptions{NoLocal: true}},
+    })
+    if err := msg.Validate(); !errors.Is(err, errcode.SharedSubscriptionNoLocal) {
+        t.Fatal("expect SharedSubscriptionNoLocal", err)
+    }
+
+    //保留位不为0
+    data := []byte{0x82, 7, 0, 1, 0, 0, 1, 'a', 0xC0}
+    if _, _, err := message.Decode(data, packet.MqttProtocolVersion5); !errors.Is(err, errcode.SubscribeReservedInvalid) {
+        t.Fatal("expect SubscribeReservedInvalid", err)
+    }
+}
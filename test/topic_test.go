@@ -0,0 +1,223 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "errors"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "mqtt/topic"
+    "testing"
+)
+
+func TestParseName(t *testing.T) {
+    valid := []string{"a", "/", "a/b/c", "/a", "a//b", "$SYS/broker/load", "温度/传感器"}
+    for _, s := range valid {
+        n, err := topic.ParseName(s)
+        if err != nil {
+            t.Fatal("expect valid", s, err)
+        }
+        if n.String() != s {
+            t.Fatal("expect", s, "got", n.String())
+        }
+    }
+
+    invalid := []string{"", "a/+", "a/#", "#", "a+b", string([]byte{'a', 0x00})}
+    for _, s := range invalid {
+        if _, err := topic.ParseName(s); err != errcode.TopicNameInvalid {
+            t.Fatal("expect TopicNameInvalid", s, err)
+        }
+    }
+
+    n, _ := topic.ParseName("/a//b")
+    levels := n.Levels()
+    if len(levels) != 4 || levels[0] != "" || levels[1] != "a" || levels[2] != "" || levels[3] != "b" {
+        t.Fatal(levels)
+    }
+    if n.IsSystem() {
+        t.Fatal("expect not system")
+    }
+    n, _ = topic.ParseName("$SYS/a")
+    if !n.IsSystem() {
+        t.Fatal("expect system")
+    }
+}
+
+func TestParseFilter(t *testing.T) {
+    valid := []string{"#", "+", "a/#", "+/+", "/+", "a/+/b", "a//#", "$SYS/#", "$share/g/a/#", "$share/g/+"}
+    for _, s := range valid {
+        f, err := topic.ParseFilter(s)
+        if err != nil {
+            t.Fatal("expect valid", s, err)
+        }
+        if f.String() != s {
+            t.Fatal("expect", s, "got", f.String())
+        }
+    }
+
+    invalid := []string{"", "a#", "a/#/b", "a/b#", "#/", "a+", "a/+b", "+a/b",
+        "$share/", "$share/g", "$share/g/", "$share//a", "$share/g+/a", "$share/g#/a"}
+    for _, s := range invalid {
+        if _, err := topic.ParseFilter(s); err != errcode.TopicFilterInvalid {
+            t.Fatal("expect TopicFilterInvalid", s, err)
+        }
+    }
+
+    f, _ := topic.ParseFilter("$share/group/sport/+/player")
+    if !f.IsShared() || f.ShareName() != "group" || f.Filter() != "sport/+/player" {
+        t.Fatal(f.ShareName(), f.Filter())
+    }
+    if !f.HasWildcard() || len(f.Levels()) != 3 {
+        t.Fatal(f.Levels())
+    }
+
+    f, _ = topic.ParseFilter("sport/tennis")
+    if f.IsShared() || f.HasWildcard() || f.Filter() != "sport/tennis" {
+        t.Fatal(f)
+    }
+}
+
+func TestTopicMatch(t *testing.T) {
+    cases := []struct {
+        filter string
+        name   string
+        match  bool
+    }{
+        {"sport/tennis/player1/#", "sport/tennis/player1", true},
+        {"sport/tennis/player1/#", "sport/tennis/player1/ranking", true},
+        {"sport/tennis/player1/#", "sport/tennis/player1/score/wimbledon", true},
+        {"sport/#", "sport", true},
+        {"sport/tennis/+", "sport/tennis/player1", true},
+        {"sport/tennis/+", "sport/tennis/player1/tournament", false},
+        {"sport/+", "sport", false},
+        {"sport/+", "sport/", true},
+        {"+/+", "/finance", true},
+        {"/+", "/finance", true},
+        {"+", "/finance", false},
+        {"#", "$SYS/broker", false},
+        {"+/monitor/Clients", "$SYS/monitor/Clients", false},
+        {"$SYS/#", "$SYS/monitor/Clients", true},
+        {"$SYS/monitor/+", "$SYS/monitor/Clients", true},
+        {"$share/g/sport/#", "sport/tennis", true},
+        {"a/b", "a/b", true},
+        {"a/b", "a/c", false},
+        {"a//b", "a//b", true},
+        {"a/#", "a/+", false},
+    }
+    for _, c := range cases {
+        if topic.Match(c.filter, c.name) != c.match {
+            t.Fatal(c.filter, c.name, "expect", c.match)
+        }
+    }
+}
+
+func TestTopicValidate(t *testing.T) {
+    pub := message.NewPublishMessage()
+    pub.SetTopicName("a/+")
+    if err := pub.Validate(); err != errcode.TopicNameInvalid {
+        t.Fatal("expect TopicNameInvalid", err)
+    }
+    pub.SetTopicName("")
+    if err := pub.Validate(); err != errcode.TopicNameInvalid {
+        t.Fatal("expect TopicNameInvalid", err)
+    }
+    pub.SetVersion(packet.MqttProtocolVersion)
+    pub.SetTopicAlias(1)
+    if err := pub.Validate(); err != nil {
+        t.Fatal(err)
+    }
+
+    sub := message.NewSubscribeMessage()
+    sub.SetPacketIdentifier(1)
+    sub.SetPayload([]message.SubscribeFilter{{Filter: "a/#"}, {Filter: "a/#/b"}})
+    if err := sub.Validate(); err != errcode.TopicFilterInvalid {
+        t.Fatal("expect TopicFilterInvalid", err)
+    }
+    sub.SetPayload([]message.SubscribeFilter{{Filter: "$share/g/a/+"}})
+    if err := sub.Validate(); err != nil {
+        t.Fatal(err)
+    }
+
+    unsub := message.NewUnsubscribeMessage()
+    unsub.SetPacketIdentifier(1)
+    unsub.SetPayload([]string{"$share//a"})
+    if err := unsub.Validate(); err != errcode.TopicFilterInvalid {
+        t.Fatal("expect TopicFilterInvalid", err)
+    }
+}
+
+func TestTopicCodec(t *testing.T) {
+    buf := bytes.NewBuffer(nil)
+    pub := message.NewPublishMessage()
+    pub.SetTopicName("a/#")
+    if _, err := message.WriteMessage(buf, pub, packet.MqttProtocolVersion5); !errors.Is(err, errcode.TopicNameInvalid) || buf.Len() != 0 {
+        t.Fatal("expect TopicNameInvalid", err)
+    }
+    sub := message.NewSubscribeMessage()
+    sub.SetPacketIdentifier(1)
+    sub.SetPayload([]message.SubscribeFilter{{Filter: "a/b+"}})
+    if _, err := message.WriteMessage(buf, sub, packet.MqttProtocolVersion5); !errors.Is(err, errcode.TopicFilterInvalid) || buf.Len() != 0 {
+        t.Fatal("expect TopicFilterInvalid", err)
+    }
+
+    //非严格模式解码时同样校验主题名及主题过滤器
+    data := [][]byte{
+        //PUBLISH a/+
+        {0x30, 6, 0, 3, 'a', '/', '+', 0},
+        //MQTT 3.1.1中PUBLISH主题名不能为空
+        {0x30, 2, 0, 0},
+        //SUBSCRIBE #/a
+        {0x82, 9, 0, 1, 0, 0, 3, '#', '/', 'a', 0},
+        //UNSUBSCRIBE a+
+        {0xA2, 7, 0, 1, 0, 0, 2, 'a', '+'},
+    }
+    versions := []byte{packet.MqttProtocolVersion5, packet.MqttProtocolVersion311, packet.MqttProtocolVersion5, packet.MqttProtocolVersion5}
+    expects := []error{errcode.TopicNameInvalid, errcode.TopicNameInvalid, errcode.TopicFilterInvalid, errcode.TopicFilterInvalid}
+    for i, b := range data {
+        if _, _, err := message.ReadMessage(bytes.NewReader(b), versions[i]); !errors.Is(err, expects[i]) {
+            t.Fatal(i, "expect", expects[i], "got", err)
+        }
+    }
+}
+
+//直接调用WriteVariableHeader及WritePayload时，校验失败不写入任何数据
+func TestTopicWriteNothing(t *testing.T) {
+    buf := bytes.NewBuffer(nil)
+    pub := message.NewPublishMessage()
+    pub.SetVersion(packet.MqttProtocolVersion5)
+    pub.SetTopicName("a/+")
+    if _, err := pub.WriteVariableHeader(buf); !errors.Is(err, errcode.TopicNameInvalid) || buf.Len() != 0 {
+        t.Fatal("expect TopicNameInvalid", err, buf.Len())
+    }
+    pub.SetTopicName("a\x00b")
+    if _, err := pub.WriteVariableHeader(buf); !errors.Is(err, errcode.MalformedPacket) || buf.Len() != 0 {
+        t.Fatal("expect MalformedPacket", err, buf.Len())
+    }
+
+    //第一个过滤器合法，第二个不合法
+    sub := message.NewSubscribeMessage()
+    sub.SetVersion(packet.MqttProtocolVersion5)
+    sub.SetPacketIdentifier(1)
+    sub.SetPayload([]message.SubscribeFilter{{Filter: "a/b"}, {Filter: "a/#/b"}})
+    if _, err := sub.WritePayload(buf); !errors.Is(err, errcode.TopicFilterInvalid) || buf.Len() != 0 {
+        t.Fatal("expect TopicFilterInvalid", err, buf.Len())
+    }
+    sub.SetPayload([]message.SubscribeFilter{{Filter: "a/b"}, {Filter: "a\x00"}})
+    if _, err := sub.WritePayload(buf); !errors.Is(err, errcode.MalformedPacket) || buf.Len() != 0 {
+        t.Fatal("expect MalformedPacket", err, buf.Len())
+    }
+
+    unsub := message.NewUnsubscribeMessage()
+    unsub.SetVersion(packet.MqttProtocolVersion5)
+    unsub.SetPacketIdentifier(1)
+    unsub.SetPayload([]string{"a/b", "a+"})
+    if _, err := unsub.WritePayload(buf); !errors.Is(err, errcode.TopicFilterInvalid) || buf.Len() != 0 {
+        t.Fatal("expect TopicFilterInvalid", err, buf.Len())
+    }
+}
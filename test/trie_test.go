@@ -0,0 +1,206 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "fmt"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/topic/trie"
+    "sort"
+    "sync"
+    "testing"
+)
+
+func matchClients(t *testing.T, tr *trie.Trie, name string) []string {
+    ret, err := tr.Match(name)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var clients []string
+    for _, sub := range ret.Subscriptions {
+        clients = append(clients, sub.ClientID+":"+sub.Filter)
+    }
+    sort.Strings(clients)
+    return clients
+}
+
+func TestTrieMatch(t *testing.T) {
+    tr := trie.New()
+    subs := []struct {
+        client string
+        filter string
+    }{
+        {"c1", "sport/tennis/player1/#"},
+        {"c2", "sport/#"},
+        {"c3", "sport/tennis/+"},
+        {"c4", "#"},
+        {"c5", "+/tennis/#"},
+        {"c6", "$SYS/#"},
+        {"c7", "+/monitor/Clients"},
+        {"c8", "sport/tennis/player1"},
+    }
+    for _, s := range subs {
+        if _, err := tr.Subscribe(s.client, message.SubscribeFilter{Filter: s.filter}, 0); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if tr.Count() != len(subs) {
+        t.Fatal("expect", len(subs), "got", tr.Count())
+    }
+
+    got := fmt.Sprint(matchClients(t, tr, "sport/tennis/player1"))
+    expect := "[c1:sport/tennis/player1/# c2:sport/# c3:sport/tennis/+ c4:# c5:+/tennis/# c8:sport/tennis/player1]"
+    if got != expect {
+        t.Fatal(got)
+    }
+    got = fmt.Sprint(matchClients(t, tr, "sport"))
+    if got != "[c2:sport/# c4:#]" {
+        t.Fatal(got)
+    }
+    got = fmt.Sprint(matchClients(t, tr, "$SYS/monitor/Clients"))
+    if got != "[c6:$SYS/#]" {
+        t.Fatal(got)
+    }
+    got = fmt.Sprint(matchClients(t, tr, "finance/monitor/Clients"))
+    if got != "[c4:# c7:+/monitor/Clients]" {
+        t.Fatal(got)
+    }
+
+    if _, err := tr.Match("a/+"); err != errcode.TopicNameInvalid {
+        t.Fatal("expect TopicNameInvalid", err)
+    }
+    if _, err := tr.Subscribe("c1", message.SubscribeFilter{Filter: "a/#/b"}, 0); err != errcode.TopicFilterInvalid {
+        t.Fatal("expect TopicFilterInvalid", err)
+    }
+}
+
+func TestTrieSubscribeOptions(t *testing.T) {
+    tr := trie.New()
+    tr.Subscribe("c1", message.SubscribeFilter{Filter: "a/+", Options: message.SubscriptionOptions{QoS: 1}}, 10)
+    replaced, _ := tr.Subscribe("c1", message.SubscribeFilter{Filter: "a/+", Options: message.SubscriptionOptions{QoS: 2}}, 11)
+    if !replaced || tr.Count() != 1 {
+        t.Fatal("expect replaced")
+    }
+    tr.Subscribe("c1", message.SubscribeFilter{Filter: "a/b", Options: message.SubscriptionOptions{QoS: 0}}, 12)
+
+    ret, _ := tr.Match("a/b")
+    if len(ret.Subscriptions) != 2 {
+        t.Fatal(ret.String())
+    }
+    ids := map[uint32]byte{}
+    for _, sub := range ret.Subscriptions {
+        ids[sub.SubscriptionIdentifier] = sub.Options.QoS
+    }
+    if ids[11] != 2 || ids[12] != 0 {
+        t.Fatal(ids)
+    }
+    if len(tr.Subscriptions("c1")) != 2 {
+        t.Fatal(tr.Subscriptions("c1"))
+    }
+}
+
+func TestTrieShared(t *testing.T) {
+    tr := trie.New()
+    tr.Subscribe("c1", message.SubscribeFilter{Filter: "$share/g1/sport/#"}, 0)
+    tr.Subscribe("c2", message.SubscribeFilter{Filter: "$share/g1/sport/#"}, 0)
+    tr.Subscribe("c3", message.SubscribeFilter{Filter: "$share/g2/sport/+"}, 0)
+    tr.Subscribe("c4", message.SubscribeFilter{Filter: "sport/tennis"}, 0)
+
+    ret, _ := tr.Match("sport/tennis")
+    if len(ret.Subscriptions) != 1 || ret.Subscriptions[0].ClientID != "c4" {
+        t.Fatal(ret.String())
+    }
+    if len(ret.Shared) != 2 || len(ret.Shared["$share/g1/sport/#"]) != 2 || len(ret.Shared["$share/g2/sport/+"]) != 1 {
+        t.Fatal(ret.String())
+    }
+
+    if !tr.Unsubscribe("c1", "$share/g1/sport/#") {
+        t.Fatal("expect unsubscribed")
+    }
+    if tr.Unsubscribe("c1", "$share/g1/sport/#") {
+        t.Fatal("expect not found")
+    }
+    ret, _ = tr.Match("sport/tennis")
+    if len(ret.Shared["$share/g1/sport/#"]) != 1 {
+        t.Fatal(ret.String())
+    }
+}
+
+func TestTrieUnsubscribe(t *testing.T) {
+    tr := trie.New()
+    tr.Subscribe("c1", message.SubscribeFilter{Filter: "a/b/c"}, 0)
+    tr.Subscribe("c1", message.SubscribeFilter{Filter: "a/#"}, 0)
+    tr.Subscribe("c2", message.SubscribeFilter{Filter: "a/b/c"}, 0)
+
+    if tr.Unsubscribe("c1", "a/b") {
+        t.Fatal("expect not found")
+    }
+    if n := tr.UnsubscribeAll("c1"); n != 2 {
+        t.Fatal("expect 2 got", n)
+    }
+    if tr.Count() != 1 || len(tr.Subscriptions("c1")) != 0 {
+        t.Fatal(tr.Count())
+    }
+    got := fmt.Sprint(matchClients(t, tr, "a/b/c"))
+    if got != "[c2:a/b/c]" {
+        t.Fatal(got)
+    }
+    tr.Unsubscribe("c2", "a/b/c")
+    if tr.Count() != 0 {
+        t.Fatal(tr.Count())
+    }
+}
+
+func TestTrieConcurrent(t *testing.T) {
+    tr := trie.New()
+    wg := sync.WaitGroup{}
+    for i := 0; i < 8; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            client := fmt.Sprintf("c%d", i)
+            for j := 0; j < 1000; j++ {
+                filter := fmt.Sprintf("a/%d/+", j%10)
+                tr.Subscribe(client, message.SubscribeFilter{Filter: filter}, 0)
+                tr.Match(fmt.Sprintf("a/%d/x", j%10))
+                if j%2 == 0 {
+                    tr.Unsubscribe(client, filter)
+                }
+            }
+        }(i)
+    }
+    wg.Wait()
+    if tr.Count() != 8*5 {
+        t.Fatal(tr.Count())
+    }
+}
+
+func BenchmarkTrieMatch(b *testing.B) {
+    tr := trie.New()
+    for i := 0; i < 1000000; i++ {
+        var filter string
+        switch i % 10 {
+        case 0:
+            filter = fmt.Sprintf("device/%d/+/status", i%1000)
+        case 1:
+            filter = fmt.Sprintf("device/%d/#", i%1000)
+        default:
+            filter = fmt.Sprintf("device/%d/sensor%d/status", i%1000, i/1000)
+        }
+        tr.Subscribe(fmt.Sprintf("c%d", i), message.SubscribeFilter{Filter: filter}, 0)
+    }
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        ret, err := tr.Match(fmt.Sprintf("device/%d/sensor%d/status", i%1000, i%1000))
+        if err != nil || len(ret.Subscriptions) == 0 {
+            b.Fatal(err)
+        }
+    }
+}
@@ -0,0 +1,107 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "mqtt/message"
+    "mqtt/packet"
+    "reflect"
+    "testing"
+)
+
+func TestUserProperties(t *testing.T) {
+    var props packet.UserProperties
+    props.Add("region", "eu")
+    props.Add("hop", "a")
+    props.Add("hop", "b")
+    props.Add("hop", "c")
+
+    if v, ok := props.Get("hop"); !ok || v != "a" {
+        t.Fatal("not match")
+    }
+    if !reflect.DeepEqual(props.GetAll("hop"), []string{"a", "b", "c"}) {
+        t.Fatal("not match", props.GetAll("hop"))
+    }
+
+    list := props.List()
+    if len(list) != 4 {
+        t.Fatal("list not match")
+    }
+    props2 := packet.UserPropertiesOf(list)
+    if !reflect.DeepEqual(props2.GetAll("hop"), []string{"a", "b", "c"}) {
+        t.Fatal("not match")
+    }
+
+    props.Del("hop")
+    if len(props) != 1 || props[0].Key() != "region" {
+        t.Fatal("del not match")
+    }
+}
+
+func TestUserPropertiesForward(t *testing.T) {
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("a")
+    keys := []string{"z", "a", "z", "m", "a"}
+    for i, k := range keys {
+        if err := msg.AddUserProperty(k, string(rune('0'+i))); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data := buf.Bytes()
+    recv, _, err := message.Decode(data, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    //原样转发，输入数据被复用后不受影响
+    fwd := message.NewPublishMessage()
+    fwd.SetTopicName("a")
+    fwd.SetUserProperties(recv.(*message.PublishMessage).GetUserProperties().Clone())
+    for i := range data {
+        data[i] = 0
+    }
+
+    props := fwd.GetUserProperties()
+    if len(props) != len(keys) {
+        t.Fatal("size not match")
+    }
+    for i, p := range props {
+        if p.Key() != keys[i] || p.Value() != string(rune('0'+i)) {
+            t.Fatal("order not match", p.String())
+        }
+    }
+}
+
+func TestWillUserProperties(t *testing.T) {
+    msg := message.NewConnectMessage()
+    msg.SetClientId("c")
+    msg.SetWillEnable(true)
+    msg.SetWillTopic("w")
+    msg.AddPayloadUserProperty("k", "1")
+    msg.AddPayloadUserProperty("k", "2")
+
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    msg2, _, err := message.ReadMessage(buf, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    all := msg2.(*message.ConnectMessage).GetPayloadUserProperties().GetAll("k")
+    if !reflect.DeepEqual(all, []string{"1", "2"}) {
+        t.Fatal("not match", all)
+    }
+}
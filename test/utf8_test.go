@@ -0,0 +1,126 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "errors"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "testing"
+)
+
+func TestValidateUTF8(t *testing.T) {
+    valid := []string{"", "a/b", "温度/传感器", "\U0001F600", "\uFEFF"}
+    for _, s := range valid {
+        if err := packet.ValidateUTF8([]byte(s), packet.ControlCharAllow); err != nil {
+            t.Fatal("expect valid", s)
+        }
+    }
+
+    invalid := [][]byte{
+        {'a', 0x00, 'b'},       //U+0000
+        {0xED, 0xA0, 0x80},     //U+D800
+        {0xED, 0xBF, 0xBF},     //U+DFFF
+        {0xC0, 0x80},           //过长编码
+        {0xE6, 0xB8},           //不完整
+        {0xFF},
+    }
+    for _, b := range invalid {
+        if err := packet.ValidateUTF8(b, packet.ControlCharAllow); !errors.Is(err, errcode.MalformedPacket) {
+            t.Fatal("expect MalformedPacket", b)
+        }
+    }
+}
+
+func TestControlCharPolicy(t *testing.T) {
+    chars := []string{"a\x01", "\x7F", "\u0085", "\uFFFF", "\U0010FFFE", "\uFDD0"}
+    for _, s := range chars {
+        if err := packet.ValidateUTF8([]byte(s), packet.ControlCharAllow); err != nil {
+            t.Fatal("expect allowed", s)
+        }
+    }
+
+    for _, s := range chars {
+        if err := packet.ValidateUTF8([]byte(s), packet.ControlCharReject); !errors.Is(err, errcode.MalformedPacket) {
+            t.Fatalf("expect MalformedPacket %q", s)
+        }
+    }
+    if err := packet.ValidateUTF8([]byte("a b "), packet.ControlCharReject); err != nil {
+        t.Fatal("expect valid")
+    }
+}
+
+func TestControlCharOption(t *testing.T) {
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("a\x01")
+    data, err := message.Encode(nil, msg, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    //同一进程中不同的读取选项互不影响
+    allow := message.ReadOption{Version: packet.MqttProtocolVersion5}
+    reject := message.ReadOption{Version: packet.MqttProtocolVersion5, ControlChars: packet.ControlCharReject}
+    if _, _, err := message.ReadMessageWithOption(bytes.NewReader(data), reject); !errors.Is(err, errcode.MalformedPacket) {
+        t.Fatal("expect MalformedPacket", err)
+    }
+    m, _, err := message.ReadMessageWithOption(bytes.NewReader(data), allow)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    //写入时按写入选项处理，与读取时的选项无关
+    buf := bytes.NewBuffer(nil)
+    opt := message.WriteOption{Version: packet.MqttProtocolVersion5, ControlChars: packet.ControlCharReject}
+    if _, err := message.WriteMessageWithOption(buf, m, opt); !errors.Is(err, errcode.MalformedPacket) || buf.Len() != 0 {
+        t.Fatal("expect MalformedPacket", err)
+    }
+    if _, err := message.WriteMessage(buf, m, packet.MqttProtocolVersion5); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestUTF8Message(t *testing.T) {
+    //主题名包含U+0000
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("a\x00b")
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
+    if !errors.Is(err, errcode.MalformedPacket) {
+        t.Fatal("expect MalformedPacket")
+    }
+
+    //用户属性包含代理项编码
+    msg.SetTopicName("a/b")
+    msg.AddUserProperty("k", string([]byte{0xED, 0xA0, 0x80}))
+    _, err = message.WriteMessage(buf, msg, packet.MqttProtocolVersion5)
+    if !errors.Is(err, errcode.MalformedPacket) {
+        t.Fatal("expect MalformedPacket")
+    }
+
+    //解码时校验
+    data := []byte{0x30, 5, 0, 3, 'a', 0xC0, 0x80}
+    _, _, err = message.ReadMessage(bytes.NewReader(data), packet.MqttProtocolVersion5)
+    if !errors.Is(err, errcode.MalformedPacket) {
+        t.Fatal("expect MalformedPacket", err)
+    }
+
+    //二进制数据不做校验
+    msg2 := message.NewPublishMessage()
+    msg2.SetTopicName("a/b")
+    msg2.SetCorrelationData([]byte{0x00, 0xED, 0xA0, 0x80})
+    _, err = message.WriteMessage(buf, msg2, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, _, err = message.ReadMessage(buf, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+}
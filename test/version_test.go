@@ -0,0 +1,265 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package test
+
+import (
+    "bytes"
+    "errors"
+    "mqtt/errcode"
+    "mqtt/message"
+    "mqtt/packet"
+    "testing"
+)
+
+func TestVersion311Connect(t *testing.T) {
+    msg := message.NewConnectMessage()
+    msg.SetVersion(packet.MqttProtocolVersion311)
+    msg.SetCleanStart(true)
+    msg.SetKeepAlive(60)
+    msg.SetClientId("device-001")
+    msg.SetWillEnable(true)
+    msg.SetWillQos(1)
+    msg.SetWillTopic("device/offline")
+    msg.SetWillPayload([]byte("bye"))
+    msg.SetUsername("test")
+    msg.SetPassword([]byte("123"))
+
+    buf := bytes.NewBuffer(nil)
+    n, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion311)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    //CONNECT报文的协议版本以报文自身为准
+    msg2, n2, err2 := message.ReadMessage(buf, packet.MqttProtocolVersion5)
+    if err2 != nil {
+        t.Fatal(err2)
+    }
+    if n != n2 {
+        t.Fatal("not match")
+    }
+
+    c := msg2.(*message.ConnectMessage)
+    if c.GetVersion() != packet.MqttProtocolVersion311 {
+        t.Fatal("version not match")
+    }
+    if !c.IsCleanStart() || !c.IsWillEnable() || c.GetWillQos() != 1 || c.GetKeepAlive() != 60 {
+        t.Fatal("flag not match")
+    }
+    if c.GetClientId() != "device-001" || c.GetUsername() != "test" || string(c.GetPassword()) != "123" {
+        t.Fatal("payload not match")
+    }
+    t.Log(c)
+}
+
+func TestVersion311Connect2(t *testing.T) {
+    //3.1.1 CONNECT: client id "a", clean session, keep alive 10
+    data := []byte{0x10, 13, 0, 4, 'M', 'Q', 'T', 'T', 4, 2, 0, 10, 0, 1, 'a'}
+    msg, n, err := message.ReadMessage(bytes.NewReader(data), packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != len(data) {
+        t.Fatal("size not match")
+    }
+    c := msg.(*message.ConnectMessage)
+    if c.GetClientId() != "a" || !c.IsCleanStart() || c.IsWillEnable() {
+        t.Fatal("not match")
+    }
+
+    buf := bytes.NewBuffer(nil)
+    _, err = message.WriteMessage(buf, c, c.GetVersion())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(buf.Bytes(), data) {
+        t.Fatal("encode not match", buf.Bytes())
+    }
+}
+
+func TestVersion311Ack(t *testing.T) {
+    msg := message.NewPubAckMessage()
+    msg.SetPacketIdentifier(0x1234)
+    msg.SetReasonString("ignored in 3.1.1")
+
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion311)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(buf.Bytes(), []byte{0x40, 2, 0x12, 0x34}) {
+        t.Fatal("encode not match", buf.Bytes())
+    }
+
+    msg2, _, err := message.ReadMessage(buf, packet.MqttProtocolVersion311)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if msg2.(*message.PubAckMessage).GetPacketIdentifier() != 0x1234 {
+        t.Fatal("not match")
+    }
+}
+
+func TestVersion311Publish(t *testing.T) {
+    msg := message.NewPublishMessage()
+    msg.SetTopicName("a/b")
+    msg.SetQos(1)
+    msg.SetPacketIdentifier(300)
+    msg.SetPayload([]byte("hello"))
+
+    buf := bytes.NewBuffer(nil)
+    n, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion311)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    msg2, n2, err := message.ReadMessage(buf, packet.MqttProtocolVersion311)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != n2 {
+        t.Fatal("not match")
+    }
+    p := msg2.(*message.PublishMessage)
+    if p.GetTopicName() != "a/b" || p.GetPacketIdentifier() != 300 || string(p.GetPayload()) != "hello" {
+        t.Fatal("not match")
+    }
+}
+
+func TestVersion311Subscribe(t *testing.T) {
+    msg := message.NewSubscribeMessage()
+    msg.SetPacketIdentifier(10)
+    msg.SetPayload([]message.SubscribeFilter{
+        {Filter: "a/+", Options: message.SubscriptionOptions{QoS: 1}},
+        {Filter: "b/#", Options: message.SubscriptionOptions{QoS: 2}},
+    })
+
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion311)
+    if err != nil {
+        t.Fatal(err)
+    }
+    msg2, _, err := message.ReadMessage(buf, packet.MqttProtocolVersion311)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(msg2.(*message.SubscribeMessage).GetPayload()) != 2 {
+        t.Fatal("not match")
+    }
+
+    ack := message.NewUnsubAckMessage()
+    ack.SetPacketIdentifier(10)
+    _, err = message.WriteMessage(buf, ack, packet.MqttProtocolVersion311)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(buf.Bytes(), []byte{0xB0, 2, 0, 10}) {
+        t.Fatal("encode not match", buf.Bytes())
+    }
+}
+
+func TestVersion311Disconnect(t *testing.T) {
+    msg := message.NewDisconnectMessage()
+    msg.SetReasonCode(errcode.ReasonServerShuttingDown)
+
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion311)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(buf.Bytes(), []byte{0xE0, 0}) {
+        t.Fatal("encode not match", buf.Bytes())
+    }
+}
+
+func TestVersion311Auth(t *testing.T) {
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, message.NewAuthMessage(), packet.MqttProtocolVersion311)
+    if !errors.Is(err, errcode.MessageNotSupport) {
+        t.Fatal("expect MessageNotSupport")
+    }
+
+    _, _, err = message.ReadMessage(bytes.NewReader([]byte{0xF0, 0}), packet.MqttProtocolVersion311)
+    if !errors.Is(err, errcode.MessageNotSupport) {
+        t.Fatal("expect MessageNotSupport")
+    }
+}
+
+func TestVersion31Connect(t *testing.T) {
+    msg := message.NewConnectMessage()
+    msg.SetVersion(packet.MqttProtocolVersion31)
+    msg.SetCleanStart(true)
+    msg.SetClientId("gateway-01")
+
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion31)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.HasPrefix(buf.Bytes()[2:], []byte{0, 6, 'M', 'Q', 'I', 's', 'd', 'p', 3}) {
+        t.Fatal("encode not match", buf.Bytes())
+    }
+
+    msg2, _, err := message.ReadMessage(buf, packet.MqttProtocolVersion5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    c := msg2.(*message.ConnectMessage)
+    if c.GetVersion() != packet.MqttProtocolVersion31 || c.GetClientId() != "gateway-01" {
+        t.Fatal("not match")
+    }
+}
+
+func TestVersion31ClientId(t *testing.T) {
+    msg := message.NewConnectMessage()
+    msg.SetVersion(packet.MqttProtocolVersion31)
+    msg.SetClientId("123456789012345678901234")
+
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion31)
+    if !errors.Is(err, errcode.ClientIdentifierNotValid) {
+        t.Fatal("expect ClientIdentifierNotValid")
+    }
+
+    //MQIsdp, level 3, client id长度为0
+    data := []byte{0x10, 14, 0, 6, 'M', 'Q', 'I', 's', 'd', 'p', 3, 2, 0, 10, 0, 0}
+    _, _, err = message.ReadMessage(bytes.NewReader(data), packet.MqttProtocolVersion5)
+    if !errors.Is(err, errcode.ClientIdentifierNotValid) {
+        t.Fatal("expect ClientIdentifierNotValid")
+    }
+}
+
+func TestVersion31ProtocolName(t *testing.T) {
+    //协议名MQIsdp与协议级别4不匹配
+    data := []byte{0x10, 15, 0, 6, 'M', 'Q', 'I', 's', 'd', 'p', 4, 2, 0, 10, 0, 1, 'a'}
+    _, _, err := message.ReadMessage(bytes.NewReader(data), packet.MqttProtocolVersion5)
+    if !errors.Is(err, errcode.UnsupportedProtocolVersion) {
+        t.Fatal("expect UnsupportedProtocolVersion")
+    }
+
+    data = []byte{0x10, 13, 0, 4, 'M', 'Q', 'T', 'X', 4, 2, 0, 10, 0, 1, 'a'}
+    _, _, err = message.ReadMessage(bytes.NewReader(data), packet.MqttProtocolVersion5)
+    if !errors.Is(err, errcode.ProtocolNameError) {
+        t.Fatal("expect ProtocolNameError")
+    }
+}
+
+func TestVersion31Connack(t *testing.T) {
+    msg := message.NewConnackMessage()
+    msg.SetAckFlag(1)
+    msg.SetVersion(packet.MqttProtocolVersion31)
+    msg.SetReasonCode(errcode.ConnackReturnCode(errcode.ReasonClientIdentifierNotValid))
+
+    buf := bytes.NewBuffer(nil)
+    _, err := message.WriteMessage(buf, msg, packet.MqttProtocolVersion31)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(buf.Bytes(), []byte{0x20, 2, 0, errcode.ConnackIdentifierRejected}) {
+        t.Fatal("encode not match", buf.Bytes())
+    }
+}
@@ -0,0 +1,195 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package topic
+
+import (
+    "math"
+    "mqtt/errcode"
+    "mqtt/packet"
+    "strings"
+)
+
+const (
+    //主题层级分隔符
+    Separator = '/'
+    //单层通配符
+    SingleWildcard = '+'
+    //多层通配符
+    MultiWildcard = '#'
+    //以$开头的主题为系统主题，首层为通配符的过滤器不能匹配 [MQTT-4.7.2-1]
+    SysPrefix = '$'
+    //共享订阅前缀，格式为$share/{ShareName}/{filter}
+    SharePrefix = "$share/"
+)
+
+//主题名，不能包含通配符
+type Name struct {
+    name   string
+    levels []string
+}
+
+//解析并校验主题名：
+//长度至少为1个字符且不超过65535字节 [MQTT-4.7.3-1]；
+//不能包含通配符 [MQTT-3.3.2-2]；
+//必须是合法的UTF-8编码字符串 [MQTT-4.7.3-2]。
+//主题层级可以为空，如"a//b"包含一个空层级。违反时返回TopicNameInvalid
+func ParseName(s string) (Name, error) {
+    if len(s) == 0 || len(s) > math.MaxUint16 {
+        return Name{}, errcode.TopicNameInvalid
+    }
+    if strings.IndexByte(s, SingleWildcard) >= 0 || strings.IndexByte(s, MultiWildcard) >= 0 {
+        return Name{}, errcode.TopicNameInvalid
+    }
+    if packet.ValidateUTF8([]byte(s), packet.ControlCharAllow) != nil {
+        return Name{}, errcode.TopicNameInvalid
+    }
+    return Name{name: s, levels: strings.Split(s, string(Separator))}, nil
+}
+
+func (n Name) String() string {
+    return n.name
+}
+
+//主题层级
+func (n Name) Levels() []string {
+    return n.levels
+}
+
+//是否为以$开头的系统主题
+func (n Name) IsSystem() bool {
+    return len(n.name) > 0 && n.name[0] == SysPrefix
+}
+
+//主题过滤器
+type Filter struct {
+    //订阅时的原始字符串，共享订阅包括$share/{ShareName}/前缀
+    raw string
+    //去掉共享订阅前缀的主题过滤器
+    filter string
+    levels []string
+    share  string
+    wild   bool
+}
+
+//解析并校验主题过滤器：
+//长度至少为1个字符且不超过65535字节 [MQTT-4.7.3-1]；
+//多层通配符必须单独占据最后一个层级 [MQTT-4.7.1-1]；
+//单层通配符必须占据整个层级 [MQTT-4.7.1-2]；
+//共享订阅的ShareName至少为1个字符，不能包含"/"、"+"或"#"，其后必须有"/"及主题过滤器 [MQTT-4.8.2-1] [MQTT-4.8.2-2]。
+//违反时返回TopicFilterInvalid
+func ParseFilter(s string) (Filter, error) {
+    if len(s) == 0 || len(s) > math.MaxUint16 {
+        return Filter{}, errcode.TopicFilterInvalid
+    }
+    if packet.ValidateUTF8([]byte(s), packet.ControlCharAllow) != nil {
+        return Filter{}, errcode.TopicFilterInvalid
+    }
+
+    f := Filter{raw: s, filter: s}
+    if strings.HasPrefix(s, SharePrefix) {
+        rest := s[len(SharePrefix):]
+        i := strings.IndexByte(rest, Separator)
+        if i <= 0 || i == len(rest)-1 {
+            return Filter{}, errcode.TopicFilterInvalid
+        }
+        f.share = rest[:i]
+        if strings.IndexByte(f.share, SingleWildcard) >= 0 || strings.IndexByte(f.share, MultiWildcard) >= 0 {
+            return Filter{}, errcode.TopicFilterInvalid
+        }
+        f.filter = rest[i+1:]
+    }
+
+    f.levels = strings.Split(f.filter, string(Separator))
+    last := len(f.levels) - 1
+    for i, level := range f.levels {
+        if strings.IndexByte(level, MultiWildcard) >= 0 {
+            if level != string(MultiWildcard) || i != last {
+                return Filter{}, errcode.TopicFilterInvalid
+            }
+            f.wild = true
+        }
+        if strings.IndexByte(level, SingleWildcard) >= 0 {
+            if level != string(SingleWildcard) {
+                return Filter{}, errcode.TopicFilterInvalid
+            }
+            f.wild = true
+        }
+    }
+    return f, nil
+}
+
+//订阅时的原始字符串
+func (f Filter) String() string {
+    return f.raw
+}
+
+//去掉共享订阅前缀的主题过滤器
+func (f Filter) Filter() string {
+    return f.filter
+}
+
+//主题过滤器的层级，不包括共享订阅前缀
+func (f Filter) Levels() []string {
+    return f.levels
+}
+
+//是否为共享订阅
+func (f Filter) IsShared() bool {
+    return f.share != ""
+}
+
+//共享订阅的ShareName，不是共享订阅时为空
+func (f Filter) ShareName() string {
+    return f.share
+}
+
+//是否包含通配符
+func (f Filter) HasWildcard() bool {
+    return f.wild
+}
+
+//是否为以$开头的系统主题过滤器
+func (f Filter) IsSystem() bool {
+    return len(f.filter) > 0 && f.filter[0] == SysPrefix
+}
+
+//主题过滤器是否匹配主题名。首层为通配符的过滤器不匹配以$开头的主题名 [MQTT-4.7.2-1]
+func (f Filter) Match(n Name) bool {
+    if n.IsSystem() && !f.IsSystem() {
+        return false
+    }
+    return matchLevels(f.levels, n.levels)
+}
+
+func matchLevels(filter, name []string) bool {
+    for i, level := range filter {
+        if level == string(MultiWildcard) {
+            //"sport/#"也匹配"sport"
+            return true
+        }
+        if i >= len(name) {
+            return false
+        }
+        if level != string(SingleWildcard) && level != name[i] {
+            return false
+        }
+    }
+    return len(filter) == len(name)
+}
+
+//主题过滤器是否匹配主题名，任一参数无效时返回false
+func Match(filter, name string) bool {
+    f, err := ParseFilter(filter)
+    if err != nil {
+        return false
+    }
+    n, err := ParseName(name)
+    if err != nil {
+        return false
+    }
+    return f.Match(n)
+}
@@ -0,0 +1,303 @@
+// Copyright (C) 2019, Xiongfa Li.
+// All right reserved.
+// @author xiongfa.li
+// @version V1.0
+// Description: 
+
+package trie
+
+import (
+    "mqtt/message"
+    "mqtt/topic"
+    "strings"
+    "sync"
+)
+
+//一个客户端的一条订阅
+type Subscription struct {
+    ClientID string
+    //订阅时的主题过滤器及订阅选项，共享订阅的Filter包括$share/{ShareName}/前缀
+    message.SubscribeFilter
+    //订阅标识符，0表示未设置
+    SubscriptionIdentifier uint32
+}
+
+//匹配结果
+type MatchResult struct {
+    //匹配的非共享订阅。客户端的多个订阅重叠时同一客户端会出现多次，
+    //由调用者按最大QoS投递一次并携带所有订阅标识符 [MQTT-3.3.4-2] [MQTT-3.3.4-3]
+    Subscriptions []Subscription
+    //匹配的共享订阅，key为共享订阅的原始主题过滤器（$share/{ShareName}/{filter}），
+    //每组由调用者选择其中一个订阅者投递
+    Shared map[string][]Subscription
+}
+
+type node struct {
+    children map[string]*node
+    //非共享订阅，key为ClientID
+    subs map[string]*Subscription
+    //共享订阅，key为ShareName，再以ClientID区分
+    shared map[string]map[string]*Subscription
+}
+
+//主题订阅树，按主题层级保存订阅，支持"+"、"#"通配符及共享订阅，可并发使用
+type Trie struct {
+    lock sync.RWMutex
+    root node
+    //客户端的订阅，key为ClientID，value为原始主题过滤器集合
+    clients map[string]map[string]struct{}
+    count   int
+}
+
+func New() *Trie {
+    return &Trie{
+        clients: map[string]map[string]struct{}{},
+    }
+}
+
+//添加订阅，主题过滤器无效时返回TopicFilterInvalid。
+//同一客户端已存在相同主题过滤器的订阅时替换原订阅并返回true [MQTT-3.8.4-3]
+func (t *Trie) Subscribe(clientID string, f message.SubscribeFilter, id uint32) (bool, error) {
+    tf, err := topic.ParseFilter(f.Filter)
+    if err != nil {
+        return false, err
+    }
+    sub := &Subscription{
+        ClientID:               clientID,
+        SubscribeFilter:        f,
+        SubscriptionIdentifier: id,
+    }
+
+    t.lock.Lock()
+    defer t.lock.Unlock()
+
+    n := &t.root
+    for _, level := range tf.Levels() {
+        if n.children == nil {
+            n.children = map[string]*node{}
+        }
+        child := n.children[level]
+        if child == nil {
+            child = &node{}
+            n.children[level] = child
+        }
+        n = child
+    }
+
+    var subs map[string]*Subscription
+    if tf.IsShared() {
+        if n.shared == nil {
+            n.shared = map[string]map[string]*Subscription{}
+        }
+        subs = n.shared[tf.ShareName()]
+        if subs == nil {
+            subs = map[string]*Subscription{}
+            n.shared[tf.ShareName()] = subs
+        }
+    } else {
+        if n.subs == nil {
+            n.subs = map[string]*Subscription{}
+        }
+        subs = n.subs
+    }
+    _, replaced := subs[clientID]
+    subs[clientID] = sub
+
+    if !replaced {
+        filters := t.clients[clientID]
+        if filters == nil {
+            filters = map[string]struct{}{}
+            t.clients[clientID] = filters
+        }
+        filters[f.Filter] = struct{}{}
+        t.count++
+    }
+    return replaced, nil
+}
+
+//删除订阅，订阅不存在时返回false
+func (t *Trie) Unsubscribe(clientID string, filter string) bool {
+    tf, err := topic.ParseFilter(filter)
+    if err != nil {
+        return false
+    }
+
+    t.lock.Lock()
+    defer t.lock.Unlock()
+
+    return t.unsubscribe(clientID, tf)
+}
+
+//删除客户端的所有订阅，返回删除的订阅数量
+func (t *Trie) UnsubscribeAll(clientID string) int {
+    t.lock.Lock()
+    defer t.lock.Unlock()
+
+    n := 0
+    for filter := range t.clients[clientID] {
+        tf, _ := topic.ParseFilter(filter)
+        if t.unsubscribe(clientID, tf) {
+            n++
+        }
+    }
+    return n
+}
+
+func (t *Trie) unsubscribe(clientID string, tf topic.Filter) bool {
+    if !remove(&t.root, tf.Levels(), clientID, tf.ShareName()) {
+        return false
+    }
+    filters := t.clients[clientID]
+    delete(filters, tf.String())
+    if len(filters) == 0 {
+        delete(t.clients, clientID)
+    }
+    t.count--
+    return true
+}
+
+//递归删除订阅并清理空节点
+func remove(n *node, levels []string, clientID, share string) bool {
+    if len(levels) == 0 {
+        if share == "" {
+            if _, ok := n.subs[clientID]; !ok {
+                return false
+            }
+            delete(n.subs, clientID)
+            return true
+        }
+        subs := n.shared[share]
+        if _, ok := subs[clientID]; !ok {
+            return false
+        }
+        delete(subs, clientID)
+        if len(subs) == 0 {
+            delete(n.shared, share)
+        }
+        return true
+    }
+
+    child := n.children[levels[0]]
+    if child == nil {
+        return false
+    }
+    if !remove(child, levels[1:], clientID, share) {
+        return false
+    }
+    if child.isEmpty() {
+        delete(n.children, levels[0])
+    }
+    return true
+}
+
+func (n *node) isEmpty() bool {
+    return len(n.children) == 0 && len(n.subs) == 0 && len(n.shared) == 0
+}
+
+//查找与主题名匹配的订阅，主题名无效时返回TopicNameInvalid。
+//首层为通配符的主题过滤器不匹配以$开头的主题名 [MQTT-4.7.2-1]
+func (t *Trie) Match(name string) (MatchResult, error) {
+    tn, err := topic.ParseName(name)
+    if err != nil {
+        return MatchResult{}, err
+    }
+
+    t.lock.RLock()
+    defer t.lock.RUnlock()
+
+    ret := MatchResult{}
+    levels := tn.Levels()
+    if tn.IsSystem() {
+        //系统主题跳过根节点的通配符
+        if child := t.root.children[levels[0]]; child != nil {
+            match(child, levels[1:], &ret)
+        }
+    } else {
+        match(&t.root, levels, &ret)
+    }
+    return ret, nil
+}
+
+func match(n *node, levels []string, ret *MatchResult) {
+    //"#"匹配当前层级及所有子层级，包括父层级本身，如"sport/#"匹配"sport"
+    if child := n.children[string(topic.MultiWildcard)]; child != nil {
+        collect(child, ret)
+    }
+    if len(levels) == 0 {
+        collect(n, ret)
+        return
+    }
+    if child := n.children[levels[0]]; child != nil {
+        match(child, levels[1:], ret)
+    }
+    if child := n.children[string(topic.SingleWildcard)]; child != nil {
+        match(child, levels[1:], ret)
+    }
+}
+
+func collect(n *node, ret *MatchResult) {
+    for _, sub := range n.subs {
+        ret.Subscriptions = append(ret.Subscriptions, *sub)
+    }
+    for _, subs := range n.shared {
+        for _, sub := range subs {
+            if ret.Shared == nil {
+                ret.Shared = map[string][]Subscription{}
+            }
+            ret.Shared[sub.Filter] = append(ret.Shared[sub.Filter], *sub)
+        }
+    }
+}
+
+//客户端的所有订阅
+func (t *Trie) Subscriptions(clientID string) []Subscription {
+    t.lock.RLock()
+    defer t.lock.RUnlock()
+
+    var ret []Subscription
+    for filter := range t.clients[clientID] {
+        tf, _ := topic.ParseFilter(filter)
+        n := &t.root
+        for _, level := range tf.Levels() {
+            if n = n.children[level]; n == nil {
+                break
+            }
+        }
+        if n == nil {
+            continue
+        }
+        var sub *Subscription
+        if tf.IsShared() {
+            sub = n.shared[tf.ShareName()][clientID]
+        } else {
+            sub = n.subs[clientID]
+        }
+        if sub != nil {
+            ret = append(ret, *sub)
+        }
+    }
+    return ret
+}
+
+//订阅总数
+func (t *Trie) Count() int {
+    t.lock.RLock()
+    defer t.lock.RUnlock()
+
+    return t.count
+}
+
+func (r *MatchResult) String() string {
+    builder := strings.Builder{}
+    for _, sub := range r.Subscriptions {
+        builder.WriteString(sub.ClientID + ": " + sub.Filter + "\n")
+    }
+    for group, subs := range r.Shared {
+        builder.WriteString(group + ":")
+        for _, sub := range subs {
+            builder.WriteString(" " + sub.ClientID)
+        }
+        builder.WriteString("\n")
+    }
+    return builder.String()
+}